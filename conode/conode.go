@@ -14,6 +14,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/urfave/cli.v1"
@@ -21,7 +23,7 @@ import (
 	"github.com/dedis/cothority/cosi/check"
 	_ "github.com/dedis/cothority/cosi/service"
 	_ "github.com/dedis/cothority/identity"
-	_ "github.com/dedis/cothority/skipchain"
+	"github.com/dedis/cothority/skipchain"
 	_ "github.com/dedis/cothority/status/service"
 	"gopkg.in/dedis/onet.v1/app"
 )
@@ -36,6 +38,7 @@ const (
 )
 
 func main() {
+	skipchain.Version = Version
 
 	cliApp := cli.NewApp()
 	cliApp.Name = "conode"
@@ -115,7 +118,24 @@ func runServer(ctx *cli.Context) {
 	// first check the options
 	config := ctx.String("config")
 
+	// onet doesn't tell the services when the conode stops, so the
+	// skipblocks are written to disk here.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		flushSkipchains()
+		os.Exit(0)
+	}()
 	app.RunServer(config)
+	flushSkipchains()
+}
+
+// flushSkipchains writes the latest skipblocks to disk.
+func flushSkipchains() {
+	if err := skipchain.FlushAll(); err != nil {
+		log.Error("Couldn't save skipblocks:", err)
+	}
 }
 
 // checkConfig contacts all servers and verifies if it receives a valid
@@ -5,6 +5,7 @@ import (
 
 	"io/ioutil"
 
+	"github.com/dedis/cothority/pop/service"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
@@ -32,6 +33,7 @@ func init() {
 		// API messages
 		&CreateIdentity{},
 		&CreateIdentityReply{},
+		&UpdateAuthKeys{},
 		&DataUpdate{},
 		&DataUpdateReply{},
 		&ProposeSend{},
@@ -255,6 +257,79 @@ func (i *Identity) CreateIdentity(t AuthType, atts []abstract.Point) onet.Client
 	return nil
 }
 
+// RegisterPopSet verifies the final statement of a pop-party and sends it to
+// all conodes of the cothority, so that the attendees can create identities
+// using PoPAuth. The request is signed with admin, whose public key needs to
+// be registered on all conodes with a PinRequest.
+func (i *Identity) RegisterPopSet(final *service.FinalStatement, admin abstract.Scalar) onet.ClientError {
+	if err := final.Verify(); err != nil {
+		return onet.NewClientErrorCode(ErrorInvalidSignature,
+			"invalid final statement: "+err.Error())
+	}
+	hash, err := final.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, admin, hash)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	for _, si := range i.Cothority.List {
+		cerr := i.Client.SendProtobuf(si, &StoreKeys{PoPAuth, final, nil, sig}, nil)
+		if cerr != nil {
+			return cerr
+		}
+	}
+	return nil
+}
+
+// UpdateAuthKeys changes the keys that are allowed to create identities on
+// all conodes of the cothority. The public keys in publics and the attendees
+// of final, if not nil, are added, or replace the current keys and sets if
+// replace is true. The request is authenticated with the private key of the
+// identity, using t and atts like CreateIdentity.
+func (i *Identity) UpdateAuthKeys(t AuthType, atts []abstract.Point,
+	publics []abstract.Point, final *service.FinalStatement, replace bool) onet.ClientError {
+	for _, si := range i.Cothority.List {
+		au := &Authenticate{[]byte{}, []byte{}}
+		cerr := i.Client.SendProtobuf(si, au, au)
+		if cerr != nil {
+			return cerr
+		}
+		uak := &UpdateAuthKeys{Publics: publics, Final: final,
+			Replace: replace, Type: t, Nonce: au.Nonce}
+		msg, err := uak.Hash()
+		if err != nil {
+			return onet.NewClientError(err)
+		}
+		switch t {
+		case PoPAuth:
+			index := 0
+			for j, key := range atts {
+				if key.Equal(i.Public) {
+					index = j
+					break
+				}
+			}
+			uak.Sig = anon.Sign(network.Suite, random.Stream, msg,
+				anon.Set(atts), au.Ctx, index, i.Private)
+		case PublicAuth:
+			uak.Public = i.Public
+			uak.SchnSig, err = crypto.SignSchnorr(network.Suite, i.Private, msg)
+			if err != nil {
+				return onet.NewClientError(err)
+			}
+		default:
+			return onet.NewClientErrorCode(ErrorAuthentication, "wrong type of authentication")
+		}
+		cerr = i.Client.SendProtobuf(si, uak, nil)
+		if cerr != nil {
+			return cerr
+		}
+	}
+	return nil
+}
+
 // ProposeSend sends the new proposition of this identity
 // ProposeVote
 func (i *Identity) ProposeSend(d *Data) onet.ClientError {
@@ -89,6 +89,56 @@ func TestIdentity_StoreKeys(t *testing.T) {
 	require.Equal(t, 1, len(srvc.auth.sets))
 }
 
+func TestIdentity_RegisterPopSet(t *testing.T) {
+	l := onet.NewTCPTest()
+	hosts, el, _ := l.GenTree(3, true)
+	services := l.GetServices(hosts, identityService)
+	defer l.CloseAll()
+	keypairAdmin := config.NewKeyPair(network.Suite)
+	keypairUser := config.NewKeyPair(network.Suite)
+	for _, srvc := range services {
+		s := srvc.(*Service)
+		s.auth.adminKeys = append(s.auth.adminKeys, keypairAdmin.Public)
+	}
+
+	final := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "test",
+			Location: "test",
+			Roster:   el,
+			Parties:  []*service.ShortDesc{},
+		},
+		Attendees: []abstract.Point{keypairUser.Public},
+	}
+	hash, err := final.Hash()
+	log.ErrFatal(err)
+	srvc := services[0].(*Service)
+	tree := el.GenerateNaryTreeWithRoot(2, srvc.ServerIdentity())
+	node, err := srvc.CreateProtocol(cosi.Name, tree)
+	log.ErrFatal(err)
+	signature := make(chan []byte)
+	c := node.(*cosi.CoSi)
+	c.RegisterSignatureHook(func(sig []byte) {
+		signature <- sig[:64]
+	})
+	c.Message = hash
+	go node.Start()
+	final.Signature = <-signature
+
+	id := NewTestIdentity(el, 1, "one", l, keypairUser)
+	defer id.Client.Close()
+	log.ErrFatal(id.RegisterPopSet(final, keypairAdmin.Secret))
+	for _, srvc := range services {
+		require.Equal(t, 1, len(srvc.(*Service).auth.sets))
+	}
+	log.ErrFatal(id.CreateIdentity(PoPAuth, final.Attendees))
+
+	log.Lvl1("Refusing unsigned final statement")
+	final.Signature = []byte{}
+	require.NotNil(t, id.RegisterPopSet(final, keypairAdmin.Secret))
+}
+
 func TestIdentity_StoreKeys2(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
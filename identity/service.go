@@ -19,6 +19,7 @@ import (
 
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/dedis/cothority/messaging"
 	"github.com/dedis/cothority/skipchain"
@@ -38,6 +39,9 @@ const ServiceName = "Identity"
 // Size of nonce used in autentication
 const nonceSize = 64
 
+// NonceTTL is how long a nonce returned by Authenticate can be used.
+var NonceTTL = 5 * time.Minute
+
 // Default number of skipchains, each user can create
 const defaultNumberSkipchains = 5
 
@@ -92,8 +96,8 @@ type authData struct {
 	keys []abstract.Point
 	// list of adminKeys
 	adminKeys []abstract.Point
-	// set of nonces
-	nonces map[string]struct{}
+	// set of nonces with the time they have been issued
+	nonces map[string]time.Time
 }
 
 /*
@@ -191,6 +195,64 @@ func (s *Service) StoreKeys(req *StoreKeys) (network.Message, onet.ClientError)
 	return nil, nil
 }
 
+// UpdateAuthKeys replaces or extends the keys and sets used to authenticate
+// CreateIdentity. The request needs to be authenticated by one of the
+// current keys or sets, using a nonce from Authenticate.
+func (s *Service) UpdateAuthKeys(uak *UpdateAuthKeys) (network.Message, onet.ClientError) {
+	log.Lvl3("UpdateAuthKeys", s.ServerIdentity())
+	if cerr := s.checkNonce(uak.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	msg, err := uak.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	valid := false
+	switch uak.Type {
+	case PoPAuth:
+		ctx := []byte(ServiceName + s.ServerIdentity().String())
+		for _, set := range s.auth.sets {
+			if _, err := anon.Verify(network.Suite, msg, set, ctx, uak.Sig); err == nil {
+				valid = true
+				break
+			}
+		}
+	case PublicAuth:
+		if uak.Public == nil {
+			return nil, onet.NewClientErrorCode(ErrorAuthentication,
+				"wrong public key authentication data")
+		}
+		for _, k := range s.auth.keys {
+			if k.Equal(uak.Public) {
+				valid = crypto.VerifySchnorr(network.Suite, uak.Public, msg, uak.SchnSig) == nil
+				break
+			}
+		}
+	default:
+		return nil, onet.NewClientErrorCode(ErrorAuthentication, "Wrong authentication type")
+	}
+	if !valid {
+		log.Error(s.ServerIdentity(), "Authentication is failed")
+		return nil, onet.NewClientErrorCode(ErrorAuthentication,
+			"Invalid Signature on UpdateAuthKeys")
+	}
+	if uak.Final != nil && uak.Final.Verify() != nil {
+		return nil, onet.NewClientErrorCode(ErrorInvalidSignature,
+			"Signature of final statement is invalid")
+	}
+	// The nonce can only be used once.
+	delete(s.auth.nonces, string(uak.Nonce))
+	if uak.Replace {
+		s.auth.keys = make([]abstract.Point, 0)
+		s.auth.sets = make([]anon.Set, 0)
+	}
+	s.auth.keys = append(s.auth.keys, uak.Publics...)
+	if uak.Final != nil {
+		s.auth.sets = append(s.auth.sets, anon.Set(uak.Final.Attendees))
+	}
+	return nil, nil
+}
+
 // Authenticate will create nonce and ctx and send it to user
 // It saves nonces in set
 // Replay attack is impossible, because after successful authentification nonce will
@@ -198,18 +260,44 @@ func (s *Service) StoreKeys(req *StoreKeys) (network.Message, onet.ClientError)
 func (s *Service) Authenticate(ap *Authenticate) (network.Message, onet.ClientError) {
 	ap.Ctx = []byte(ServiceName + s.ServerIdentity().String())
 	ap.Nonce = random.Bytes(nonceSize, random.Stream)
-	s.auth.nonces[string(ap.Nonce)] = struct{}{}
+	s.pruneNonces()
+	s.auth.nonces[string(ap.Nonce)] = time.Now()
 	return ap, nil
 }
 
+// pruneNonces removes all nonces that are older than NonceTTL, so that
+// unused nonces don't accumulate.
+func (s *Service) pruneNonces() {
+	for n, issued := range s.auth.nonces {
+		if time.Since(issued) > NonceTTL {
+			delete(s.auth.nonces, n)
+		}
+	}
+}
+
+// checkNonce returns an error if the nonce is not stored or has expired.
+// An expired nonce is removed.
+func (s *Service) checkNonce(nonce []byte) onet.ClientError {
+	issued, ok := s.auth.nonces[string(nonce)]
+	if !ok {
+		log.Error("Given nonce is not stored on ", s.ServerIdentity())
+		return onet.NewClientErrorCode(ErrorAuthentication,
+			fmt.Sprintf("Given nonce is not stored on %s", s.ServerIdentity()))
+	}
+	if time.Since(issued) > NonceTTL {
+		delete(s.auth.nonces, string(nonce))
+		return onet.NewClientErrorCode(ErrorAuthentication,
+			fmt.Sprintf("Given nonce has expired on %s", s.ServerIdentity()))
+	}
+	return nil
+}
+
 // CreateIdentity will register a new SkipChain and add it to our list of
 // managed identities.
 func (s *Service) CreateIdentity(ai *CreateIdentity) (network.Message, onet.ClientError) {
 	ctx := []byte(ServiceName + s.ServerIdentity().String())
-	if _, ok := s.auth.nonces[string(ai.Nonce)]; !ok {
-		log.Error("Given nonce is not stored on ", s.ServerIdentity())
-		return nil, onet.NewClientErrorCode(ErrorAuthentication,
-			fmt.Sprintf("Given nonce is not stored on %s", s.ServerIdentity()))
+	if cerr := s.checkNonce(ai.Nonce); cerr != nil {
+		return nil, cerr
 	}
 	valid := false
 	var tag string
@@ -738,12 +826,12 @@ func newIdentityService(c *onet.Context) onet.Service {
 	}
 	if err := s.RegisterHandlers(s.ProposeSend, s.ProposeVote,
 		s.CreateIdentity, s.ProposeUpdate, s.DataUpdate, s.PinRequest,
-		s.StoreKeys, s.Authenticate); err != nil {
+		s.StoreKeys, s.Authenticate, s.UpdateAuthKeys); err != nil {
 		log.Fatal("Registration error:", err)
 	}
 	skipchain.RegisterVerification(c, verifyIdentity, s.VerifyBlock)
 	s.auth.pins = make(map[string]struct{})
-	s.auth.nonces = make(map[string]struct{})
+	s.auth.nonces = make(map[string]time.Time)
 	s.auth.sets = make([]anon.Set, 0)
 	s.auth.adminKeys = make([]abstract.Point, 0)
 	s.tagsLimits = make(map[string]int8)
@@ -2,8 +2,10 @@ package identity
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
@@ -35,7 +37,7 @@ func TestService_CreateIdentity2(t *testing.T) {
 	ci.Data = il
 	ci.Roster = el
 	ci.Nonce = random.Bytes(nonceSize, random.Stream)
-	service.auth.nonces[string(ci.Nonce)] = struct{}{}
+	service.auth.nonces[string(ci.Nonce)] = time.Now()
 	ctx := []byte(ServiceName + service.ServerIdentity().String())
 
 	ci.Sig = anon.Sign(network.Suite, random.Stream, ci.Nonce,
@@ -66,7 +68,7 @@ func TestService_CreateIdentity3(t *testing.T) {
 	ci.Roster = el
 	ci.Public = kp.Public
 	ci.Nonce = random.Bytes(nonceSize, random.Stream)
-	service.auth.nonces[string(ci.Nonce)] = struct{}{}
+	service.auth.nonces[string(ci.Nonce)] = time.Now()
 	var err error
 	ci.SchnSig, err = crypto.SignSchnorr(network.Suite, kp.Secret, ci.Nonce)
 	log.ErrFatal(err)
@@ -79,3 +81,99 @@ func TestService_CreateIdentity3(t *testing.T) {
 	assert.True(t, ok)
 	assert.NotNil(t, id)
 }
+
+func TestService_UpdateAuthKeys(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	_, el, s := local.MakeHELS(3, identityService)
+	service := s.(*Service)
+
+	kpOld := config.NewKeyPair(network.Suite)
+	kpNew := config.NewKeyPair(network.Suite)
+	service.auth.keys = append(service.auth.keys, kpOld.Public)
+
+	newUpdate := func(kp *config.KeyPair) *UpdateAuthKeys {
+		uak := &UpdateAuthKeys{
+			Publics: []abstract.Point{kpNew.Public},
+			Replace: true,
+			Type:    PublicAuth,
+			Public:  kp.Public,
+			Nonce:   random.Bytes(nonceSize, random.Stream),
+		}
+		service.auth.nonces[string(uak.Nonce)] = time.Now()
+		msg, err := uak.Hash()
+		log.ErrFatal(err)
+		uak.SchnSig, err = crypto.SignSchnorr(network.Suite, kp.Secret, msg)
+		log.ErrFatal(err)
+		return uak
+	}
+	createIdentity := func(kp *config.KeyPair) onet.ClientError {
+		ci := &CreateIdentity{
+			Data:   NewData(50, kp.Public, "one"),
+			Roster: el,
+			Type:   PublicAuth,
+			Public: kp.Public,
+			Nonce:  random.Bytes(nonceSize, random.Stream),
+		}
+		service.auth.nonces[string(ci.Nonce)] = time.Now()
+		var err error
+		ci.SchnSig, err = crypto.SignSchnorr(network.Suite, kp.Secret, ci.Nonce)
+		log.ErrFatal(err)
+		_, cerr := service.CreateIdentity(ci)
+		return cerr
+	}
+
+	log.Lvl1("Unknown key can't update")
+	_, cerr := service.UpdateAuthKeys(newUpdate(kpNew))
+	assert.NotNil(t, cerr)
+
+	log.Lvl1("Rotating to the new key")
+	uak := newUpdate(kpOld)
+	_, cerr = service.UpdateAuthKeys(uak)
+	log.ErrFatal(cerr)
+	assert.Equal(t, []abstract.Point{kpNew.Public}, service.auth.keys)
+
+	log.Lvl1("Replaying the update fails")
+	service.auth.keys = append(service.auth.keys, kpOld.Public)
+	_, cerr = service.UpdateAuthKeys(uak)
+	assert.NotNil(t, cerr)
+	service.auth.keys = service.auth.keys[:1]
+
+	assert.NotNil(t, createIdentity(kpOld))
+	assert.Nil(t, createIdentity(kpNew))
+}
+
+func TestService_NonceExpiry(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	_, el, s := local.MakeHELS(3, identityService)
+	service := s.(*Service)
+
+	kp := config.NewKeyPair(network.Suite)
+	service.auth.keys = append(service.auth.keys, kp.Public)
+	ci := &CreateIdentity{
+		Data:   NewData(50, kp.Public, "one"),
+		Roster: el,
+		Type:   PublicAuth,
+		Public: kp.Public,
+		Nonce:  random.Bytes(nonceSize, random.Stream),
+	}
+	var err error
+	ci.SchnSig, err = crypto.SignSchnorr(network.Suite, kp.Secret, ci.Nonce)
+	log.ErrFatal(err)
+	service.auth.nonces[string(ci.Nonce)] = time.Now().Add(-2 * NonceTTL)
+
+	_, cerr := service.CreateIdentity(ci)
+	require.NotNil(t, cerr)
+	assert.Contains(t, cerr.Error(), "expired")
+	assert.Equal(t, 0, len(service.auth.nonces))
+
+	log.Lvl1("Unused nonces get pruned")
+	service.auth.nonces["stale"] = time.Now().Add(-2 * NonceTTL)
+	au := &Authenticate{[]byte{}, []byte{}}
+	_, cerr = service.Authenticate(au)
+	log.ErrFatal(cerr)
+	assert.Equal(t, 1, len(service.auth.nonces))
+	_, ok := service.auth.nonces[string(au.Nonce)]
+	assert.True(t, ok)
+}
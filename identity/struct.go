@@ -213,6 +213,52 @@ type StoreKeys struct {
 	Sig     crypto.SchnorrSig
 }
 
+// UpdateAuthKeys replaces or extends the keys and sets that are allowed to
+// create identities. It is authenticated with a key of the current keys or
+// sets, like CreateIdentity, but signing the Hash of the request.
+type UpdateAuthKeys struct {
+	// new public keys for PublicAuth
+	Publics []abstract.Point
+	// new set of attendees for PoPAuth
+	Final *service.FinalStatement
+	// Replace removes all current keys and sets before adding the new ones
+	Replace bool
+	Type    AuthType
+	// authentication via Public key
+	Public  abstract.Point
+	SchnSig crypto.SchnorrSig
+	// authentication via Linkable Ring Signature
+	Sig []byte
+	// Nonce returned by Authenticate
+	Nonce []byte
+}
+
+// Hash returns the message that is signed to authenticate the request.
+func (uak *UpdateAuthKeys) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write(uak.Nonce)
+	if uak.Replace {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	for _, p := range uak.Publics {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+	}
+	if uak.Final != nil {
+		b, err := uak.Final.Hash()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+	}
+	return h.Sum(nil), nil
+}
+
 // CreateIdentity starts a new identity-skipchain with the initial
 // Data and asking all nodes in Roster to participate.
 type CreateIdentity struct {
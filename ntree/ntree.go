@@ -3,7 +3,11 @@ package ntree
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/dedis/cothority/cosi/protocol"
+	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
@@ -14,6 +18,7 @@ func init() {
 	// register network messages and protocol
 	network.RegisterMessage(Message{})
 	network.RegisterMessage(SignatureReply{})
+	network.RegisterMessage(AggregateReply{})
 	onet.GlobalProtocolRegister("NaiveTree", NewProtocol)
 }
 
@@ -27,8 +32,38 @@ type Protocol struct {
 	// 1 - verify the collective signature
 	// 2 - verify all sub-signatures
 	VerifySignature int
+	// Aggregate makes the root run a CoSi-round once all signatures are
+	// collected, so the result is one collective signature instead of one
+	// signature per node. Only used by the root.
+	Aggregate bool
+	// Signatures is set by the root to all collected signatures if
+	// Aggregate is false.
+	Signatures []*SignatureReply
+	// Aggregated is set by the root to the collective signature if
+	// Aggregate is true.
+	Aggregated *AggregateReply
+	// FailedIndices is set by the root to the roster-indices of the nodes
+	// whose signatures failed the verification.
+	FailedIndices []int
+	// Timeout, if not 0, is how long the root waits for the signatures of
+	// its children before finishing with the ones it has.
+	Timeout time.Duration
+	// Threshold, if not 0, is the number of valid signatures, including
+	// its own, after which the root finishes without waiting for the
+	// other children.
+	Threshold int
+	// Collected is set by the root to the number of nodes it collected a
+	// valid signature from.
+	Collected int
 	// signature of this particular participant:
 	signature *SignatureReply
+	// bundles received from the children, protected by bundlesLock
+	bundles []structSignatureBundle
+	// valid holds the roster-indices of the valid signatures received by
+	// the root, so that a signature sent twice is only counted once
+	valid       map[int]bool
+	finished    bool
+	bundlesLock sync.Mutex
 }
 
 // NewProtocol is used internally to register the protocol.
@@ -40,7 +75,7 @@ func NewProtocol(node *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't register handler %v", err)
 	}
-	err = p.RegisterHandler(p.HandleSignBundle)
+	err = p.RegisterHandler(p.handleSignBundle)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't register handler %v", err)
 	}
@@ -56,6 +91,13 @@ func (p *Protocol) Start() error {
 		return errors.New("No children for root")
 	}
 	log.Lvl3("Starting ntree/naive")
+	if p.Timeout > 0 {
+		go func() {
+			time.Sleep(p.Timeout)
+			log.Lvl3("Timeout while waiting for signatures")
+			p.finish()
+		}()
+	}
 	return p.HandleSignRequest(structMessage{p.TreeNode(),
 		Message{p.Message, p.VerifySignature}})
 }
@@ -75,9 +117,10 @@ func (p *Protocol) HandleSignRequest(msg structMessage) error {
 		Index: p.TreeNode().RosterIndex}
 	if !p.IsLeaf() {
 		for _, c := range p.Children() {
-			err := p.SendTo(c, &msg.Message)
-			if err != nil {
-				return err
+			// Don't stop on a dead child, the root will finish on
+			// timeout or threshold.
+			if err := p.SendTo(c, &msg.Message); err != nil {
+				log.Error(p.Name(), "couldn't send to child:", err)
 			}
 		}
 	} else {
@@ -88,6 +131,53 @@ func (p *Protocol) HandleSignRequest(msg structMessage) error {
 	return nil
 }
 
+// handleSignBundle stores the bundle of a child. Once all children sent
+// their bundle, or the root has Threshold valid signatures, the bundles are
+// passed to HandleSignBundle. Bundles arriving after that are dropped.
+func (p *Protocol) handleSignBundle(msg structSignatureBundle) error {
+	p.bundlesLock.Lock()
+	if p.finished {
+		p.bundlesLock.Unlock()
+		log.Lvl3(p.Name(), "dropping late bundle from", msg.ServerIdentity)
+		return nil
+	}
+	p.bundles = append(p.bundles, msg)
+	done := len(p.bundles) == len(p.Children())
+	if p.IsRoot() && p.Threshold > 0 {
+		if p.valid == nil {
+			// Our own signature is valid, too.
+			p.valid = map[int]bool{p.TreeNode().RosterIndex: true}
+		}
+		for _, s := range append([]*SignatureReply{msg.ChildSig}, msg.SubSigs...) {
+			if s != nil && p.verifySignatureReply(s) == "SUCCESS" {
+				p.valid[s.Index] = true
+			}
+		}
+		done = done || len(p.valid) >= p.Threshold
+	}
+	p.bundlesLock.Unlock()
+	if done {
+		p.finish()
+	}
+	return nil
+}
+
+// finish passes the collected bundles to HandleSignBundle, but only the
+// first time it's called.
+func (p *Protocol) finish() {
+	p.bundlesLock.Lock()
+	if p.finished {
+		p.bundlesLock.Unlock()
+		return
+	}
+	p.finished = true
+	bundles := p.bundles
+	p.bundlesLock.Unlock()
+	if err := p.HandleSignBundle(bundles); err != nil {
+		log.Error(p.Name(), "couldn't handle signatures:", err)
+	}
+}
+
 // HandleSignBundle is a handler responsible for adding the node's signature
 // and verifying the children's signatures (verification level can be controlled
 // by the VerifySignature flag).
@@ -101,13 +191,16 @@ func (p *Protocol) HandleSignBundle(reply []structSignatureBundle) error {
 		// and count how many from the sub-trees
 		count += len(s.SubSigs)
 	}
-	sig.SubSigs = make([]*SignatureReply, count)
+	sig.SubSigs = make([]*SignatureReply, 0, count)
 	for _, sigs := range reply {
 		// Check only direct children
 		// see https://github.com/dedis/cothority/issues/260
 		if p.VerifySignature == 1 || p.VerifySignature == 2 {
 			s := p.verifySignatureReply(sigs.ChildSig)
 			log.Lvl3(p.Name(), "direct children verification:", s)
+			if s == "FAIL" {
+				sig.FailedIndices = addFailed(sig.FailedIndices, sigs.ChildSig.Index)
+			}
 		}
 		// Verify also the whole subtree
 		if p.VerifySignature == 2 {
@@ -115,8 +208,12 @@ func (p *Protocol) HandleSignBundle(reply []structSignatureBundle) error {
 			for _, sub := range sigs.SubSigs {
 				s := p.verifySignatureReply(sub)
 				log.Lvl3(p.Name(), "verifying subtree signature:", s)
+				if s == "FAIL" {
+					sig.FailedIndices = addFailed(sig.FailedIndices, sub.Index)
+				}
 			}
 		}
+		sig.FailedIndices = addFailed(sig.FailedIndices, sigs.FailedIndices...)
 		if p.VerifySignature == 0 {
 			log.Lvl3(p.Name(), "Skipping signature verification..")
 		}
@@ -127,15 +224,75 @@ func (p *Protocol) HandleSignBundle(reply []structSignatureBundle) error {
 
 	if !p.IsRoot() {
 		p.SendTo(p.Parent(), &sig)
-	} else {
-		log.Lvl3("Leader got", len(reply), "signatures. Children:", len(p.Children()))
-		p.Done()
+		return nil
+	}
+	log.Lvl3("Leader got", len(reply), "signatures. Children:", len(p.Children()))
+	p.FailedIndices = sig.FailedIndices
+	sigs := append([]*SignatureReply{sig.ChildSig}, sig.SubSigs...)
+	signers := make(map[int]bool)
+	for _, s := range sigs {
+		if s != nil && p.verifySignatureReply(s) == "SUCCESS" {
+			signers[s.Index] = true
+		}
 	}
+	p.Collected = len(signers)
+	log.Lvl3("Leader collected", p.Collected, "valid signatures")
+	if p.Aggregate {
+		return p.aggregate(signers)
+	}
+	p.Signatures = sigs
+	p.Done()
 	return nil
 }
 
+// aggregate runs a CoSi-round over the nodes that sent a valid signature, so
+// that a dead node doesn't block the round, to replace their signatures with
+// one collective signature.
+func (p *Protocol) aggregate(signers map[int]bool) error {
+	if p.TreeNode().RosterIndex != 0 {
+		return errors.New("aggregation needs the root at roster-index 0")
+	}
+	mask := make([]byte, (len(p.Roster().List)+7)/8)
+	var list []*network.ServerIdentity
+	for i, si := range p.Roster().List {
+		if signers[i] {
+			mask[i/8] |= 1 << uint(i%8)
+			list = append(list, si)
+		}
+	}
+	tree := onet.NewRoster(list).GenerateNaryTreeWithRoot(2, p.ServerIdentity())
+	pi, err := p.CreateProtocol(cosi.Name, tree)
+	if err != nil {
+		return err
+	}
+	round := pi.(*cosi.CoSi)
+	round.SigningMessage(p.Message)
+	round.RegisterSignatureHook(func(sig []byte) {
+		p.Aggregated = &AggregateReply{Signature: sig, Mask: mask}
+		p.Done()
+	})
+	return round.Start()
+}
+
+// addFailed appends all indices to failed that are not yet in it.
+func addFailed(failed []int, indices ...int) []int {
+	for _, i := range indices {
+		found := false
+		for _, f := range failed {
+			if f == i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
+
 func (p *Protocol) verifySignatureReply(sig *SignatureReply) string {
-	if sig.Index >= len(p.Roster().List) {
+	if sig.Index < 0 || sig.Index >= len(p.Roster().List) {
 		log.Error("Index in signature reply out of range")
 		return "FAIL"
 	}
@@ -177,6 +334,30 @@ type SignatureBundle struct {
 	ChildSig *SignatureReply
 	// Child subtree signatures
 	SubSigs []*SignatureReply
+	// Roster-indices of the nodes in the child subtree whose signatures
+	// failed the verification
+	FailedIndices []int
+}
+
+// AggregateReply holds the collective signature of the root in aggregate
+// mode. It can be verified with cosi.VerifySignature and the public keys
+// returned by Publics. Mask has bit i set if the roster-member i sent a valid
+// individual signature and took part in the collective signature.
+type AggregateReply struct {
+	Signature []byte
+	Mask      []byte
+}
+
+// Publics returns the public keys of the members of the roster that took
+// part in the collective signature.
+func (ar *AggregateReply) Publics(roster *onet.Roster) []abstract.Point {
+	var publics []abstract.Point
+	for i, si := range roster.List {
+		if i/8 < len(ar.Mask) && ar.Mask[i/8]&(1<<uint(i%8)) != 0 {
+			publics = append(publics, si.Public)
+		}
+	}
+	return publics
 }
 
 type structMessage struct {
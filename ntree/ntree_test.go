@@ -4,9 +4,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dedis/cothority/cosi/protocol"
 	"github.com/dedis/cothority/ntree"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestMain(m *testing.M) {
@@ -57,3 +61,150 @@ func TestNtree(t *testing.T) {
 		local.CloseAll()
 	}
 }
+
+func TestNtree_Aggregate(t *testing.T) {
+	nbrHosts := 7
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, tree := local.GenBigTree(nbrHosts, nbrHosts, 3, true)
+	msg := []byte("Ntree rocks collectively")
+
+	pi, err := local.CreateProtocol("NaiveTree", tree)
+	log.ErrFatal(err)
+	root := pi.(*ntree.Protocol)
+	root.Message = msg
+	root.Aggregate = true
+	done := make(chan bool)
+	root.OnDoneCallback(func() bool {
+		done <- true
+		return true
+	})
+	log.ErrFatal(pi.Start())
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Protocol didn't finish in time")
+	}
+
+	require.Nil(t, root.Signatures)
+	require.NotNil(t, root.Aggregated)
+	require.Equal(t, []byte{0x7f}, root.Aggregated.Mask)
+	log.ErrFatal(cosi.VerifySignature(network.Suite, roster.Publics(), msg,
+		root.Aggregated.Signature))
+	require.NotNil(t, cosi.VerifySignature(network.Suite, roster.Publics(),
+		[]byte("other message"), root.Aggregated.Signature))
+}
+
+func TestNtree_AggregateDeadLeaf(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(4, true)
+	dead := network.NewServerIdentity(network.Suite.Point().Mul(nil,
+		network.Suite.Scalar().Pick(random.Stream)),
+		network.NewLocalAddress("dead:2000"))
+	roster = onet.NewRoster(append(roster.List, dead))
+	tree := roster.GenerateNaryTreeWithRoot(4, roster.List[0])
+	local.Trees[tree.ID] = tree
+	msg := []byte("Ntree aggregates the living")
+
+	pi, err := local.CreateProtocol("NaiveTree", tree)
+	log.ErrFatal(err)
+	root := pi.(*ntree.Protocol)
+	root.Message = msg
+	root.Aggregate = true
+	root.Timeout = time.Second
+	done := make(chan bool)
+	root.OnDoneCallback(func() bool {
+		done <- true
+		return true
+	})
+	log.ErrFatal(pi.Start())
+	select {
+	case <-done:
+	case <-time.After(time.Second * 3):
+		t.Fatal("Protocol didn't finish in time")
+	}
+
+	require.Equal(t, []byte{0x0f}, root.Aggregated.Mask)
+	publics := root.Aggregated.Publics(roster)
+	require.Equal(t, 4, len(publics))
+	log.ErrFatal(cosi.VerifySignature(network.Suite, publics, msg,
+		root.Aggregated.Signature))
+}
+
+func TestNtree_FailedIndices(t *testing.T) {
+	for _, verify := range []int{1, 2} {
+		log.Lvl2("Running with VerifySignature", verify)
+		nbrHosts := 7
+		local := onet.NewLocalTest()
+		_, roster, _ := local.GenBigTree(nbrHosts, nbrHosts, 2, true)
+		// The last node signs with a key that doesn't match its public
+		// key in the roster.
+		bad := len(roster.List) - 1
+		list := make([]*network.ServerIdentity, len(roster.List))
+		copy(list, roster.List)
+		si := *list[bad]
+		si.Public = network.Suite.Point().Mul(nil,
+			network.Suite.Scalar().Pick(random.Stream))
+		list[bad] = &si
+		tree := onet.NewRoster(list).GenerateNaryTreeWithRoot(2, list[0])
+		local.Trees[tree.ID] = tree
+
+		pi, err := local.CreateProtocol("NaiveTree", tree)
+		log.ErrFatal(err)
+		root := pi.(*ntree.Protocol)
+		root.Message = []byte("Ntree finds the culprit")
+		root.VerifySignature = verify
+		done := make(chan bool)
+		root.OnDoneCallback(func() bool {
+			done <- true
+			return true
+		})
+		log.ErrFatal(pi.Start())
+		select {
+		case <-done:
+		case <-time.After(time.Second * 2):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		require.Equal(t, []int{bad}, root.FailedIndices)
+		local.CloseAll()
+	}
+}
+
+func TestNtree_DeadLeaf(t *testing.T) {
+	for _, threshold := range []int{4, 5} {
+		log.Lvl2("Running with threshold", threshold)
+		local := onet.NewLocalTest()
+		_, roster, _ := local.GenTree(4, true)
+		// Add a leaf that will never answer.
+		dead := network.NewServerIdentity(network.Suite.Point().Mul(nil,
+			network.Suite.Scalar().Pick(random.Stream)),
+			network.NewLocalAddress("dead:2000"))
+		list := append(roster.List, dead)
+		tree := onet.NewRoster(list).GenerateNaryTreeWithRoot(4, list[0])
+		local.Trees[tree.ID] = tree
+
+		pi, err := local.CreateProtocol("NaiveTree", tree)
+		log.ErrFatal(err)
+		root := pi.(*ntree.Protocol)
+		root.Message = []byte("Ntree doesn't wait for the dead")
+		root.Timeout = time.Second
+		root.Threshold = threshold
+		done := make(chan bool)
+		root.OnDoneCallback(func() bool {
+			done <- true
+			return true
+		})
+		log.ErrFatal(pi.Start())
+		select {
+		case <-done:
+		case <-time.After(time.Second * 2):
+			t.Fatal("Protocol didn't finish in time")
+		}
+		// The threshold of 5 can only be reached with the dead leaf, so
+		// the root finishes on timeout with 4 signatures.
+		require.Equal(t, 4, root.Collected)
+		require.Equal(t, 4, len(root.Signatures))
+		local.CloseAll()
+	}
+}
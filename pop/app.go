@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"os"
 	"path"
@@ -45,8 +46,11 @@ type Config struct {
 	OrgPublic abstract.Point
 	// Private key of org. Used for authentication
 	OrgPrivate abstract.Scalar
-	// Address of the linked conode.
+	// Address of the first linked conode. It is kept for compatibility
+	// with older config-files, use Addresses instead.
 	Address network.Address
+	// Addresses of all linked conodes, tried in turn.
+	Addresses []network.Address
 	// Map of Final statements of the parties.
 	// indexed by hash of party desciption
 	Parties map[string]*PartyConfig
@@ -133,7 +137,7 @@ func orgLink(c *cli.Context) error {
 		}
 		return err
 	}
-	cfg.Address = addr
+	cfg.addAddress(addr)
 	log.Lvl3("Successfully linked with", addr)
 	cfg.write()
 	return nil
@@ -147,38 +151,27 @@ func orgConfig(c *cli.Context) error {
 		merge_party.toml`)
 	}
 	cfg, client := getConfigClient(c)
-	if cfg.Address.String() == "" {
+	if len(cfg.Addresses) == 0 {
 		log.Fatal("No address")
 		return errors.New("No address found - please link first")
 	}
-	desc := &service.PopDesc{}
-	pdFile := c.Args().First()
-	buf, err := ioutil.ReadFile(pdFile)
-	log.ErrFatal(err, "While reading", pdFile)
-	err = decodePopDesc(string(buf), desc)
-	log.ErrFatal(err, "While decoding", pdFile)
-	if c.NArg() == 2 {
-		mergeFile := c.Args().Get(1)
-		buf, err = ioutil.ReadFile(mergeFile)
-		log.ErrFatal(err, "While reading", mergeFile)
-		desc.Parties, err = decodeGroups(string(buf))
-		log.ErrFatal(err, "While decoding ", mergeFile)
-
-		// Check that current party is included in merge config
-		found := false
-		for _, party := range desc.Parties {
-			if service.Equal(desc.Roster, party.Roster) {
-				found = true
-				break
-			}
-		}
-		if !found {
-			log.Fatal("party is not included in merge config")
-		}
-	}
+	desc, err := readPopDesc(c.Args().First(), c.Args().Get(1))
+	log.ErrFatal(err)
 	hash := base64.StdEncoding.EncodeToString(desc.Hash())
 	log.Lvlf2("Hash of config: %s", hash)
-	log.ErrFatal(client.StoreConfig(cfg.Address, desc, cfg.OrgPrivate))
+	// Store the config on all linked conodes, so that every one of them
+	// can be used later on.
+	stored := 0
+	for _, addr := range cfg.Addresses {
+		if err := client.StoreConfig(addr, desc, cfg.OrgPrivate); err != nil {
+			log.Warn("Couldn't store config on", addr, err)
+			continue
+		}
+		stored++
+	}
+	if stored == 0 {
+		log.Fatal("Couldn't store config on any linked conode")
+	}
 	if val, ok := cfg.Parties[hash]; !ok {
 		kp := config.NewKeyPair(network.Suite)
 		cfg.Parties[hash] = &PartyConfig{
@@ -198,6 +191,19 @@ func orgConfig(c *cli.Context) error {
 	return nil
 }
 
+// orgValidate reads and validates the description of a party and prints its
+// hash, without contacting any conode.
+func orgValidate(c *cli.Context) error {
+	log.Lvl3("Org: Validate")
+	if c.NArg() < 1 {
+		log.Fatal("Please give pop_desc.toml and (optionaly) merge_party.toml")
+	}
+	desc, err := readPopDesc(c.Args().First(), c.Args().Get(1))
+	log.ErrFatal(err)
+	log.Info("Hash of config:", base64.StdEncoding.EncodeToString(desc.Hash()))
+	return nil
+}
+
 // adds a public key to the list
 func orgPublic(c *cli.Context) error {
 	if c.NArg() < 2 {
@@ -218,20 +224,40 @@ func orgPublic(c *cli.Context) error {
 	cfg, _ := getConfigClient(c)
 	party, err := cfg.getPartybyHash(c.Args().Get(1))
 	log.ErrFatal(err)
+	added, skipped := party.addAttendees(keys)
+	cfg.write()
+	log.Infof("Added %d public keys, skipped %d", added, skipped)
+	return nil
+}
+
+// addAttendees appends the base64-encoded public keys to the attendees of
+// the party. Keys that are invalid or already present are skipped with a
+// warning. It returns how many keys were added and skipped.
+func (party *PartyConfig) addAttendees(keys []string) (added, skipped int) {
+	null := network.Suite.Point().Null()
 	for _, k := range keys {
 		pub, err := crypto.String64ToPoint(network.Suite, k)
-		if err != nil {
-			log.Fatal("Couldn't parse public key:", k, err)
+		if err != nil || pub.Equal(null) {
+			log.Warn("Skipping invalid public key:", k)
+			skipped++
+			continue
 		}
+		duplicate := false
 		for _, p := range party.Final.Attendees {
 			if p.Equal(pub) {
-				log.Fatal("This key already exists")
+				duplicate = true
+				break
 			}
 		}
+		if duplicate {
+			log.Warn("Skipping existing public key:", k)
+			skipped++
+			continue
+		}
 		party.Final.Attendees = append(party.Final.Attendees, pub)
+		added++
 	}
-	cfg.write()
-	return nil
+	return
 }
 
 // finalizes the statement
@@ -245,7 +271,7 @@ func orgFinal(c *cli.Context) error {
 	if len(cfg.Parties) == 0 {
 		log.Fatal("No configs stored - first store at least one")
 	}
-	if cfg.Address == "" {
+	if len(cfg.Addresses) == 0 {
 		log.Fatal("Not linked")
 	}
 	party, err := cfg.getPartybyHash(c.Args().First())
@@ -256,9 +282,16 @@ func orgFinal(c *cli.Context) error {
 		log.Lvl2("Final statement already here:\n", "\n"+string(finst))
 		return nil
 	}
-	fs, cerr := client.Finalize(cfg.Address, party.Final.Desc,
-		party.Final.Attendees, cfg.OrgPrivate)
-	log.ErrFatal(cerr)
+	var fs *service.FinalStatement
+	log.ErrFatal(cfg.tryLinked(func(addr network.Address) error {
+		var cerr onet.ClientError
+		fs, cerr = client.Finalize(addr, party.Final.Desc,
+			party.Final.Attendees, cfg.OrgPrivate)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	}))
 	party.Final = fs
 	cfg.write()
 	finst, err := fs.ToToml()
@@ -274,7 +307,7 @@ func orgMerge(c *cli.Context) error {
 		log.Fatal("Please give party-hash")
 	}
 	cfg, client := getConfigClient(c)
-	if cfg.Address == "" {
+	if len(cfg.Addresses) == 0 {
 		log.Fatal("Not linked")
 	}
 	party, err := cfg.getPartybyHash(c.Args().First())
@@ -282,7 +315,7 @@ func orgMerge(c *cli.Context) error {
 	if len(party.Final.Signature) <= 0 || party.Final.Verify() != nil {
 		log.Lvl2("The local config is not finished yet")
 		log.Lvl2("Fetching final statement")
-		fs, err := client.FetchFinal(cfg.Address, party.Final.Desc.Hash())
+		fs, err := cfg.fetchFinal(client, party.Final.Desc.Hash())
 		log.ErrFatal(err)
 		if len(fs.Signature) <= 0 || fs.Verify() != nil {
 			log.Fatal("Fetched final statement is invalid")
@@ -300,7 +333,15 @@ func orgMerge(c *cli.Context) error {
 		log.Fatal("there is no parties to merge")
 	}
 
-	fs, err := client.Merge(cfg.Address, party.Final.Desc, cfg.OrgPrivate)
+	var fs *service.FinalStatement
+	err = cfg.tryLinked(func(addr network.Address) error {
+		var cerr onet.ClientError
+		fs, cerr = client.Merge(addr, party.Final.Desc, cfg.OrgPrivate)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -312,6 +353,36 @@ func orgMerge(c *cli.Context) error {
 	return nil
 }
 
+// lists the parties stored on the first linked conode that answers
+func orgList(c *cli.Context) error {
+	log.Lvl3("Org: List")
+	cfg, client := getConfigClient(c)
+	if len(cfg.Addresses) == 0 {
+		log.Fatal("Not linked")
+	}
+	var parties []*service.PartyInfo
+	log.ErrFatal(cfg.tryLinked(func(addr network.Address) error {
+		var cerr onet.ClientError
+		parties, cerr = client.ListParties(addr, cfg.OrgPrivate)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	}))
+	for _, p := range parties {
+		status := ""
+		if p.Finalized {
+			status += " finalized"
+		}
+		if p.Merged {
+			status += " merged"
+		}
+		log.Infof("%s: %s, %s, %s%s", base64.StdEncoding.EncodeToString(p.ID),
+			p.Name, p.DateTime, p.Location, status)
+	}
+	return nil
+}
+
 // creates a new private/public pair
 func attCreate(c *cli.Context) error {
 	priv := network.Suite.NewKey(random.Stream)
@@ -348,12 +419,12 @@ func attJoin(c *cli.Context) error {
 	log.ErrFatal(err)
 	if len(final.Signature) <= 0 || final.Verify() != nil {
 		log.Lvl2("The local config is not finished yet")
-		if cfg.Address != "" {
+		if len(cfg.Addresses) > 0 {
 			log.Lvl2("Fetching final statement")
 			// Need to get the updated version of party config
 			// Cause attendee doesn't know,
 			// whether it has finished successfully or not
-			fs, err := client.FetchFinal(cfg.Address, final.Desc.Hash())
+			fs, err := cfg.fetchFinal(client, final.Desc.Hash())
 			log.ErrFatal(err)
 			if len(fs.Signature) <= 0 || fs.Verify() != nil {
 				log.Fatal("Fetched final statement is invalid")
@@ -366,9 +437,9 @@ func attJoin(c *cli.Context) error {
 
 	if len(final.Desc.Parties) > 0 && !final.Merged {
 		log.Lvl2("The local party is not merged yet")
-		if cfg.Address != "" {
+		if len(cfg.Addresses) > 0 {
 			log.Lvl2("Fetching final statement")
-			fs, err := client.FetchFinal(cfg.Address, final.Desc.Hash())
+			fs, err := cfg.fetchFinal(client, final.Desc.Hash())
 			log.ErrFatal(err)
 			if !fs.Merged {
 				log.Fatal("Global party is not merged")
@@ -442,11 +513,7 @@ func attSign(c *cli.Context) error {
 
 	msg := []byte(c.Args().First())
 	ctx := []byte(c.Args().Get(1))
-	Set := anon.Set(party.Final.Attendees)
-	sigtag := anon.Sign(network.Suite, random.Stream, msg,
-		Set, ctx, party.Index, party.Private)
-	sig := sigtag[:len(sigtag)-service.SIGSIZE/2]
-	tag := sigtag[len(sigtag)-service.SIGSIZE/2:]
+	sig, tag := signMsg(party, msg, ctx)
 	log.Lvlf2("\nSignature: %s\nTag: %s", base64.StdEncoding.EncodeToString(sig),
 		base64.StdEncoding.EncodeToString(tag))
 	return nil
@@ -472,14 +539,129 @@ func attVerify(c *cli.Context) error {
 	log.ErrFatal(err)
 	tag, err := base64.StdEncoding.DecodeString(c.Args().Get(3))
 	log.ErrFatal(err)
-	sigtag := append(sig, tag...)
-	ctag, err := anon.Verify(network.Suite, msg,
-		anon.Set(party.Final.Attendees), ctx, sigtag)
+	log.ErrFatal(verifyMsg(party.Final, msg, ctx, sig, tag))
+	log.Lvl3("Successfully verified signature and tag")
+	return nil
+}
+
+// signs a message + context and writes the signature, the tag and the final
+// statement to a file, so that it can be verified offline.
+func attExport(c *cli.Context) error {
+	log.Lvl3("att: export")
+	cfg, _ := getConfigClient(c)
+	if c.NArg() < 4 {
+		log.Fatal("Please give party hash, msg, context and output file")
+	}
+	party, err := cfg.getPartybyHash(c.Args().First())
+	log.ErrFatal(err)
+
+	if party.Index == -1 || party.Private == nil || party.Public == nil ||
+		!network.Suite.Point().Mul(nil, party.Private).Equal(party.Public) {
+		log.Fatal("No public key stored. Please join a party")
+	}
+
+	if len(party.Final.Signature) <= 0 || party.Final.Verify() != nil {
+		log.Fatal("Party is not finilized or signature is not valid")
+	}
+
+	tb, err := newTokenBundle(party, []byte(c.Args().Get(1)),
+		[]byte(c.Args().Get(2)))
+	log.ErrFatal(err)
+	buf, err := json.Marshal(tb)
+	log.ErrFatal(err)
+	log.ErrFatal(ioutil.WriteFile(c.Args().Get(3), buf, 0660))
+	log.Lvl2("Exported token to", c.Args().Get(3))
+	return nil
+}
+
+// verifies an exported token without contacting any conode. The token must
+// belong to the party with the given hash, else anybody could create their
+// own party and sign the token with it.
+func attVerifyExport(c *cli.Context) error {
+	log.Lvl3("att: verifyExport")
+	if c.NArg() < 2 {
+		log.Fatal("Please give the exported token file and the party hash")
+	}
+	buf, err := ioutil.ReadFile(c.Args().First())
+	log.ErrFatal(err)
+	party, err := base64.StdEncoding.DecodeString(c.Args().Get(1))
 	log.ErrFatal(err)
+	tb := &tokenBundle{}
+	log.ErrFatal(json.Unmarshal(buf, tb))
+	log.ErrFatal(tb.verify(party))
+	log.Infof("Verified signature and tag on message %q with context %q "+
+		"of party %s", tb.Message, tb.Context, c.Args().Get(1))
+	return nil
+}
+
+// tokenBundle holds everything a verifier needs to check a pop-token
+// offline: the message, the context, the signature, the tag and the final
+// statement in its toml-representation.
+type tokenBundle struct {
+	Message   []byte
+	Context   []byte
+	Signature []byte
+	Tag       []byte
+	Final     string
+}
+
+// newTokenBundle signs msg and ctx with the key of the attendee and returns a
+// bundle that can be verified offline.
+func newTokenBundle(party *PartyConfig, msg, ctx []byte) (*tokenBundle, error) {
+	final, err := party.Final.ToToml()
+	if err != nil {
+		return nil, err
+	}
+	sig, tag := signMsg(party, msg, ctx)
+	return &tokenBundle{
+		Message:   msg,
+		Context:   ctx,
+		Signature: sig,
+		Tag:       tag,
+		Final:     string(final),
+	}, nil
+}
+
+// verify checks that the final statement belongs to the party with the
+// given hash, its collective signature, and the signature and tag of the
+// bundle against the attendees of the final statement.
+func (tb *tokenBundle) verify(party []byte) error {
+	final, err := service.NewFinalStatementFromToml([]byte(tb.Final))
+	if err != nil {
+		return err
+	}
+	if final.Desc == nil || !bytes.Equal(final.Desc.Hash(), party) {
+		return errors.New("token is not from the given party")
+	}
+	if len(final.Signature) <= 0 || final.Verify() != nil {
+		return errors.New("final statement is not finalized or signature is not valid")
+	}
+	return verifyMsg(final, tb.Message, tb.Context, tb.Signature, tb.Tag)
+}
+
+// signMsg creates a linkable ring signature on msg and ctx and returns the
+// signature and the tag.
+func signMsg(party *PartyConfig, msg, ctx []byte) (sig, tag []byte) {
+	Set := anon.Set(party.Final.Attendees)
+	sigtag := anon.Sign(network.Suite, random.Stream, msg,
+		Set, ctx, party.Index, party.Private)
+	sig = sigtag[:len(sigtag)-service.SIGSIZE/2]
+	tag = sigtag[len(sigtag)-service.SIGSIZE/2:]
+	return
+}
+
+// verifyMsg checks that sig and tag have been created by one of the
+// attendees of final on msg and ctx.
+func verifyMsg(final *service.FinalStatement, msg, ctx, sig, tag []byte) error {
+	sigtag := append(append([]byte{}, sig...), tag...)
+	ctag, err := anon.Verify(network.Suite, msg,
+		anon.Set(final.Attendees), ctx, sigtag)
+	if err != nil {
+		return err
+	}
 	if !bytes.Equal(tag, ctag) {
-		log.Fatalf("Tag and calculated tag are not equal:\n%x - %x", tag, ctag)
+		return fmt.Errorf("Tag and calculated tag are not equal:\n%x - %x", tag, ctag)
 	}
-	log.Lvl3("Successfully verified signature and tag")
 	return nil
 }
 
@@ -549,6 +731,9 @@ func newConfig(fileConfig string) (*Config, error) {
 	if cfg.Parties == nil {
 		cfg.Parties = make(map[string]*PartyConfig)
 	}
+	if cfg.Address != "" && len(cfg.Addresses) == 0 {
+		cfg.Addresses = []network.Address{cfg.Address}
+	}
 	cfg.name = name
 	return cfg, nil
 }
@@ -560,6 +745,48 @@ func (cfg *Config) write() {
 	log.ErrFatal(ioutil.WriteFile(cfg.name, buf, 0660))
 }
 
+// addAddress appends addr to the linked conodes if it is not already linked.
+func (cfg *Config) addAddress(addr network.Address) {
+	for _, a := range cfg.Addresses {
+		if a == addr {
+			return
+		}
+	}
+	cfg.Addresses = append(cfg.Addresses, addr)
+	cfg.Address = cfg.Addresses[0]
+}
+
+// tryLinked calls f with every linked conode in turn until one of them
+// succeeds. If all conodes fail, the error of the last one is returned.
+func (cfg *Config) tryLinked(f func(addr network.Address) error) error {
+	if len(cfg.Addresses) == 0 {
+		return errors.New("not linked to any conode")
+	}
+	var err error
+	for _, addr := range cfg.Addresses {
+		if err = f(addr); err == nil {
+			return nil
+		}
+		log.Lvl2("Linked conode", addr, "failed:", err)
+	}
+	return err
+}
+
+// fetchFinal fetches the final statement from the first linked conode that
+// answers.
+func (cfg *Config) fetchFinal(client *service.Client, hash []byte) (*service.FinalStatement, error) {
+	var fs *service.FinalStatement
+	err := cfg.tryLinked(func(addr network.Address) error {
+		var cerr onet.ClientError
+		fs, cerr = client.FetchFinal(addr, hash)
+		if cerr != nil {
+			return cerr
+		}
+		return nil
+	})
+	return fs, err
+}
+
 func (cfg *Config) getPartybyHash(hash string) (*PartyConfig, error) {
 	if val, ok := cfg.Parties[hash]; ok {
 		return val, nil
@@ -588,6 +815,63 @@ type PopDescGroupToml struct {
 	Servers  []*app.ServerToml `toml:"servers"`
 }
 
+// readPopDesc reads the description of a party from pdFile and checks that
+// it has a valid roster. If mergeFile is not empty, the parties to merge with
+// are read from it and the party from pdFile must be one of them.
+func readPopDesc(pdFile, mergeFile string) (*service.PopDesc, error) {
+	buf, err := ioutil.ReadFile(pdFile)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %s", pdFile, err)
+	}
+	desc := &service.PopDesc{}
+	if err = decodePopDesc(string(buf), desc); err != nil {
+		return nil, fmt.Errorf("while decoding %s: %s", pdFile, err)
+	}
+	if err = checkRoster(desc.Roster); err != nil {
+		return nil, fmt.Errorf("invalid roster in %s: %s", pdFile, err)
+	}
+	if mergeFile == "" {
+		return desc, nil
+	}
+	buf, err = ioutil.ReadFile(mergeFile)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %s", mergeFile, err)
+	}
+	desc.Parties, err = decodeGroups(string(buf))
+	if err != nil {
+		return nil, fmt.Errorf("while decoding %s: %s", mergeFile, err)
+	}
+	// Check that current party is included in merge config
+	found := false
+	for _, party := range desc.Parties {
+		if err = checkRoster(party.Roster); err != nil {
+			return nil, fmt.Errorf("invalid roster for %s in %s: %s",
+				party.Location, mergeFile, err)
+		}
+		if service.Equal(desc.Roster, party.Roster) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.New("party is not included in merge config")
+	}
+	return desc, nil
+}
+
+// checkRoster returns an error if the roster is empty or holds an invalid
+// address.
+func checkRoster(roster *onet.Roster) error {
+	if roster == nil || len(roster.List) == 0 {
+		return errors.New("no servers")
+	}
+	for _, si := range roster.List {
+		if !si.Address.Valid() {
+			return errors.New("invalid address " + si.Address.String())
+		}
+	}
+	return nil
+}
+
 func decodePopDesc(buf string, desc *service.PopDesc) error {
 	descGroup := &PopDescGroupToml{}
 	_, err := toml.Decode(buf, descGroup)
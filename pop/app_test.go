@@ -1,13 +1,24 @@
 package main
 
 import (
+	"errors"
 	"io/ioutil"
 	"testing"
 
 	"os"
 
+	"encoding/json"
+
+	"github.com/dedis/cothority/pop/service"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestConfigNew(t *testing.T) {
@@ -29,7 +40,208 @@ func TestConfigNew(t *testing.T) {
 	require.Equal(t, "127.0.0.1:3123", string(cfg.Address))
 }
 
+func TestConfigAddresses(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "config")
+	log.ErrFatal(err)
+	tmp.Close()
+	os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name())
+	cfg, err := newConfig(tmp.Name())
+	log.ErrFatal(err)
+	first := network.NewTCPAddress("127.0.0.1:2002")
+	second := network.NewTCPAddress("127.0.0.1:2004")
+	cfg.addAddress(first)
+	cfg.addAddress(second)
+	cfg.addAddress(first)
+	require.Equal(t, []network.Address{first, second}, cfg.Addresses)
+	require.Equal(t, first, cfg.Address)
+	cfg.write()
+
+	cfg, err = newConfig(tmp.Name())
+	log.ErrFatal(err)
+	require.Equal(t, []network.Address{first, second}, cfg.Addresses)
+
+	// Old config-files only have the Address.
+	cfg.Addresses = nil
+	cfg.write()
+	cfg, err = newConfig(tmp.Name())
+	log.ErrFatal(err)
+	require.Equal(t, []network.Address{first}, cfg.Addresses)
+}
+
+func TestConfigTryLinked(t *testing.T) {
+	cfg := &Config{}
+	require.NotNil(t, cfg.tryLinked(func(network.Address) error {
+		return nil
+	}))
+
+	l := onet.NewTCPTest()
+	defer l.CloseAll()
+	servers, _, _ := l.GenTree(1, true)
+	unreachable := network.NewTCPAddress("127.0.0.1:2")
+	reachable := servers[0].ServerIdentity.Address
+	cfg.addAddress(unreachable)
+	cfg.addAddress(reachable)
+
+	// The first conode is unreachable, so the request must succeed on the
+	// second one.
+	client := service.NewClient()
+	var tried []network.Address
+	err := cfg.tryLinked(func(addr network.Address) error {
+		tried = append(tried, addr)
+		// A reachable conode answers that it doesn't know the party.
+		_, cerr := client.FetchFinal(addr, []byte{})
+		if cerr != nil && cerr.ErrorCode() != service.ErrorInternal {
+			return cerr
+		}
+		return nil
+	})
+	log.ErrFatal(err)
+	require.Equal(t, []network.Address{unreachable, reachable}, tried)
+
+	// All conodes failing returns the last error.
+	err = cfg.tryLinked(func(network.Address) error {
+		return errors.New("failed")
+	})
+	require.NotNil(t, err)
+}
+
 func TestMainFunc(t *testing.T) {
 	os.Args = []string{os.Args[0], "--help"}
 	main()
 }
+
+func TestTokenBundle(t *testing.T) {
+	ed := eddsa.NewEdDSA(random.Stream)
+	si := network.NewServerIdentity(ed.Public, network.NewAddress(network.PlainTCP, "0:2000"))
+	atts := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	final := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: []abstract.Point{atts[0].Public, atts[1].Public},
+	}
+	h, err := final.Hash()
+	log.ErrFatal(err)
+	final.Signature, err = ed.Sign(h)
+	log.ErrFatal(err)
+	party := &PartyConfig{
+		Private: atts[1].Secret,
+		Public:  atts[1].Public,
+		Index:   1,
+		Final:   final,
+	}
+
+	tb, err := newTokenBundle(party, []byte("msg"), []byte("ctx"))
+	log.ErrFatal(err)
+	buf, err := json.Marshal(tb)
+	log.ErrFatal(err)
+
+	tb2 := &tokenBundle{}
+	log.ErrFatal(json.Unmarshal(buf, tb2))
+	log.ErrFatal(tb2.verify(final.Desc.Hash()))
+
+	// Changing the message must break the verification
+	tb2.Message = []byte("other msg")
+	require.NotNil(t, tb2.verify(final.Desc.Hash()))
+
+	// A self-signed final statement of another party is rejected
+	edForeign := eddsa.NewEdDSA(random.Stream)
+	siForeign := network.NewServerIdentity(edForeign.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	foreign := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{siForeign}),
+		},
+		Attendees: final.Attendees,
+	}
+	h, err = foreign.Hash()
+	log.ErrFatal(err)
+	foreign.Signature, err = edForeign.Sign(h)
+	log.ErrFatal(err)
+	tbForeign, err := newTokenBundle(&PartyConfig{
+		Private: atts[1].Secret,
+		Public:  atts[1].Public,
+		Index:   1,
+		Final:   foreign,
+	}, []byte("msg"), []byte("ctx"))
+	log.ErrFatal(err)
+	log.ErrFatal(tbForeign.verify(foreign.Desc.Hash()))
+	require.NotNil(t, tbForeign.verify(final.Desc.Hash()))
+
+	// A final statement without valid collective signature is rejected
+	final.Signature = []byte{}
+	tb3, err := newTokenBundle(party, []byte("msg"), []byte("ctx"))
+	log.ErrFatal(err)
+	require.NotNil(t, tb3.verify(final.Desc.Hash()))
+}
+
+func TestPartyConfig_AddAttendees(t *testing.T) {
+	party := &PartyConfig{Index: -1, Final: &service.FinalStatement{}}
+	var keys []string
+	for i := 0; i < 3; i++ {
+		kp := config.NewKeyPair(network.Suite)
+		str, err := crypto.PointToString64(nil, kp.Public)
+		log.ErrFatal(err)
+		keys = append(keys, str)
+	}
+	null, err := crypto.PointToString64(nil, network.Suite.Point().Null())
+	log.ErrFatal(err)
+
+	added, skipped := party.addAttendees([]string{keys[0], keys[1], keys[0]})
+	require.Equal(t, 2, added)
+	require.Equal(t, 1, skipped)
+	require.Equal(t, 2, len(party.Final.Attendees))
+
+	// Re-running with the same keys only adds the new ones.
+	added, skipped = party.addAttendees(append(keys, "notakey", null))
+	require.Equal(t, 1, added)
+	require.Equal(t, 4, skipped)
+	require.Equal(t, 3, len(party.Final.Attendees))
+}
+
+func TestReadPopDesc(t *testing.T) {
+	kp := config.NewKeyPair(network.Suite)
+	public, err := crypto.PubToString64(network.Suite, kp.Public)
+	log.ErrFatal(err)
+	writeTmp := func(content string) string {
+		tmp, err := ioutil.TempFile("", "pop_desc")
+		log.ErrFatal(err)
+		_, err = tmp.WriteString(content)
+		log.ErrFatal(err)
+		tmp.Close()
+		return tmp.Name()
+	}
+	header := `Name = "Proof-of-Personhood Party"
+DateTime = "2017-08-08 15:00 UTC"
+Location = "Earth, City"
+`
+	valid := writeTmp(header + `[[servers]]
+  Address = "tcp://127.0.0.1:2002"
+  Public = "` + public + `"
+  Description = "Conode_1"
+`)
+	defer os.Remove(valid)
+	desc, err := readPopDesc(valid, "")
+	log.ErrFatal(err)
+	require.Equal(t, 1, len(desc.Roster.List))
+	require.True(t, desc.Roster.List[0].Public.Equal(kp.Public))
+
+	noServers := writeTmp(header)
+	defer os.Remove(noServers)
+	_, err = readPopDesc(noServers, "")
+	require.NotNil(t, err)
+
+	badPublic := writeTmp(header + `[[servers]]
+  Address = "tcp://127.0.0.1:2002"
+  Public = "not a key"
+`)
+	defer os.Remove(badPublic)
+	_, err = readPopDesc(badPublic, "")
+	require.NotNil(t, err)
+}
@@ -29,6 +29,13 @@ func init() {
 				ArgsUsage: "pop_desc.toml [merged_party.toml]",
 				Action:    orgConfig,
 			},
+			{
+				Name:      "validate",
+				Aliases:   []string{"v"},
+				Usage:     "validates the configuration without storing it",
+				ArgsUsage: "pop_desc.toml [merged_party.toml]",
+				Action:    orgValidate,
+			},
 			{
 				Name:      "public",
 				Aliases:   []string{"p"},
@@ -50,6 +57,12 @@ func init() {
 				ArgsUsage: "party_hash",
 				Action:    orgMerge,
 			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "lists all parties stored on the linked conode",
+				Action:  orgList,
+			},
 		},
 	}
 
@@ -91,6 +104,20 @@ func init() {
 				ArgsUsage: "message context signature tag party_hash",
 				Action:    attVerify,
 			},
+			{
+				Name:      "export",
+				Aliases:   []string{"e"},
+				Usage:     "sign a message and its context and export it for offline verification",
+				ArgsUsage: "party_hash message context output_file",
+				Action:    attExport,
+			},
+			{
+				Name:      "verifyExport",
+				Aliases:   []string{"ve"},
+				Usage:     "verifies an exported token offline",
+				ArgsUsage: "token_file party_hash",
+				Action:    attVerifyExport,
+			},
 		},
 	}
 	commandAuth = cli.Command{
@@ -3,6 +3,8 @@ package service
 import (
 	"bytes"
 	"errors"
+	"strconv"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/satori/go.uuid"
@@ -33,11 +35,17 @@ const (
 	// ErrorMergeInProgress indicates that there was an attempt
 	// to launch proccess twice on the same node
 	ErrorMergeInProgress
+	// ErrorPinLockout indicates that too many wrong PINs have been sent
+	// and PinRequest is locked for some time
+	ErrorPinLockout
 )
 
 func init() {
 	network.RegisterMessage(&FinalStatement{})
 	network.RegisterMessage(&PopDesc{})
+	network.RegisterMessage(&FinalMeta{})
+	network.RegisterMessage(&PartyList{})
+	network.RegisterMessage(&VerifyTokenReply{})
 }
 
 // Client is a structure to communicate with any app that wants to use our
@@ -86,6 +94,52 @@ func (c *Client) FetchFinal(dst network.Address, hash []byte) (
 	return res.Final, nil
 }
 
+// FetchFinalMeta returns a summary of the final statement with the given
+// hash, without the public keys of the attendees.
+func (c *Client) FetchFinalMeta(dst network.Address, hash []byte) (
+	*FinalMeta, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &FinalMeta{}
+	err := c.SendProtobuf(si, &fetchMetaRequest{hash}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListParties returns a summary of all parties stored on the conode. The
+// request is signed with the private key of the organizer linked to the
+// conode.
+func (c *Client) ListParties(dst network.Address, priv abstract.Scalar) (
+	[]*PartyInfo, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	req := &listRequest{Time: time.Now().Unix()}
+	sg, err := crypto.SignSchnorr(network.Suite, priv, req.hash())
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature = sg
+	res := &PartyList{}
+	if cerr := c.SendProtobuf(si, req, res); cerr != nil {
+		return nil, cerr
+	}
+	return res.Parties, nil
+}
+
+// VerifyToken asks the conode to verify the signature and tag created by an
+// attendee of the party descID on msg in the context ctx. The conode must
+// hold the final statement of the party.
+func (c *Client) VerifyToken(dst network.Address, descID, msg, ctx, sig,
+	tag []byte) (*VerifyTokenReply, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &VerifyTokenReply{}
+	err := c.SendProtobuf(si, &verifyTokenRequest{descID, msg, ctx, sig, tag}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // Finalize takes the address of the conode-server, a pop-description and a
 // list of attendees public keys. It contacts the other conodes and checks
 // if they are available and already have a description. If so, all attendees
@@ -135,6 +189,30 @@ func (c *Client) Merge(dst network.Address, p *PopDesc, priv abstract.Scalar) (
 	return res.Final, nil
 }
 
+// RevokeAttendee takes the address of the conode-server, the hash of the
+// pop-description, the public key of the attendee to revoke and the private
+// key of the organizer. It returns the new final statement without the
+// attendee, signed again by all conodes.
+func (c *Client) RevokeAttendee(dst network.Address, descHash []byte,
+	attendee abstract.Point, priv abstract.Scalar) (*FinalStatement, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	req := &revokeRequest{DescID: descHash, Attendee: attendee}
+	hash, err := req.hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, hash)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &finalizeResponse{}
+	e := c.SendProtobuf(si, req, res)
+	if e != nil {
+		return nil, e
+	}
+	return res.Final, nil
+}
+
 // FinalStatement is the final configuration holding all data necessary
 // for a verifier.
 type FinalStatement struct {
@@ -146,6 +224,50 @@ type FinalStatement struct {
 	Signature []byte
 	// Flag indicates, that party was merged
 	Merged bool
+	// Abstained holds the indices in Desc.Roster of the conodes that didn't
+	// agree on the attendees. They are only allowed if Desc.Threshold is
+	// set, and the signature is created by the other conodes.
+	Abstained []int
+}
+
+// FinalMeta summarizes a FinalStatement without the attendees' public keys.
+type FinalMeta struct {
+	// Desc is the description of the pop-party.
+	Desc *PopDesc
+	// Merged is true if the party was merged
+	Merged bool
+	// Attendees is the number of attendees
+	Attendees int
+	// Signed is true if the final statement has a valid signature
+	Signed bool
+}
+
+// VerifyTokenReply is returned by VerifyToken.
+type VerifyTokenReply struct {
+	// Valid is true if the signature and tag have been created by an
+	// attendee of the party.
+	Valid bool
+	// Replayed is true if the tag of a valid token has been seen before in
+	// the same context.
+	Replayed bool
+}
+
+// PartyInfo summarizes a party stored on a conode.
+type PartyInfo struct {
+	// ID is the hash of the description of the party.
+	ID       []byte
+	Name     string
+	DateTime string
+	Location string
+	// Merged is true if the party was merged
+	Merged bool
+	// Finalized is true if the final statement has a valid signature
+	Finalized bool
+}
+
+// PartyList is returned by ListParties.
+type PartyList struct {
+	Parties []*PartyInfo
 }
 
 // The toml-structure for (un)marshaling with toml
@@ -154,6 +276,7 @@ type finalStatementToml struct {
 	Attendees []string
 	Signature string
 	Merged    bool
+	Abstained []int
 }
 
 func newFinalStatementFromTomlStruct(fsToml *finalStatementToml) (*FinalStatement, error) {
@@ -179,6 +302,7 @@ func newFinalStatementFromTomlStruct(fsToml *finalStatementToml) (*FinalStatemen
 		Attendees: atts,
 		Signature: sig,
 		Merged:    fsToml.Merged,
+		Abstained: fsToml.Abstained,
 	}, nil
 }
 
@@ -246,11 +370,12 @@ func (desc *PopDesc) toTomlStruct() (*popDescToml, error) {
 		}
 	}
 	descToml := &popDescToml{
-		Name:     desc.Name,
-		DateTime: desc.DateTime,
-		Location: desc.Location,
-		Roster:   rostr,
-		Parties:  parties,
+		Name:      desc.Name,
+		DateTime:  desc.DateTime,
+		Location:  desc.Location,
+		Roster:    rostr,
+		Parties:   parties,
+		Threshold: desc.Threshold,
 	}
 	return descToml, nil
 }
@@ -303,11 +428,12 @@ func newPopDescFromTomlStruct(descToml *popDescToml) (*PopDesc, error) {
 	}
 
 	return &PopDesc{
-		Name:     descToml.Name,
-		DateTime: descToml.DateTime,
-		Location: descToml.Location,
-		Roster:   rostr,
-		Parties:  mparties,
+		Name:      descToml.Name,
+		DateTime:  descToml.DateTime,
+		Location:  descToml.Location,
+		Roster:    rostr,
+		Parties:   mparties,
+		Threshold: descToml.Threshold,
 	}, nil
 }
 
@@ -343,6 +469,7 @@ func (fs *FinalStatement) toTomlStruct() (*finalStatementToml, error) {
 		Attendees: atts,
 		Signature: base64.StdEncoding.EncodeToString(fs.Signature),
 		Merged:    fs.Merged,
+		Abstained: fs.Abstained,
 	}
 	return fsToml, nil
 }
@@ -383,13 +510,43 @@ func (fs *FinalStatement) Hash() ([]byte, error) {
 }
 
 // Verify checks if the collective signature is correct and has been created
-// by the roster. On success, this returns nil.
+// by the roster, except for the conodes that abstained. On success, this
+// returns nil.
 func (fs *FinalStatement) Verify() error {
 	h, err := fs.Hash()
 	if err != nil {
 		return err
 	}
-	return eddsa.Verify(fs.Desc.Roster.Aggregate, h, fs.Signature)
+	signers, err := fs.signers()
+	if err != nil {
+		return err
+	}
+	return eddsa.Verify(signers.Aggregate, h, fs.Signature)
+}
+
+// signers returns the roster of the conodes that sign the final statement.
+// It returns an error if more conodes abstained than the threshold allows.
+func (fs *FinalStatement) signers() (*onet.Roster, error) {
+	if len(fs.Abstained) == 0 {
+		return fs.Desc.Roster, nil
+	}
+	abstained := make(map[int]bool)
+	for _, i := range fs.Abstained {
+		if i < 0 || i >= len(fs.Desc.Roster.List) {
+			return nil, errors.New("abstained conode not in roster")
+		}
+		abstained[i] = true
+	}
+	var list []*network.ServerIdentity
+	for i, si := range fs.Desc.Roster.List {
+		if !abstained[i] {
+			list = append(list, si)
+		}
+	}
+	if fs.Desc.Threshold == 0 || len(list) < fs.Desc.Threshold {
+		return nil, errors.New("not enough conodes signed")
+	}
+	return onet.NewRoster(list), nil
 }
 
 // PopDesc holds the name, date and a roster of all involved conodes.
@@ -405,15 +562,19 @@ type PopDesc struct {
 	Roster *onet.Roster
 	// List of parties to be merged
 	Parties []*ShortDesc
+	// Threshold is the number of conodes that need to agree on the
+	// attendees to finalize the party. If 0, all conodes need to agree.
+	Threshold int
 }
 
 // represents a PopDesc in string-version for toml.
 type popDescToml struct {
-	Name     string
-	DateTime string
-	Location string
-	Roster   [][]string
-	Parties  []shortDescToml
+	Name      string
+	DateTime  string
+	Location  string
+	Roster    [][]string
+	Parties   []shortDescToml
+	Threshold int
 }
 
 // ShortDesc represents Short Description of Pop party
@@ -451,6 +612,9 @@ func (desc *PopDesc) Hash() []byte {
 			hash.Write(buf)
 		}
 	}
+	if desc.Threshold > 0 {
+		hash.Write([]byte(strconv.Itoa(desc.Threshold)))
+	}
 	return hash.Sum(nil)
 }
 
@@ -32,11 +32,13 @@ import (
 	"math/big"
 	"sort"
 	"strings"
+	gosync "sync"
 	"time"
 
 	"github.com/dedis/cothority/bftcosi"
 	"github.com/dedis/cothority/messaging"
 	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
@@ -51,6 +53,7 @@ func init() {
 	checkConfigReplyID = network.RegisterMessage(checkConfigReply{})
 	mergeConfigID = network.RegisterMessage(mergeConfig{})
 	mergeConfigReplyID = network.RegisterMessage(mergeConfigReply{})
+	network.RegisterMessage(&revokeData{})
 }
 
 // Name is the name to refer to the Template service from another
@@ -59,11 +62,25 @@ const Name = "PoPServer"
 const cfgName = "pop.bin"
 const bftSignFinal = "BFTFinal"
 const bftSignMerge = "PopBFTSignMerge"
+const bftSignRevoke = "PopBFTSignRevoke"
 
 const propagFinal = "PoPPropagateFinal"
 
 const timeout = 60 * time.Second
 
+// A listRequest is refused if its time differs more than listMaxSkew from
+// the time of the conode.
+const listMaxSkew = 5 * time.Minute
+
+// After pinMaxAttempts wrong PINs, PinRequest is locked for pinBackoff. Every
+// further wrong PIN doubles the lockout, up to pinBackoff << pinMaxBackoff.
+// The lockout is the same for all clients, as the handlers of onet don't get
+// the address of the client. So it is kept short enough that a client
+// guessing PINs cannot lock out the organizer for long.
+const pinMaxAttempts = 3
+const pinBackoff = time.Minute
+const pinMaxBackoff = 6
+
 // SIGSIZE size of signature
 const SIGSIZE = 64
 
@@ -93,6 +110,20 @@ type Service struct {
 	// key of map is ID of party
 	// synchronizing inside one party
 	syncs map[string]*sync
+	// pinMutex protects the Pin of data, pinFailures and pinLockout
+	pinMutex gosync.Mutex
+	// wrong PINs since the last correct one
+	pinFailures int
+	// no PIN is accepted before this time
+	pinLockout time.Time
+	// how long FinalizeRequest waits for the checkConfigReply of a conode
+	checkConfigTimeout time.Duration
+	// dataMutex protects the Finals and Tags of data, which are accessed
+	// by concurrent requests
+	dataMutex gosync.Mutex
+	// revocations holds the attendee the linked organizer asked to revoke,
+	// the key is the ID of the party. It is protected by dataMutex.
+	revocations map[string]abstract.Point
 }
 
 type saveData struct {
@@ -106,6 +137,36 @@ type saveData struct {
 	// The info used in merge process
 	// key is ID of party
 	merges map[string]*merge
+	// The tags of all verified tokens
+	// key is ID of party
+	Tags map[string]*partyTags
+}
+
+// partyTags holds the tags of the valid tokens of one party. Only the tags
+// are stored, not the messages.
+// key is the context of the tokens
+type partyTags struct {
+	Contexts map[string]*seenTags
+}
+
+// seenTags holds the tags of the valid tokens of one context.
+type seenTags struct {
+	Tags map[string]bool
+}
+
+// seen returns whether the tag has already been seen in the context and
+// stores it otherwise. The caller must hold the dataMutex of the service.
+func (pt *partyTags) seen(ctx, tag []byte) bool {
+	st, ok := pt.Contexts[string(ctx)]
+	if !ok {
+		st = &seenTags{Tags: make(map[string]bool)}
+		pt.Contexts[string(ctx)] = st
+	}
+	if st.Tags[string(tag)] {
+		return true
+	}
+	st.Tags[string(tag)] = true
+	return false
 }
 
 type merge struct {
@@ -120,20 +181,54 @@ type sync struct {
 	ccChannel chan *checkConfigReply
 	// channel to return the mergereply
 	mcChannel chan *mergeConfigReply
+	// conode FinalizeRequest currently waits a configreply from
+	waiting      *network.ServerIdentity
+	waitingMutex gosync.Mutex
+}
+
+// setWaiting records the conode whose checkConfigReply is accepted. nil
+// refuses all replies.
+func (sd *sync) setWaiting(si *network.ServerIdentity) {
+	sd.waitingMutex.Lock()
+	defer sd.waitingMutex.Unlock()
+	sd.waiting = si
 }
 
 // PinRequest prints out a pin if none is given, else it verifies it has the
 // correct pin, and if so, it stores the public key as reference.
+// After too many wrong pins, all pins are refused for some time. This
+// lockout is not per requester, see pinMaxAttempts.
 func (s *Service) PinRequest(req *PinRequest) (network.Message, onet.ClientError) {
+	s.pinMutex.Lock()
+	defer s.pinMutex.Unlock()
 	if req.Pin == "" {
-		s.data.Pin = fmt.Sprintf("%06d", random.Int(big.NewInt(1000000), random.Stream))
-		log.Info("PIN:", s.data.Pin)
+		pin := fmt.Sprintf("%06d", random.Int(big.NewInt(1000000), random.Stream))
+		s.dataMutex.Lock()
+		s.data.Pin = pin
+		s.dataMutex.Unlock()
+		log.Info("PIN:", pin)
 		return nil, onet.NewClientErrorCode(ErrorWrongPIN, "Read PIN in server-log")
 	}
+	if time.Now().Before(s.pinLockout) {
+		return nil, onet.NewClientErrorCode(ErrorPinLockout,
+			"Too many wrong PINs, try again later")
+	}
 	if req.Pin != s.data.Pin {
+		s.pinFailures++
+		if s.pinFailures >= pinMaxAttempts {
+			shift := s.pinFailures - pinMaxAttempts
+			if shift > pinMaxBackoff {
+				shift = pinMaxBackoff
+			}
+			s.pinLockout = time.Now().Add(pinBackoff << uint(shift))
+			log.Warn("Too many wrong PINs, locking until", s.pinLockout)
+		}
 		return nil, onet.NewClientErrorCode(ErrorWrongPIN, "Wrong PIN")
 	}
+	s.pinFailures = 0
+	s.dataMutex.Lock()
 	s.data.Public = req.Public
+	s.dataMutex.Unlock()
 	s.save()
 	log.Lvl1("Successfully registered PIN/Public", s.data.Pin, req.Public)
 	return nil, nil
@@ -152,7 +247,8 @@ func (s *Service) StoreConfig(req *storeConfig) (network.Message, onet.ClientErr
 	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature"+err.Error())
 	}
-	s.data.Finals[string(hash)] = &FinalStatement{Desc: req.Desc, Signature: []byte{}}
+	final := &FinalStatement{Desc: req.Desc, Signature: []byte{}}
+	s.setFinal(string(hash), final)
 	s.syncs[string(hash)] = &sync{
 		ccChannel: make(chan *checkConfigReply, 1),
 		mcChannel: make(chan *mergeConfigReply, 1),
@@ -161,7 +257,7 @@ func (s *Service) StoreConfig(req *storeConfig) (network.Message, onet.ClientErr
 		meta := newMerge()
 		s.data.merges[string(hash)] = meta
 		// party is merged with itself already
-		meta.statementsMap[string(hash)] = s.data.Finals[string(hash)]
+		meta.statementsMap[string(hash)] = final
 	}
 	s.save()
 	return &storeConfigReply{hash}, nil
@@ -170,6 +266,8 @@ func (s *Service) StoreConfig(req *storeConfig) (network.Message, onet.ClientErr
 // FinalizeRequest returns the FinalStatement if all conodes already received
 // a PopDesc and signed off. The FinalStatement holds the updated PopDesc, the
 // pruned attendees-public-key-list and the collective signature.
+// If the PopDesc has a Threshold, it is enough that this many conodes agree,
+// and the other conodes are recorded as abstained.
 func (s *Service) FinalizeRequest(req *finalizeRequest) (network.Message, onet.ClientError) {
 	log.Lvlf2("Finalize: %s %+v", s.Context.ServerIdentity(), req)
 	if s.data.Public == nil {
@@ -185,7 +283,7 @@ func (s *Service) FinalizeRequest(req *finalizeRequest) (network.Message, onet.C
 
 	var final *FinalStatement
 	var ok bool
-	if final, ok = s.data.Finals[string(req.DescID)]; !ok || final == nil || final.Desc == nil {
+	if final, ok = s.getFinal(string(req.DescID)); !ok || final == nil || final.Desc == nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
 	}
 	if final.Verify() == nil {
@@ -196,23 +294,64 @@ func (s *Service) FinalizeRequest(req *finalizeRequest) (network.Message, onet.C
 	// Contact all other nodes and ask them if they already have a config.
 	final.Attendees = make([]abstract.Point, len(req.Attendees))
 	copy(final.Attendees, req.Attendees)
+	final.Abstained = nil
 	cc := &checkConfig{final.Desc.Hash(), req.Attendees}
-	for _, c := range final.Desc.Roster.List {
+	var silent []string
+	for i, c := range final.Desc.Roster.List {
 		if !c.ID.Equal(s.ServerIdentity().ID) {
 			log.Lvl2("Contacting", c, cc.Attendees)
+			syncData, ok := s.syncs[string(req.DescID)]
+			if ok {
+				// Only accept the reply of c, so that a late reply
+				// of a conode that abstained is ignored.
+				syncData.setWaiting(c)
+				select {
+				case <-syncData.ccChannel:
+				default:
+				}
+			}
 			err := s.SendRaw(c, cc)
 			if err != nil {
-				return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+				if ok {
+					syncData.setWaiting(nil)
+				}
+				if final.Desc.Threshold == 0 {
+					return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+				}
+				log.Lvl2("Conode", c, "abstains:", err)
+				final.Abstained = append(final.Abstained, i)
+				continue
 			}
-			if syncData, ok := s.syncs[string(req.DescID)]; ok {
-				rep := <-syncData.ccChannel
+			if ok {
+				var rep *checkConfigReply
+				select {
+				case rep = <-syncData.ccChannel:
+				case <-time.After(s.checkConfigTimeout):
+					log.Lvl2("Timeout while waiting for", c)
+					silent = append(silent, c.Address.String())
+				}
+				syncData.setWaiting(nil)
 				if rep == nil {
-					return nil, onet.NewClientErrorCode(ErrorOtherFinals,
-						"Not all other conodes finalized yet")
+					if final.Desc.Threshold == 0 {
+						return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+							"Not all other conodes finalized yet"+
+								silentConodes(silent))
+					}
+					log.Lvl2("Conode", c, "abstains")
+					final.Abstained = append(final.Abstained, i)
 				}
 			}
 		}
 	}
+	if len(final.Desc.Roster.List)-len(final.Abstained) < final.Desc.Threshold {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Not enough other conodes finalized yet"+silentConodes(silent))
+	}
+	if len(silent) > 0 {
+		log.Lvl1("Finalizing without reply from", silent)
+	}
+	// All conodes need the same order of the attendees to get the same hash.
+	sort.Sort(byPoint(final.Attendees))
 	data, err := final.ToToml()
 	if err != nil {
 		return nil, onet.NewClientError(err)
@@ -232,7 +371,7 @@ func (s *Service) FetchFinal(req *fetchRequest) (network.Message,
 	log.Lvlf2("FetchFinal: %s %v", s.Context.ServerIdentity(), req.ID)
 	var fs *FinalStatement
 	var ok bool
-	if fs, ok = s.data.Finals[string(req.ID)]; !ok {
+	if fs, ok = s.getFinal(string(req.ID)); !ok {
 		return nil, onet.NewClientErrorCode(ErrorInternal,
 			"No config found")
 	}
@@ -243,6 +382,98 @@ func (s *Service) FetchFinal(req *fetchRequest) (network.Message,
 	return &finalizeResponse{fs}, nil
 }
 
+// FetchFinalMeta returns a summary of the FinalStatement by hash, without
+// the attendees' public keys
+func (s *Service) FetchFinalMeta(req *fetchMetaRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("FetchFinalMeta: %s %v", s.Context.ServerIdentity(), req.ID)
+	fs, ok := s.getFinal(string(req.ID))
+	if !ok || fs == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"No config found")
+	}
+	return &FinalMeta{
+		Desc:      fs.Desc,
+		Merged:    fs.Merged,
+		Attendees: len(fs.Attendees),
+		Signed:    len(fs.Signature) > 0 && fs.Verify() == nil,
+	}, nil
+}
+
+// VerifyToken verifies a signature and tag created with a pop-token of a
+// party whose final statement is stored on this conode. The tags of valid
+// tokens are stored per context, so that an attendee using the same context
+// a second time is marked as replayed. This allows for one vote per
+// attendee and context.
+func (s *Service) VerifyToken(req *verifyTokenRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("VerifyToken: %s %x", s.Context.ServerIdentity(), req.DescID)
+	final, ok := s.getFinal(string(req.DescID))
+	if !ok || final == nil || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) == 0 || final.Verify() != nil {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is not finalized yet")
+	}
+	reply := &VerifyTokenReply{}
+	sigtag := append(append([]byte{}, req.Signature...), req.Tag...)
+	ctag, err := anon.Verify(network.Suite, req.Message,
+		anon.Set(final.Attendees), req.Context, sigtag)
+	if err != nil || !bytes.Equal(req.Tag, ctag) {
+		log.Lvl2("Invalid token:", err)
+		return reply, nil
+	}
+	reply.Valid = true
+	s.dataMutex.Lock()
+	pt, ok := s.data.Tags[string(req.DescID)]
+	if !ok {
+		pt = &partyTags{Contexts: make(map[string]*seenTags)}
+		s.data.Tags[string(req.DescID)] = pt
+	}
+	reply.Replayed = pt.seen(req.Context, ctag)
+	s.dataMutex.Unlock()
+	if !reply.Replayed {
+		s.save()
+	}
+	return reply, nil
+}
+
+// ListParties returns a summary of all parties stored on this conode, sorted
+// by date. The request must be signed by the linked organizer.
+func (s *Service) ListParties(req *listRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("ListParties: %s", s.Context.ServerIdentity())
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, req.hash(), req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature:"+err.Error())
+	}
+	skew := time.Since(time.Unix(req.Time, 0))
+	if skew > listMaxSkew || skew < -listMaxSkew {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Request too old or in the future")
+	}
+	list := &PartyList{}
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	for id, fs := range s.data.Finals {
+		if fs == nil || fs.Desc == nil {
+			continue
+		}
+		list.Parties = append(list.Parties, &PartyInfo{
+			ID:        []byte(id),
+			Name:      fs.Desc.Name,
+			DateTime:  fs.Desc.DateTime,
+			Location:  fs.Desc.Location,
+			Merged:    fs.Merged,
+			Finalized: len(fs.Signature) > 0 && fs.Verify() == nil,
+		})
+	}
+	sort.Sort(byDate(list.Parties))
+	return list, nil
+}
+
 // MergeRequest starts Merge process and returns FinalStatement after
 // used after finalization
 func (s *Service) MergeRequest(req *mergeRequest) (network.Message,
@@ -256,7 +487,7 @@ func (s *Service) MergeRequest(req *mergeRequest) (network.Message,
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: err")
 	}
 
-	final, ok := s.data.Finals[string(req.ID)]
+	final, ok := s.getFinal(string(req.ID))
 	if !ok {
 		return nil, onet.NewClientErrorCode(ErrorInternal,
 			"No config found")
@@ -301,6 +532,8 @@ func (s *Service) MergeRequest(req *mergeRequest) (network.Message,
 		if cerr.ErrorCode() == ErrorMergeInProgress {
 			return final, nil
 		}
+		// Allow to retry once all parties are finalized.
+		m.distrib = false
 		return nil, cerr
 	}
 
@@ -317,7 +550,7 @@ func (s *Service) MergeRequest(req *mergeRequest) (network.Message,
 	}
 	// refresh data
 	hash := string(newFinal.Desc.Hash())
-	s.data.Finals[hash] = newFinal
+	s.setFinal(hash, newFinal)
 	s.data.merges[hash] = m
 	s.syncs[hash] = syncData
 	m.statementsMap = make(map[string]*FinalStatement)
@@ -327,6 +560,65 @@ func (s *Service) MergeRequest(req *mergeRequest) (network.Message,
 	return &finalizeResponse{newFinal}, nil
 }
 
+// RevokeAttendee removes an attendee from a finalized party. The final
+// statement without the attendee is signed again and propagated to all
+// conodes. Holders of the old final statement can detect the revocation by
+// the changed signature. As with FinalizeRequest, the organizers of all
+// conodes of the party must ask for the revocation.
+func (s *Service) RevokeAttendee(req *revokeRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("RevokeAttendee: %s %x", s.Context.ServerIdentity(), req.DescID)
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature:"+err.Error())
+	}
+	final, ok := s.getFinal(string(req.DescID))
+	if !ok || final == nil || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	// Remember the revocation, so that this conode agrees if the organizer
+	// of another conode of the party starts it.
+	s.dataMutex.Lock()
+	s.revocations[string(req.DescID)] = req.Attendee
+	s.dataMutex.Unlock()
+	if final.Verify() != nil {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is not finalized yet")
+	}
+	newFinal := &FinalStatement{}
+	*newFinal = *final
+	newFinal.Attendees = make([]abstract.Point, 0, len(final.Attendees))
+	for _, a := range final.Attendees {
+		if !a.Equal(req.Attendee) {
+			newFinal.Attendees = append(newFinal.Attendees, a)
+		}
+	}
+	if len(newFinal.Attendees) == len(final.Attendees) {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Attendee not found")
+	}
+	finalToml, err := newFinal.ToToml()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	data, err := network.Marshal(&revokeData{Final: finalToml, Request: req})
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	cerr := s.signAndPropagate(newFinal, bftSignRevoke, data)
+	if cerr != nil {
+		return nil, cerr
+	}
+	s.setFinal(string(req.DescID), newFinal)
+	s.save()
+	return &finalizeResponse{newFinal}, nil
+}
+
 // MergeConfig receives a final statement of requesting party,
 // hash of local party. Checks if they are from one merge party and responses with
 // own finalStatement
@@ -346,7 +638,7 @@ func (s *Service) MergeConfig(req *network.Envelope) {
 	var final *FinalStatement
 	func() {
 		var m *merge
-		if final, ok = s.data.Finals[string(mc.ID)]; !ok {
+		if final, ok = s.getFinal(string(mc.ID)); !ok {
 			log.Errorf("No config found")
 			mcr.PopStatus = PopStatusWrongHash
 			return
@@ -385,7 +677,7 @@ func (s *Service) MergeConfig(req *network.Envelope) {
 }
 
 // MergeConfigReply processes the response after MergeConfig message
-func (s Service) MergeConfigReply(req *network.Envelope) {
+func (s *Service) MergeConfigReply(req *network.Envelope) {
 	log.Lvlf2("MergeConfigReply: %s from %s got %v",
 		s.ServerIdentity(), req.ServerIdentity.String(), req.Msg)
 	mcrVal, ok := req.Msg.(*mergeConfigReply)
@@ -397,7 +689,7 @@ func (s Service) MergeConfigReply(req *network.Envelope) {
 				return nil
 			}
 			var final *FinalStatement
-			if final, ok = s.data.Finals[string(mcrVal.PopHash)]; !ok {
+			if final, ok = s.getFinal(string(mcrVal.PopHash)); !ok {
 				log.Error("No party with given hash")
 				return nil
 			}
@@ -432,9 +724,12 @@ func (s *Service) CheckConfig(req *network.Envelope) {
 	}
 
 	ccr := &checkConfigReply{PopStatusOK, cc.PopHash, nil}
-	if len(s.data.Finals) > 0 {
+	s.dataMutex.Lock()
+	parties := len(s.data.Finals)
+	s.dataMutex.Unlock()
+	if parties > 0 {
 		var final *FinalStatement
-		if final, ok = s.data.Finals[string(cc.PopHash)]; !ok {
+		if final, ok = s.getFinal(string(cc.PopHash)); !ok {
 			ccr.PopStatus = PopStatusWrongHash
 		} else {
 			final.Attendees = intersectAttendees(final.Attendees, cc.Attendees)
@@ -453,19 +748,46 @@ func (s *Service) CheckConfig(req *network.Envelope) {
 	}
 }
 
+// SetCheckConfigTimeout sets how long FinalizeRequest waits for every other
+// conode to reply with its attendees. A conode that doesn't reply in time
+// abstains.
+func (s *Service) SetCheckConfigTimeout(t time.Duration) {
+	s.checkConfigTimeout = t
+}
+
+// silentConodes returns the addresses of the conodes that didn't reply, to
+// be appended to an error message.
+func silentConodes(silent []string) string {
+	if len(silent) == 0 {
+		return ""
+	}
+	return " - no reply from: " + strings.Join(silent, ", ")
+}
+
 // CheckConfigReply strips the attendees missing in the reply, if the
-// PopStatus == PopStatusOK.
+// PopStatus == PopStatusOK. Only the reply of the conode FinalizeRequest is
+// waiting for is taken into account.
 func (s *Service) CheckConfigReply(req *network.Envelope) {
 	ccrVal, ok := req.Msg.(*checkConfigReply)
+	if !ok {
+		log.Errorf("Didn't get a CheckConfigReply: %v", req.Msg)
+		return
+	}
 
 	if syncData, found := s.syncs[string(ccrVal.PopHash)]; found {
+		// Hold the lock until the reply is handled, so that a conode
+		// FinalizeRequest stopped waiting for can't change the attendees.
+		syncData.waitingMutex.Lock()
+		defer syncData.waitingMutex.Unlock()
+		if syncData.waiting == nil || req.ServerIdentity == nil ||
+			!syncData.waiting.ID.Equal(req.ServerIdentity.ID) {
+			log.Lvl2("Ignoring CheckConfigReply from", req.ServerIdentity)
+			return
+		}
+		syncData.waiting = nil
 		ccr := func() *checkConfigReply {
-			if !ok {
-				log.Errorf("Didn't get a CheckConfigReply: %v", req.Msg)
-				return nil
-			}
 			var final *FinalStatement
-			if final, ok = s.data.Finals[string(ccrVal.PopHash)]; !ok {
+			if final, ok = s.getFinal(string(ccrVal.PopHash)); !ok {
 				log.Error("No party with given hash")
 				return nil
 			}
@@ -541,14 +863,21 @@ func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) bool {
 		log.Error("hash of received Final stmt and msg are not equal")
 		return false
 	}
+	if len(final.Abstained) > 0 {
+		if _, err := final.signers(); err != nil {
+			log.Error(err.Error())
+			return false
+		}
+	}
 	var fs *FinalStatement
 	var ok bool
 
-	if fs, ok = s.data.Finals[string(final.Desc.Hash())]; !ok {
+	if fs, ok = s.getFinal(string(final.Desc.Hash())); !ok {
 		log.Error("final Statement not found")
 		return false
 	}
 
+	sort.Sort(byPoint(fs.Attendees))
 	hash, err = fs.Hash()
 
 	if !bytes.Equal(hash, Msg) {
@@ -558,6 +887,86 @@ func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) bool {
 	return true
 }
 
+// Verification function for signing during Revocation. The new final
+// statement must hold all attendees of the local one, except the one of the
+// revocation request. The request must be signed by the organizer linked to
+// this conode, or this organizer must have asked for the same revocation.
+func (s *Service) bftVerifyRevoke(Msg []byte, Data []byte) bool {
+	_, msg, err := network.Unmarshal(Data)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	rd, ok := msg.(*revokeData)
+	if !ok || rd.Request == nil || rd.Request.Attendee == nil {
+		log.Error("invalid revocation data")
+		return false
+	}
+	if !s.revokeAuthorized(rd.Request) {
+		log.Error("revocation is not signed by the organizer")
+		return false
+	}
+	final, err := NewFinalStatementFromToml(rd.Final)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	hash, err := final.Hash()
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if !bytes.Equal(hash, Msg) {
+		log.Error("hash of received Final stmt and msg are not equal")
+		return false
+	}
+	fs, ok := s.getFinal(string(final.Desc.Hash()))
+	if !ok {
+		log.Error("final Statement not found")
+		return false
+	}
+	if fs.Verify() != nil {
+		log.Error("local party is not finalized")
+		return false
+	}
+	if !bytes.Equal(rd.Request.DescID, final.Desc.Hash()) {
+		log.Error("revocation is for another party")
+		return false
+	}
+	if len(final.Attendees) != len(fs.Attendees)-1 ||
+		len(intersectAttendees(fs.Attendees, final.Attendees)) != len(final.Attendees) {
+		log.Error("revocation must remove exactly one attendee")
+		return false
+	}
+	for _, a := range final.Attendees {
+		if a.Equal(rd.Request.Attendee) {
+			log.Error("revocation removes another attendee")
+			return false
+		}
+	}
+	return true
+}
+
+// revokeAuthorized returns true if the request is signed by the organizer
+// linked to this conode, or if this organizer asked for the same revocation
+// before.
+func (s *Service) revokeAuthorized(req *revokeRequest) bool {
+	if s.data.Public == nil {
+		return false
+	}
+	hash, err := req.hash()
+	if err != nil {
+		return false
+	}
+	if crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature) == nil {
+		return true
+	}
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	att, ok := s.revocations[string(req.DescID)]
+	return ok && att.Equal(req.Attendee)
+}
+
 // Verification function for sighning during Merging
 func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
 	stmtsMap, err := decodeMapFinal(Data)
@@ -573,7 +982,7 @@ func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
 	finals := make([]*FinalStatement, 0)
 	var ok, found bool
 	for _, finalReceived = range stmtsMap {
-		if f, ok := s.data.Finals[string(finalReceived.Desc.Hash())]; ok {
+		if f, ok := s.getFinal(string(finalReceived.Desc.Hash())); ok {
 
 			found = true
 			final = f
@@ -637,6 +1046,7 @@ func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
 	sortAll(locs, Roster.List, na)
 	final.Desc.Location = strings.Join(locs, DELIMETER)
 	final.Merged = true
+	final.Abstained = nil
 	final.Desc.Roster = Roster
 	final.Attendees = na
 
@@ -654,7 +1064,7 @@ func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
 
 	// update local data
 	newHash := string(final.Desc.Hash())
-	s.data.Finals[newHash] = final
+	s.setFinal(newHash, final)
 	s.data.merges[newHash] = m
 	s.syncs[newHash] = syncData
 	m.statementsMap = make(map[string]*FinalStatement)
@@ -665,7 +1075,7 @@ func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
 	for _, f := range finals {
 		// but signature on this conodes will be invalid
 		// because it's impossible to save signature on old hashes
-		s.data.Finals[string(f.Desc.Hash())] = final
+		s.setFinal(string(f.Desc.Hash()), final)
 		// there is no need to support consistency of syncData and merge
 		// for old parties because their finalStatements are rewritten
 	}
@@ -685,7 +1095,16 @@ func (s *Service) PropagateFinal(msg network.Message) {
 		log.Error(err)
 		return
 	}
-	*s.data.Finals[string(fs.Desc.Hash())] = *fs
+	s.dataMutex.Lock()
+	final, ok := s.data.Finals[string(fs.Desc.Hash())]
+	if ok {
+		*final = *fs
+	}
+	s.dataMutex.Unlock()
+	if !ok {
+		log.Error("No config found for final statement")
+		return
+	}
 	s.save()
 	log.Lvlf2("%s Stored final statement %v", s.ServerIdentity(), fs)
 }
@@ -693,7 +1112,11 @@ func (s *Service) PropagateFinal(msg network.Message) {
 //signs FinalStatement with BFTCosi and Propagates signature to other nodes
 func (s *Service) signAndPropagate(final *FinalStatement, protoName string,
 	data []byte) onet.ClientError {
-	tree := final.Desc.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
+	signers, err := final.signers()
+	if err != nil {
+		return onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	tree := signers.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
 	if tree == nil {
 		return onet.NewClientErrorCode(ErrorInternal,
 			"Root does not exist")
@@ -785,6 +1208,7 @@ func (s *Service) merge(final *FinalStatement, m *merge) (*FinalStatement,
 			continue
 		}
 		mc := &mergeConfig{Final: final, ID: hash}
+		nonFinalized := false
 		for _, si := range party.Roster.List {
 			log.Lvlf2("Sending from %s to %s", s.ServerIdentity(), si)
 			err := s.SendRaw(si, mc)
@@ -807,8 +1231,15 @@ func (s *Service) merge(final *FinalStatement, m *merge) (*FinalStatement,
 				m.statementsMap[string(hash)] = mcr.Final
 				break
 			}
+			if mcr.PopStatus == PopStatusMergeNonFinalized {
+				nonFinalized = true
+			}
 		}
 		if _, ok = m.statementsMap[string(hash)]; !ok {
+			if nonFinalized {
+				return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+					"party in "+party.Location+" is not finalized yet")
+			}
 			return nil, onet.NewClientErrorCode(ErrorMerge,
 				"merge with party failed")
 		}
@@ -836,12 +1267,30 @@ func (s *Service) merge(final *FinalStatement, m *merge) (*FinalStatement,
 	newFinal.Desc.Roster = Roster
 	newFinal.Attendees = na
 	newFinal.Merged = true
+	newFinal.Abstained = nil
 	return newFinal, nil
 }
 
+// getFinal returns the final statement stored under the given hash.
+func (s *Service) getFinal(hash string) (*FinalStatement, bool) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	final, ok := s.data.Finals[hash]
+	return final, ok
+}
+
+// setFinal stores the final statement under the given hash.
+func (s *Service) setFinal(hash string, final *FinalStatement) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+	s.data.Finals[hash] = final
+}
+
 // saves the actual identity
 func (s *Service) save() {
 	log.Lvl2("Saving service", s.ServerIdentity())
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
 	err := s.Save("storage", s.data)
 	if err != nil {
 		log.Error("Couldn't save data:", err)
@@ -922,11 +1371,13 @@ func unionRoster(r1, r2 *onet.Roster) *onet.Roster {
 // newService registers the request-methods.
 func newService(c *onet.Context) onet.Service {
 	s := &Service{
-		ServiceProcessor: onet.NewServiceProcessor(c),
-		data:             &saveData{},
+		ServiceProcessor:   onet.NewServiceProcessor(c),
+		data:               &saveData{},
+		checkConfigTimeout: timeout,
 	}
 	log.ErrFatal(s.RegisterHandlers(s.PinRequest, s.StoreConfig, s.FinalizeRequest,
-		s.FetchFinal, s.MergeRequest), "Couldn't register messages")
+		s.FetchFinal, s.FetchFinalMeta, s.MergeRequest, s.RevokeAttendee,
+		s.ListParties), "Couldn't register messages")
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 	}
@@ -936,7 +1387,11 @@ func newService(c *onet.Context) onet.Service {
 	if s.data.merges == nil {
 		s.data.merges = make(map[string]*merge)
 	}
+	if s.data.Tags == nil {
+		s.data.Tags = make(map[string]*partyTags)
+	}
 	s.syncs = make(map[string]*sync)
+	s.revocations = make(map[string]abstract.Point)
 	var err error
 	s.PropagateFinalize, err = messaging.NewPropagationFunc(c, propagFinal, s.PropagateFinal)
 	log.ErrFatal(err)
@@ -950,6 +1405,9 @@ func newService(c *onet.Context) onet.Service {
 	s.ProtocolRegister(bftSignMerge, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyMerge)
 	})
+	s.ProtocolRegister(bftSignRevoke, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyRevoke)
+	})
 	return s
 }
 
@@ -968,6 +1426,17 @@ func (p byIdentity) Less(i, j int) bool {
 	return p[i].String() < p[j].String()
 }
 
+type byDate []*PartyInfo
+
+func (p byDate) Len() int      { return len(p) }
+func (p byDate) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byDate) Less(i, j int) bool {
+	if p[i].DateTime != p[j].DateTime {
+		return p[i].DateTime < p[j].DateTime
+	}
+	return bytes.Compare(p[i].ID, p[j].ID) < 0
+}
+
 type byPoint []abstract.Point
 
 func (p byPoint) Len() int      { return len(p) }
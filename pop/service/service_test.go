@@ -5,7 +5,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
@@ -51,6 +53,34 @@ func TestService_PinRequest(t *testing.T) {
 	require.Equal(t, service.data.Public, pub)
 }
 
+func TestService_PinRequestLockout(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	service := local.GetServices(servers, serviceID)[0].(*Service)
+	pub := config.NewKeyPair(network.Suite).Public
+	_, cerr := service.PinRequest(&PinRequest{"", pub})
+	require.Equal(t, ErrorWrongPIN, cerr.ErrorCode())
+	pin := service.data.Pin
+	wrong := "wrong"
+	for i := 0; i < pinMaxAttempts; i++ {
+		_, cerr = service.PinRequest(&PinRequest{wrong, pub})
+		require.Equal(t, ErrorWrongPIN, cerr.ErrorCode())
+	}
+	_, cerr = service.PinRequest(&PinRequest{wrong, pub})
+	require.Equal(t, ErrorPinLockout, cerr.ErrorCode())
+	_, cerr = service.PinRequest(&PinRequest{pin, pub})
+	require.Equal(t, ErrorPinLockout, cerr.ErrorCode())
+	require.Nil(t, service.data.Public)
+
+	// After the cooldown, the correct PIN is accepted again.
+	service.pinLockout = time.Now()
+	_, cerr = service.PinRequest(&PinRequest{pin, pub})
+	require.Nil(t, cerr)
+	require.Equal(t, pub, service.data.Public)
+	require.Equal(t, 0, service.pinFailures)
+}
+
 func TestService_StoreConfig(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -97,12 +127,14 @@ func TestService_CheckConfigMessage(t *testing.T) {
 		break
 	}
 	cc.PopHash = []byte(hash)
+	srvcs[0].syncs[hash].setWaiting(r.List[1])
 	srvcs[0].SendRaw(r.List[1], cc)
 	require.NotNil(t, <-srvcs[0].syncs[hash].ccChannel)
 	require.Equal(t, 2, len(srvcs[0].data.Finals[hash].Attendees))
 	require.Equal(t, 2, len(srvcs[1].data.Finals[hash].Attendees))
 
 	cc.Attendees = atts[:1]
+	srvcs[0].syncs[hash].setWaiting(r.List[1])
 	srvcs[0].SendRaw(r.List[1], cc)
 	require.NotNil(t, <-srvcs[0].syncs[hash].ccChannel)
 	require.Equal(t, 1, len(srvcs[0].data.Finals[hash].Attendees))
@@ -126,19 +158,34 @@ func TestService_CheckConfigReply(t *testing.T) {
 			ServerIdentity: nodes[1].ServerIdentity,
 		}
 
+		s0.syncs[hash].setWaiting(nodes[1].ServerIdentity)
 		s0.CheckConfigReply(req)
 		<-s0.syncs[hash].ccChannel
 		require.Equal(t, 2, len(s0.data.Finals[hash].Attendees))
 
 		ccr.Attendees = atts[:1]
 		req.Msg = ccr
+		s0.syncs[hash].setWaiting(nodes[1].ServerIdentity)
 		s0.CheckConfigReply(req)
 		<-s0.syncs[hash].ccChannel
 		require.Equal(t, 2, len(s0.data.Finals[hash].Attendees))
 
+		// A reply nobody waits for, e.g. the late reply of a conode
+		// that abstained, is ignored.
 		ccr.PopStatus = PopStatusOK + 1
 		req.Msg = ccr
 		s0.CheckConfigReply(req)
+		require.Equal(t, 0, len(s0.syncs[hash].ccChannel))
+		require.Equal(t, 2, len(s0.data.Finals[hash].Attendees))
+
+		// So is a reply from another conode than the one waited for.
+		s0.syncs[hash].setWaiting(nodes[0].ServerIdentity)
+		s0.CheckConfigReply(req)
+		require.Equal(t, 0, len(s0.syncs[hash].ccChannel))
+		require.Equal(t, 2, len(s0.data.Finals[hash].Attendees))
+
+		s0.syncs[hash].setWaiting(nodes[1].ServerIdentity)
+		s0.CheckConfigReply(req)
 		<-s0.syncs[hash].ccChannel
 		require.Equal(t, 1, len(s0.data.Finals[hash].Attendees))
 	}
@@ -204,6 +251,229 @@ func TestService_FinalizeRequest(t *testing.T) {
 	}
 }
 
+func TestService_FetchFinalMeta(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, services, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	descHash := descs[0].Hash()
+
+	msg, cerr := services[0].FetchFinalMeta(&fetchMetaRequest{descHash})
+	log.ErrFatal(cerr)
+	meta := msg.(*FinalMeta)
+	require.False(t, meta.Signed)
+
+	fr := &finalizeRequest{DescID: descHash, Attendees: atts}
+	hash, err := fr.hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], hash)
+	log.ErrFatal(err)
+	_, cerr = services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[1], hash)
+	log.ErrFatal(err)
+	_, cerr = services[1].FinalizeRequest(fr)
+	log.ErrFatal(cerr)
+
+	msg, cerr = services[0].FetchFinal(&fetchRequest{descHash})
+	log.ErrFatal(cerr)
+	final := msg.(*finalizeResponse).Final
+	msg, cerr = services[0].FetchFinalMeta(&fetchMetaRequest{descHash})
+	log.ErrFatal(cerr)
+	meta = msg.(*FinalMeta)
+	require.True(t, meta.Signed)
+	require.False(t, meta.Merged)
+	require.Equal(t, len(final.Attendees), meta.Attendees)
+	require.Equal(t, descHash, meta.Desc.Hash())
+
+	_, cerr = services[0].FetchFinalMeta(&fetchMetaRequest{[]byte{}})
+	require.NotNil(t, cerr)
+}
+
+func TestService_ListParties(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, services, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 2)
+
+	req := &listRequest{Time: time.Now().Unix()}
+	var err error
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv[1], req.hash())
+	log.ErrFatal(err)
+	_, cerr := services[0].ListParties(req)
+	require.NotNil(t, cerr)
+
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], req.hash())
+	log.ErrFatal(err)
+	msg, cerr := services[0].ListParties(req)
+	log.ErrFatal(cerr)
+	parties := msg.(*PartyList).Parties
+	require.Equal(t, 2, len(parties))
+	for _, desc := range descs {
+		found := false
+		for _, p := range parties {
+			if string(p.ID) == string(desc.Hash()) {
+				found = true
+				require.Equal(t, desc.Name, p.Name)
+				require.Equal(t, desc.DateTime, p.DateTime)
+				require.Equal(t, desc.Location, p.Location)
+				require.False(t, p.Merged)
+				require.False(t, p.Finalized)
+			}
+		}
+		require.True(t, found, "missing party "+desc.Location)
+	}
+
+	req.Time = time.Now().Add(-2 * listMaxSkew).Unix()
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], req.hash())
+	log.ErrFatal(err)
+	_, cerr = services[0].ListParties(req)
+	require.NotNil(t, cerr)
+}
+
+func TestService_FinalizeThreshold(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	desc := &PopDesc{
+		Name:      "name",
+		DateTime:  "2017-07-31 00:00",
+		Location:  "city",
+		Roster:    onet.NewRoster(r.List),
+		Threshold: 2,
+	}
+	atts := make([]abstract.Point, 4)
+	for i := range atts {
+		atts[i] = config.NewKeyPair(network.Suite).Public
+	}
+	var services []*Service
+	var privs []abstract.Scalar
+	for _, s := range local.GetServices(nodes, serviceID) {
+		service := s.(*Service)
+		kp := config.NewKeyPair(network.Suite)
+		service.data.Public = kp.Public
+		sig, err := crypto.SignSchnorr(network.Suite, kp.Secret, desc.Hash())
+		log.ErrFatal(err)
+		_, cerr := service.StoreConfig(&storeConfig{desc, sig})
+		log.ErrFatal(cerr)
+		services = append(services, service)
+		privs = append(privs, kp.Secret)
+	}
+
+	fr := &finalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.hash()
+	log.ErrFatal(err)
+	// The last conode never submits its attendees, so the first one only
+	// has its own agreement.
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	_, cerr := services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	msg, cerr := services[1].FinalizeRequest(fr)
+	log.ErrFatal(cerr)
+	final := msg.(*finalizeResponse).Final
+	require.Equal(t, []int{2}, final.Abstained)
+	require.Equal(t, 4, len(final.Attendees))
+	require.Nil(t, final.Verify())
+
+	// The abstained conode can't be hidden.
+	final.Abstained = nil
+	require.NotNil(t, final.Verify())
+}
+
+func TestService_CheckConfigTimeout(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	desc := &PopDesc{
+		Name:      "name",
+		DateTime:  "2017-07-31 00:00",
+		Location:  "city",
+		Roster:    onet.NewRoster(r.List),
+		Threshold: 2,
+	}
+	atts := make([]abstract.Point, 4)
+	for i := range atts {
+		atts[i] = config.NewKeyPair(network.Suite).Public
+	}
+	var services []*Service
+	var privs []abstract.Scalar
+	for _, s := range local.GetServices(nodes, serviceID) {
+		service := s.(*Service)
+		kp := config.NewKeyPair(network.Suite)
+		service.data.Public = kp.Public
+		sig, err := crypto.SignSchnorr(network.Suite, kp.Secret, desc.Hash())
+		log.ErrFatal(err)
+		_, cerr := service.StoreConfig(&storeConfig{desc, sig})
+		log.ErrFatal(cerr)
+		service.SetCheckConfigTimeout(500 * time.Millisecond)
+		services = append(services, service)
+		privs = append(privs, kp.Secret)
+	}
+	// The last conode never answers a checkConfig.
+	services[2].RegisterProcessorFunc(checkConfigID, func(*network.Envelope) {})
+
+	fr := &finalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.hash()
+	log.ErrFatal(err)
+	// The first conode didn't get its attendees yet and refuses, so only
+	// the second conode agrees.
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	_, cerr := services[1].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), nodes[2].ServerIdentity.Address.String())
+
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	start := time.Now()
+	msg, cerr := services[0].FinalizeRequest(fr)
+	log.ErrFatal(cerr)
+	require.True(t, time.Now().Sub(start) < timeout)
+	final := msg.(*finalizeResponse).Final
+	require.Equal(t, []int{2}, final.Abstained)
+	require.Equal(t, 4, len(final.Attendees))
+	require.Nil(t, final.Verify())
+}
+
+func TestService_FinalizeOrder(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 4, 2)
+	reversed := make([]abstract.Point, len(atts))
+	for i, a := range atts {
+		reversed[len(atts)-1-i] = a
+	}
+
+	var finals []*FinalStatement
+	for i, order := range [][]abstract.Point{atts, reversed} {
+		fr := &finalizeRequest{DescID: descs[i].Hash(), Attendees: order}
+		hash, err := fr.hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+		log.ErrFatal(err)
+		_, cerr := services[0].FinalizeRequest(fr)
+		require.NotNil(t, cerr)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+		log.ErrFatal(err)
+		msg, cerr := services[1].FinalizeRequest(fr)
+		log.ErrFatal(cerr)
+		final := msg.(*finalizeResponse).Final
+		require.Nil(t, final.Verify())
+		finals = append(finals, final)
+	}
+	hash0, err := (&FinalStatement{Desc: descs[0], Attendees: finals[0].Attendees}).Hash()
+	log.ErrFatal(err)
+	hash1, err := (&FinalStatement{Desc: descs[0], Attendees: finals[1].Attendees}).Hash()
+	log.ErrFatal(err)
+	require.Equal(t, hash0, hash1)
+}
+
 func TestService_FetchFinal(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -254,6 +524,193 @@ func TestService_FetchFinal(t *testing.T) {
 	}
 }
 
+func TestService_RevokeAttendee(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	desc := descs[0]
+	kps := make([]*config.KeyPair, 4)
+	atts := make([]abstract.Point, len(kps))
+	for i := range kps {
+		kps[i] = config.NewKeyPair(network.Suite)
+		atts[i] = kps[i].Public
+	}
+
+	fr := &finalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	_, cerr := services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	msg, cerr := services[1].FinalizeRequest(fr)
+	log.ErrFatal(cerr)
+	oldFinal := msg.(*finalizeResponse).Final
+	oldAtts := make([]abstract.Point, len(oldFinal.Attendees))
+	copy(oldAtts, oldFinal.Attendees)
+	oldSig := oldFinal.Signature
+
+	// The revoked attendee signs with the old set.
+	revoked := 2
+	mine := -1
+	for i, a := range oldAtts {
+		if a.Equal(atts[revoked]) {
+			mine = i
+		}
+	}
+	require.NotEqual(t, -1, mine)
+	ctx := []byte("context")
+	sigtag := anon.Sign(network.Suite, random.Stream, []byte("message"),
+		anon.Set(oldAtts), ctx, mine, kps[revoked].Secret)
+	_, err = anon.Verify(network.Suite, []byte("message"), anon.Set(oldAtts),
+		ctx, sigtag)
+	log.ErrFatal(err)
+
+	rr := &revokeRequest{DescID: desc.Hash(), Attendee: atts[revoked]}
+	hash, err = rr.hash()
+	log.ErrFatal(err)
+	rr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	_, cerr = services[0].RevokeAttendee(rr)
+	require.NotNil(t, cerr, "wrong organizer")
+	_, cerr = services[1].RevokeAttendee(rr)
+	require.NotNil(t, cerr, "other organizer didn't ask for the revocation")
+	rr0 := &revokeRequest{DescID: desc.Hash(), Attendee: atts[revoked]}
+	rr0.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	msg, cerr = services[0].RevokeAttendee(rr0)
+	log.ErrFatal(cerr)
+	final := msg.(*finalizeResponse).Final
+	require.Nil(t, final.Verify())
+	require.Equal(t, 3, len(final.Attendees))
+	require.NotEqual(t, oldSig, final.Signature)
+
+	for _, s := range services {
+		fetched, cerr := s.FetchFinal(&fetchRequest{desc.Hash()})
+		log.ErrFatal(cerr)
+		require.Equal(t, final.Signature, fetched.(*finalizeResponse).Final.Signature)
+	}
+	_, err = anon.Verify(network.Suite, []byte("message"),
+		anon.Set(final.Attendees), ctx, sigtag)
+	require.NotNil(t, err)
+
+	_, cerr = services[1].RevokeAttendee(rr)
+	require.NotNil(t, cerr, "attendee already revoked")
+}
+
+func TestService_VerifyToken(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	desc := descs[0]
+	kps := make([]*config.KeyPair, 3)
+	atts := make([]abstract.Point, len(kps))
+	for i := range kps {
+		kps[i] = config.NewKeyPair(network.Suite)
+		atts[i] = kps[i].Public
+	}
+	msg := []byte("message")
+	ctx := []byte("context")
+	vt := &verifyTokenRequest{DescID: desc.Hash(), Message: msg, Context: ctx}
+	_, cerr := services[0].VerifyToken(vt)
+	require.NotNil(t, cerr, "party not finalized")
+
+	fr := &finalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	services[0].FinalizeRequest(fr)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	reply, cerr := services[1].FinalizeRequest(fr)
+	log.ErrFatal(cerr)
+	final := reply.(*finalizeResponse).Final
+	mine := -1
+	for i, a := range final.Attendees {
+		if a.Equal(atts[1]) {
+			mine = i
+		}
+	}
+	sign := func(priv abstract.Scalar) {
+		sigtag := anon.Sign(network.Suite, random.Stream, vt.Message,
+			anon.Set(final.Attendees), vt.Context, mine, priv)
+		vt.Signature = sigtag[:len(sigtag)-SIGSIZE/2]
+		vt.Tag = sigtag[len(sigtag)-SIGSIZE/2:]
+	}
+
+	sign(kps[1].Secret)
+	res, cerr := services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.True(t, res.(*VerifyTokenReply).Valid)
+	require.False(t, res.(*VerifyTokenReply).Replayed)
+
+	log.Lvl1("Replaying the token")
+	sign(kps[1].Secret)
+	res, cerr = services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.True(t, res.(*VerifyTokenReply).Valid)
+	require.True(t, res.(*VerifyTokenReply).Replayed)
+
+	log.Lvl1("Forging a token")
+	sign(config.NewKeyPair(network.Suite).Secret)
+	res, cerr = services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.False(t, res.(*VerifyTokenReply).Valid)
+	vt.Message = []byte("other message")
+	sign(kps[1].Secret)
+	vt.Message = msg
+	res, cerr = services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.False(t, res.(*VerifyTokenReply).Valid)
+
+	log.Lvl1("Using the token in another context")
+	vt.Context = []byte("other context")
+	sign(kps[1].Secret)
+	res, cerr = services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.True(t, res.(*VerifyTokenReply).Valid)
+	require.False(t, res.(*VerifyTokenReply).Replayed)
+	sign(kps[1].Secret)
+	res, cerr = services[0].VerifyToken(vt)
+	log.ErrFatal(cerr)
+	require.True(t, res.(*VerifyTokenReply).Replayed)
+	require.Equal(t, 2, len(services[0].data.Tags[string(desc.Hash())].Contexts))
+
+	log.Lvl1("Verifying tokens concurrently")
+	nbr := 10
+	valid := make(chan bool)
+	for i := 0; i < nbr; i++ {
+		go func(i int) {
+			req := *vt
+			req.Context = []byte(fmt.Sprintf("concurrent %d", i))
+			sigtag := anon.Sign(network.Suite, random.Stream, req.Message,
+				anon.Set(final.Attendees), req.Context, mine, kps[1].Secret)
+			req.Signature = sigtag[:len(sigtag)-SIGSIZE/2]
+			req.Tag = sigtag[len(sigtag)-SIGSIZE/2:]
+			res, cerr := services[0].VerifyToken(&req)
+			valid <- cerr == nil && res.(*VerifyTokenReply).Valid
+		}(i)
+	}
+	for i := 0; i < nbr; i++ {
+		require.True(t, <-valid)
+	}
+	require.Equal(t, 2+nbr, len(services[0].data.Tags[string(desc.Hash())].Contexts))
+}
+
+func TestPartyTags_Seen(t *testing.T) {
+	pt := &partyTags{Contexts: make(map[string]*seenTags)}
+	require.False(t, pt.seen([]byte("vote1"), []byte("tag")))
+	require.True(t, pt.seen([]byte("vote1"), []byte("tag")))
+	require.False(t, pt.seen([]byte("vote2"), []byte("tag")))
+	require.False(t, pt.seen([]byte("vote1"), []byte("other tag")))
+	require.True(t, pt.seen([]byte("vote2"), []byte("tag")))
+}
+
 func TestService_MergeConfig(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -405,6 +862,56 @@ func TestService_MergeRequest(t *testing.T) {
 
 }
 
+func TestService_MergeRequestThree(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 6
+	nbrAtt := 6
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	require.Equal(t, 3, len(descs))
+
+	finalize := func(i int) {
+		fr := &finalizeRequest{}
+		fr.DescID = descs[i].Hash()
+		fr.Attendees = atts[2*i : 2*i+2]
+		hash, err := fr.hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[2*i], hash)
+		log.ErrFatal(err)
+		_, cerr := srvcs[2*i].FinalizeRequest(fr)
+		require.NotNil(t, cerr)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[2*i+1], hash)
+		log.ErrFatal(err)
+		_, cerr = srvcs[2*i+1].FinalizeRequest(fr)
+		log.ErrFatal(cerr)
+	}
+	finalize(0)
+	finalize(1)
+
+	mr := &mergeRequest{ID: descs[0].Hash()}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.ID)
+	log.ErrFatal(err)
+	_, cerr := srvcs[0].MergeRequest(mr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+
+	finalize(2)
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	log.ErrFatal(cerr)
+	final := msg.(*finalizeResponse).Final
+	require.True(t, final.Merged)
+	require.Equal(t, nbrAtt, len(final.Attendees))
+	require.Equal(t, nbrNodes, len(final.Desc.Roster.List))
+	require.Nil(t, final.Verify())
+	for i, s := range srvcs {
+		f := s.data.Finals[string(descs[i/2].Hash())]
+		require.True(t, f.Merged, fmt.Sprintf("Server %d not Merged", i))
+		require.Nil(t, f.Verify(), fmt.Sprintf("Server %d not signed", i))
+	}
+}
+
 func storeDesc(srvcs []onet.Service, el *onet.Roster, nbr int,
 	nprts int) ([]*PopDesc, []abstract.Point, []*Service, []abstract.Scalar) {
 	descs := make([]*PopDesc, nprts)
@@ -5,6 +5,8 @@ This holds the messages used to communicate with the service over the network.
 */
 
 import (
+	"strconv"
+
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/network"
@@ -14,7 +16,8 @@ import (
 func init() {
 	for _, msg := range []interface{}{
 		checkConfig{}, checkConfigReply{},
-		PinRequest{}, fetchRequest{}, mergeRequest{},
+		PinRequest{}, fetchRequest{}, fetchMetaRequest{}, mergeRequest{},
+		listRequest{}, verifyTokenRequest{},
 	} {
 		network.RegisterMessage(msg)
 	}
@@ -126,8 +129,70 @@ type fetchRequest struct {
 	ID []byte
 }
 
+// fetchMetaRequest asks to get the FinalMeta of a FinalStatement
+type fetchMetaRequest struct {
+	ID []byte
+}
+
 // mergeRequest asks to start merging process for given Party
 type mergeRequest struct {
 	ID        []byte
 	Signature crypto.SchnorrSig
 }
+
+// revokeRequest asks to remove an attendee from the final statement of
+// the party DescID. It is signed by the organizer.
+type revokeRequest struct {
+	DescID    []byte
+	Attendee  abstract.Point
+	Signature crypto.SchnorrSig
+}
+
+// revokeData is sent to all conodes when signing a revocation, so that they
+// can check the request of the organizer.
+type revokeData struct {
+	Final   []byte
+	Request *revokeRequest
+}
+
+func (rr *revokeRequest) hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	_, err := h.Write(rr.DescID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := rr.Attendee.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	_, err = h.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// listRequest asks for a summary of all parties stored on the conode. It is
+// signed by the organizer. Time is the unix-time of the request, so that an
+// old request cannot be replayed.
+type listRequest struct {
+	Time      int64
+	Signature crypto.SchnorrSig
+}
+
+func (lr *listRequest) hash() []byte {
+	h := network.Suite.Hash()
+	h.Write([]byte("listParties"))
+	h.Write([]byte(strconv.FormatInt(lr.Time, 10)))
+	return h.Sum(nil)
+}
+
+// verifyTokenRequest asks to verify a signature and tag created with a
+// pop-token of the party DescID on Message in Context.
+type verifyTokenRequest struct {
+	DescID    []byte
+	Message   []byte
+	Context   []byte
+	Signature []byte
+	Tag       []byte
+}
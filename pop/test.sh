@@ -25,8 +25,10 @@ main(){
 	test Build
 	test Check
 	test OrgLink
+	test OrgValidate
 	test Save
 	test OrgConfig
+	test OrgList
 	test AtCreate
 	test OrgPublic
 	test OrgPublic2
@@ -37,6 +39,7 @@ main(){
 	test AtSign
 	test AuthStore
 	test AtVerify
+	test AtExport
 	test AtMultipleKey
 	test Merge
 	stopTest
@@ -182,6 +185,19 @@ mkClSign(){
 	done
 }
 
+testAtExport(){
+	mkAtJoin
+	testFail runCl 1 attendee export ${pop_hash[1]} msg1 ctx1
+	testFail runCl 1 attendee export ${pop_hash[2]} msg1 ctx1 token1.json
+	testOK runCl 1 attendee export ${pop_hash[1]} msg1 ctx1 token1.json
+	testOK runCl 2 attendee verifyExport token1.json ${pop_hash[1]}
+	testFail runCl 2 attendee verifyExport token1.json
+	testFail runCl 2 attendee verifyExport token1.json ${pop_hash[2]}
+	testFail runCl 2 attendee verifyExport
+	sed -i -e "s/Message\":\"[^\"]*/Message\":\"AAAA/" token1.json
+	testFail runCl 2 attendee verifyExport token1.json ${pop_hash[1]}
+}
+
 testAtSign(){
 	mkFinal
 	testFail runCl 1 attendee sign msg1 ctx1 ${pop_hash[1]}
@@ -318,7 +334,7 @@ testOrgPublic2(){
 	testOK runCl 3 org public ${pub[1]} ${pop_hash[3]}
 	testOK runCl 1 org public ${pub[1]} ${pop_hash[3]}
 
-	testFail runCl 3 org public ${pub[1]} ${pop_hash[2]}
+	testGrep "skipped 1" runCl 3 org public ${pub[1]} ${pop_hash[2]}
 }
 
 testOrgPublic(){
@@ -327,7 +343,7 @@ testOrgPublic(){
 	testFail runCl 1 org public ${pub[1]}
 	testFail runCl 1 org public ${pub[1]} wrong_hash
 	testOK runCl 1 org public ${pub[1]} ${pop_hash[1]}
-	testFail runCl 1 org public ${pub[1]} ${pop_hash[1]}
+	testGrep "Added 0 public keys, skipped 1" runCl 1 org public ${pub[1]} ${pop_hash[1]}
 	testOK runCl 1 org public ${pub[2]} ${pop_hash[1]}
 }
 
@@ -375,6 +391,14 @@ mkKeypair(){
 	done
 }
 
+testOrgValidate(){
+	mkPopConfig 1 1
+	testOK runCl 1 org validate pop_desc1.toml
+	testGrep "Hash of config" runCl 1 org validate pop_desc1.toml
+	head -n 3 pop_desc1.toml > pop_desc_empty.toml
+	testFail runCl 1 org validate pop_desc_empty.toml
+}
+
 testOrgConfig(){
 	mkPopConfig 1 1
 	testFail runCl 1 org config pop_desc1.toml
@@ -383,6 +407,15 @@ testOrgConfig(){
 	testOK runCl 2 org config pop_desc1.toml
 }
 
+testOrgList(){
+	mkPopConfig 1 1
+	testFail runCl 1 org list
+	mkLink 1
+	testNGrep "City1" runCl 1 org list
+	testOK runCl 1 org config pop_desc1.toml
+	testGrep "Earth, City1" runCl 1 org list
+}
+
 # $1 number of parties $2 number of organizers
 mkPopConfig(){
 	local n
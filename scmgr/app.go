@@ -18,12 +18,16 @@ import (
 	"path"
 
 	"bytes"
+	"math/rand"
 	"sort"
+	"time"
 
 	"encoding/json"
+	"html/template"
 	"path/filepath"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/dedis/cothority/skipchain"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
@@ -39,6 +43,26 @@ type html struct {
 	Data []byte
 }
 
+// chainExport holds all blocks of a skipchain, ordered by their index.
+type chainExport struct {
+	Blocks []*skipchain.SkipBlock
+}
+
+func init() {
+	network.RegisterMessage(&chainExport{})
+}
+
+// dataTypes holds the message-types that can be stored with addWeb. The
+// data is decoded from JSON or TOML into a new message of that type.
+var dataTypes = map[string]func() network.Message{
+	"skipchain.ExternalData": func() network.Message {
+		return &skipchain.ExternalData{}
+	},
+	"skipchain.SkipBlockData": func() network.Message {
+		return &skipchain.SkipBlockData{}
+	},
+}
+
 func main() {
 	network.RegisterMessage(&config{})
 	network.RegisterMessage(&html{})
@@ -90,7 +114,14 @@ func main() {
 			Usage:     "add a web-site to a skipchain",
 			Aliases:   []string{"a"},
 			ArgsUsage: "skipchain-id page.html",
-			Action:    addWeb,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "type, t",
+					Value: "html",
+					Usage: "type of the data: html, raw or a message-type of the JSON- or TOML-file",
+				},
+			},
+			Action: addWeb,
 		},
 		{
 			Name:      "update",
@@ -99,6 +130,43 @@ func main() {
 			ArgsUsage: "skipchain-id",
 			Action:    update,
 		},
+		{
+			Name:      "analyze",
+			Usage:     "show proof-lengths and heights of a locally stored skipchain",
+			Aliases:   []string{"an"},
+			ArgsUsage: "skipchain-id",
+			Action:    analyze,
+		},
+		{
+			Name:      "export",
+			Usage:     "write all blocks of a skipchain to a file",
+			ArgsUsage: "skipchain-id file",
+			Action:    export,
+		},
+		{
+			Name:      "import",
+			Usage:     "verify and store the blocks of an exported skipchain",
+			ArgsUsage: "file",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "check that back-links and forward-links agree",
+				},
+			},
+			Action: importChain,
+		},
+		{
+			Name:  "skipchain",
+			Usage: "inspect skipchains",
+			Subcommands: []cli.Command{
+				{
+					Name:      "path",
+					Usage:     "show the blocks traversed to get from one block to another",
+					ArgsUsage: "id-from id-to",
+					Action:    scPath,
+				},
+			},
+		},
 		{
 			Name:  "list",
 			Usage: "handle list of skipblocks",
@@ -112,6 +180,22 @@ func main() {
 							Name:  "long, l",
 							Usage: "give long id of blocks",
 						},
+						cli.StringFlag{
+							Name:  "chain",
+							Usage: "only list blocks of this skipchain",
+						},
+						cli.IntFlag{
+							Name:  "limit",
+							Usage: "only list this many blocks per skipchain",
+						},
+						cli.IntFlag{
+							Name:  "offset",
+							Usage: "skip this many blocks per skipchain",
+						},
+						cli.BoolFlag{
+							Name:  "verify",
+							Usage: "verify the signatures and links of the blocks",
+						},
 					},
 					Action: lsKnown,
 				},
@@ -119,7 +203,17 @@ func main() {
 					Name:      "index",
 					Usage:     "create index-files for all known skipchains",
 					ArgsUsage: "output path",
-					Action:    lsIndex,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "write json instead of html",
+						},
+						cli.StringFlag{
+							Name:  "html-template",
+							Usage: "template-file defining \"index\" and \"block\"",
+						},
+					},
+					Action: lsIndex,
 				},
 				{
 					Name:      "fetch",
@@ -130,9 +224,43 @@ func main() {
 							Name:  "recursive, r",
 							Usage: "recurse into other conodes",
 						},
+						cli.IntFlag{
+							Name:  "max-depth",
+							Usage: "recurse at most this many hops (0: no limit)",
+						},
+						cli.IntFlag{
+							Name:  "max-nodes",
+							Usage: "ask at most this many conodes (0: no limit)",
+						},
 					},
 					Action: lsFetch,
 				},
+				{
+					Name:      "compact",
+					Usage:     "drop local blocks not needed to prove the latest block",
+					ArgsUsage: "skipchain-id",
+					Action:    lsCompact,
+				},
+			},
+		},
+		{
+			Name:  "admin",
+			Usage: "administrate conodes",
+			Subcommands: []cli.Command{
+				{
+					Name:      "status",
+					Aliases:   []string{"s"},
+					Usage:     "show the number of skipchains and blocks of the conodes",
+					ArgsUsage: groupsDef,
+					Action:    adminStatus,
+				},
+				{
+					Name:      "ping",
+					Aliases:   []string{"p"},
+					Usage:     "check that the skipchain-service of a conode is responsive",
+					ArgsUsage: "address of the conode",
+					Action:    adminPing,
+				},
 			},
 		},
 	}
@@ -252,9 +380,11 @@ func addWeb(c *cli.Context) error {
 	}
 	latest := guc.Update[len(guc.Update)-1]
 	log.Info("Reading file", c.Args().Get(1))
-	data, err := ioutil.ReadFile(c.Args().Get(1))
-	log.ErrFatal(err)
-	ssbr, cerr := client.StoreSkipBlock(latest, nil, &html{data})
+	data, err := readData(c.String("type"), c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	ssbr, cerr := client.StoreSkipBlock(latest, nil, data)
 	if cerr != nil {
 		return errors.New("while storing block: " + cerr.Error())
 	}
@@ -264,6 +394,38 @@ func addWeb(c *cli.Context) error {
 	return nil
 }
 
+// readData reads the file and returns the data to be stored in a block. For
+// the type html the content is wrapped in an html-message, for raw it is
+// stored as is. Else the file is decoded as TOML if it ends in .toml, or as
+// JSON, into a message of one of the dataTypes, so that it keeps its type
+// when marshalled.
+func readData(typ, file string) (network.Message, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "html":
+		return &html{buf}, nil
+	case "raw":
+		return buf, nil
+	}
+	newMsg, ok := dataTypes[typ]
+	if !ok {
+		return nil, errors.New("unknown type " + typ)
+	}
+	msg := newMsg()
+	if strings.HasSuffix(file, ".toml") {
+		_, err = toml.Decode(string(buf), msg)
+	} else {
+		err = json.Unmarshal(buf, msg)
+	}
+	if err != nil {
+		return nil, errors.New("couldn't parse " + file + ": " + err.Error())
+	}
+	return msg, nil
+}
+
 // Updates a block to the latest block
 func update(c *cli.Context) error {
 	log.Info("Updating block")
@@ -295,6 +457,133 @@ func update(c *cli.Context) error {
 	return nil
 }
 
+// analyze prints statistics about the proof-lengths and the heights of the
+// blocks of a locally stored skipchain.
+func analyze(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give skipchain-id to analyze")
+	}
+	cfg := getConfigOrFail(c)
+	sb := cfg.Sbm.GetFuzzy(c.Args().First())
+	if sb == nil {
+		return errors.New("didn't find skipchain in local store")
+	}
+	if sb.Index > 0 {
+		sb = cfg.Sbm.GetByID(sb.GenesisID)
+		if sb == nil {
+			return errors.New("didn't find genesis-block in local store")
+		}
+	}
+	stats, err := cfg.analyzeChain(sb)
+	if err != nil {
+		return err
+	}
+	log.Infof("Skipchain %x with base %d and maximum height %d",
+		sb.Hash, sb.BaseHeight, sb.MaximumHeight)
+	log.Infof("Blocks: %d", stats.Blocks)
+	for h := 1; h <= sb.MaximumHeight; h++ {
+		log.Infof("  height %d: %d blocks", h, stats.Heights[h])
+	}
+	log.Infof("Proofs over %d pairs of blocks: average length %.2f, worst %d",
+		stats.Pairs, stats.Average, stats.Worst)
+	for l := 0; l <= stats.Worst; l++ {
+		if stats.Lengths[l] > 0 {
+			log.Infof("  length %d: %d pairs", l, stats.Lengths[l])
+		}
+	}
+	return nil
+}
+
+// export writes all blocks of a skipchain to a file, after fetching the
+// missing blocks from the conodes.
+func export(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("please give skipchain-id and file")
+	}
+	cfg := getConfigOrFail(c)
+	sb := cfg.Sbm.GetFuzzy(c.Args().First())
+	if sb == nil {
+		return errors.New("didn't find skipchain in local store")
+	}
+	genesisID := sb.SkipChainID()
+	genesis := cfg.Sbm.GetByID(genesisID)
+	if genesis == nil {
+		return errors.New("didn't find genesis-block in local store")
+	}
+	latest, err := cfg.Sbm.GetLatest(genesis)
+	if err != nil {
+		return err
+	}
+	client := skipchain.NewClient()
+	guc, cerr := client.GetUpdateChain(latest.Roster, latest.Hash)
+	if cerr != nil {
+		return errors.New("while updating chain: " + cerr.Error())
+	}
+	latest = guc.Update[len(guc.Update)-1]
+	gbr, cerr := client.GetBlockRange(latest.Roster, genesisID, 0, latest.Index)
+	if cerr != nil {
+		return errors.New("while fetching blocks: " + cerr.Error())
+	}
+	for _, b := range gbr.Blocks {
+		cfg.Sbm.Store(b)
+	}
+	log.ErrFatal(cfg.save(c))
+	buf, err := cfg.exportChain(genesis)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.Args().Get(1), buf, 0660); err != nil {
+		return err
+	}
+	log.Infof("Exported skipchain %x to %s", genesisID, c.Args().Get(1))
+	return nil
+}
+
+// importChain reads a file written by export and stores the blocks if all
+// of them verify.
+func importChain(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give file to import")
+	}
+	buf, err := ioutil.ReadFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	cfg := getConfigOrFail(c)
+	n, err := cfg.importChain(buf, c.Bool("check"))
+	if err != nil {
+		return errors.New("couldn't import skipchain: " + err.Error())
+	}
+	log.ErrFatal(cfg.save(c))
+	log.Infof("Imported %d blocks", n)
+	return nil
+}
+
+// scPath prints the hops of the skip-traversal from one block to another.
+func scPath(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("please give id-from and id-to")
+	}
+	cfg := getConfigOrFail(c)
+	from := cfg.Sbm.GetFuzzy(c.Args().Get(0))
+	if from == nil {
+		return errors.New("didn't find block " + c.Args().Get(0))
+	}
+	to := cfg.Sbm.GetFuzzy(c.Args().Get(1))
+	if to == nil {
+		return errors.New("didn't find block " + c.Args().Get(1))
+	}
+	path, err := cfg.skipPath(from, to)
+	if err != nil {
+		return err
+	}
+	log.ErrFatal(cfg.save(c))
+	for _, line := range pathLines(path) {
+		log.Info(line)
+	}
+	return nil
+}
+
 // lsKnown shows all known skipblocks
 func lsKnown(c *cli.Context) error {
 	cfg, err := loadConfig(c)
@@ -305,9 +594,31 @@ func lsKnown(c *cli.Context) error {
 		log.Info("Didn't find any blocks yet")
 		return nil
 	}
-	for _, g := range cfg.getSortedGenesis() {
-		short := !c.Bool("long")
-		log.Info(g.Sprint(short))
+	genesis := cfg.getSortedGenesis()
+	if id := c.String("chain"); id != "" {
+		sb := cfg.Sbm.GetFuzzy(id)
+		if sb == nil {
+			return errors.New("didn't find skipchain " + id)
+		}
+		if sb.Index > 0 {
+			sb = cfg.Sbm.GetByID(sb.GenesisID)
+			if sb == nil {
+				return errors.New("didn't find genesis-block of " + id)
+			}
+		}
+		genesis = []*skipchain.SkipBlock{sb}
+	}
+	short := !c.Bool("long")
+	verify := c.Bool("verify")
+	limit, offset := c.Int("limit"), c.Int("offset")
+	for _, g := range genesis {
+		log.Info(cfg.blockLine(g, short, verify))
+		if limit > 0 || offset > 0 {
+			cfg.walkChain(g, offset, limit, func(sb *skipchain.SkipBlock) {
+				log.Info("  " + cfg.blockLine(sb, short, verify))
+			})
+			continue
+		}
 		sub := sbli{}
 		for _, sb := range cfg.Sbm.SkipBlocks {
 			if sb.GenesisID.Equal(g.Hash) {
@@ -316,12 +627,37 @@ func lsKnown(c *cli.Context) error {
 		}
 		sort.Sort(sub)
 		for _, sb := range sub {
-			log.Info("  " + sb.Sprint(short))
+			log.Info("  " + cfg.blockLine(sb, short, verify))
 		}
 	}
 	return nil
 }
 
+// lsCompact removes all blocks of a skipchain from the local config that are
+// not on the path of highest forward-links from the genesis-block to the
+// latest block. The conodes are not touched.
+func lsCompact(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give skipchain-id")
+	}
+	cfg := getConfigOrFail(c)
+	sb := cfg.Sbm.GetFuzzy(c.Args().First())
+	if sb == nil {
+		return errors.New("didn't find skipchain in local store")
+	}
+	genesis := cfg.Sbm.GetByID(sb.SkipChainID())
+	if genesis == nil {
+		return errors.New("didn't find genesis-block in local store")
+	}
+	removed, err := cfg.compactChain(genesis)
+	if err != nil {
+		return err
+	}
+	log.ErrFatal(cfg.save(c))
+	log.Infof("Removed %d blocks", removed)
+	return nil
+}
+
 // lsIndex writes one index-file for every known skipchain and an index.html
 // for all skiplchains.
 func lsIndex(c *cli.Context) error {
@@ -330,13 +666,29 @@ func lsIndex(c *cli.Context) error {
 		return errors.New("Missing output path")
 	}
 
+	tmpl := indexTemplate
+	if file := c.String("html-template"); file != "" {
+		var err error
+		tmpl, err = template.ParseFiles(file)
+		if err != nil {
+			return errors.New("couldn't read template: " + err.Error())
+		}
+	}
+
 	cleanHTMLFiles(output)
 
 	cfg, err := loadConfig(c)
 	if err != nil {
 		return errors.New("couldn't read config: " + err.Error())
 	}
+	if c.Bool("json") {
+		return cfg.writeIndexJSON(output)
+	}
+	return cfg.writeIndexHTML(output, tmpl)
+}
 
+// writeIndexJSON writes the genesis-blocks as json in the index-files.
+func (cfg *config) writeIndexJSON(output string) error {
 	// Get the list of genesis block
 	genesis := cfg.getSortedGenesis()
 
@@ -346,13 +698,9 @@ func lsIndex(c *cli.Context) error {
 	for i, g := range genesis {
 		block := &blocks.Blocks[i]
 		block.GenesisID = hex.EncodeToString(g.Hash)
-		block.Servers = make([]string, len(g.Roster.List))
+		block.Servers = rosterAddresses(g.Roster)
 		block.Data = g.Data
 
-		for j, server := range g.Roster.List {
-			block.Servers[j] = server.Address.Host() + ":" + server.Address.Port()
-		}
-
 		// Write the genesis block file
 		content, _ := json.Marshal(block)
 		err := ioutil.WriteFile(filepath.Join(output, block.GenesisID+".html"), content, 0644)
@@ -376,6 +724,51 @@ func lsIndex(c *cli.Context) error {
 	return nil
 }
 
+// writeIndexHTML renders the templates "block" for every genesis-block and
+// "index" for all genesis-blocks into the index-files.
+func (cfg *config) writeIndexHTML(output string, tmpl *template.Template) error {
+	genesis := cfg.getSortedGenesis()
+	blocks := htmlBlockList{}
+	blocks.Blocks = make([]htmlBlock, len(genesis))
+	for i, g := range genesis {
+		block := &blocks.Blocks[i]
+		block.GenesisID = hex.EncodeToString(g.Hash)
+		block.Servers = rosterAddresses(g.Roster)
+		block.Data = string(g.Data)
+		if len(g.Data) > maxDataPreview {
+			block.Data = string(g.Data[:maxDataPreview]) + "..."
+		}
+		cfg.walkChain(g, 0, 0, func(*skipchain.SkipBlock) {
+			block.Blocks++
+		})
+
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "block", block); err != nil {
+			return err
+		}
+		err := ioutil.WriteFile(filepath.Join(output, block.GenesisID+".html"),
+			buf.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "index", blocks); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(output, "index.html"), buf.Bytes(), 0644)
+}
+
+// rosterAddresses returns the host:port of all conodes of the roster.
+func rosterAddresses(roster *onet.Roster) []string {
+	servers := make([]string, len(roster.List))
+	for j, server := range roster.List {
+		servers[j] = server.Address.Host() + ":" + server.Address.Port()
+	}
+	return servers
+}
+
 func lsFetch(c *cli.Context) error {
 	cfg := getConfigOrFail(c)
 	rec := c.Bool("recursive")
@@ -396,31 +789,111 @@ func lsFetch(c *cli.Context) error {
 		log.Info(si.Address)
 	}
 	client := skipchain.NewClient()
+	cfg.fetchChains(sisNew, sisAll, rec, c.Int("max-depth"), c.Int("max-nodes"),
+		func(si *network.ServerIdentity) ([]*skipchain.SkipBlock, error) {
+			gasr, cerr := client.GetAllSkipchains(si)
+			if cerr != nil {
+				return nil, cerr
+			}
+			return gasr.SkipChains, nil
+		})
+	return cfg.save(c)
+}
+
+// fetchChains asks all conodes in sisNew for their skipchains, using fetch,
+// and stores them. If rec is true, the conodes of the rosters of the found
+// skipchains are asked, too. sisAll holds all conodes that have already been
+// added, so every conode is asked at most once. maxDepth limits the number
+// of hops from the conodes in sisNew and maxNodes the number of conodes
+// asked, where 0 means no limit. It returns the conodes asked.
+func (cfg *config) fetchChains(sisNew []*network.ServerIdentity,
+	sisAll map[network.ServerIdentityID]*network.ServerIdentity, rec bool,
+	maxDepth, maxNodes int,
+	fetch func(*network.ServerIdentity) ([]*skipchain.SkipBlock, error)) []*network.ServerIdentity {
+	depth := map[network.ServerIdentityID]int{}
+	var asked []*network.ServerIdentity
+	depthReached := false
 	for len(sisNew) > 0 {
-		si := sisNew[0]
-		if len(sisNew) > 1 {
-			sisNew = sisNew[1:]
-		} else {
-			sisNew = []*network.ServerIdentity{}
+		if maxNodes > 0 && len(asked) >= maxNodes {
+			log.Infof("Reached max-nodes %d, not asking %d more conodes",
+				maxNodes, len(sisNew))
+			break
 		}
+		si := sisNew[0]
+		sisNew = sisNew[1:]
 		log.Info("si, sisNew:", si, sisNew)
-		gasr, cerr := client.GetAllSkipchains(si)
-		if cerr != nil {
+		asked = append(asked, si)
+		sbs, err := fetch(si)
+		if err != nil {
 			// Error is not fatal here - perhaps the node is down,
 			// but we can continue anyway.
-			log.Error(cerr)
+			log.Error(err)
 			continue
 		}
-		for _, sb := range gasr.SkipChains {
+		for _, sb := range sbs {
 			log.Infof("Found skipchain %x", sb.SkipChainID())
 			cfg.Sbm.Store(sb)
-			if rec {
-				log.Info("Recursive fetch")
-				sisNew = updateNewSIs(sb.Roster, sisNew, sisAll)
+			if !rec {
+				continue
+			}
+			if maxDepth > 0 && depth[si.ID] >= maxDepth {
+				for _, s := range sb.Roster.List {
+					if _, exists := sisAll[s.ID]; !exists {
+						depthReached = true
+					}
+				}
+				continue
+			}
+			log.Info("Recursive fetch")
+			start := len(sisNew)
+			sisNew = updateNewSIs(sb.Roster, sisNew, sisAll)
+			for _, s := range sisNew[start:] {
+				depth[s.ID] = depth[si.ID] + 1
 			}
 		}
 	}
-	return cfg.save(c)
+	if depthReached {
+		log.Infof("Reached max-depth %d, didn't follow all conodes", maxDepth)
+	}
+	return asked
+}
+
+// adminStatus prints the status of all conodes in the given group.
+func adminStatus(c *cli.Context) error {
+	group := readGroup(c, 0)
+	client := skipchain.NewClient()
+	for _, si := range group.Roster.List {
+		gsr, cerr := client.GetStatus(si)
+		if cerr != nil {
+			log.Errorf("Couldn't get status of %s: %s", si.Address, cerr)
+			continue
+		}
+		log.Infof("Conode %s: %d skipchains, %d blocks, propagating: %t",
+			si.Address, gsr.Chains, gsr.Blocks, gsr.Propagating)
+	}
+	return nil
+}
+
+// adminPing sends a HealthCheck to the conode at the given address and shows
+// the reply together with the round-trip latency.
+func adminPing(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return errors.New("please give the address of the conode")
+	}
+	addr := network.NewTCPAddress(c.Args().First())
+	if !addr.Valid() {
+		return errors.New("invalid address " + c.Args().First())
+	}
+	si := network.NewServerIdentity(network.Suite.Point().Null(), addr)
+	start := time.Now()
+	reply, cerr := skipchain.NewClient().Ping(si)
+	if cerr != nil {
+		return errors.New("couldn't ping conode: " + cerr.Error())
+	}
+	log.Infof("Conode %s: version %s, time %s, latency %s",
+		reply.ServerIdentity, reply.Version,
+		time.Unix(0, reply.Time).Format(time.RFC3339), time.Since(start))
+	return nil
 }
 
 // Remove every file matching *.html in the given directory
@@ -454,6 +927,59 @@ type jsonBlockList struct {
 	Blocks []jsonBlock
 }
 
+// maxDataPreview is the number of bytes of the data shown in the html-files.
+const maxDataPreview = 256
+
+// skipchain element to be rendered by the html-templates
+type htmlBlock struct {
+	GenesisID string
+	Servers   []string
+	// Data is the beginning of the data of the genesis-block.
+	Data string
+	// Blocks is the number of locally known blocks of the skipchain.
+	Blocks int
+}
+
+// list of skipchains to be rendered by the "index" html-template
+type htmlBlockList struct {
+	Blocks []htmlBlock
+}
+
+// indexTemplate is used by lsIndex if no html-template is given. The
+// html/template package escapes the data of the blocks.
+var indexTemplate = template.Must(template.New("index").Parse(`{{define "index"}}<!DOCTYPE html>
+<html>
+<head><title>Skipchains</title></head>
+<body>
+<h1>Skipchains</h1>
+<table>
+<tr><th>Genesis-block</th><th>Blocks</th><th>Conodes</th><th>Data</th></tr>
+{{range .Blocks}}<tr>
+<td><a href="{{.GenesisID}}.html">{{.GenesisID}}</a></td>
+<td>{{.Blocks}}</td>
+<td>{{range .Servers}}{{.}}<br>{{end}}</td>
+<td><pre>{{.Data}}</pre></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+{{end}}{{define "block"}}<!DOCTYPE html>
+<html>
+<head><title>Skipchain {{.GenesisID}}</title></head>
+<body>
+<h1>Skipchain {{.GenesisID}}</h1>
+<p>Blocks: {{.Blocks}}</p>
+<h2>Conodes</h2>
+<ul>
+{{range .Servers}}<li>{{.}}</li>
+{{end}}</ul>
+<h2>Data</h2>
+<pre>{{.Data}}</pre>
+<p><a href="index.html">All skipchains</a></p>
+</body>
+</html>
+{{end}}`))
+
 // sbl is used to make a nice output with ordered list of geneis-skipblocks.
 type sbl []*skipchain.SkipBlock
 
@@ -555,6 +1081,267 @@ func (cfg *config) getSortedGenesis() []*skipchain.SkipBlock {
 	return genesis
 }
 
+// walkChain follows the forward-links starting at genesis and calls f for
+// every block, skipping the first offset blocks. If limit is > 0, f is called
+// at most limit times. It stops at the first block missing in the config.
+func (cfg *config) walkChain(genesis *skipchain.SkipBlock, offset, limit int,
+	f func(*skipchain.SkipBlock)) {
+	sb := genesis
+	for i := 0; limit <= 0 || i < offset+limit; i++ {
+		if i >= offset {
+			f(sb)
+		}
+		if sb.GetForwardLen() == 0 {
+			return
+		}
+		sb = cfg.Sbm.GetByID(sb.GetForward(0).Hash)
+		if sb == nil {
+			return
+		}
+	}
+}
+
+// blockLine returns the description of the block. If verify is true and the
+// block doesn't verify, the error is appended.
+func (cfg *config) blockLine(sb *skipchain.SkipBlock, short, verify bool) string {
+	line := sb.Sprint(short)
+	if verify {
+		if err := cfg.verifyBlock(sb); err != nil {
+			line += " - VERIFICATION FAILED: " + err.Error()
+		}
+	}
+	return line
+}
+
+// verifyBlock checks the signatures of the forward-links of the block and
+// that the previous block, if it is stored, links to this block.
+func (cfg *config) verifyBlock(sb *skipchain.SkipBlock) error {
+	if !sb.Hash.Equal(sb.CalculateHash()) {
+		return errors.New("wrong hash")
+	}
+	if sb.Roster == nil {
+		return errors.New("no roster")
+	}
+	if err := sb.VerifyForwardSignatures(); err != nil {
+		return err
+	}
+	if sb.Index == 0 || len(sb.BackLinkIDs) == 0 {
+		return nil
+	}
+	prev := cfg.Sbm.GetByID(sb.BackLinkIDs[0])
+	if prev == nil {
+		return nil
+	}
+	if prev.Index != sb.Index-1 {
+		return fmt.Errorf("back-link points to block with index %d", prev.Index)
+	}
+	if prev.GetForwardLen() == 0 || !prev.GetForward(0).Hash.Equal(sb.Hash) {
+		return errors.New("previous block doesn't link to this block")
+	}
+	return nil
+}
+
+// exportChain returns all locally stored blocks of the chain starting at
+// genesis as a marshalled chainExport.
+func (cfg *config) exportChain(genesis *skipchain.SkipBlock) ([]byte, error) {
+	ce := &chainExport{}
+	cfg.walkChain(genesis, 0, 0, func(sb *skipchain.SkipBlock) {
+		ce.Blocks = append(ce.Blocks, sb)
+	})
+	return network.Marshal(ce)
+}
+
+// importChain verifies the hashes and the links of all blocks of the
+// marshalled chainExport and stores them. If check is true, the consistency
+// of back-links and forward-links is verified, too. If one block doesn't
+// verify, nothing is stored. It returns the number of imported blocks.
+func (cfg *config) importChain(buf []byte, check bool) (int, error) {
+	_, msg, err := network.Unmarshal(buf)
+	if err != nil {
+		return 0, err
+	}
+	ce, ok := msg.(*chainExport)
+	if !ok || len(ce.Blocks) == 0 {
+		return 0, errors.New("no skipchain in file")
+	}
+	sbm := skipchain.NewSkipBlockMap()
+	for _, sb := range cfg.Sbm.SkipBlocks {
+		sbm.Store(sb)
+	}
+	for _, sb := range ce.Blocks {
+		if !sb.Hash.Equal(sb.CalculateHash()) {
+			return 0, fmt.Errorf("wrong hash of block %x", sb.Hash)
+		}
+		sbm.Store(sb)
+	}
+	for _, sb := range ce.Blocks {
+		if err := sbm.VerifyLinks(sb); err != nil {
+			return 0, fmt.Errorf("block %x: %s", sb.Hash, err)
+		}
+	}
+	if check {
+		if err := sbm.CheckConsistency(ce.Blocks[0].SkipChainID()); err != nil {
+			return 0, err
+		}
+	}
+	for _, sb := range ce.Blocks {
+		cfg.Sbm.Store(sb)
+	}
+	return len(ce.Blocks), nil
+}
+
+// compactChain keeps only the blocks of the chain starting at genesis that
+// are needed to prove the latest block: the genesis-block, the blocks
+// reached by following the highest forward-links and the latest block. It
+// returns the number of removed blocks.
+func (cfg *config) compactChain(genesis *skipchain.SkipBlock) (int, error) {
+	latest, err := cfg.Sbm.GetLatest(genesis)
+	if err != nil {
+		return 0, err
+	}
+	proof, err := cfg.Sbm.GetProof(genesis, latest)
+	if err != nil {
+		return 0, err
+	}
+	keep := map[string]bool{}
+	for _, sb := range proof {
+		keep[string(sb.Hash)] = true
+	}
+	cfg.Sbm.Lock()
+	defer cfg.Sbm.Unlock()
+	removed := 0
+	for id, sb := range cfg.Sbm.SkipBlocks {
+		if sb.SkipChainID().Equal(genesis.Hash) && !keep[id] {
+			delete(cfg.Sbm.SkipBlocks, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// skipPath returns the blocks traversed to get from the block from to the
+// block to, following the highest possible forward-links. Missing blocks are
+// fetched from the roster of from.
+func (cfg *config) skipPath(from, to *skipchain.SkipBlock) ([]*skipchain.SkipBlock, error) {
+	if !from.SkipChainID().Equal(to.SkipChainID()) {
+		return nil, errors.New("blocks are on different skipchains")
+	}
+	if from.Index > to.Index {
+		return nil, errors.New("id-from is after id-to")
+	}
+	path, err := cfg.Sbm.GetProof(from, to)
+	if err == nil {
+		return path, nil
+	}
+	client := skipchain.NewClient()
+	guc, cerr := client.GetUpdateChain(from.Roster, from.Hash)
+	if cerr != nil {
+		return nil, errors.New("while updating chain: " + cerr.Error())
+	}
+	for _, sb := range guc.Update {
+		cfg.Sbm.Store(sb)
+	}
+	if path, err = cfg.Sbm.GetProof(from, to); err == nil {
+		return path, nil
+	}
+	gbr, cerr := client.GetBlockRange(from.Roster, from.SkipChainID(),
+		from.Index, to.Index)
+	if cerr != nil {
+		return nil, errors.New("while fetching blocks: " + cerr.Error())
+	}
+	for _, sb := range gbr.Blocks {
+		cfg.Sbm.Store(sb)
+	}
+	return cfg.Sbm.GetProof(from, to)
+}
+
+// pathLines returns one line per hop of the path in the form
+// "index(height) -> index".
+func pathLines(path []*skipchain.SkipBlock) []string {
+	var lines []string
+	for i := 0; i < len(path)-1; i++ {
+		lines = append(lines, fmt.Sprintf("%d(%d) -> %d", path[i].Index,
+			path[i].Height, path[i+1].Index))
+	}
+	return lines
+}
+
+// maxAnalyzePairs is the maximum number of pairs of blocks for which
+// analyzeChain calculates the proof-length. For longer chains, random pairs
+// are chosen.
+const maxAnalyzePairs = 10000
+
+// chainStats holds the statistics calculated by analyzeChain.
+type chainStats struct {
+	// Blocks is the number of stored blocks of the chain.
+	Blocks int
+	// Heights maps each height to the number of blocks with that height.
+	Heights map[int]int
+	// Pairs is the number of pairs of blocks the proof-lengths are
+	// calculated for.
+	Pairs int
+	// Lengths maps each proof-length to the number of pairs with that
+	// length.
+	Lengths map[int]int
+	// Average and Worst are the average and maximum proof-length.
+	Average float64
+	Worst   int
+}
+
+// analyzeChain calculates the distribution of heights and of the
+// proof-lengths between pairs of blocks of the chain starting at genesis.
+func (cfg *config) analyzeChain(genesis *skipchain.SkipBlock) (*chainStats, error) {
+	var blocks []*skipchain.SkipBlock
+	cfg.walkChain(genesis, 0, 0, func(sb *skipchain.SkipBlock) {
+		blocks = append(blocks, sb)
+	})
+	stats := &chainStats{
+		Blocks:  len(blocks),
+		Heights: map[int]int{},
+		Lengths: map[int]int{},
+	}
+	for _, sb := range blocks {
+		stats.Heights[sb.Height]++
+	}
+	addPair := func(from, to *skipchain.SkipBlock) error {
+		l, err := cfg.Sbm.ProofLength(from, to)
+		if err != nil {
+			return err
+		}
+		stats.Pairs++
+		stats.Lengths[l]++
+		stats.Average += float64(l)
+		if l > stats.Worst {
+			stats.Worst = l
+		}
+		return nil
+	}
+	n := len(blocks)
+	if n*(n-1)/2 <= maxAnalyzePairs {
+		for i := range blocks {
+			for j := i + 1; j < n; j++ {
+				if err := addPair(blocks[i], blocks[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		for p := 0; p < maxAnalyzePairs; p++ {
+			i, j := rand.Intn(n), rand.Intn(n)
+			if i > j {
+				i, j = j, i
+			}
+			if err := addPair(blocks[i], blocks[j]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if stats.Pairs > 0 {
+		stats.Average /= float64(stats.Pairs)
+	}
+	return stats, nil
+}
+
 func updateNewSIs(roster *onet.Roster, sisNew []*network.ServerIdentity,
 	sisAll map[network.ServerIdentityID]*network.ServerIdentity) []*network.ServerIdentity {
 	for _, si := range roster.List {
@@ -1,11 +1,328 @@
 package main
 
 import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/dedis/cothority/skipchain"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestMain(m *testing.M) {
 	log.MainTest(m)
 }
+
+func TestReadData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scmgr")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data.json")
+	log.ErrFatal(ioutil.WriteFile(file,
+		[]byte(`{"URL": "https://dedis.ch", "Hash": "AQID"}`), 0660))
+
+	msg, err := readData("skipchain.ExternalData", file)
+	log.ErrFatal(err)
+	buf, err := network.Marshal(msg)
+	log.ErrFatal(err)
+	_, back, err := network.Unmarshal(buf)
+	log.ErrFatal(err)
+	ed, ok := back.(*skipchain.ExternalData)
+	require.True(t, ok)
+	require.Equal(t, "https://dedis.ch", ed.URL)
+	require.Equal(t, []byte{1, 2, 3}, ed.Hash)
+
+	file = filepath.Join(dir, "data.toml")
+	log.ErrFatal(ioutil.WriteFile(file, []byte(`URL = "https://dedis.ch"`), 0660))
+	msg, err = readData("skipchain.ExternalData", file)
+	log.ErrFatal(err)
+	require.Equal(t, "https://dedis.ch", msg.(*skipchain.ExternalData).URL)
+
+	_, err = readData("skipchain.Unknown", file)
+	require.NotNil(t, err)
+	log.ErrFatal(ioutil.WriteFile(file, []byte(`URL = `), 0660))
+	_, err = readData("skipchain.ExternalData", file)
+	require.NotNil(t, err)
+	msg, err = readData("raw", file)
+	log.ErrFatal(err)
+	require.Equal(t, []byte(`URL = `), msg)
+}
+
+func TestConfig_WalkChain(t *testing.T) {
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	blocks := make([]*skipchain.SkipBlock, 20)
+	for i := range blocks {
+		sb := skipchain.NewSkipBlock()
+		sb.Index = i
+		sb.Data = []byte{byte(i)}
+		sb.Hash = sb.CalculateHash()
+		if i > 0 {
+			blocks[i-1].ForwardLink = []*skipchain.BlockLink{{Hash: sb.Hash}}
+		}
+		blocks[i] = sb
+	}
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+
+	var indexes []int
+	cfg.walkChain(blocks[0], 10, 5, func(sb *skipchain.SkipBlock) {
+		indexes = append(indexes, sb.Index)
+	})
+	require.Equal(t, []int{10, 11, 12, 13, 14}, indexes)
+
+	indexes = []int{}
+	cfg.walkChain(blocks[0], 18, 5, func(sb *skipchain.SkipBlock) {
+		indexes = append(indexes, sb.Index)
+	})
+	require.Equal(t, []int{18, 19}, indexes)
+}
+
+func TestConfig_WriteIndexHTML(t *testing.T) {
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	si := network.NewServerIdentity(network.Suite.Point().Base(),
+		network.NewAddress(network.PlainTCP, "127.0.0.1:2000"))
+	blocks := make([]*skipchain.SkipBlock, 3)
+	for i := range blocks {
+		sb := skipchain.NewSkipBlock()
+		sb.Index = i
+		sb.Roster = onet.NewRoster([]*network.ServerIdentity{si})
+		sb.Data = []byte("<script>alert(1)</script>")
+		sb.Hash = sb.CalculateHash()
+		if i > 0 {
+			blocks[i-1].ForwardLink = []*skipchain.BlockLink{{Hash: sb.Hash}}
+		}
+		blocks[i] = sb
+	}
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+
+	dir, err := ioutil.TempDir("", "scmgr")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	log.ErrFatal(cfg.writeIndexHTML(dir, indexTemplate))
+
+	id := hex.EncodeToString(blocks[0].Hash)
+	for _, file := range []string{"index.html", id + ".html"} {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, file))
+		log.ErrFatal(err)
+		page := string(buf)
+		require.Contains(t, page, id)
+		require.Contains(t, page, "127.0.0.1:2000")
+		require.Contains(t, page, "&lt;script&gt;")
+		require.NotContains(t, page, "<script>")
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, id+".html"))
+	log.ErrFatal(err)
+	require.Contains(t, string(buf), "Blocks: 3")
+}
+
+func TestConfig_FetchChains(t *testing.T) {
+	// Conode i knows a skipchain with the roster {i, i+1}, so the conodes
+	// form a line 0 - 1 - 2 - ... - 5.
+	sis := make([]*network.ServerIdentity, 6)
+	for i := range sis {
+		pub := network.Suite.Point().Mul(nil,
+			network.Suite.Scalar().SetInt64(int64(i+1)))
+		sis[i] = network.NewServerIdentity(pub,
+			network.NewAddress(network.PlainTCP, fmt.Sprintf("127.0.0.1:%d", 2000+i)))
+	}
+	fetch := func(si *network.ServerIdentity) ([]*skipchain.SkipBlock, error) {
+		for i := range sis {
+			if si.ID.Equal(sis[i].ID) && i+1 < len(sis) {
+				sb := skipchain.NewSkipBlock()
+				sb.Roster = onet.NewRoster(sis[i : i+2])
+				sb.Data = []byte{byte(i)}
+				sb.Hash = sb.CalculateHash()
+				return []*skipchain.SkipBlock{sb}, nil
+			}
+		}
+		return nil, nil
+	}
+	askedFrom := func(rec bool, maxDepth, maxNodes int) []*network.ServerIdentity {
+		cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+		sisAll := map[network.ServerIdentityID]*network.ServerIdentity{
+			sis[0].ID: sis[0],
+		}
+		return cfg.fetchChains(sis[0:1], sisAll, rec, maxDepth, maxNodes, fetch)
+	}
+
+	require.Equal(t, sis[0:1], askedFrom(false, 0, 0))
+	require.Equal(t, sis, askedFrom(true, 0, 0))
+	require.Equal(t, sis[0:3], askedFrom(true, 2, 0))
+	require.Equal(t, sis[0:4], askedFrom(true, 0, 4))
+	require.Equal(t, sis[0:2], askedFrom(true, 3, 2))
+}
+
+func TestConfig_ExportImport(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	blocks := newSignedChain(roster, 5)
+	genesis, latest := blocks[0], blocks[4]
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+
+	buf, err := cfg.exportChain(cfg.Sbm.GetByID(genesis.Hash))
+	log.ErrFatal(err)
+	cfg = &config{Sbm: skipchain.NewSkipBlockMap()}
+	n, err := cfg.importChain(buf, true)
+	log.ErrFatal(err)
+	require.Equal(t, 5, n)
+	require.Equal(t, 5, cfg.Sbm.Length())
+	imported, err := cfg.Sbm.GetLatest(cfg.Sbm.GetByID(genesis.Hash))
+	log.ErrFatal(err)
+	require.True(t, latest.Equal(imported))
+
+	// A block whose forward-link is not signed is refused.
+	_, msg, err := network.Unmarshal(buf)
+	log.ErrFatal(err)
+	ce := msg.(*chainExport)
+	ce.Blocks[2].ForwardLink[0].Signature = nil
+	buf, err = network.Marshal(ce)
+	log.ErrFatal(err)
+	cfg = &config{Sbm: skipchain.NewSkipBlockMap()}
+	_, err = cfg.importChain(buf, false)
+	require.NotNil(t, err)
+	require.Equal(t, 0, cfg.Sbm.Length())
+}
+
+func TestConfig_VerifyBlock(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	blocks := newSignedChain(roster, 4)
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+	for _, sb := range blocks {
+		log.ErrFatal(cfg.verifyBlock(sb))
+		require.NotContains(t, cfg.blockLine(sb, true, true), "FAILED")
+	}
+
+	// Corrupt the forward-link of block 1, which makes block 1 and 2
+	// fail.
+	cfg.Sbm.GetByID(blocks[1].Hash).ForwardLink[0].Hash = blocks[3].Hash
+	for i, sb := range blocks {
+		line := cfg.blockLine(sb, true, true)
+		if i == 1 || i == 2 {
+			require.Contains(t, line, "VERIFICATION FAILED")
+		} else {
+			require.NotContains(t, line, "FAILED")
+		}
+		require.NotContains(t, cfg.blockLine(sb, true, false), "FAILED")
+	}
+}
+
+func TestConfig_CompactChain(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	blocks := newSignedChain(roster, 10)
+	genesis, latest := blocks[0], blocks[9]
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+	other := newSignedChain(roster, 2)
+	for _, sb := range other {
+		cfg.Sbm.Store(sb)
+	}
+
+	removed, err := cfg.compactChain(genesis)
+	log.ErrFatal(err)
+	require.True(t, removed > 0)
+	require.Equal(t, 12-removed, cfg.Sbm.Length())
+	require.NotNil(t, cfg.Sbm.GetByID(other[1].Hash))
+
+	l2, err := cfg.Sbm.GetLatest(genesis)
+	log.ErrFatal(err)
+	require.True(t, l2.Equal(latest))
+	proof, err := cfg.Sbm.GetProof(genesis, latest)
+	log.ErrFatal(err)
+	require.Equal(t, 10-removed, len(proof))
+	log.ErrFatal(skipchain.VerifyProof(genesis.Hash, proof))
+}
+
+func TestConfig_SkipPath(t *testing.T) {
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	blocks := skipchain.NewSyntheticChain(4, 4, 64)
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+	path, err := cfg.skipPath(blocks[1], blocks[63])
+	log.ErrFatal(err)
+	lines := pathLines(path)
+	require.Equal(t, len(path)-1, len(lines))
+	require.True(t, len(lines) < 63-1)
+	require.Equal(t, "1(1) -> 2", lines[0])
+	require.Contains(t, lines, "48(3) -> 52")
+	require.True(t, path[len(path)-1].Equal(blocks[63]))
+
+	_, err = cfg.skipPath(blocks[63], blocks[1])
+	require.NotNil(t, err)
+	other := skipchain.NewSkipBlock()
+	other.Index = 10
+	other.GenesisID = skipchain.SkipBlockID{1, 2, 3}
+	_, err = cfg.skipPath(blocks[1], other)
+	require.NotNil(t, err)
+}
+
+func TestConfig_AnalyzeChain(t *testing.T) {
+	nbrBlocks := 64
+	cfg := &config{Sbm: skipchain.NewSkipBlockMap()}
+	blocks := skipchain.NewSyntheticChain(4, 4, nbrBlocks)
+	for _, sb := range blocks {
+		cfg.Sbm.Store(sb)
+	}
+
+	l, err := cfg.Sbm.ProofLength(blocks[0], blocks[63])
+	log.ErrFatal(err)
+	require.Equal(t, 9, l)
+	l, err = cfg.Sbm.ProofLength(blocks[1], blocks[63])
+	log.ErrFatal(err)
+	require.Equal(t, 14, l)
+	_, err = cfg.Sbm.ProofLength(blocks[63], blocks[1])
+	require.NotNil(t, err)
+
+	stats, err := cfg.analyzeChain(blocks[0])
+	log.ErrFatal(err)
+	require.Equal(t, nbrBlocks, stats.Blocks)
+	require.Equal(t, map[int]int{1: 48, 2: 12, 3: 3, 4: 1}, stats.Heights)
+	require.Equal(t, nbrBlocks*(nbrBlocks-1)/2, stats.Pairs)
+	pairs := 0
+	for _, n := range stats.Lengths {
+		pairs += n
+	}
+	require.Equal(t, stats.Pairs, pairs)
+	require.True(t, stats.Worst >= 14)
+	require.True(t, stats.Average > 1 && stats.Average < float64(stats.Worst))
+}
+
+// newSignedChain creates a skipchain with nbr blocks on the roster and
+// returns all blocks.
+func newSignedChain(roster *onet.Roster, nbr int) []*skipchain.SkipBlock {
+	client := skipchain.NewClient()
+	genesis, cerr := client.CreateGenesis(roster, 2, 2,
+		skipchain.VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	for i := 1; i < nbr; i++ {
+		ssbr, cerr := client.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+	gbr, cerr := client.GetBlockRange(roster, genesis.Hash, 0, latest.Index)
+	log.ErrFatal(cerr)
+	return gbr.Blocks
+}
@@ -19,6 +19,11 @@ main(){
 	test Index
 	test Html
 	test Fetch
+	test Analyze
+	test AdminStatus
+	test AdminPing
+	test Export
+	test Compact
 	stopTest
 }
 
@@ -32,6 +37,48 @@ testFetch(){
 	testGrep 2004 runSc list known
 }
 
+testAnalyze(){
+	startCl
+	setupGenesis
+	testFail runSc analyze
+	testFail runSc analyze 1234
+	testGrep "Blocks: 1" runSc analyze $ID
+}
+
+testAdminPing(){
+	startCl
+	testFail runSc admin ping
+	testGrep "latency" runSc admin ping 127.0.0.1:2002
+}
+
+testAdminStatus(){
+	startCl
+	testFail runSc admin status
+	setupGenesis
+	testGrep "propagating: false" runSc admin status public.toml
+}
+
+testExport(){
+	startCl
+	setupGenesis
+	testOK runSc add $ID public.toml
+	testFail runSc export $ID
+	testOK runSc export $ID chain.bin
+	rm $CFG
+	testFail runSc import
+	testOK runSc import -check chain.bin
+	testGrep "Blocks: 2" runSc analyze $ID
+	rm chain.bin
+}
+
+testCompact(){
+	startCl
+	setupGenesis
+	testFail runSc list compact
+	testOK runSc list compact $ID
+	testGrep "Blocks: 1" runSc analyze $ID
+}
+
 testHtml(){
 	startCl
 	testOK runSc create -html http://dedis.ch public.toml
@@ -98,7 +145,11 @@ testIndex(){
 	testGrep "127.0.0.1" cat index.html
 	testGrep "$ID" cat "$ID.html"
 	testGrep "127.0.0.1" cat "$ID.html"
+	testGrep "<html>" cat index.html
 	testNFile random.html
+
+	testOK runSc list index --json $PWD
+	testGrep "GenesisID" cat index.html
 }
 
 testConfig(){
@@ -0,0 +1,152 @@
+package skipchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the protocol used by the leader to collect signed
+acknowledgements from all nodes that they stored a new block.
+*/
+
+const ackProtocolName = "SkipchainAck"
+
+func init() {
+	network.RegisterMessage(&AckRequest{})
+	network.RegisterMessage(&BlockAck{})
+}
+
+// AckRequest is sent by the leader to all nodes to ask for an acknowledgement
+// of the block with the given ID.
+type AckRequest struct {
+	ID SkipBlockID
+}
+
+// BlockAck is a Schnorr-signature of a node on the ackMessage of a block,
+// proving that this node stored the block. If the node doesn't have the
+// block, the signature is empty.
+type BlockAck struct {
+	ServerIdentity *network.ServerIdentity
+	Signature      crypto.SchnorrSig
+}
+
+// Verify returns an error if the signature of the acknowledgement is not
+// valid for the given block.
+func (ba *BlockAck) Verify(id SkipBlockID) error {
+	if len(ba.Signature) == 0 {
+		return errors.New("empty signature")
+	}
+	return crypto.VerifySchnorr(network.Suite, ba.ServerIdentity.Public,
+		ackMessage(id), ba.Signature)
+}
+
+// ackMessage returns the message signed in a BlockAck. It is tagged, so that
+// an acknowledgement can't be used as a signature on the block-hash
+// elsewhere.
+func ackMessage(id SkipBlockID) []byte {
+	h := sha256.New()
+	h.Write([]byte("skipchain-ack"))
+	h.Write(id)
+	return h.Sum(nil)
+}
+
+// ackProtocol asks all children for a BlockAck and returns the valid ones to
+// the root.
+type ackProtocol struct {
+	*onet.TreeNodeInstance
+	service *Service
+	// ID of the block to acknowledge, only used by the root.
+	ID SkipBlockID
+	// Acks is filled by the root with all valid acknowledgements.
+	Acks           chan []*BlockAck
+	ChannelRequest chan struct {
+		*onet.TreeNode
+		AckRequest
+	}
+	ChannelAck chan struct {
+		*onet.TreeNode
+		BlockAck
+	}
+}
+
+func (s *Service) newAckProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	p := &ackProtocol{
+		TreeNodeInstance: n,
+		service:          s,
+		Acks:             make(chan []*BlockAck, 1),
+	}
+	for _, h := range []interface{}{&p.ChannelRequest, &p.ChannelAck} {
+		if err := p.RegisterChannel(h); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Start sends the request to all children.
+func (p *ackProtocol) Start() error {
+	return p.SendToChildren(&AckRequest{p.ID})
+}
+
+// Dispatch signs the block if it is stored. The root waits for all
+// acknowledgements of the children, the other nodes send their
+// acknowledgement to the root.
+func (p *ackProtocol) Dispatch() error {
+	defer p.Done()
+	if !p.IsRoot() {
+		req := <-p.ChannelRequest
+		return p.SendToParent(p.ack(req.ID, req.TreeNode.ServerIdentity))
+	}
+	acks := []*BlockAck{p.ack(p.ID, p.ServerIdentity())}
+	timeout := time.After(p.service.getPropagateTimeout())
+	for range p.Children() {
+		select {
+		case reply := <-p.ChannelAck:
+			// Only trust the identity of the tree, not the one sent
+			// in the acknowledgement.
+			ack := &reply.BlockAck
+			ack.ServerIdentity = reply.TreeNode.ServerIdentity
+			if err := ack.Verify(p.ID); err != nil {
+				log.Lvl2("Invalid acknowledgement from", ack.ServerIdentity, err)
+				continue
+			}
+			acks = append(acks, ack)
+		case <-timeout:
+			log.Lvl2("Timeout while waiting for acknowledgements")
+			p.Acks <- acks
+			return nil
+		}
+	}
+	p.Acks <- acks
+	return nil
+}
+
+// ack returns a signed acknowledgement if the block is stored and the
+// request comes from a member of its roster, or an acknowledgement with an
+// empty signature otherwise.
+func (p *ackProtocol) ack(id SkipBlockID, from *network.ServerIdentity) *BlockAck {
+	ack := &BlockAck{ServerIdentity: p.ServerIdentity()}
+	sb := p.service.Sbm.GetByID(id)
+	if sb == nil {
+		log.Lvl2("Don't have block to acknowledge")
+		return ack
+	}
+	if i, _ := sb.Roster.Search(from.ID); i < 0 {
+		log.Lvl2("Acknowledgement requested by a node outside the roster")
+		return ack
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, p.Private(), ackMessage(id))
+	if err != nil {
+		log.Error(err)
+		return ack
+	}
+	ack.Signature = sig
+	return ack
+}
@@ -1,8 +1,21 @@
 package skipchain
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -26,12 +39,159 @@ const (
 	// ErrorBlockInProgress indicates that currently a block is being formed
 	// and propagated
 	ErrorBlockInProgress
+	// ErrorCASFailed indicates that a CompareAndAppend didn't find the
+	// expected block as the latest block of the skipchain.
+	ErrorCASFailed
+	// ErrorTimeout indicates that the conode didn't reply before the
+	// timeout of the client.
+	ErrorTimeout
+	// ErrorBlockNotLatest indicates that a new block should be appended to
+	// a block that is not the latest block of the skipchain. The message
+	// holds the ID of the actual latest block, see NotLatestTip.
+	ErrorBlockNotLatest
+	// ErrorForwardLinkMissing indicates that the block doesn't have a
+	// signed forward-link at the requested height yet.
+	ErrorForwardLinkMissing
+)
+
+// The sentinel errors of the skipchain service, one for every error code.
+// The errors returned by the service have the same code as the sentinel, but
+// a more detailed message, so they have to be compared with the Is*
+// predicates and not with ==.
+var (
+	ErrBlockNotFound      = onet.NewClientErrorCode(ErrorBlockNotFound, "block not found")
+	ErrBlockNoParent      = onet.NewClientErrorCode(ErrorBlockNoParent, "parent block not found")
+	ErrBlockContent       = onet.NewClientErrorCode(ErrorBlockContent, "invalid block content")
+	ErrParameterWrong     = onet.NewClientErrorCode(ErrorParameterWrong, "wrong parameter")
+	ErrVerification       = onet.NewClientErrorCode(ErrorVerification, "verification failed")
+	ErrOnet               = onet.NewClientErrorCode(ErrorOnet, "onet error")
+	ErrBlockInProgress    = onet.NewClientErrorCode(ErrorBlockInProgress, "block in progress")
+	ErrCASFailed          = onet.NewClientErrorCode(ErrorCASFailed, "compare-and-append failed")
+	ErrTimeout            = onet.NewClientErrorCode(ErrorTimeout, "timeout")
+	ErrBlockNotLatest     = onet.NewClientErrorCode(ErrorBlockNotLatest, "block is not the latest block")
+	ErrForwardLinkMissing = onet.NewClientErrorCode(ErrorForwardLinkMissing, "forward-link missing")
 )
 
+// notLatestPrefix is used in the message of an ErrorBlockNotLatest in front
+// of the hex-encoded ID of the latest block.
+const notLatestPrefix = "block is not the latest block - latest is "
+
+// updateChainTries is the number of conodes GetUpdateChain asks before it
+// gives up.
+const updateChainTries = 3
+
+// NotLatestTip returns the ID of the latest block if cerr is an
+// ErrorBlockNotLatest, or nil otherwise.
+func NotLatestTip(cerr onet.ClientError) SkipBlockID {
+	if !isError(cerr, ErrBlockNotLatest) {
+		return nil
+	}
+	i := strings.Index(cerr.ErrorMsg(), notLatestPrefix)
+	if i < 0 {
+		return nil
+	}
+	id, err := hex.DecodeString(cerr.ErrorMsg()[i+len(notLatestPrefix):])
+	if err != nil {
+		return nil
+	}
+	return SkipBlockID(id)
+}
+
+// isError returns true if err is an onet.ClientError with the code of the
+// sentinel.
+func isError(err error, sentinel onet.ClientError) bool {
+	cerr, ok := err.(onet.ClientError)
+	return ok && cerr.ErrorCode() == sentinel.ErrorCode()
+}
+
+// IsBlockNotFound returns true if err is an ErrorBlockNotFound.
+func IsBlockNotFound(err error) bool {
+	return isError(err, ErrBlockNotFound)
+}
+
+// IsBlockNoParent returns true if err is an ErrorBlockNoParent.
+func IsBlockNoParent(err error) bool {
+	return isError(err, ErrBlockNoParent)
+}
+
+// IsBlockContent returns true if err is an ErrorBlockContent.
+func IsBlockContent(err error) bool {
+	return isError(err, ErrBlockContent)
+}
+
+// IsParameterWrong returns true if err is an ErrorParameterWrong.
+func IsParameterWrong(err error) bool {
+	return isError(err, ErrParameterWrong)
+}
+
+// IsVerification returns true if err is an ErrorVerification.
+func IsVerification(err error) bool {
+	return isError(err, ErrVerification)
+}
+
+// IsOnet returns true if err is an ErrorOnet.
+func IsOnet(err error) bool {
+	return isError(err, ErrOnet)
+}
+
+// IsBlockInProgress returns true if err is an ErrorBlockInProgress. The
+// request can be retried once the other block is stored.
+func IsBlockInProgress(err error) bool {
+	return isError(err, ErrBlockInProgress)
+}
+
+// IsCASFailed returns true if err is an ErrorCASFailed. If it has been
+// returned by Client.CompareAndAppend, it is a *CASFailedError.
+func IsCASFailed(err error) bool {
+	return isError(err, ErrCASFailed)
+}
+
+// IsTimeout returns true if err is an ErrorTimeout.
+func IsTimeout(err error) bool {
+	return isError(err, ErrTimeout)
+}
+
+// IsBlockNotLatest returns true if err is an ErrorBlockNotLatest. The latest
+// block is returned by NotLatestTip.
+func IsBlockNotLatest(err error) bool {
+	return isError(err, ErrBlockNotLatest)
+}
+
+// IsForwardLinkMissing returns true if err is an ErrorForwardLinkMissing.
+func IsForwardLinkMissing(err error) bool {
+	return isError(err, ErrForwardLinkMissing)
+}
+
+// CASFailedError is returned by CompareAndAppend if the expected block is not
+// the latest block of the skipchain anymore. Tip holds the actual latest
+// block, so the caller can rebase its new block on it.
+type CASFailedError struct {
+	Tip *SkipBlock
+}
+
+// Error implements the error-interface.
+func (e *CASFailedError) Error() string {
+	return fmt.Sprintf("%d: %s", e.ErrorCode(), e.ErrorMsg())
+}
+
+// ErrorCode implements the onet.ClientError-interface.
+func (e *CASFailedError) ErrorCode() int {
+	return ErrorCASFailed
+}
+
+// ErrorMsg implements the onet.ClientError-interface.
+func (e *CASFailedError) ErrorMsg() string {
+	return "expected block is not the latest block - actual latest is " +
+		e.Tip.Short()
+}
+
 // Client is a structure to communicate with the Skipchain
 // service from the outside
 type Client struct {
 	*onet.Client
+	// Timeout is the maximum time to wait for a reply from a conode. If it
+	// is 0, the client waits forever.
+	Timeout time.Duration
 }
 
 // NewClient instantiates a new client with name 'n'
@@ -39,6 +199,48 @@ func NewClient() *Client {
 	return &Client{Client: onet.NewClient("Skipchain")}
 }
 
+// WithTimeout returns a client returning an ErrorTimeout if a conode doesn't
+// reply within d. Every request of that client uses its own connection, which
+// is closed once the reply arrived or the timeout passed.
+func (c *Client) WithTimeout(d time.Duration) *Client {
+	return &Client{Client: c.Client, Timeout: d}
+}
+
+// send is like SendProtobuf, but returns an ErrorTimeout if no reply is
+// received before the timeout of the client.
+func (c *Client) send(si *network.ServerIdentity, msg, reply interface{}) onet.ClientError {
+	if c.Timeout == 0 {
+		return c.SendProtobuf(si, msg, reply)
+	}
+	// Decode into a temporary reply, so that a late reply doesn't
+	// overwrite the reply of the caller after the timeout.
+	var tmp interface{}
+	if reply != nil {
+		tmp = reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	}
+	// A client of its own lets us close the connection on a timeout
+	// without disturbing the other requests sent through c.
+	cl := onet.NewClient(ServiceName)
+	done := make(chan onet.ClientError, 1)
+	go func() {
+		done <- cl.SendProtobuf(si, msg, tmp)
+	}()
+	select {
+	case cerr := <-done:
+		cl.Close()
+		if cerr == nil && reply != nil {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(tmp).Elem())
+		}
+		return cerr
+	case <-time.After(c.Timeout):
+		// Closing the connection makes the pending SendProtobuf return,
+		// so neither the goroutine nor the connection are left behind.
+		go cl.Close()
+		return onet.NewClientErrorCode(ErrorTimeout,
+			"no reply from "+si.String()+" within "+c.Timeout.String())
+	}
+}
+
 // StoreSkipBlock asks the cothority to store the new skipblock, and eventually
 // attach it to the 'latest' skipblock.
 //  - latest is the skipblock where the new skipblock is appended. If el and d
@@ -55,6 +257,7 @@ func (c *Client) StoreSkipBlock(latest *SkipBlock, el *onet.Roster, d network.Me
 		newBlock = latest
 	} else {
 		newBlock = latest.Copy()
+		newBlock.Attachment = nil
 		if el != nil {
 			newBlock.Roster = el
 		}
@@ -72,15 +275,122 @@ func (c *Client) StoreSkipBlock(latest *SkipBlock, el *onet.Roster, d network.Me
 		}
 		latestID = latest.Hash
 	}
+	if len(newBlock.Data) > DataChunkSize {
+		if newBlock == latest {
+			newBlock = latest.Copy()
+		}
+		if cerr := c.storeChunks(newBlock, latest.Roster); cerr != nil {
+			return nil, cerr
+		}
+	}
 	host := latest.Roster.Get(0)
 	reply = &StoreSkipBlockReply{}
-	cerr = c.SendProtobuf(host, &StoreSkipBlock{latestID, newBlock}, reply)
+	cerr = c.send(host, &StoreSkipBlock{LatestID: latestID, NewBlock: newBlock}, reply)
 	if cerr != nil {
 		return nil, cerr
 	}
 	return reply, nil
 }
 
+// storeChunks sends the Data of sb in chunks to all conodes of its roster and
+// of the roster of the previous block, which verifies sb. Then the Data is
+// replaced by its hash and the number of chunks.
+func (c *Client) storeChunks(sb *SkipBlock, prev *onet.Roster) onet.ClientError {
+	hash := sha256.Sum256(sb.Data)
+	count := (len(sb.Data) + DataChunkSize - 1) / DataChunkSize
+	conodes := append([]*network.ServerIdentity{}, sb.Roster.List...)
+	for _, si := range prev.List {
+		if i, _ := sb.Roster.Search(si.ID); i < 0 {
+			conodes = append(conodes, si)
+		}
+	}
+	for _, si := range conodes {
+		for i := 0; i < count; i++ {
+			end := (i + 1) * DataChunkSize
+			if end > len(sb.Data) {
+				end = len(sb.Data)
+			}
+			cerr := c.send(si, &StoreDataChunk{hash[:], i, count,
+				sb.Data[i*DataChunkSize : end]}, &StoreDataChunkReply{})
+			if cerr != nil {
+				return cerr
+			}
+		}
+	}
+	sb.Data = nil
+	sb.DataHash = hash[:]
+	sb.DataChunks = count
+	return nil
+}
+
+// GetBlockData returns the Data of the block with the given id. If the Data
+// is stored in chunks, all chunks are fetched and verified against the hash
+// in the block.
+func (c *Client) GetBlockData(roster *onet.Roster, id SkipBlockID) ([]byte, onet.ClientError) {
+	si := roster.RandomServerIdentity()
+	sb := &SkipBlock{}
+	if cerr := c.send(si, &GetSingleBlock{id}, sb); cerr != nil {
+		return nil, cerr
+	}
+	if !sb.Hash.Equal(id) || !sb.CalculateHash().Equal(id) {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"got wrong block")
+	}
+	if sb.DataChunks == 0 {
+		if err := sb.VerifyDataHash(); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorVerification,
+				err.Error())
+		}
+		return sb.Data, nil
+	}
+	var data []byte
+	for i := 0; i < sb.DataChunks; i++ {
+		reply := &GetBlockDataReply{}
+		if cerr := c.send(si, &GetBlockData{id, i}, reply); cerr != nil {
+			return nil, cerr
+		}
+		data = append(data, reply.Chunk...)
+	}
+	hash := sha256.Sum256(data)
+	if !bytes.Equal(hash[:], sb.DataHash) {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"chunks don't match data-hash")
+	}
+	return data, nil
+}
+
+// StoreSkipBlocks appends all blocks to the skipchain with the latest block
+// latest. The blocks are chained by the leader, who sets their back-links,
+// index and height. A block without a roster gets the roster of the block
+// before. Either all blocks are appended, or none.
+func (c *Client) StoreSkipBlocks(latest *SkipBlock, blocks []*SkipBlock) (*StoreSkipBlocksReply, onet.ClientError) {
+	reply := &StoreSkipBlocksReply{}
+	cerr := c.send(latest.Roster.Get(0),
+		&StoreSkipBlocks{latest.Hash, blocks}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// CompareAndAppend appends newBlock to the skipchain only if the block with
+// the id 'expected' is still the latest block of the chain. If another block
+// has been appended in the meantime, a *CASFailedError is returned that holds
+// the actual latest block, so that the caller can rebase in one round-trip.
+// The request is sent to the leader of the roster of newBlock.
+func (c *Client) CompareAndAppend(expected SkipBlockID, newBlock *SkipBlock) (*StoreSkipBlockReply, onet.ClientError) {
+	reply := &CompareAndAppendReply{}
+	cerr := c.send(newBlock.Roster.Get(0),
+		&CompareAndAppend{expected, newBlock}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if reply.Stored == nil {
+		return nil, &CASFailedError{reply.Tip}
+	}
+	return reply.Stored, nil
+}
+
 // CreateGenesis is a convenience function to create a new SkipChain with the
 // given parameters.
 //  - el is the responsible roster
@@ -95,6 +405,15 @@ func (c *Client) StoreSkipBlock(latest *SkipBlock, el *onet.Roster, d network.Me
 // This function returns the created skipblock or nil and an error.
 func (c *Client) CreateGenesis(el *onet.Roster, baseH, maxH int, ver []VerifierID,
 	data interface{}, parent SkipBlockID) (*SkipBlock, onet.ClientError) {
+	return c.CreateGenesisBackLink(el, baseH, maxH, ver, data, parent, nil)
+}
+
+// CreateGenesisBackLink is like CreateGenesis, but uses the given 32 bytes
+// as the back-link of the genesis-block instead of random bytes. The same
+// parameters and backLink always give the same genesis-block. If backLink
+// is nil, it behaves like CreateGenesis.
+func (c *Client) CreateGenesisBackLink(el *onet.Roster, baseH, maxH int, ver []VerifierID,
+	data interface{}, parent SkipBlockID, backLink []byte) (*SkipBlock, onet.ClientError) {
 	genesis := NewSkipBlock()
 	genesis.Roster = el
 	genesis.VerifierIDs = ver
@@ -113,11 +432,17 @@ func (c *Client) CreateGenesis(el *onet.Roster, baseH, maxH int, ver []VerifierI
 			genesis.Data = buf
 		}
 	}
-	sb, cerr := c.StoreSkipBlock(genesis, nil, nil)
+	if len(genesis.Data) > DataChunkSize {
+		if cerr := c.storeChunks(genesis, el); cerr != nil {
+			return nil, cerr
+		}
+	}
+	reply := &StoreSkipBlockReply{}
+	cerr := c.send(el.Get(0), &StoreSkipBlock{NewBlock: genesis, GenesisBackLink: backLink}, reply)
 	if cerr != nil {
 		return nil, cerr
 	}
-	return sb.Latest, nil
+	return reply.Latest, nil
 }
 
 // CreateRootControl is a convenience function and creates two Skipchains:
@@ -149,20 +474,585 @@ func (c *Client) CreateRootControl(elRoot, elControl *onet.Roster,
 // GetUpdateChain will return the chain of SkipBlocks going from the 'latest' to
 // the most current SkipBlock of the chain. It takes a roster that knows the
 // 'latest' skipblock and the id (=hash) of the latest skipblock.
+// If a conode fails, up to updateChainTries different conodes of the roster
+// are asked before an error with all failures is returned.
 func (c *Client) GetUpdateChain(roster *onet.Roster, latest SkipBlockID) (reply *GetUpdateChainReply, cerr onet.ClientError) {
+	if roster == nil || len(roster.List) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"empty roster")
+	}
+	var failures []string
+	for i, idx := range rand.Perm(len(roster.List)) {
+		if i >= updateChainTries {
+			break
+		}
+		si := roster.List[idx]
+		reply = &GetUpdateChainReply{}
+		cerr = c.send(si, &GetUpdateChain{LatestID: latest}, reply)
+		if cerr == nil {
+			return reply, nil
+		}
+		log.Lvl2("Couldn't get update-chain from", si, cerr)
+		failures = append(failures, si.Address.String()+": "+cerr.Error())
+	}
+	return nil, onet.NewClientErrorCode(cerr.ErrorCode(),
+		"all conodes failed: "+strings.Join(failures, "; "))
+}
+
+// GetUpdateChainLimited works like GetUpdateChain, but returns at most
+// maxBlocks blocks. If reply.More is set, the next part of the chain can be
+// requested with the hash of the last returned block.
+func (c *Client) GetUpdateChainLimited(roster *onet.Roster, latest SkipBlockID, maxBlocks int) (reply *GetUpdateChainReply, cerr onet.ClientError) {
+	reply = &GetUpdateChainReply{}
+	cerr = c.send(roster.RandomServerIdentity(),
+		&GetUpdateChain{LatestID: latest, MaxBlocks: maxBlocks}, reply)
+	return
+}
+
+// VerifyGenesis returns an ErrorVerification if not all blocks of the reply
+// belong to the skipchain with the given genesis-id, if the hash of a block
+// is wrong, or if a block is not linked to the next one by a valid
+// forward-link.
+func (gucr *GetUpdateChainReply) VerifyGenesis(genesis SkipBlockID) onet.ClientError {
+	if !gucr.GenesisID.Equal(genesis) {
+		return onet.NewClientErrorCode(ErrorVerification,
+			"update-chain is for skipchain "+gucr.GenesisID.Short()+
+				" instead of "+genesis.Short())
+	}
+	for _, sb := range gucr.Update {
+		if !sb.SkipChainID().Equal(genesis) {
+			return onet.NewClientErrorCode(ErrorVerification,
+				"block "+sb.Short()+" is not part of skipchain "+genesis.Short())
+		}
+	}
+	if err := verifyLinks(gucr.Update); err != nil {
+		return onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return nil
+}
+
+// StaleWarning is returned by GetUpdateChainCrossCheck if two conodes
+// don't agree on the latest block of a skipchain. This indicates that one
+// of them is lagging behind or partitioned.
+type StaleWarning struct {
+	// Conodes that have been asked.
+	Conodes []*network.ServerIdentity
+	// Tips are the latest blocks returned by the conodes.
+	Tips []*SkipBlock
+}
+
+// GetUpdateChainCrossCheck works like GetUpdateChain, but asks two different
+// conodes of the roster. Both update-chains are verified, and the longer one
+// is returned. If the latest blocks of the conodes differ, a StaleWarning is
+// returned, too.
+func (c *Client) GetUpdateChainCrossCheck(roster *onet.Roster, latest SkipBlockID) (*GetUpdateChainReply, *StaleWarning, onet.ClientError) {
+	first := roster.RandomServerIdentity()
+	// Take the next conode in the roster that is not the first one.
+	var second *network.ServerIdentity
+	i, _ := roster.Search(first.ID)
+	for j := 1; j < len(roster.List); j++ {
+		si := roster.List[(i+j)%len(roster.List)]
+		if !si.Equal(first) {
+			second = si
+			break
+		}
+	}
+	if second == nil {
+		reply, cerr := c.GetUpdateChain(roster, latest)
+		return reply, nil, cerr
+	}
+	conodes := []*network.ServerIdentity{first, second}
+	replies := make([]*GetUpdateChainReply, len(conodes))
+	for i, si := range conodes {
+		replies[i] = &GetUpdateChainReply{}
+		cerr := c.send(si, &GetUpdateChain{LatestID: latest}, replies[i])
+		if cerr != nil {
+			return nil, nil, cerr
+		}
+		if len(replies[i].Update) == 0 {
+			return nil, nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				"got empty update-chain from "+si.String())
+		}
+		if !replies[i].Update[0].Hash.Equal(latest) {
+			return nil, nil, onet.NewClientErrorCode(ErrorVerification,
+				"update-chain from "+si.String()+" doesn't start with latest block")
+		}
+		if err := verifyLinks(replies[i].Update); err != nil {
+			return nil, nil, onet.NewClientErrorCode(ErrorVerification,
+				"invalid update-chain from "+si.String()+": "+err.Error())
+		}
+	}
+	tips := make([]*SkipBlock, len(replies))
+	for i, r := range replies {
+		tips[i] = r.Update[len(r.Update)-1]
+	}
+	reply := replies[0]
+	if tips[1].Index > tips[0].Index {
+		reply = replies[1]
+	}
+	if tips[0].Equal(tips[1]) {
+		return reply, nil, nil
+	}
+	return reply, &StaleWarning{conodes, tips}, nil
+}
+
+// GetUpdateChainIfChanged works like GetUpdateChain, but if the latest block
+// of the skipchain is the block with the id tip, the reply will only have
+// NotModified set and no blocks. This is useful for clients polling a
+// skipchain.
+func (c *Client) GetUpdateChainIfChanged(roster *onet.Roster, latest, tip SkipBlockID) (reply *GetUpdateChainReply, cerr onet.ClientError) {
 	reply = &GetUpdateChainReply{}
-	r := roster.RandomServerIdentity()
-	cerr = c.SendProtobuf(r,
-		&GetUpdateChain{latest}, reply)
+	cerr = c.send(roster.RandomServerIdentity(),
+		&GetUpdateChain{LatestID: latest, IfChangedFrom: tip}, reply)
 	return
 }
 
+// GetFullChain returns all blocks of the skipchain, from the genesis-block
+// to the latest block, in order. It uses GetUpdateChain to find the latest
+// block and fetches all blocks with GetBlockRange, in batches of at most
+// maxBlockRange blocks. All hashes and forward-links are verified.
+func (c *Client) GetFullChain(roster *onet.Roster, genesis SkipBlockID) ([]*SkipBlock, onet.ClientError) {
+	update, cerr := c.GetUpdateChain(roster, genesis)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if len(update.Update) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"got empty update-chain")
+	}
+	if err := VerifyProof(genesis, update.Update); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	tip := update.Update[len(update.Update)-1]
+	var chain []*SkipBlock
+	for from := 0; from <= tip.Index; from += maxBlockRange {
+		to := from + maxBlockRange - 1
+		if to > tip.Index {
+			to = tip.Index
+		}
+		reply, cerr := c.GetBlockRange(roster, genesis, from, to)
+		if cerr != nil {
+			return nil, cerr
+		}
+		chain = append(chain, reply.Blocks...)
+	}
+	if len(chain) != tip.Index+1 || !chain[len(chain)-1].Hash.Equal(tip.Hash) {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"got wrong blocks up to index "+strconv.Itoa(tip.Index))
+	}
+	if err := verifyChain(genesis, chain); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return chain, nil
+}
+
+// verifyChain returns nil if chain starts with the genesis-block and every
+// block is followed by the block its height-1 forward-link points to.
+func verifyChain(genesis SkipBlockID, chain []*SkipBlock) error {
+	for i, sb := range chain {
+		if sb.Index != i {
+			return fmt.Errorf("block %d has index %d", i, sb.Index)
+		}
+		if err := sb.VerifyHash(); err != nil {
+			return fmt.Errorf("wrong hash in block %d: %s", i, err)
+		}
+		if i == 0 {
+			if !sb.Hash.Equal(genesis) {
+				return errors.New("chain doesn't start with genesis-block")
+			}
+			continue
+		}
+		prev := chain[i-1]
+		if err := prev.VerifyForwardSignatures(); err != nil {
+			return fmt.Errorf("block %d: %s", i-1, err)
+		}
+		if prev.GetForwardLen() == 0 || !prev.GetForward(0).Hash.Equal(sb.Hash) {
+			return fmt.Errorf("block %d is not linked to block %d", i-1, i)
+		}
+	}
+	return nil
+}
+
+// replayInterval is the time Replay waits before asking again for a block
+// that is not available yet.
+const replayInterval = 500 * time.Millisecond
+
+// Replay calls handler for every block of the skipchain starting at
+// fromIndex, in order, and then for every new block that is appended to the
+// skipchain, until the returned stop-function is called. The existing blocks
+// are fetched with GetBlockRange, and the new blocks with FollowUpdate, like
+// SubscribeUpdates does. Every block is delivered exactly once, and only after
+// verifying that it belongs to the skipchain and follows the previous block.
+// Once stop returns, handler will not be called anymore.
+func (c *Client) Replay(roster *onet.Roster, genesis SkipBlockID, fromIndex int,
+	handler func(*SkipBlock)) (stop func()) {
+	quit := make(chan bool)
+	done := make(chan bool)
+	wait := func() bool {
+		select {
+		case <-quit:
+			return false
+		case <-time.After(replayInterval):
+			return true
+		}
+	}
+	deliver := func(sb *SkipBlock) bool {
+		select {
+		case <-quit:
+			return false
+		default:
+		}
+		if sb.Index >= fromIndex {
+			handler(sb)
+		}
+		return true
+	}
+	go func() {
+		defer close(done)
+		// prev is the last verified block, next the index of the next
+		// block to deliver.
+		var prev *SkipBlock
+		next := fromIndex
+		for {
+			update, cerr := c.GetUpdateChain(roster, genesis)
+			if cerr == nil && len(update.Update) > 0 {
+				cerr = update.VerifyGenesis(genesis)
+			}
+			if cerr != nil || len(update.Update) == 0 {
+				log.Lvl3("Waiting for skipchain", cerr)
+				if !wait() {
+					return
+				}
+				continue
+			}
+			tip := update.Update[len(update.Update)-1]
+			if next > tip.Index {
+				if prev == nil {
+					prev = tip
+				}
+				break
+			}
+			to := next + maxBlockRange - 1
+			if to > tip.Index {
+				to = tip.Index
+			}
+			reply, cerr := c.GetBlockRange(roster, genesis, next, to)
+			if cerr != nil {
+				log.Lvl3("Couldn't get blocks", next, to, cerr)
+				if !wait() {
+					return
+				}
+				continue
+			}
+			for _, sb := range reply.Blocks {
+				if !nextBlockValid(genesis, prev, sb, next) {
+					log.Lvl2("Got invalid block", next)
+					if !wait() {
+						return
+					}
+					break
+				}
+				if !deliver(sb) {
+					return
+				}
+				prev = sb
+				next++
+			}
+		}
+		c.follow(genesis, prev, quit, wait, deliver)
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// SubscribeUpdates returns a channel that receives every new block of the
+// skipchain, in order, starting after the latest block at the time of the
+// call. The conode holds every request until a new block is added, so there
+// is no need to poll. Calling the returned function cancels the
+// subscription; the channel is closed once the request running at that time
+// returns.
+func (c *Client) SubscribeUpdates(roster *onet.Roster, genesis SkipBlockID) (<-chan *SkipBlock, func()) {
+	blocks := make(chan *SkipBlock, 10)
+	quit := make(chan bool)
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(quit) })
+	}
+	wait := func() bool {
+		select {
+		case <-quit:
+			return false
+		case <-time.After(replayInterval):
+			return true
+		}
+	}
+	go func() {
+		defer close(blocks)
+		var latest *SkipBlock
+		for {
+			update, cerr := c.GetUpdateChain(roster, genesis)
+			if cerr == nil && len(update.Update) > 0 {
+				latest = update.Update[len(update.Update)-1]
+				break
+			}
+			if !wait() {
+				return
+			}
+		}
+		c.follow(genesis, latest, quit, wait, func(sb *SkipBlock) bool {
+			select {
+			case blocks <- sb:
+				return true
+			case <-quit:
+				return false
+			}
+		})
+	}()
+	return blocks, cancel
+}
+
+// follow asks the conodes of the latest block for the blocks appended after
+// it with FollowUpdate, and calls deliver for every new, valid block in
+// order. It returns once quit is closed, or deliver or wait return false.
+func (c *Client) follow(genesis SkipBlockID, latest *SkipBlock, quit chan bool,
+	wait func() bool, deliver func(*SkipBlock) bool) {
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		reply := &FollowUpdateReply{}
+		cerr := c.send(latest.Roster.RandomServerIdentity(),
+			&FollowUpdate{genesis, latest.Hash}, reply)
+		if cerr != nil {
+			log.Lvl2("Couldn't get update:", cerr)
+			if !wait() {
+				return
+			}
+			continue
+		}
+		invalid := false
+		for _, sb := range reply.Blocks {
+			if !nextBlockValid(genesis, latest, sb, latest.Index+1) {
+				log.Lvl2("Got invalid block", sb.Index)
+				invalid = true
+				break
+			}
+			if !deliver(sb) {
+				return
+			}
+			latest = sb
+		}
+		// Don't ask a misbehaving conode again right away.
+		if invalid && !wait() {
+			return
+		}
+	}
+}
+
+// nextBlockValid returns true if sb has the given index and a correct hash,
+// belongs to the skipchain genesis and, if prev is not nil, directly follows
+// prev.
+func nextBlockValid(genesis SkipBlockID, prev, sb *SkipBlock, index int) bool {
+	if sb.Index != index || sb.VerifyHash() != nil ||
+		!sb.SkipChainID().Equal(genesis) {
+		return false
+	}
+	return prev == nil || (len(sb.BackLinkIDs) > 0 &&
+		sb.BackLinkIDs[0].Equal(prev.Hash))
+}
+
+// GetProof returns the blocks whose forward-links link the genesis-block to
+// the target-block. The proof can be verified offline using VerifyProof.
+func (c *Client) GetProof(roster *onet.Roster, genesis, target SkipBlockID) (reply *GetProofReply,
+	cerr onet.ClientError) {
+	reply = &GetProofReply{}
+	cerr = c.send(roster.RandomServerIdentity(), &GetProof{genesis, target}, reply)
+	return
+}
+
+// VerifyProof returns nil if the proof starts with the genesis-block, every
+// block has a correct hash and valid forward-signatures, and each block is
+// linked to by a forward-link of the previous block. The last block of the
+// proof is then proven to be part of the skipchain.
+func VerifyProof(genesis SkipBlockID, proof []*SkipBlock) error {
+	if len(proof) == 0 {
+		return errors.New("empty proof")
+	}
+	if !proof[0].Hash.Equal(genesis) || proof[0].Index != 0 {
+		return errors.New("proof doesn't start with genesis-block")
+	}
+	return verifyLinks(proof)
+}
+
+// verifyLinks returns nil if the hash of every block is correct and every
+// block has a valid forward-link to the next block.
+func verifyLinks(proof []*SkipBlock) error {
+	for i, sb := range proof {
+		if err := sb.VerifyHash(); err != nil {
+			return fmt.Errorf("wrong hash in block %d: %s", i, err)
+		}
+		if i == len(proof)-1 {
+			break
+		}
+		if err := sb.VerifyForwardSignatures(); err != nil {
+			return fmt.Errorf("block %d: %s", i, err)
+		}
+		next := proof[i+1]
+		linked := false
+		for _, fl := range sb.ForwardLink {
+			if fl.Hash.Equal(next.Hash) {
+				linked = true
+				break
+			}
+		}
+		if !linked || next.Index <= sb.Index {
+			return fmt.Errorf("block %d is not linked to block %d", i, i+1)
+		}
+	}
+	return nil
+}
+
 // GetAllSkipchains returns all skipchains known to that conode. If none are
 // known, an empty slice is returned.
 func (c *Client) GetAllSkipchains(si *network.ServerIdentity) (reply *GetAllSkipchainsReply,
 	cerr onet.ClientError) {
 	reply = &GetAllSkipchainsReply{}
-	cerr = c.SendProtobuf(si, &GetAllSkipchains{}, reply)
+	cerr = c.send(si, &GetAllSkipchains{}, reply)
+	return
+}
+
+// GetAllSkipchainsPage returns at most max skipchains known to that conode,
+// sorted by their ID and starting with the skipchain-ID start. To get the
+// next page, call it again with start set to reply.Next, until it is nil.
+func (c *Client) GetAllSkipchainsPage(si *network.ServerIdentity, start SkipBlockID,
+	max int) (reply *GetAllSkipchainsReply, cerr onet.ClientError) {
+	reply = &GetAllSkipchainsReply{}
+	cerr = c.send(si, &GetAllSkipchains{start, max}, reply)
+	return
+}
+
+// GetKnownConodes returns the deduplicated list of all conodes that are part
+// of a roster of one of the skipblocks known to that conode.
+func (c *Client) GetKnownConodes(si *network.ServerIdentity) (reply *GetKnownConodesReply,
+	cerr onet.ClientError) {
+	reply = &GetKnownConodesReply{}
+	cerr = c.send(si, &GetKnownConodes{}, reply)
+	return
+}
+
+// PingRoster asks the conode si to check which members of the latest roster
+// of the skipchain holding the block with the given id are reachable. This can
+// be used before storing a new block, to make sure the BFT-round can succeed.
+func (c *Client) PingRoster(si *network.ServerIdentity, id SkipBlockID) (reply *PingRosterReply,
+	cerr onet.ClientError) {
+	reply = &PingRosterReply{}
+	cerr = c.send(si, &PingRoster{id}, reply)
+	return
+}
+
+// RepairForwardLinks asks the conode si to fetch the forward-links it is
+// missing for the skipchain with the given genesis-id from the other conodes.
+func (c *Client) RepairForwardLinks(si *network.ServerIdentity, genesis SkipBlockID) (reply *RepairForwardLinksReply,
+	cerr onet.ClientError) {
+	reply = &RepairForwardLinksReply{}
+	cerr = c.send(si, &RepairForwardLinks{genesis}, reply)
+	return
+}
+
+// GetMetrics returns the metrics of the conode si in the Prometheus
+// text-format.
+func (c *Client) GetMetrics(si *network.ServerIdentity) (reply *GetMetricsReply,
+	cerr onet.ClientError) {
+	reply = &GetMetricsReply{}
+	cerr = c.send(si, &GetMetrics{}, reply)
+	return
+}
+
+// GetStatus returns the number of skipchains and skipblocks stored by the
+// conode si, and whether it is propagating a block.
+func (c *Client) GetStatus(si *network.ServerIdentity) (reply *GetStatusReply,
+	cerr onet.ClientError) {
+	reply = &GetStatusReply{}
+	cerr = c.send(si, &GetStatus{}, reply)
+	return
+}
+
+// ProposeLeader asks the conode si to become the leader of the skipchain with
+// the latest block latest. This only succeeds if the actual leader is
+// unreachable and a quorum of the roster accepts the new leader.
+func (c *Client) ProposeLeader(si *network.ServerIdentity, latest SkipBlockID) (reply *ProposeLeaderReply,
+	cerr onet.ClientError) {
+	reply = &ProposeLeaderReply{}
+	cerr = c.send(si, &ProposeLeader{latest}, reply)
+	return
+}
+
+// RemoveChain asks the conode si to delete all blocks of the skipchain with
+// the given genesis-id. priv must be the private key belonging to the
+// AdminKey of the conode.
+func (c *Client) RemoveChain(si *network.ServerIdentity, genesis SkipBlockID,
+	priv abstract.Scalar) (reply *RemoveChainReply, cerr onet.ClientError) {
+	rc := &RemoveChain{Genesis: genesis, Time: time.Now().Unix()}
+	var err error
+	rc.Signature, err = crypto.SignSchnorr(network.Suite, priv, rc.hash())
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	reply = &RemoveChainReply{}
+	cerr = c.send(si, rc, reply)
+	return
+}
+
+// Ping sends a HealthCheck to si and returns its reply. The round-trip
+// latency can be measured by timing the call.
+func (c *Client) Ping(si *network.ServerIdentity) (reply *HealthCheckReply,
+	cerr onet.ClientError) {
+	reply = &HealthCheckReply{}
+	cerr = c.send(si, &HealthCheck{}, reply)
+	return
+}
+
+// GetAcks returns the signed acknowledgements of all nodes that stored the
+// block. It needs to be sent to the leader that stored the block.
+func (c *Client) GetAcks(si *network.ServerIdentity, id SkipBlockID) (reply *GetAcksReply,
+	cerr onet.ClientError) {
+	reply = &GetAcksReply{}
+	cerr = c.send(si, &GetAcks{id}, reply)
+	return
+}
+
+// Snapshot returns all skipblocks stored on the conode si. The returned data
+// can be loaded into an empty conode using Restore. priv must be the private
+// key of the conode.
+func (c *Client) Snapshot(si *network.ServerIdentity, priv abstract.Scalar) (reply *SnapshotReply,
+	cerr onet.ClientError) {
+	snap := &Snapshot{Time: time.Now().Unix()}
+	sig, err := crypto.SignSchnorr(network.Suite, priv, snap.hash())
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	snap.Signature = sig
+	reply = &SnapshotReply{}
+	cerr = c.send(si, snap, reply)
+	return
+}
+
+// Restore loads the data returned by Snapshot into the conode si, which must
+// not hold any skipblocks yet. priv must be the private key of the conode.
+func (c *Client) Restore(si *network.ServerIdentity, data []byte,
+	priv abstract.Scalar) (reply *RestoreReply, cerr onet.ClientError) {
+	r := &Restore{Data: data}
+	sig, err := crypto.SignSchnorr(network.Suite, priv, r.hash())
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	r.Signature = sig
+	reply = &RestoreReply{}
+	cerr = c.send(si, r, reply)
 	return
 }
 
@@ -170,16 +1060,84 @@ func (c *Client) GetAllSkipchains(si *network.ServerIdentity) (reply *GetAllSkip
 // or an error if that block is not found.
 func (c *Client) GetSingleBlock(roster *onet.Roster, id SkipBlockID) (reply *SkipBlock, cerr onet.ClientError) {
 	reply = &SkipBlock{}
-	cerr = c.SendProtobuf(roster.RandomServerIdentity(),
+	cerr = c.send(roster.RandomServerIdentity(),
 		&GetSingleBlock{id}, reply)
 	return
 }
 
+// GetForwardLink returns the forward-link at the given height of the block
+// with the given id. Forward-links above height 0 are added asynchronously,
+// so if it is not signed yet, an ErrorForwardLinkMissing is returned.
+func (c *Client) GetForwardLink(roster *onet.Roster, id SkipBlockID, height int) (*BlockLink, onet.ClientError) {
+	reply := &GetForwardLinkReply{}
+	cerr := c.send(roster.RandomServerIdentity(),
+		&GetForwardLink{id, height}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return reply.Link, nil
+}
+
+// GetTipProof returns the headers of the blocks linking the block lastKnown
+// to the latest block of the skipchain genesis. The headers hold no Data, so
+// a light client can follow the chain without fetching all payloads. The
+// first header is the one of lastKnown, and all links are verified.
+func (c *Client) GetTipProof(roster *onet.Roster, genesis, lastKnown SkipBlockID) ([]*SkipBlock, onet.ClientError) {
+	reply := &GetTipProofReply{}
+	cerr := c.send(roster.RandomServerIdentity(),
+		&GetTipProof{genesis, lastKnown}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if len(reply.Headers) == 0 || !reply.Headers[0].Hash.Equal(lastKnown) {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"tip-proof doesn't start with last known block")
+	}
+	if err := verifyLinks(reply.Headers); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification, err.Error())
+	}
+	return reply.Headers, nil
+}
+
+// GetBlocks returns all blocks with the given IDs known to a member of the
+// roster in one request. The IDs of unknown blocks are returned in Missing.
+func (c *Client) GetBlocks(roster *onet.Roster, ids []SkipBlockID) (reply *GetBlocksReply, cerr onet.ClientError) {
+	reply = &GetBlocksReply{}
+	cerr = c.send(roster.RandomServerIdentity(), &GetBlocks{ids}, reply)
+	return
+}
+
+// GetBlockRange returns all blocks of the skipchain with indices in
+// [from, to], ordered by their index.
+func (c *Client) GetBlockRange(roster *onet.Roster, genesis SkipBlockID, from, to int) (reply *GetBlockRangeReply, cerr onet.ClientError) {
+	reply = &GetBlockRangeReply{}
+	cerr = c.send(roster.RandomServerIdentity(),
+		&GetBlockRange{genesis, from, to}, reply)
+	return
+}
+
+// GetAttachment returns the attachment of the block with the given id. If
+// hash is not nil, the attachment is checked against it, which is
+// usually the hash stored in the Data of the block.
+func (c *Client) GetAttachment(roster *onet.Roster, id SkipBlockID, hash []byte) ([]byte, onet.ClientError) {
+	reply := &GetAttachmentReply{}
+	cerr := c.send(roster.RandomServerIdentity(),
+		&GetAttachment{id}, reply)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if hash != nil && !bytes.Equal(hash, HashAttachment(reply.Attachment)) {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"attachment doesn't match hash")
+	}
+	return reply.Attachment, nil
+}
+
 // GetSingleBlockByIndex searches for a block with the given index following the genesis-block.
 // It returns that block, or an error if that block is not found.
 func (c *Client) GetSingleBlockByIndex(roster *onet.Roster, genesis SkipBlockID, index int) (reply *SkipBlock, cerr onet.ClientError) {
 	reply = &SkipBlock{}
-	cerr = c.SendProtobuf(roster.RandomServerIdentity(),
+	cerr = c.send(roster.RandomServerIdentity(),
 		&GetSingleBlockByIndex{genesis, index}, reply)
 	return
 }
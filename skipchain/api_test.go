@@ -7,9 +7,17 @@ import (
 
 	"bytes"
 
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
 	"sync"
+	"time"
 
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -36,6 +44,22 @@ func TestClient_CreateGenesis(t *testing.T) {
 	require.NotNil(t, cerr)
 }
 
+func TestClient_Ping(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(1, true)
+	defer l.CloseAll()
+	c := newTestClient(l)
+	start := time.Now()
+	reply, cerr := c.Ping(roster.List[0])
+	latency := time.Since(start)
+	log.ErrFatal(cerr)
+	require.True(t, reply.ServerIdentity.Equal(roster.List[0]))
+	require.Equal(t, Version, reply.Version)
+	require.True(t, reply.Time >= start.UnixNano())
+	require.True(t, reply.Time <= start.Add(latency).UnixNano())
+	require.True(t, latency > 0)
+}
+
 func TestClient_CreateRootControl(t *testing.T) {
 	l := onet.NewTCPTest()
 	_, roster, _ := l.GenTree(3, true)
@@ -142,6 +166,142 @@ func TestClient_StoreSkipBlock(t *testing.T) {
 	c.Close()
 }
 
+func TestClient_CompareAndAppend(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+
+	// Two clients racing to append to the same tip - only one may win.
+	type result struct {
+		reply *StoreSkipBlockReply
+		cerr  onet.ClientError
+	}
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			block := genesis.Copy()
+			block.Data = []byte{byte(i)}
+			reply, cerr := newTestClient(l).CompareAndAppend(genesis.Hash, block)
+			results <- result{reply, cerr}
+		}(i)
+	}
+	var winner *StoreSkipBlockReply
+	var loser onet.ClientError
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.cerr != nil {
+			require.Nil(t, loser, "Both appends failed")
+			loser = res.cerr
+		} else {
+			require.Nil(t, winner, "Both appends succeeded")
+			winner = res.reply
+		}
+	}
+	require.NotNil(t, winner)
+	require.NotNil(t, loser)
+	require.Equal(t, ErrorCASFailed, loser.ErrorCode())
+	casErr, ok := loser.(*CASFailedError)
+	require.True(t, ok)
+	require.True(t, casErr.Tip.Equal(winner.Latest))
+
+	// Rebasing on the returned tip succeeds.
+	reply, cerr := c.CompareAndAppend(casErr.Tip.Hash, casErr.Tip.Copy())
+	log.ErrFatal(cerr)
+	require.Equal(t, 2, reply.Latest.Index)
+}
+
+func TestClient_ErrorPredicates(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 2, 2, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	ssbr, cerr := c.StoreSkipBlock(genesis, nil, []byte("first"))
+	log.ErrFatal(cerr)
+	genesis = ssbr.Previous
+
+	_, cerr = c.GetSingleBlock(el, SkipBlockID("unknown"))
+	require.True(t, IsBlockNotFound(cerr))
+	require.Equal(t, ErrBlockNotFound.ErrorCode(), cerr.ErrorCode())
+	require.False(t, IsParameterWrong(cerr))
+
+	_, cerr = c.StoreSkipBlock(genesis, nil, []byte("second"))
+	require.True(t, IsBlockNotLatest(cerr))
+	require.True(t, NotLatestTip(cerr).Equal(ssbr.Latest.Hash))
+
+	block := genesis.Copy()
+	_, cerr = c.CompareAndAppend(genesis.Hash, block)
+	require.True(t, IsCASFailed(cerr))
+	require.False(t, IsBlockNotLatest(cerr))
+
+	_, cerr = c.GetForwardLink(el, genesis.Hash, 1)
+	require.True(t, IsForwardLinkMissing(cerr))
+	_, cerr = c.GetForwardLink(el, genesis.Hash, 2)
+	require.True(t, IsParameterWrong(cerr))
+
+	_, cerr = c.WithTimeout(time.Nanosecond).GetSingleBlock(el, genesis.Hash)
+	require.True(t, IsTimeout(cerr))
+
+	require.False(t, IsBlockNotFound(nil))
+	require.False(t, IsBlockNotFound(errors.New("block not found")))
+	require.True(t, IsBlockNotFound(ErrBlockNotFound))
+}
+
+func TestClient_GetBlockData(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationStandard,
+		[]byte("small"), nil)
+	log.ErrFatal(cerr)
+	data, cerr := c.GetBlockData(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.Equal(t, []byte("small"), data)
+
+	log.Lvl1("Storing data in chunks")
+	big := random.Bytes(3*DataChunkSize+DataChunkSize/2, random.Stream)
+	ssbr, cerr := c.StoreSkipBlock(genesis, nil, big)
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+	require.Equal(t, 4, latest.DataChunks)
+	require.Equal(t, 0, len(latest.Data))
+	for i := 0; i < 3; i++ {
+		data, cerr = c.GetBlockData(el, latest.Hash)
+		log.ErrFatal(cerr)
+		require.True(t, bytes.Equal(big, data))
+	}
+
+	log.Lvl1("Refusing to overwrite stored chunks")
+	cerr = c.send(el.List[0], &StoreDataChunk{latest.DataHash, 0, 4,
+		[]byte("wrong chunk")}, &StoreDataChunkReply{})
+	require.NotNil(t, cerr)
+	cerr = c.send(el.List[0], &StoreDataChunk{latest.DataHash, 3, 4,
+		big[3*DataChunkSize:]}, &StoreDataChunkReply{})
+	log.ErrFatal(cerr)
+	data, cerr = c.GetBlockData(onet.NewRoster(el.List[0:1]), latest.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, bytes.Equal(big, data))
+
+	log.Lvl1("Refusing genesis-block with missing chunks")
+	sb := NewSkipBlock()
+	sb.Roster = el
+	sb.MaximumHeight = 1
+	sb.BaseHeight = 1
+	sb.DataHash = random.Bytes(32, random.Stream)
+	sb.DataChunks = 2
+	_, cerr = c.StoreSkipBlock(sb, nil, nil)
+	require.NotNil(t, cerr)
+}
+
 func TestClient_GetAllSkipchains(t *testing.T) {
 	nbrHosts := 3
 	l := onet.NewTCPTest()
@@ -168,6 +328,722 @@ func TestClient_GetAllSkipchains(t *testing.T) {
 	require.NotEmpty(t, sb1id, sb2id)
 }
 
+func TestClient_GetKnownConodes(t *testing.T) {
+	nbrHosts := 4
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	log.Lvl1("Creating two chains with overlapping rosters")
+	roster1 := onet.NewRoster(el.List[0:2])
+	_, cerr := c.CreateGenesis(roster1, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	_, cerr = c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+
+	reply, cerr := c.GetKnownConodes(el.List[0])
+	log.ErrFatal(cerr)
+	require.Equal(t, nbrHosts, len(reply.ServerIdentities))
+	for _, si := range el.List {
+		found := false
+		for _, known := range reply.ServerIdentities {
+			if known.ID.Equal(si.ID) {
+				found = true
+			}
+		}
+		require.True(t, found, "missing", si)
+	}
+}
+
+func TestClient_GetUpdateChainRetry(t *testing.T) {
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	sb, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	ssbr, cerr := c.StoreSkipBlock(sb, nil, []byte("first"))
+	log.ErrFatal(cerr)
+
+	log.Lvl1("Shutting down one conode")
+	log.ErrFatal(servers[2].Close())
+	delete(l.Servers, servers[2].ServerIdentity.ID)
+	// The unreachable conode is chosen at random, so ask several times.
+	for i := 0; i < 5; i++ {
+		gucr, cerr := c.GetUpdateChain(el, sb.Hash)
+		log.ErrFatal(cerr)
+		require.True(t, gucr.Update[len(gucr.Update)-1].Hash.Equal(ssbr.Latest.Hash))
+	}
+
+	log.Lvl1("Failing if no conode is reachable")
+	for _, srv := range servers[:2] {
+		log.ErrFatal(srv.Close())
+		delete(l.Servers, srv.ServerIdentity.ID)
+	}
+	_, cerr = c.GetUpdateChain(el, sb.Hash)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), el.List[2].Address.String())
+}
+
+func TestClient_PingRoster(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	sb, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+
+	reply, cerr := c.PingRoster(el.List[0], sb.Hash)
+	log.ErrFatal(cerr)
+	require.Equal(t, nbrHosts, len(reply.Reachable))
+	require.Equal(t, 0, len(reply.Unreachable))
+
+	log.Lvl1("Shutting down one conode")
+	log.ErrFatal(servers[2].Close())
+	delete(l.Servers, servers[2].ServerIdentity.ID)
+	reply, cerr = c.PingRoster(el.List[0], sb.Hash)
+	log.ErrFatal(cerr)
+	require.Equal(t, nbrHosts-1, len(reply.Reachable))
+	require.Equal(t, 1, len(reply.Unreachable))
+	require.True(t, reply.Unreachable[0].Equal(el.List[2]))
+}
+
+func TestClient_ProposeLeader(t *testing.T) {
+	nbrHosts := 4
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	sb, cerr := c.CreateGenesis(el, 1, 1, VerificationStandard, nil, nil)
+	log.ErrFatal(cerr)
+	ssbr, cerr := c.StoreSkipBlock(sb, nil, []byte("first"))
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	_, cerr = c.ProposeLeader(el.List[1], latest.Hash)
+	require.NotNil(t, cerr, "leader is still online")
+
+	log.Lvl1("Shutting down the leader")
+	log.ErrFatal(servers[0].Close())
+	delete(l.Servers, servers[0].ServerIdentity.ID)
+	_, cerr = c.ProposeLeader(el.List[1], sb.Hash)
+	require.NotNil(t, cerr, "not the latest block")
+	plr, cerr := c.ProposeLeader(el.List[1], latest.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, plr.Latest.Roster.Get(0).Equal(el.List[1]))
+	require.Equal(t, nbrHosts, len(plr.Latest.Roster.List))
+	require.Equal(t, 1, len(plr.Previous.ForwardLink))
+	require.Nil(t, plr.Previous.VerifyForwardSignatures())
+	require.True(t, plr.Previous.ForwardLink[0].Hash.Equal(plr.Latest.Hash))
+	for _, s := range l.GetServices(servers[1:], skipchainSID) {
+		s := s.(*Service)
+		s.handoversMutex.Lock()
+		require.Equal(t, 0, len(s.handovers))
+		s.handoversMutex.Unlock()
+	}
+
+	log.Lvl1("Appending with the new leader")
+	ssbr, cerr = c.StoreSkipBlock(plr.Latest, nil, []byte("second"))
+	log.ErrFatal(cerr)
+	require.Equal(t, plr.Latest.Index+1, ssbr.Latest.Index)
+	require.Nil(t, ssbr.Previous.VerifyForwardSignatures())
+}
+
+func TestClient_ProposeLeaderOnce(t *testing.T) {
+	nbrHosts := 4
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	signer := l.GetServices(servers, skipchainSID)[3].(*Service)
+	log.ErrFatal(servers[0].Close())
+	delete(l.Servers, servers[0].ServerIdentity.ID)
+	// handover returns the hash and the data of a handover-block
+	// proposed by the given member.
+	handover := func(member int) ([]byte, []byte) {
+		prop := genesis.Copy()
+		list := []*network.ServerIdentity{el.List[member]}
+		for i, si := range el.List {
+			if i != member {
+				list = append(list, si)
+			}
+		}
+		prop.Roster = onet.NewRoster(list)
+		log.ErrFatal(signer.chainBlock(genesis, prop))
+		data, err := network.Marshal(prop)
+		log.ErrFatal(err)
+		return prop.Hash, append(genesis.Hash, data...)
+	}
+
+	log.Lvl1("Signing only the first of two handovers")
+	msg1, data1 := handover(1)
+	msg2, data2 := handover(2)
+	require.True(t, signer.bftVerifyHandover(msg1, data1))
+	require.False(t, signer.bftVerifyHandover(msg2, data2))
+	require.True(t, signer.bftVerifyHandover(msg1, data1))
+
+	log.Lvl1("Refusing a block of the old leader after the handover")
+	msg0, data0 := handover(0)
+	require.False(t, signer.bftVerifyNewBlock(msg0, data0))
+}
+
+func TestClient_GetForwardLink(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 2, 3, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	for i := 0; i < 4; i++ {
+		ssbr, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+
+	link, cerr := c.GetForwardLink(el, genesis.Hash, 2)
+	log.ErrFatal(cerr)
+	require.True(t, link.Hash.Equal(latest.Hash))
+	require.Nil(t, link.VerifySignature(el.Publics()))
+
+	_, cerr = c.GetForwardLink(el, latest.Hash, 0)
+	require.Equal(t, ErrorForwardLinkMissing, cerr.ErrorCode())
+	_, cerr = c.GetForwardLink(el, genesis.Hash, 3)
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+	_, cerr = c.GetForwardLink(el, SkipBlockID{}, 0)
+	require.Equal(t, ErrorBlockNotFound, cerr.ErrorCode())
+}
+
+func TestClient_GetAttachment(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	att1 := []byte("first attachment")
+	att2 := []byte("second attachment")
+	sb1 := NewSkipBlock()
+	sb1.Roster = el
+	sb1.MaximumHeight = 1
+	sb1.BaseHeight = 1
+	sb1.Data = append(HashAttachment(att1), HashAttachment(att2)...)
+	sb2 := sb1.Copy()
+	sb1.Attachment = att1
+	sb2.Attachment = att2
+	require.Equal(t, sb1.CalculateHash(), sb2.CalculateHash())
+
+	bad := sb1.Copy()
+	bad.Attachment = []byte("unknown attachment")
+	_, cerr := c.StoreSkipBlock(bad, nil, nil)
+	require.NotNil(t, cerr, "hash of attachment not in data")
+
+	reply1, cerr := c.StoreSkipBlock(sb1, nil, nil)
+	log.ErrFatal(cerr)
+	reply2, cerr := c.StoreSkipBlock(sb2, nil, nil)
+	log.ErrFatal(cerr)
+
+	att, cerr := c.GetAttachment(el, reply1.Latest.Hash, nil)
+	log.ErrFatal(cerr)
+	require.Equal(t, att1, att)
+	att, cerr = c.GetAttachment(el, reply2.Latest.Hash, HashAttachment(att2))
+	log.ErrFatal(cerr)
+	require.Equal(t, att2, att)
+	_, cerr = c.GetAttachment(el, reply2.Latest.Hash, HashAttachment(att1))
+	require.NotNil(t, cerr)
+
+	// The attachment is not sent with the block.
+	sb, cerr := c.GetSingleBlock(el, reply1.Latest.Hash)
+	log.ErrFatal(cerr)
+	require.Nil(t, sb.Attachment)
+	update, cerr := c.GetUpdateChain(el, reply1.Latest.Hash)
+	log.ErrFatal(cerr)
+	for _, b := range update.Update {
+		require.Nil(t, b.Attachment)
+	}
+}
+
+func TestClient_RepairForwardLinks(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 2, 2, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	for i := 0; i < 4; i++ {
+		reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	log.Lvl1("Removing forward-links on one conode")
+	sparse := l.GetServices(servers, skipchainSID)[2].(*Service)
+	sparse.Sbm.Lock()
+	for _, sb := range sparse.Sbm.SkipBlocks {
+		if sb.Index < 4 {
+			sb.ForwardLink = sb.ForwardLink[:1]
+		}
+	}
+	sparse.Sbm.Unlock()
+	gen := sparse.Sbm.GetByID(genesis.Hash)
+	require.Equal(t, 1, len(gen.ForwardLink))
+
+	reply, cerr := c.RepairForwardLinks(el.List[2], genesis.Hash)
+	log.ErrFatal(cerr)
+	require.NotEqual(t, 0, reply.Repaired)
+	gen = sparse.Sbm.GetByID(genesis.Hash)
+	require.Equal(t, 2, len(gen.ForwardLink))
+}
+
+func TestClient_GetFullChain(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 4, 3, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	nbrBlocks := 10
+	for i := 1; i < nbrBlocks; i++ {
+		reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	update, cerr := c.GetUpdateChain(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, len(update.Update) < nbrBlocks)
+
+	chain, cerr := c.GetFullChain(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.Equal(t, nbrBlocks, len(chain))
+	for i, sb := range chain {
+		require.Equal(t, i, sb.Index)
+	}
+	require.True(t, chain[nbrBlocks-1].Equal(latest))
+
+	// A block that is not a genesis-block is refused.
+	_, cerr = c.GetFullChain(el, chain[1].Hash)
+	require.True(t, IsVerification(cerr))
+}
+
+func TestClient_Replay(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 2, 3, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	for i := 1; i < 4; i++ {
+		reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	blocks := make(chan *SkipBlock, 10)
+	stop := c.Replay(el, genesis.Hash, 2, func(sb *SkipBlock) {
+		blocks <- sb
+	})
+	appended := make(chan bool)
+	go func() {
+		for i := 4; i < 7; i++ {
+			reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+			log.ErrFatal(cerr)
+			latest = reply.Latest
+		}
+		appended <- true
+	}()
+
+	for i := 2; i < 7; i++ {
+		select {
+		case sb := <-blocks:
+			require.Equal(t, i, sb.Index)
+			require.Equal(t, []byte{byte(i)}, sb.Data)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Didn't get block", i)
+		}
+	}
+	<-appended
+	stop()
+	select {
+	case sb := <-blocks:
+		t.Fatal("Got superfluous block", sb.Index)
+	default:
+	}
+
+	log.Lvl1("Nothing is replayed for a block that is not a genesis-block")
+	stop = c.Replay(el, latest.Hash, 0, func(sb *SkipBlock) {
+		blocks <- sb
+	})
+	time.Sleep(2 * replayInterval)
+	stop()
+	require.Equal(t, 0, len(blocks))
+}
+
+func TestClient_GetProof(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 4, 3, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	nbrBlocks := 20
+	for i := 1; i < nbrBlocks; i++ {
+		reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	reply, cerr := c.GetProof(el, genesis.Hash, latest.Hash)
+	log.ErrFatal(cerr)
+	proof := reply.Proof
+	require.True(t, len(proof) < nbrBlocks)
+	require.True(t, proof[len(proof)-1].Equal(latest))
+	log.ErrFatal(VerifyProof(genesis.Hash, proof))
+	require.NotNil(t, VerifyProof(latest.Hash, proof))
+
+	for i := range proof[:len(proof)-1] {
+		tampered := make([]*SkipBlock, len(proof))
+		for j, sb := range proof {
+			tampered[j] = sb.Copy()
+		}
+		tampered[i].ForwardLink[0].Signature[0] ^= 0xff
+		require.NotNil(t, VerifyProof(genesis.Hash, tampered))
+	}
+}
+
+func TestClient_GetTipProof(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 2, 3, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	latest := genesis
+	data := make([]byte, 10000)
+	for i := 1; i < 8; i++ {
+		data[0] = byte(i)
+		reply, cerr := c.StoreSkipBlock(latest, nil, data)
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	headers, cerr := c.GetTipProof(el, genesis.Hash, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, headers[0].Hash.Equal(genesis.Hash))
+	require.True(t, headers[len(headers)-1].Hash.Equal(latest.Hash))
+	for _, h := range headers {
+		require.Equal(t, 0, len(h.Data))
+	}
+
+	update, cerr := c.GetUpdateChain(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	full, err := network.Marshal(update)
+	log.ErrFatal(err)
+	light, err := network.Marshal(&GetTipProofReply{headers})
+	log.ErrFatal(err)
+	log.Lvl1("Sizes of update-chain and tip-proof:", len(full), len(light))
+	require.True(t, len(light)*4 < len(full))
+
+	_, cerr = c.GetTipProof(el, latest.Hash, genesis.Hash)
+	require.NotNil(t, cerr)
+}
+
+func TestClient_SubscribeUpdates(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+
+	log.Lvl1("Refusing to follow an unknown skipchain")
+	service := l.GetServices(servers, skipchainSID)[0].(*Service)
+	unknown := SkipBlockID{1, 2, 3}
+	_, cerr = service.FollowUpdate(&FollowUpdate{unknown, unknown})
+	require.NotNil(t, cerr)
+	service.updatesMutex.Lock()
+	require.Equal(t, 0, len(service.updates))
+	service.updatesMutex.Unlock()
+
+	blocks, cancel := c.SubscribeUpdates(el, genesis.Hash)
+	// Make sure the subscription found the genesis-block before adding
+	// new blocks.
+	time.Sleep(time.Second)
+	latest := genesis
+	for i := 1; i <= 3; i++ {
+		reply, cerr := c.StoreSkipBlock(latest, nil, []byte{byte(i)})
+		log.ErrFatal(cerr)
+		latest = reply.Latest
+	}
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case sb := <-blocks:
+			require.Equal(t, i, sb.Index)
+			require.Equal(t, []byte{byte(i)}, sb.Data)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Didn't get block", i)
+		}
+	}
+	cancel()
+	for range blocks {
+	}
+}
+
+func TestClient_GetBlocks(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	reply, cerr := c.StoreSkipBlock(genesis, nil, []byte{1})
+	log.ErrFatal(cerr)
+	latest := reply.Latest
+
+	unknown := SkipBlockID{1, 2, 3}
+	gbr, cerr := c.GetBlocks(el, []SkipBlockID{latest.Hash, unknown, genesis.Hash})
+	log.ErrFatal(cerr)
+	require.Equal(t, 2, len(gbr.Blocks))
+	require.True(t, gbr.Blocks[0].Equal(latest))
+	require.True(t, gbr.Blocks[1].Equal(genesis))
+	require.Equal(t, []SkipBlockID{unknown}, gbr.Missing)
+}
+
+func TestClient_CreateGenesisBackLink(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	seed := bytes.Repeat([]byte{1}, 32)
+	genesis1, cerr := c.CreateGenesisBackLink(el, 1, 1, VerificationNone, nil, nil, seed)
+	log.ErrFatal(cerr)
+	require.Equal(t, SkipBlockID(seed), genesis1.BackLinkIDs[0])
+	genesis2, cerr := c.CreateGenesisBackLink(el, 1, 1, VerificationNone, nil, nil, seed)
+	log.ErrFatal(cerr)
+	require.True(t, genesis1.Equal(genesis2))
+
+	other, cerr := c.CreateGenesisBackLink(el, 1, 1, VerificationNone, nil, nil,
+		bytes.Repeat([]byte{2}, 32))
+	log.ErrFatal(cerr)
+	require.False(t, genesis1.Equal(other))
+
+	_, cerr = c.CreateGenesisBackLink(el, 1, 1, VerificationNone, nil, nil, []byte{1})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+}
+
+func TestClient_GetUpdateChainIfChanged(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	reply, cerr := c.StoreSkipBlock(genesis, nil, []byte{1})
+	log.ErrFatal(cerr)
+	tip := reply.Latest
+
+	update, cerr := c.GetUpdateChainIfChanged(el, genesis.Hash, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.False(t, update.NotModified)
+	require.Equal(t, 2, len(update.Update))
+
+	update, cerr = c.GetUpdateChainIfChanged(el, genesis.Hash, tip.Hash)
+	log.ErrFatal(cerr)
+	require.True(t, update.NotModified)
+	require.Equal(t, 0, len(update.Update))
+}
+
+func TestClient_GetAcks(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	reply, cerr := c.StoreSkipBlock(genesis, nil, []byte{1})
+	log.ErrFatal(cerr)
+
+	// The acknowledgements are collected in the background.
+	var acks *GetAcksReply
+	for i := 0; i < 50; i++ {
+		acks, cerr = c.GetAcks(el.List[0], reply.Latest.Hash)
+		if cerr == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.ErrFatal(cerr)
+	require.Equal(t, nbrHosts, len(acks.Acks))
+	for _, ack := range acks.Acks {
+		i, si := el.Search(ack.ServerIdentity.ID)
+		require.True(t, i >= 0)
+		require.True(t, si.Public.Equal(ack.ServerIdentity.Public))
+		log.ErrFatal(ack.Verify(reply.Latest.Hash))
+		// The bare block-hash is not signed.
+		require.NotNil(t, crypto.VerifySchnorr(network.Suite,
+			ack.ServerIdentity.Public, reply.Latest.Hash, ack.Signature))
+	}
+	require.NotNil(t, acks.Acks[0].Verify(genesis.Hash))
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// A conode that accepts connections but never answers.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	log.ErrFatal(err)
+	defer ln.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conns <- conn
+		}
+	}()
+	// The websocket of a conode listens on the port following its address.
+	port := ln.Addr().(*net.TCPAddr).Port - 1
+	si := network.NewServerIdentity(network.Suite.Point().Null(),
+		network.NewTCPAddress("127.0.0.1:"+strconv.Itoa(port)))
+
+	c := NewClient().WithTimeout(time.Second)
+	start := time.Now()
+	_, cerr := c.GetAllSkipchains(si)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorTimeout, cerr.ErrorCode())
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	// The client must close its connection after the timeout.
+	conn := <-conns
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(ioutil.Discard, conn)
+	require.Nil(t, err, "connection has not been closed by the client")
+}
+
+func TestGetUpdateChainReply_VerifyGenesis(t *testing.T) {
+	nbrHosts := 3
+	l := onet.NewTCPTest()
+	_, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	trusted, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	other, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	reply, cerr := c.StoreSkipBlock(other, nil, []byte{1})
+	log.ErrFatal(cerr)
+
+	update, cerr := c.GetUpdateChain(el, other.Hash)
+	log.ErrFatal(cerr)
+	require.Equal(t, 2, len(update.Update))
+	require.True(t, update.Update[1].Equal(reply.Latest))
+	require.True(t, update.GenesisID.Equal(other.Hash))
+	log.ErrFatal(update.VerifyGenesis(other.Hash))
+	cerr = update.VerifyGenesis(trusted.Hash)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorVerification, cerr.ErrorCode())
+
+	log.Lvl1("Refusing reply with a wrong hash")
+	latest := update.Update[1]
+	latest.Data = []byte{2}
+	require.NotNil(t, update.VerifyGenesis(other.Hash))
+	latest.Data = []byte{1}
+	log.ErrFatal(update.VerifyGenesis(other.Hash))
+
+	log.Lvl1("Refusing reply with a forged forward-link")
+	fl := update.Update[0].ForwardLink[0]
+	sig := fl.Signature
+	fl.Signature = append([]byte{}, sig...)
+	fl.Signature[0] ^= 0xff
+	require.NotNil(t, update.VerifyGenesis(other.Hash))
+	fl.Signature = sig
+
+	log.Lvl1("Refusing reply with a foreign block")
+	update.GenesisID = trusted.Hash
+	update.Update = append(update.Update, trusted)
+	require.NotNil(t, update.VerifyGenesis(trusted.Hash))
+}
+
+func TestClient_GetUpdateChainCrossCheck(t *testing.T) {
+	nbrHosts := 2
+	l := onet.NewTCPTest()
+	servers, el, _ := l.GenTree(nbrHosts, true)
+	defer l.CloseAll()
+
+	c := newTestClient(l)
+	genesis, cerr := c.CreateGenesis(el, 1, 1, VerificationNone, nil, nil)
+	log.ErrFatal(cerr)
+	reply, cerr := c.StoreSkipBlock(genesis, nil, []byte{1})
+	log.ErrFatal(cerr)
+
+	update, warning, cerr := c.GetUpdateChainCrossCheck(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.Nil(t, warning)
+	require.Equal(t, 2, len(update.Update))
+
+	log.Lvl1("Keeping one conode behind")
+	behind := l.GetServices(servers, skipchainSID)[1].(*Service)
+	behind.Sbm.Lock()
+	delete(behind.Sbm.SkipBlocks, string(reply.Latest.Hash))
+	behind.Sbm.SkipBlocks[string(genesis.Hash)].ForwardLink = nil
+	behind.Sbm.Unlock()
+
+	update, warning, cerr = c.GetUpdateChainCrossCheck(el, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.NotNil(t, warning)
+	require.Equal(t, 2, len(warning.Tips))
+	require.False(t, warning.Tips[0].Equal(warning.Tips[1]))
+	require.True(t, update.Update[len(update.Update)-1].Equal(reply.Latest))
+
+	log.Lvl1("A roster with the same conode twice")
+	double := onet.NewRoster([]*network.ServerIdentity{el.List[0], el.List[0]})
+	update, warning, cerr = c.GetUpdateChainCrossCheck(double, genesis.Hash)
+	log.ErrFatal(cerr)
+	require.Nil(t, warning)
+	require.True(t, update.Update[len(update.Update)-1].Equal(reply.Latest))
+
+	log.Lvl1("A conode returning a wrong block")
+	behind.Sbm.Lock()
+	behind.Sbm.SkipBlocks[string(genesis.Hash)].Data = []byte("forged")
+	behind.Sbm.Unlock()
+	_, _, cerr = c.GetUpdateChainCrossCheck(el, genesis.Hash)
+	require.True(t, IsVerification(cerr))
+}
+
 func TestClient_GetSingleBlockByIndex(t *testing.T) {
 	nbrHosts := 3
 	l := onet.NewTCPTest()
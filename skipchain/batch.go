@@ -0,0 +1,238 @@
+package skipchain
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the methods to append a batch of blocks to a skipchain with
+one request. The blocks are chained and signed before any of them is
+stored, so either all blocks of the batch are appended, or none.
+*/
+
+// bftNewBatch is the BFT-protocol used to sign the forward-links of a batch.
+const bftNewBatch = "SkipchainBFTNewBatch"
+
+// StoreSkipBlocks appends all blocks of the request to the skipchain with
+// the latest block LatestID. The fields of the blocks that depend on their
+// place in the skipchain are set by the leader. All blocks of the batch are
+// verified in every BFT-round, so that a single refused block refuses the
+// whole batch. Only after all forward-links are signed, the blocks are
+// stored and propagated.
+func (s *Service) StoreSkipBlocks(ssb *StoreSkipBlocks) (*StoreSkipBlocksReply, onet.ClientError) {
+	if len(ssb.Blocks) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"no blocks to store")
+	}
+	prev := s.Sbm.GetByID(ssb.LatestID)
+	if prev == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find latest block")
+	}
+	if i, _ := prev.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"We're not responsible for latest block")
+	}
+	if len(prev.ForwardLink) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"the latest block already has a follower")
+	}
+	if !s.newBlockStart(prev) {
+		return nil, onet.NewClientErrorCode(ErrorBlockInProgress,
+			"this skipchain-id is currently processing a block")
+	}
+	defer s.newBlockEnd(prev)
+
+	blocks := make([]*SkipBlock, len(ssb.Blocks))
+	defer s.pending.remove(blocks)
+	last := prev
+	now := time.Now().UnixNano()
+	for i, sb := range ssb.Blocks {
+		prop := sb.Copy()
+		if prop.Roster == nil {
+			prop.Roster = last.Roster
+		}
+		if !s.ServerIdentity().Equal(prop.Roster.Get(0)) {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				"only leader is allowed to add blocks")
+		}
+		prop.ForwardLink = []*BlockLink{}
+		prop.ChildSL = nil
+		prop.ChildAuth = nil
+		prop.Timestamp = now + int64(i)
+		if cerr := s.chainBlock(last, prop); cerr != nil {
+			return nil, cerr
+		}
+		if err := s.verifyBlock(prop); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				err.Error())
+		}
+		blocks[i] = prop
+		s.pending.add(prop)
+		last = prop
+	}
+
+	data, err := network.Marshal(&StoreSkipBlocks{prev.Hash, blocks})
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorOnet, err.Error())
+	}
+	links := make([]*BlockLink, len(blocks))
+	src := prev
+	for i, prop := range blocks {
+		start := time.Now()
+		link, err := s.startBFT(bftNewBatch, src.Roster, prop.Hash, data)
+		s.logOp(OpBFT, prop, start, err)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorVerification,
+				"Couldn't get forward signature on block: "+err.Error())
+		}
+		links[i] = link
+		src = prop
+	}
+
+	if len(s.Sbm.GetByID(prev.Hash).ForwardLink) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"Forward-link got signed during our signing")
+	}
+	src = prev
+	for i, prop := range blocks {
+		src.ForwardLink = []*BlockLink{links[i]}
+		if err := src.VerifyForwardSignatures(); err != nil {
+			prev.ForwardLink = []*BlockLink{}
+			return nil, onet.NewClientErrorCode(ErrorVerification,
+				"Wrong BFT-signature: "+err.Error())
+		}
+		src = prop
+	}
+	if err := s.startPropagation(append([]*SkipBlock{prev}, blocks...)); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"Couldn't propagate new blocks: "+err.Error())
+	}
+
+	src = prev
+	for _, prop := range blocks {
+		if _, cerr := s.addHigherForwardLinks(src, prop); cerr != nil {
+			log.Error("Couldn't add higher forward-links:", cerr)
+		}
+		src = prop
+	}
+	s.save()
+	for range blocks {
+		s.metrics.add(&s.metrics.blocksStored)
+	}
+	if err := s.collectAcks(last); err != nil {
+		log.Error("Couldn't collect acknowledgements:", err)
+	}
+	return &StoreSkipBlocksReply{Previous: prev, Latest: last}, nil
+}
+
+// bftVerifyBatch verifies all blocks of a batch, whose marshalled
+// StoreSkipBlocks is in data. The blocks must follow each other, starting
+// at the latest block, and msg must be the hash of one of them.
+func (s *Service) bftVerifyBatch(msg []byte, data []byte) bool {
+	_, ssbi, err := network.Unmarshal(data)
+	if err != nil {
+		log.Error("Couldn't unmarshal batch:", err)
+		return false
+	}
+	ssb, ok := ssbi.(*StoreSkipBlocks)
+	if !ok || len(ssb.Blocks) == 0 {
+		log.Error("Got wrong batch")
+		return false
+	}
+	prev := s.Sbm.GetByID(ssb.LatestID)
+	if prev == nil {
+		log.Error("Didn't find latest block of batch")
+		return false
+	}
+	if len(prev.ForwardLink) > 0 {
+		log.Lvl2("latest block of batch already has forward-link")
+		return false
+	}
+	s.pending.add(ssb.Blocks...)
+	defer s.pending.remove(ssb.Blocks)
+	found := false
+	start := time.Now()
+	for _, sb := range ssb.Blocks {
+		if !sb.Hash.Equal(sb.CalculateHash()) || sb.Index != prev.Index+1 ||
+			len(sb.BackLinkIDs) == 0 || !sb.BackLinkIDs[0].Equal(prev.Hash) {
+			log.Lvl2("Block of batch doesn't follow its previous block")
+			return false
+		}
+		if !s.runVerifiers(sb.Hash, prev, sb) {
+			s.metrics.add(&s.metrics.verificationFailures)
+			s.logOp(OpVerify, sb, start, errRefused)
+			return false
+		}
+		found = found || sb.Hash.Equal(msg)
+		prev = sb
+	}
+	if !found {
+		log.Lvlf2("Batch doesn't hold block %x", msg)
+	}
+	return found
+}
+
+// getBlockOrPending returns the stored block with the given id, or a block of a
+// batch that is being verified.
+func (s *Service) getBlockOrPending(id SkipBlockID) *SkipBlock {
+	if sb := s.Sbm.GetByID(id); sb != nil {
+		return sb
+	}
+	return s.pending.get(id)
+}
+
+// pendingBlocks holds the blocks of batches that are being verified, so
+// that the verification of a block can find the previous block in the
+// batch. As the same batch can be verified concurrently, the blocks are
+// reference-counted.
+type pendingBlocks struct {
+	sync.Mutex
+	blocks map[string]*SkipBlock
+	refs   map[string]int
+}
+
+func newPendingBlocks() *pendingBlocks {
+	return &pendingBlocks{
+		blocks: make(map[string]*SkipBlock),
+		refs:   make(map[string]int),
+	}
+}
+
+func (p *pendingBlocks) add(sbs ...*SkipBlock) {
+	p.Lock()
+	defer p.Unlock()
+	for _, sb := range sbs {
+		id := string(sb.Hash)
+		p.blocks[id] = sb
+		p.refs[id]++
+	}
+}
+
+// remove ignores nil blocks, so that a partially filled batch can be
+// removed.
+func (p *pendingBlocks) remove(sbs []*SkipBlock) {
+	p.Lock()
+	defer p.Unlock()
+	for _, sb := range sbs {
+		if sb == nil {
+			continue
+		}
+		id := string(sb.Hash)
+		if p.refs[id]--; p.refs[id] <= 0 {
+			delete(p.blocks, id)
+			delete(p.refs, id)
+		}
+	}
+}
+
+func (p *pendingBlocks) get(id SkipBlockID) *SkipBlock {
+	p.Lock()
+	defer p.Unlock()
+	return p.blocks[string(id)]
+}
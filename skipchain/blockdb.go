@@ -0,0 +1,315 @@
+package skipchain
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the BlockDB, which stores every skipblock under its hash
+instead of writing all skipblocks to one file. If a BlockDB is used, the
+SkipBlockMap only caches up to maxCachedBlocks of the blocks that have been
+accessed. The BlockDB keeps the number of blocks and an index of the blocks
+of every skipchain, so that they can be found without reading all blocks.
+*/
+
+// BlockDBEnv is the environment-variable holding the path of the bolt-file
+// used as BlockDB. If it is not set, all skipblocks are kept in memory and
+// saved to one file.
+const BlockDBEnv = "SKIPCHAIN_BLOCKDB"
+
+// BlockDB stores skipblocks indexed by their hash.
+type BlockDB interface {
+	// Store writes the skipblock, replacing an existing skipblock with the
+	// same hash.
+	Store(sb *SkipBlock) error
+	// Get returns the skipblock with the given hash or nil if it doesn't
+	// exist.
+	Get(id SkipBlockID) (*SkipBlock, error)
+	// ForEach calls f for all stored skipblocks.
+	ForEach(f func(sb *SkipBlock) error) error
+	// Delete removes the skipblock with the given hash, if it exists.
+	Delete(id SkipBlockID) error
+	// Count returns the number of stored skipblocks.
+	Count() (int, error)
+	// Chains returns the IDs of all skipchains with stored skipblocks.
+	Chains() ([]SkipBlockID, error)
+	// ChainBlocks returns the hashes of all stored skipblocks of the
+	// skipchain with the given ID.
+	ChainBlocks(genesis SkipBlockID) ([]SkipBlockID, error)
+	// Close releases the resources of the BlockDB.
+	Close() error
+}
+
+var boltBucket = []byte("skipblocks")
+
+// boltChains holds a bucket for every skipchain with the hashes of its
+// skipblocks as keys.
+var boltChains = []byte("chains")
+
+// boltMeta holds the number of skipblocks under boltCount.
+var boltMeta = []byte("meta")
+var boltCount = []byte("count")
+
+// boltBlockDB is a BlockDB using a bolt-file.
+type boltBlockDB struct {
+	db *bolt.DB
+}
+
+// NewBoltBlockDB opens or creates the bolt-file at path and returns a
+// BlockDB using it.
+func NewBoltBlockDB(path string) (BlockDB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		blocks, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltChains); err != nil {
+			return err
+		}
+		if tx.Bucket(boltMeta) != nil {
+			return nil
+		}
+		// Files written before the index was kept are indexed once.
+		if _, err := tx.CreateBucket(boltMeta); err != nil {
+			return err
+		}
+		return blocks.ForEach(func(k, v []byte) error {
+			sb, err := unmarshalBlock(v)
+			if err != nil {
+				return err
+			}
+			return boltIndex(tx, sb)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltBlockDB{db}, nil
+}
+
+// Store implements BlockDB.
+func (b *boltBlockDB) Store(sb *SkipBlock) error {
+	buf, err := network.Marshal(sb)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket(boltBucket)
+		if blocks.Get(sb.Hash) == nil {
+			if err := boltIndex(tx, sb); err != nil {
+				return err
+			}
+		}
+		return blocks.Put(sb.Hash, buf)
+	})
+}
+
+// Get implements BlockDB.
+func (b *boltBlockDB) Get(id SkipBlockID) (*SkipBlock, error) {
+	var sb *SkipBlock
+	err := b.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(boltBucket).Get(id)
+		if buf == nil {
+			return nil
+		}
+		var err error
+		sb, err = unmarshalBlock(buf)
+		return err
+	})
+	return sb, err
+}
+
+// ForEach implements BlockDB.
+func (b *boltBlockDB) ForEach(f func(sb *SkipBlock) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			sb, err := unmarshalBlock(v)
+			if err != nil {
+				return err
+			}
+			return f(sb)
+		})
+	})
+}
+
+// Delete implements BlockDB.
+func (b *boltBlockDB) Delete(id SkipBlockID) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket(boltBucket)
+		buf := blocks.Get(id)
+		if buf == nil {
+			return nil
+		}
+		sb, err := unmarshalBlock(buf)
+		if err != nil {
+			return err
+		}
+		chains := tx.Bucket(boltChains)
+		if chain := chains.Bucket(sb.SkipChainID()); chain != nil {
+			if err := chain.Delete(id); err != nil {
+				return err
+			}
+			if k, _ := chain.Cursor().First(); k == nil {
+				if err := chains.DeleteBucket(sb.SkipChainID()); err != nil {
+					return err
+				}
+			}
+		}
+		if err := boltAddCount(tx, -1); err != nil {
+			return err
+		}
+		return blocks.Delete(id)
+	})
+}
+
+// Count implements BlockDB.
+func (b *boltBlockDB) Count() (int, error) {
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		count = boltGetCount(tx)
+		return nil
+	})
+	return count, err
+}
+
+// Chains implements BlockDB.
+func (b *boltBlockDB) Chains() ([]SkipBlockID, error) {
+	var ids []SkipBlockID
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltChains).ForEach(func(k, v []byte) error {
+			ids = append(ids, append(SkipBlockID{}, k...))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// ChainBlocks implements BlockDB.
+func (b *boltBlockDB) ChainBlocks(genesis SkipBlockID) ([]SkipBlockID, error) {
+	var ids []SkipBlockID
+	err := b.db.View(func(tx *bolt.Tx) error {
+		chain := tx.Bucket(boltChains).Bucket(genesis)
+		if chain == nil {
+			return nil
+		}
+		return chain.ForEach(func(k, v []byte) error {
+			ids = append(ids, append(SkipBlockID{}, k...))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Close implements BlockDB.
+func (b *boltBlockDB) Close() error {
+	return b.db.Close()
+}
+
+// boltIndex adds the new skipblock sb to the index of its skipchain and to
+// the number of skipblocks.
+func boltIndex(tx *bolt.Tx, sb *SkipBlock) error {
+	if id := sb.SkipChainID(); len(id) > 0 {
+		chain, err := tx.Bucket(boltChains).CreateBucketIfNotExists(id)
+		if err != nil {
+			return err
+		}
+		if err := chain.Put(sb.Hash, []byte{}); err != nil {
+			return err
+		}
+	}
+	return boltAddCount(tx, 1)
+}
+
+// boltGetCount returns the number of skipblocks.
+func boltGetCount(tx *bolt.Tx) int {
+	buf := tx.Bucket(boltMeta).Get(boltCount)
+	if len(buf) != 8 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint64(buf))
+}
+
+// boltAddCount adds diff to the number of skipblocks.
+func boltAddCount(tx *bolt.Tx, diff int) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(boltGetCount(tx)+diff))
+	return tx.Bucket(boltMeta).Put(boltCount, buf)
+}
+
+// unmarshalBlock decodes a skipblock. The buffer is copied, as bolt only
+// guarantees its content during the transaction.
+func unmarshalBlock(buf []byte) (*SkipBlock, error) {
+	_, msg, err := network.Unmarshal(append([]byte{}, buf...))
+	if err != nil {
+		return nil, err
+	}
+	sb, ok := msg.(*SkipBlock)
+	if !ok {
+		return nil, errors.New("stored data is not a skipblock")
+	}
+	return sb, nil
+}
+
+// encryptedBlockDB encrypts the Data of all skipblocks before passing them
+// to the underlying BlockDB.
+type encryptedBlockDB struct {
+	BlockDB
+	aead cipher.AEAD
+}
+
+// newEncryptedBlockDB returns a BlockDB that encrypts the Data of all
+// skipblocks stored in db using key.
+func newEncryptedBlockDB(db BlockDB, key []byte) (BlockDB, error) {
+	aead, err := storageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedBlockDB{db, aead}, nil
+}
+
+// Store implements BlockDB.
+func (e *encryptedBlockDB) Store(sb *SkipBlock) error {
+	enc := sb.Copy()
+	enc.Data = sealData(e.aead, sb)
+	return e.BlockDB.Store(enc)
+}
+
+// Get implements BlockDB.
+func (e *encryptedBlockDB) Get(id SkipBlockID) (*SkipBlock, error) {
+	sb, err := e.BlockDB.Get(id)
+	if err != nil || sb == nil {
+		return sb, err
+	}
+	return sb, e.decrypt(sb)
+}
+
+// ForEach implements BlockDB.
+func (e *encryptedBlockDB) ForEach(f func(sb *SkipBlock) error) error {
+	return e.BlockDB.ForEach(func(sb *SkipBlock) error {
+		if err := e.decrypt(sb); err != nil {
+			return err
+		}
+		return f(sb)
+	})
+}
+
+// decrypt replaces the encrypted Data of sb with the plaintext.
+func (e *encryptedBlockDB) decrypt(sb *SkipBlock) error {
+	data, err := openData(e.aead, sb)
+	if err != nil {
+		return err
+	}
+	sb.Data = data
+	return nil
+}
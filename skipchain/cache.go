@@ -0,0 +1,82 @@
+package skipchain
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+// defaultVerifiedCacheSize is the number of verified blocks remembered by
+// the service.
+const defaultVerifiedCacheSize = 1024
+
+// verifiedCache is a least-recently-used set of fingerprints of blocks whose
+// signatures have already been verified. A nil cache never holds anything.
+type verifiedCache struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newVerifiedCache(size int) *verifiedCache {
+	return &verifiedCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// contains returns whether the key is in the cache and marks it as recently
+// used.
+func (vc *verifiedCache) contains(key string) bool {
+	if vc == nil {
+		return false
+	}
+	vc.Lock()
+	defer vc.Unlock()
+	e, ok := vc.entries[key]
+	if ok {
+		vc.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// add stores the key and evicts the least recently used key if the cache is
+// full.
+func (vc *verifiedCache) add(key string) {
+	if vc == nil {
+		return
+	}
+	vc.Lock()
+	defer vc.Unlock()
+	if e, ok := vc.entries[key]; ok {
+		vc.order.MoveToFront(e)
+		return
+	}
+	vc.entries[key] = vc.order.PushFront(key)
+	if vc.order.Len() > vc.size {
+		last := vc.order.Back()
+		vc.order.Remove(last)
+		delete(vc.entries, last.Value.(string))
+	}
+}
+
+// verifiedKey returns the fingerprint of a block for the cache. It covers
+// the recalculated hash of the block and all its forward-links including
+// their signature scheme, so a block with changed content or forward-links
+// is verified again. The kind separates the different verifications done on
+// a block.
+func verifiedKey(kind string, sb *SkipBlock) string {
+	h := network.Suite.Hash()
+	h.Write([]byte(kind))
+	h.Write(sb.CalculateHash())
+	for _, fl := range sb.ForwardLink {
+		binary.Write(h, binary.LittleEndian, int64(fl.SigScheme))
+		h.Write(fl.Hash)
+		h.Write(fl.Signature)
+	}
+	return string(h.Sum(nil))
+}
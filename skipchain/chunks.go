@@ -0,0 +1,298 @@
+package skipchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the storage of the Data of skipblocks that is too big to be
+sent in one message. The client splits the Data in chunks of at most
+DataChunkSize bytes and sends them with StoreDataChunk to all conodes of the
+roster. The block itself only holds the hash of the Data and the number of
+chunks. The chunks are returned by GetBlockData.
+
+Chunks that are not yet referenced by a stored block are only kept in memory,
+up to chunkQuota bytes and for at most chunkTTL. Once a block referencing
+them is stored, the chunks are written to disk, each set under its own key.
+*/
+
+// DataChunkSize is the maximum size of the Data sent in one message. Bigger
+// Data is stored in chunks by Client.StoreSkipBlock.
+const DataChunkSize = 1024 * 1024
+
+// maxDataChunks is the maximum number of chunks of the Data of one block.
+const maxDataChunks = 256
+
+// chunkQuota is the maximum number of bytes of chunks that are not
+// referenced by a stored block.
+const chunkQuota = 2 * maxDataChunks * DataChunkSize
+
+// chunkTTL is how long chunks that are not referenced by a stored block are
+// kept.
+const chunkTTL = 10 * time.Minute
+
+// chunksID is the key used to store the list of hashes of the chunks on
+// disk. Every set of chunks is stored under chunksID and its hash.
+const chunksID = "skipchunks"
+
+func init() {
+	network.RegisterMessage(&storedChunks{})
+	network.RegisterMessage(&dataChunks{})
+}
+
+// dataChunks holds all chunks of the Data with the given hash. If the
+// service has a StorageKey, all chunks are encrypted.
+type dataChunks struct {
+	Hash      []byte
+	Chunks    [][]byte
+	Encrypted bool
+}
+
+// storedChunks holds the hashes of all sets of chunks written to disk.
+type storedChunks struct {
+	Hashes [][]byte
+}
+
+// chunkSet holds the chunks of one Data.
+type chunkSet struct {
+	chunks [][]byte
+	// size is the number of bytes of all chunks received.
+	size int
+	// added is when the first chunk was received.
+	added time.Time
+	// stored is true once a block referencing the chunks is stored.
+	stored bool
+}
+
+// chunkStore holds the chunks of the Data of skipblocks, indexed by the hash
+// of the Data.
+type chunkStore struct {
+	sync.Mutex
+	sets map[string]*chunkSet
+	// pending is the number of bytes of chunks that are not stored.
+	pending int
+	quota   int
+	ttl     time.Duration
+}
+
+func newChunkStore() *chunkStore {
+	return &chunkStore{
+		sets:  make(map[string]*chunkSet),
+		quota: chunkQuota,
+		ttl:   chunkTTL,
+	}
+}
+
+// add stores the chunk at index of the Data with the given hash that is
+// split in count chunks. It returns true if all chunks are present. A chunk
+// that is already stored can only be sent again unchanged, so that nobody can
+// overwrite the Data of a stored block. If the complete chunks don't match
+// the hash, they are removed and an error is returned.
+func (cs *chunkStore) add(hash []byte, index, count int, chunk []byte) (bool, error) {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.expire()
+	key := string(hash)
+	set, ok := cs.sets[key]
+	if !ok {
+		set = &chunkSet{chunks: make([][]byte, count), added: time.Now()}
+		cs.sets[key] = set
+	}
+	if len(set.chunks) != count {
+		return false, errors.New("wrong number of chunks")
+	}
+	if set.chunks[index] != nil {
+		if !bytes.Equal(set.chunks[index], chunk) {
+			return false, errors.New("chunk already stored")
+		}
+	} else {
+		if cs.pending+len(chunk) > cs.quota {
+			if set.size == 0 {
+				delete(cs.sets, key)
+			}
+			return false, errors.New("quota of chunks exceeded")
+		}
+		set.chunks[index] = chunk
+		set.size += len(chunk)
+		cs.pending += len(chunk)
+	}
+	if !set.complete() {
+		return false, nil
+	}
+	data := sha256.Sum256(bytes.Join(set.chunks, nil))
+	if !bytes.Equal(data[:], hash) {
+		cs.remove(key)
+		return false, errors.New("chunks don't match hash")
+	}
+	return true, nil
+}
+
+// get returns all chunks of the Data with the given hash, or nil if some
+// are missing.
+func (cs *chunkStore) get(hash []byte) [][]byte {
+	cs.Lock()
+	defer cs.Unlock()
+	set := cs.sets[string(hash)]
+	if set == nil || !set.complete() {
+		return nil
+	}
+	return set.chunks
+}
+
+// expire removes all chunks that are not referenced by a stored block and
+// are older than the ttl. The caller must hold the lock.
+func (cs *chunkStore) expire() {
+	for key, set := range cs.sets {
+		if !set.stored && time.Since(set.added) > cs.ttl {
+			cs.remove(key)
+		}
+	}
+}
+
+// remove deletes the chunks that are not stored. The caller must hold the
+// lock.
+func (cs *chunkStore) remove(key string) {
+	set := cs.sets[key]
+	if set == nil || set.stored {
+		return
+	}
+	cs.pending -= set.size
+	delete(cs.sets, key)
+}
+
+// complete returns true if all chunks are present.
+func (set *chunkSet) complete() bool {
+	for _, c := range set.chunks {
+		if c == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyChunks returns an error if sb holds its Data in chunks and this
+// conode doesn't have all of them.
+func (s *Service) verifyChunks(sb *SkipBlock) error {
+	if sb.DataChunks == 0 {
+		return nil
+	}
+	if len(sb.Data) > 0 {
+		return errors.New("block with chunks must not hold data")
+	}
+	if len(s.chunks.get(sb.DataHash)) != sb.DataChunks {
+		return errors.New("missing chunks of data")
+	}
+	return nil
+}
+
+// chunksKey returns the key used to store the chunks of the given hash.
+func chunksKey(hash []byte) string {
+	return chunksID + "-" + hex.EncodeToString(hash)
+}
+
+// saveChunks writes the chunks of the Data of sb to disk, encrypted if a
+// StorageKey is set, and keeps them from expiring. Chunks that are already
+// written, or that this conode doesn't hold, are skipped.
+func (s *Service) saveChunks(sb *SkipBlock) error {
+	if sb.DataChunks == 0 {
+		return nil
+	}
+	s.chunks.Lock()
+	defer s.chunks.Unlock()
+	set := s.chunks.sets[string(sb.DataHash)]
+	if set == nil || !set.complete() || set.stored {
+		return nil
+	}
+	dc := &dataChunks{Hash: sb.DataHash, Encrypted: s.StorageKey != nil}
+	aead, err := storageCipher(s.StorageKey)
+	if err != nil && dc.Encrypted {
+		return err
+	}
+	for _, c := range set.chunks {
+		if dc.Encrypted {
+			nonce := random.Bytes(aead.NonceSize(), random.Stream)
+			c = aead.Seal(nonce, nonce, c, dc.Hash)
+		}
+		dc.Chunks = append(dc.Chunks, c)
+	}
+	if err := s.Save(chunksKey(sb.DataHash), dc); err != nil {
+		return err
+	}
+	sc := &storedChunks{Hashes: [][]byte{sb.DataHash}}
+	for hash, other := range s.chunks.sets {
+		if other.stored {
+			sc.Hashes = append(sc.Hashes, []byte(hash))
+		}
+	}
+	if err := s.Save(chunksID, sc); err != nil {
+		return err
+	}
+	set.stored = true
+	s.chunks.pending -= set.size
+	return nil
+}
+
+// loadChunks reads the chunks stored on disk, if any.
+func (s *Service) loadChunks() error {
+	if !s.DataAvailable(chunksID) {
+		return nil
+	}
+	msg, err := s.Load(chunksID)
+	if err != nil {
+		return err
+	}
+	sc, ok := msg.(*storedChunks)
+	if !ok {
+		return errors.New("Data of wrong type")
+	}
+	s.chunks.Lock()
+	defer s.chunks.Unlock()
+	for _, hash := range sc.Hashes {
+		msg, err := s.Load(chunksKey(hash))
+		if err != nil {
+			return err
+		}
+		dc, ok := msg.(*dataChunks)
+		if !ok {
+			return errors.New("Data of wrong type")
+		}
+		if dc.Encrypted {
+			if err := s.decryptChunks(dc); err != nil {
+				return err
+			}
+		}
+		set := &chunkSet{chunks: dc.Chunks, stored: true}
+		s.chunks.sets[string(dc.Hash)] = set
+	}
+	return nil
+}
+
+// decryptChunks decrypts all chunks of dc in place.
+func (s *Service) decryptChunks(dc *dataChunks) error {
+	if s.StorageKey == nil {
+		return errStorageKeyMissing
+	}
+	aead, err := storageCipher(s.StorageKey)
+	if err != nil {
+		return err
+	}
+	size := aead.NonceSize()
+	for i, c := range dc.Chunks {
+		if len(c) < size {
+			return errors.New("encrypted chunk too short")
+		}
+		dc.Chunks[i], err = aead.Open(nil, c[:size], c[size:], dc.Hash)
+		if err != nil {
+			return errors.New("couldn't decrypt chunk: " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package skipchain
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+/*
+This file holds the metrics of the skipchain-service and exports them in the
+text-format of Prometheus.
+*/
+
+// metrics holds the counters and gauges of the service.
+type metrics struct {
+	sync.Mutex
+	blocksStored         uint64
+	propagations         uint64
+	bftRounds            uint64
+	bftTimeouts          uint64
+	verificationFailures uint64
+	propagationsInFlight int64
+}
+
+// add increases the given counter by one.
+func (m *metrics) add(counter *uint64) {
+	m.Lock()
+	*counter++
+	m.Unlock()
+}
+
+// propagationStart increases the propagation-counter and the gauge of the
+// propagations in flight.
+func (m *metrics) propagationStart() {
+	m.Lock()
+	m.propagations++
+	m.propagationsInFlight++
+	m.Unlock()
+}
+
+// propagationEnd decreases the gauge of the propagations in flight.
+func (m *metrics) propagationEnd() {
+	m.Lock()
+	m.propagationsInFlight--
+	m.Unlock()
+}
+
+// prometheus returns all metrics in the Prometheus text-format. chains is
+// the number of known skipchains.
+func (m *metrics) prometheus(chains int) string {
+	m.Lock()
+	defer m.Unlock()
+	var buf bytes.Buffer
+	write := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n%s %d\n",
+			name, help, name, kind, name, value)
+	}
+	write("skipchain_blocks_stored_total", "counter",
+		"Number of blocks stored by this conode as leader.", m.blocksStored)
+	write("skipchain_propagations_total", "counter",
+		"Number of propagations started.", m.propagations)
+	write("skipchain_bft_rounds_total", "counter",
+		"Number of BFT-rounds started.", m.bftRounds)
+	write("skipchain_bft_timeouts_total", "counter",
+		"Number of BFT-rounds that timed out.", m.bftTimeouts)
+	write("skipchain_verification_failures_total", "counter",
+		"Number of new blocks refused by a verifier.", m.verificationFailures)
+	write("skipchain_chains_known", "gauge",
+		"Number of skipchains known to this conode.", chains)
+	write("skipchain_propagations_in_flight", "gauge",
+		"Number of propagations currently running.", m.propagationsInFlight)
+	return buf.String()
+}
@@ -1,6 +1,12 @@
 package skipchain
 
-import "gopkg.in/dedis/onet.v1/network"
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/network"
+)
 
 func init() {
 	for _, m := range []interface{}{
@@ -8,14 +14,76 @@ func init() {
 		// Store new skipblock
 		&StoreSkipBlock{},
 		&StoreSkipBlockReply{},
+		// Conditionally store new skipblock
+		&CompareAndAppend{},
+		&CompareAndAppendReply{},
+		// Store a batch of new skipblocks
+		&StoreSkipBlocks{},
+		&StoreSkipBlocksReply{},
 		// Requests for data
 		&GetUpdateChain{},
 		&GetUpdateChainReply{},
 		// Request updated block
 		&GetSingleBlock{},
+		// Request a forward-link of a block
+		&GetForwardLink{},
+		&GetForwardLinkReply{},
+		&GetTipProof{},
+		&GetTipProofReply{},
+		&StoreDataChunk{},
+		&StoreDataChunkReply{},
+		&GetBlockData{},
+		&GetBlockDataReply{},
+		// Request many blocks at once
+		&GetBlocks{},
+		&GetBlocksReply{},
+		// Request a range of blocks by index
+		&GetBlockRange{},
+		&GetBlockRangeReply{},
+		// Request attachment of a block
+		&GetAttachment{},
+		&GetAttachmentReply{},
 		// Fetch all skipchains
 		&GetAllSkipchains{},
 		&GetAllSkipchainsReply{},
+		// Fetch all known conodes
+		&GetKnownConodes{},
+		&GetKnownConodesReply{},
+		// Check reachability of the roster
+		&PingRoster{},
+		&PingRosterReply{},
+		// Fetch missing forward-links
+		&RepairForwardLinks{},
+		&RepairForwardLinksReply{},
+		// Export metrics
+		&GetMetrics{},
+		&GetMetricsReply{},
+		// Fetch acknowledgements of a block
+		&GetAcks{},
+		&GetAcksReply{},
+		// Backup and restore the stored skipblocks
+		&Snapshot{},
+		&SnapshotReply{},
+		&Restore{},
+		&RestoreReply{},
+		// Fetch a proof of a block
+		&GetProof{},
+		&GetProofReply{},
+		// Wait for new blocks
+		&FollowUpdate{},
+		&FollowUpdateReply{},
+		// Fetch the status of the conode
+		&GetStatus{},
+		&GetStatusReply{},
+		// Take over the leadership of a skipchain
+		&ProposeLeader{},
+		&ProposeLeaderReply{},
+		// Remove a skipchain from the conode
+		&RemoveChain{},
+		&RemoveChainReply{},
+		// Check that the service is responsive
+		&HealthCheck{},
+		&HealthCheckReply{},
 		// - Internal calls
 		// Propagation
 		&PropagateSkipBlocks{},
@@ -25,9 +93,19 @@ func init() {
 		&GetBlock{},
 		// Reply with updated block
 		&GetBlockReply{},
+		// Check whether a conode is reachable
+		&Ping{},
+		&PingReply{},
+		// Forward a StoreSkipBlock-request to the leader
+		&ForwardStore{},
+		&ForwardStoreReply{},
 		// - Data structures
 		&SkipBlockFix{},
 		&SkipBlock{},
+		&RateLimit{},
+		&RosterAllowlist{},
+		&ExternalData{},
+		&TimestampedData{},
 		// Own service
 		&Service{},
 	} {
@@ -46,6 +124,14 @@ func init() {
 type StoreSkipBlock struct {
 	LatestID SkipBlockID
 	NewBlock *SkipBlock
+	// GenesisBackLink, if set when creating a new skipchain, is the
+	// 32-byte back-link of the genesis-block instead of random bytes. The
+	// timestamp of NewBlock is kept, too, so the genesis-block is
+	// reproducible.
+	GenesisBackLink []byte
+	// DryRun only verifies the new block, without signing and storing it.
+	// The reply holds the block with the computed back-links and height.
+	DryRun bool
 }
 
 // StoreSkipBlockReply - returns the signed SkipBlock with updated backlinks
@@ -54,26 +140,273 @@ type StoreSkipBlockReply struct {
 	Latest   *SkipBlock
 }
 
+// StoreSkipBlocks - requests to append all Blocks, in order, to the
+// skipchain with the latest block LatestID. Either all blocks are appended,
+// or none.
+type StoreSkipBlocks struct {
+	LatestID SkipBlockID
+	Blocks   []*SkipBlock
+}
+
+// StoreSkipBlocksReply - returns the previous latest block with its new
+// forward-link and the last block of the batch, which is the new latest
+// block.
+type StoreSkipBlocksReply struct {
+	Previous *SkipBlock
+	Latest   *SkipBlock
+}
+
+// CompareAndAppend - Requests a new skipblock to be appended, but only if
+// ExpectedTip is still the latest block of the skipchain.
+type CompareAndAppend struct {
+	ExpectedTip SkipBlockID
+	NewBlock    *SkipBlock
+}
+
+// CompareAndAppendReply - if the block has been appended, Stored holds the
+// reply of StoreSkipBlock. Else Tip holds the actual latest block of the
+// skipchain.
+type CompareAndAppendReply struct {
+	Stored *StoreSkipBlockReply
+	Tip    *SkipBlock
+}
+
 // GetUpdateChain - the client sends the hash of the last known
 // Skipblock and will get back a list of all necessary SkipBlocks
 // to get to the latest.
 type GetUpdateChain struct {
 	LatestID SkipBlockID
+	// IfChangedFrom is optional. If it is the id of the latest block,
+	// no blocks are returned and NotModified is set in the reply.
+	IfChangedFrom SkipBlockID
+	// MaxBlocks is optional. If > 0, at most MaxBlocks blocks are returned,
+	// but at least two, and More is set in the reply if the chain goes on.
+	MaxBlocks int
 }
 
 // GetUpdateChainReply - returns the shortest chain to the current SkipBlock,
 // starting from the SkipBlock the client sent
 type GetUpdateChainReply struct {
 	Update []*SkipBlock
+	// NotModified is true if the latest block is the one given in
+	// IfChangedFrom.
+	NotModified bool
+	// GenesisID is the SkipChainID of the returned blocks.
+	GenesisID SkipBlockID
+	// More is true if the reply has been cut at MaxBlocks. The next
+	// blocks can be requested starting with the last returned block.
+	More bool
+}
+
+// GetAttachment - returns the attachment of the block with the given ID.
+type GetAttachment struct {
+	ID SkipBlockID
 }
 
-// GetAllSkipchains - returns all known last blocks of skipchains.
+// GetAttachmentReply - returns the attachment of the block.
+type GetAttachmentReply struct {
+	Attachment []byte
+}
+
+// GetAllSkipchains - returns all known last blocks of skipchains. The
+// skipchains are sorted by their ID. If Max is > 0, at most Max skipchains
+// are returned, starting with the skipchain-ID Start.
 type GetAllSkipchains struct {
+	Start SkipBlockID
+	Max   int
 }
 
-// GetAllSkipchainsReply - returns all known last blocks of skipchains.
+// GetAllSkipchainsReply - returns all known last blocks of skipchains. If
+// more skipchains are available, Next is the Start of the next page.
 type GetAllSkipchainsReply struct {
 	SkipChains []*SkipBlock
+	Next       SkipBlockID
+}
+
+// GetKnownConodes - returns all conodes present in the rosters of the
+// known skipblocks.
+type GetKnownConodes struct {
+}
+
+// GetKnownConodesReply - returns the deduplicated list of conodes.
+type GetKnownConodesReply struct {
+	ServerIdentities []*network.ServerIdentity
+}
+
+// PingRoster - checks which members of the roster of the latest block of
+// the skipchain containing the block with ID are reachable.
+type PingRoster struct {
+	ID SkipBlockID
+}
+
+// PingRosterReply - returns the reachable and unreachable members of the
+// roster.
+type PingRosterReply struct {
+	Reachable   []*network.ServerIdentity
+	Unreachable []*network.ServerIdentity
+}
+
+// RepairForwardLinks - fetches missing forward-links of all blocks of the
+// skipchain from the other conodes.
+type RepairForwardLinks struct {
+	Genesis SkipBlockID
+}
+
+// RepairForwardLinksReply - returns how many blocks got new forward-links.
+type RepairForwardLinksReply struct {
+	Repaired int
+}
+
+// GetMetrics - requests the metrics of the service.
+type GetMetrics struct {
+}
+
+// GetMetricsReply - returns the metrics in the Prometheus text-format.
+type GetMetricsReply struct {
+	Metrics string
+}
+
+// GetStatus - requests the number of skipchains and skipblocks stored by the
+// conode.
+type GetStatus struct {
+}
+
+// GetStatusReply - returns the status of the conode.
+type GetStatusReply struct {
+	// Chains is the number of distinct skipchains.
+	Chains int
+	// Blocks is the total number of skipblocks.
+	Blocks int
+	// Propagating is true if a propagation is running.
+	Propagating bool
+}
+
+// ProposeLeader - asks the conode to become the leader of the skipchain
+// with the latest block Latest, because the actual leader is unreachable.
+type ProposeLeader struct {
+	Latest SkipBlockID
+}
+
+// ProposeLeaderReply - returns the previous latest block with the new
+// forward-link and the new latest block with the conode as leader.
+type ProposeLeaderReply struct {
+	Previous *SkipBlock
+	Latest   *SkipBlock
+}
+
+// RemoveChain - requests the conode to delete all blocks of the skipchain
+// Genesis. Time is the unix-time of the request and Signature a
+// Schnorr-signature on hash() by the private key belonging to the AdminKey
+// of the conode.
+type RemoveChain struct {
+	Genesis   SkipBlockID
+	Time      int64
+	Signature crypto.SchnorrSig
+}
+
+// hash returns the message signed in a RemoveChain request.
+func (rc *RemoveChain) hash() []byte {
+	h := sha256.New()
+	h.Write([]byte("skipchain-remove-chain"))
+	h.Write(rc.Genesis)
+	binary.Write(h, binary.LittleEndian, rc.Time)
+	return h.Sum(nil)
+}
+
+// RemoveChainReply - returns the number of removed blocks.
+type RemoveChainReply struct {
+	Removed int
+}
+
+// HealthCheck - checks that the service is responsive. It doesn't access the
+// storage and needs no authentication.
+type HealthCheck struct {
+}
+
+// HealthCheckReply - returns the identity and the software version of the
+// conode, together with its current time in nanoseconds since the epoch.
+type HealthCheckReply struct {
+	ServerIdentity *network.ServerIdentity
+	Version        string
+	Time           int64
+}
+
+// GetAcks - requests the signed acknowledgements of the block with the
+// given ID.
+type GetAcks struct {
+	ID SkipBlockID
+}
+
+// GetAcksReply - returns the acknowledgements of all nodes that stored the
+// block.
+type GetAcksReply struct {
+	Acks []*BlockAck
+}
+
+// Snapshot - requests a copy of all skipblocks stored in the service.
+// Signature is a Schnorr-signature on Time, the unix-time of the request, by
+// the private key of the conode.
+type Snapshot struct {
+	Time      int64
+	Signature crypto.SchnorrSig
+}
+
+// hash returns the message signed in a Snapshot request.
+func (s *Snapshot) hash() []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(s.Time))
+	return b
+}
+
+// SnapshotReply - returns all skipblocks of the service as a marshalled
+// SkipBlockMap.
+type SnapshotReply struct {
+	Data []byte
+}
+
+// Restore - loads a snapshot into a service that doesn't hold any skipblocks
+// yet. Signature is a Schnorr-signature on the sha256-hash of Data by the
+// private key of the conode.
+type Restore struct {
+	Data      []byte
+	Signature crypto.SchnorrSig
+}
+
+// hash returns the message signed in a Restore request.
+func (r *Restore) hash() []byte {
+	h := sha256.Sum256(r.Data)
+	return h[:]
+}
+
+// RestoreReply - returns the number of restored skipblocks.
+type RestoreReply struct {
+	Blocks int
+}
+
+// GetProof - requests the blocks linking the genesis-block to the target.
+type GetProof struct {
+	Genesis SkipBlockID
+	Target  SkipBlockID
+}
+
+// GetProofReply - returns the blocks from the genesis-block to the target,
+// following the highest possible forward-link at each block.
+type GetProofReply struct {
+	Proof []*SkipBlock
+}
+
+// FollowUpdate - requests the blocks following Latest in the skipchain
+// Genesis. If there are none yet, the conode waits for a new block before
+// replying.
+type FollowUpdate struct {
+	Genesis SkipBlockID
+	Latest  SkipBlockID
+}
+
+// FollowUpdateReply - returns the new blocks in order. It is empty if no new
+// block has been added before the timeout.
+type FollowUpdateReply struct {
+	Blocks []*SkipBlock
 }
 
 // Internal calls
@@ -105,6 +438,85 @@ type GetSingleBlock struct {
 	ID SkipBlockID
 }
 
+// GetForwardLink asks for the forward-link at the given height of a block.
+// Height is the index in the ForwardLink-slice of the block.
+type GetForwardLink struct {
+	ID     SkipBlockID
+	Height int
+}
+
+// GetForwardLinkReply returns the requested forward-link.
+type GetForwardLinkReply struct {
+	Link *BlockLink
+}
+
+// GetTipProof asks for the headers of the blocks linking LastKnown to the
+// latest block of the skipchain Genesis.
+type GetTipProof struct {
+	Genesis   SkipBlockID
+	LastKnown SkipBlockID
+}
+
+// GetTipProofReply returns the headers from LastKnown to the latest block,
+// following the highest forward-link at each block. The headers don't hold
+// the Data of the blocks.
+type GetTipProofReply struct {
+	Headers []*SkipBlock
+}
+
+// StoreDataChunk stores the chunk at Index of the Data with the hash
+// DataHash, which is split in Count chunks. It has to be sent to all conodes
+// of the roster before the block is stored.
+type StoreDataChunk struct {
+	DataHash []byte
+	Index    int
+	Count    int
+	Chunk    []byte
+}
+
+// StoreDataChunkReply is returned when the chunk is stored. Complete is true
+// if the conode has all chunks of the Data.
+type StoreDataChunkReply struct {
+	Complete bool
+}
+
+// GetBlockData asks for the chunk at Index of the Data of the block with
+// the given ID. For a block without chunks, Index 0 returns its Data.
+type GetBlockData struct {
+	ID    SkipBlockID
+	Index int
+}
+
+// GetBlockDataReply returns the requested chunk.
+type GetBlockDataReply struct {
+	Chunk []byte
+}
+
+// GetBlocks asks for all blocks with the given IDs.
+type GetBlocks struct {
+	IDs []SkipBlockID
+}
+
+// GetBlocksReply returns the found blocks in the order of the request, and
+// the IDs of the blocks that were not found.
+type GetBlocksReply struct {
+	Blocks  []*SkipBlock
+	Missing []SkipBlockID
+}
+
+// GetBlockRange asks for all blocks of the skipchain with indices in
+// [From, To].
+type GetBlockRange struct {
+	Genesis SkipBlockID
+	From    int
+	To      int
+}
+
+// GetBlockRangeReply returns the blocks, ordered by their index.
+type GetBlockRangeReply struct {
+	Blocks []*SkipBlock
+}
+
 // GetSingleBlockByIndex asks for a single block.
 type GetSingleBlockByIndex struct {
 	Genesis SkipBlockID
@@ -129,3 +541,30 @@ type PropagateSkipBlock struct {
 type GetBlockReply struct {
 	SkipBlock *SkipBlock
 }
+
+// Ping is sent to another conode to check whether it is reachable.
+type Ping struct {
+	Nonce []byte
+}
+
+// PingReply is returned with the nonce of the Ping.
+type PingReply struct {
+	Nonce []byte
+}
+
+// ForwardStore is sent by a conode that is not the leader of a new block to
+// the leader, asking it to store the block. A ForwardStore is never
+// forwarded again, so requests can't loop between conodes.
+type ForwardStore struct {
+	Nonce   []byte
+	Request *StoreSkipBlock
+}
+
+// ForwardStoreReply returns the reply of the leader. If the leader returned
+// an error, ErrorCode is non-zero.
+type ForwardStoreReply struct {
+	Nonce     []byte
+	Reply     *StoreSkipBlockReply
+	ErrorCode int
+	ErrorMsg  string
+}
@@ -0,0 +1,81 @@
+package skipchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+)
+
+/*
+This file holds the structured logging of block-operations. It is independent
+of the onet-logging and only active if an OpLogger is set in the service.
+*/
+
+// Names of the operations in an OpRecord.
+const (
+	OpStore     = "store"
+	OpPropagate = "propagate"
+	OpVerify    = "verify"
+	OpBFT       = "bft"
+)
+
+// OpRecord describes one operation of the service on a block.
+type OpRecord struct {
+	Time      time.Time `json:"time"`
+	Server    string    `json:"server"`
+	Operation string    `json:"op"`
+	Chain     string    `json:"chain"`
+	Block     string    `json:"block"`
+	Index     int       `json:"index"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// OpLogger is called by the service with a record for every operation on a
+// block.
+type OpLogger func(rec *OpRecord)
+
+// JSONOpLogger returns an OpLogger that writes every record as one line of
+// JSON to w.
+func JSONOpLogger(w io.Writer) OpLogger {
+	var mutex sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(rec *OpRecord) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := enc.Encode(rec); err != nil {
+			log.Error("Couldn't write record:", err)
+		}
+	}
+}
+
+// logOp sends a record of the operation on sb to the OpLogger, if one is
+// set.
+func (s *Service) logOp(op string, sb *SkipBlock, start time.Time, err error) {
+	if s.OpLogger == nil {
+		return
+	}
+	rec := &OpRecord{
+		Time:      start,
+		Server:    s.ServerIdentity().Address.String(),
+		Operation: op,
+		LatencyMs: float64(time.Now().Sub(start)) / float64(time.Millisecond),
+	}
+	if sb != nil && sb.SkipBlockFix != nil {
+		rec.Chain = hex.EncodeToString(sb.SkipChainID())
+		rec.Block = hex.EncodeToString(sb.Hash)
+		rec.Index = sb.Index
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	s.OpLogger(rec)
+}
+
+// errRefused is logged if a verifier refuses a new block.
+var errRefused = errors.New("refused by verifier")
@@ -0,0 +1,207 @@
+package skipchain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the protocol used to collect Schnorr-signatures of a quorum
+of a roster, for rosters with unreachable members where a BFT-round would
+not finish.
+*/
+
+const quorumProtocolName = "SkipchainQuorum"
+
+// bftHandover is used as the type of a QuorumRequest for a new block that
+// hands the leadership over to another member of the roster.
+const bftHandover = "SkipchainHandover"
+
+func init() {
+	network.RegisterMessage(&QuorumRequest{})
+	network.RegisterMessage(&QuorumSignature{})
+}
+
+// QuorumRequest is sent by the root to all children, asking them to verify
+// the data and to sign msg. Type is the name of the BFT-protocol whose
+// verification is used.
+type QuorumRequest struct {
+	Type string
+	Msg  []byte
+	Data []byte
+}
+
+// QuorumSignature holds the Schnorr-signatures of the members of a roster on
+// a message. Marshalled, it is accepted by BlockLink.VerifySignature if a
+// quorum of the roster signed.
+type QuorumSignature struct {
+	Acks []*BlockAck
+}
+
+// quorum returns how many of n members of a roster need to sign. It is the
+// same threshold as the one used by bftcosi.
+func quorum(n int) int {
+	return (n + 1) * 2 / 3
+}
+
+// verifyQuorum returns nil if buf is a marshalled QuorumSignature with valid
+// signatures on msg by a quorum of publics.
+func verifyQuorum(publics []abstract.Point, msg, buf []byte) error {
+	_, m, err := network.Unmarshal(buf)
+	if err != nil {
+		return err
+	}
+	qs, ok := m.(*QuorumSignature)
+	if !ok {
+		return errors.New("not a quorum-signature")
+	}
+	signed := map[int]bool{}
+	for _, ack := range qs.Acks {
+		if ack.ServerIdentity == nil || ack.Verify(msg) != nil {
+			continue
+		}
+		for i, p := range publics {
+			if p.Equal(ack.ServerIdentity.Public) {
+				signed[i] = true
+			}
+		}
+	}
+	if len(signed) < quorum(len(publics)) {
+		return fmt.Errorf("only %d out of %d signed", len(signed), len(publics))
+	}
+	return nil
+}
+
+// quorumProtocol sends a QuorumRequest to all children and returns the valid
+// signatures to the root. Unreachable children are ignored.
+type quorumProtocol struct {
+	*onet.TreeNodeInstance
+	service *Service
+	// Request to be signed, only used by the root.
+	Request *QuorumRequest
+	// Acks is filled by the root with all valid signatures.
+	Acks chan []*BlockAck
+	// sent is the number of children that got the request.
+	sent           chan int
+	ChannelRequest chan struct {
+		*onet.TreeNode
+		QuorumRequest
+	}
+	ChannelAck chan struct {
+		*onet.TreeNode
+		BlockAck
+	}
+}
+
+func (s *Service) newQuorumProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	p := &quorumProtocol{
+		TreeNodeInstance: n,
+		service:          s,
+		Acks:             make(chan []*BlockAck, 1),
+		sent:             make(chan int, 1),
+	}
+	for _, h := range []interface{}{&p.ChannelRequest, &p.ChannelAck} {
+		if err := p.RegisterChannel(h); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Start sends the request to all reachable children.
+func (p *quorumProtocol) Start() error {
+	sent := 0
+	for _, c := range p.Children() {
+		if err := p.SendTo(c, p.Request); err != nil {
+			log.Lvl2("Couldn't send request to", c.ServerIdentity, err)
+			continue
+		}
+		sent++
+	}
+	p.sent <- sent
+	return nil
+}
+
+// Dispatch signs the request if it verifies. The root waits for the
+// signatures of the children until a timeout, the other nodes send their
+// signature to the root.
+func (p *quorumProtocol) Dispatch() error {
+	defer p.Done()
+	if !p.IsRoot() {
+		req := <-p.ChannelRequest
+		return p.SendToParent(p.sign(&req.QuorumRequest))
+	}
+	sent := <-p.sent
+	var acks []*BlockAck
+	if ack := p.sign(p.Request); len(ack.Signature) > 0 {
+		acks = append(acks, ack)
+	}
+	timeout := time.After(p.service.getPropagateTimeout())
+	for i := 0; i < sent; i++ {
+		select {
+		case reply := <-p.ChannelAck:
+			ack := &reply.BlockAck
+			if err := ack.Verify(p.Request.Msg); err != nil {
+				log.Lvl2("No valid signature from", ack.ServerIdentity, err)
+				continue
+			}
+			acks = append(acks, ack)
+		case <-timeout:
+			log.Lvl2("Timeout while waiting for signatures")
+			p.Acks <- acks
+			return nil
+		}
+	}
+	p.Acks <- acks
+	return nil
+}
+
+// sign returns a signature on the message of the request if it verifies, or
+// an acknowledgement with an empty signature otherwise.
+func (p *quorumProtocol) sign(req *QuorumRequest) *BlockAck {
+	ack := &BlockAck{ServerIdentity: p.ServerIdentity()}
+	verify := p.service.verifyFunction(req.Type)
+	if verify == nil || !verify(req.Msg, req.Data) {
+		log.Lvl2("Refusing to sign", req.Type)
+		return ack
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, p.Private(), req.Msg)
+	if err != nil {
+		log.Error(err)
+		return ack
+	}
+	ack.Signature = sig
+	return ack
+}
+
+// startQuorum asks all members of the roster to verify the data using the
+// verification of proto and to sign msg. It returns the marshalled
+// QuorumSignature if a quorum of the roster signed.
+func (s *Service) startQuorum(proto string, roster *onet.Roster, msg, data []byte) ([]byte, error) {
+	tree := roster.GenerateNaryTreeWithRoot(len(roster.List), s.ServerIdentity())
+	if tree == nil {
+		return nil, errors.New("Didn't find ourselves in roster")
+	}
+	pi, err := s.CreateProtocol(quorumProtocolName, tree)
+	if err != nil {
+		return nil, err
+	}
+	p := pi.(*quorumProtocol)
+	p.Request = &QuorumRequest{proto, msg, data}
+	if err := p.Start(); err != nil {
+		return nil, err
+	}
+	acks := <-p.Acks
+	if len(acks) < quorum(len(roster.List)) {
+		return nil, fmt.Errorf("only %d out of %d signed", len(acks),
+			len(roster.List))
+	}
+	return network.Marshal(&QuorumSignature{acks})
+}
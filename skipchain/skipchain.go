@@ -1,7 +1,11 @@
 package skipchain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"os"
+	"sort"
 
 	"strconv"
 
@@ -14,8 +18,10 @@ import (
 	"github.com/dedis/cothority/bftcosi"
 	"github.com/dedis/cothority/messaging"
 	"github.com/satori/go.uuid"
+	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -24,6 +30,11 @@ import (
 const ServiceName = "Skipchain"
 const bftNewBlock = "SkipchainBFTNew"
 const bftFollowBlock = "SkipchainBFTFollow"
+const bftAddChild = "SkipchainBFTChild"
+
+// Version is returned by HealthCheck. The conode sets it to the version of
+// its binary.
+var Version = "unknown"
 
 func init() {
 	skipchainSID, _ = onet.RegisterNewService(ServiceName, newSkipchainService)
@@ -36,18 +47,94 @@ var skipchainSID onet.ServiceID
 // Name used to store skipblocks
 const skipblocksID = "skipblocks"
 
+// casRetryInterval is how long CompareAndAppend waits before trying again
+// if another block of the skipchain is being stored.
+const casRetryInterval = 50 * time.Millisecond
+
+// maxAcks is the number of blocks for which the acknowledgements are kept.
+const maxAcks = 1000
+
+// A Snapshot request is refused if its time differs more than
+// snapshotMaxSkew from the time of the conode.
+const snapshotMaxSkew = 5 * time.Minute
+
+// A RemoveChain request is refused if its time differs more than
+// removeChainMaxSkew from the time of the conode.
+const removeChainMaxSkew = 5 * time.Minute
+
+// AdminKeyEnv is the environment-variable holding the base64-encoded public
+// key that signs RemoveChain requests.
+const AdminKeyEnv = "SKIPCHAIN_ADMIN_KEY"
+
 // Service handles adding new SkipBlocks
 type Service struct {
 	*onet.ServiceProcessor
 	// Sbm is the skipblock-map that holds all known skipblocks to this service.
 	Sbm                *SkipBlockMap
 	propagate          messaging.PropagationFunc
-	verifiers          map[VerifierID]SkipBlockVerifier
+	verifiers          map[VerifierID]SkipBlockVerifierV2
 	blockRequestsMutex sync.Mutex
 	blockRequests      map[string]chan *SkipBlock
 	lastSave           time.Time
 	newBlocksMutex     sync.Mutex
 	newBlocks          map[string]bool
+	pingRequestsMutex  sync.Mutex
+	pingRequests       map[string]chan bool
+	metrics            metrics
+	acksMutex          sync.Mutex
+	acks               map[string][]*BlockAck
+	acksOrder          []string
+	updatesMutex       sync.Mutex
+	updates            map[string]chan bool
+	forwardsMutex      sync.Mutex
+	forwards           map[string]chan *ForwardStoreReply
+	// offline holds the conodes that were unreachable during a
+	// leader-handover.
+	offlineMutex sync.Mutex
+	offline      map[string]*network.ServerIdentity
+	// handovers holds, for every previous block, the hash of the
+	// handover-block this conode signed, so that it never signs two
+	// different blocks after a handover. The entry is removed once the
+	// previous block has a forward-link.
+	handoversMutex sync.Mutex
+	handovers      map[string]SkipBlockID
+	// propagateTimeout is how long to wait for the other nodes during
+	// propagation and while collecting acknowledgements.
+	timeoutMutex     sync.Mutex
+	propagateTimeout time.Duration
+	// pending holds the blocks of batches that are being verified.
+	pending *pendingBlocks
+	// verified holds the blocks whose signatures have already been
+	// verified.
+	verified *verifiedCache
+	// ExternalDataHashOnly disables fetching of the data in
+	// VerifyExternalData, e.g., for offline cosigners.
+	ExternalDataHashOnly bool
+	// AllowedVerifiers restricts the verifiers a new skipchain may use. If
+	// it is empty, all verifiers are allowed.
+	AllowedVerifiers map[VerifierID]bool
+	// StorageKey, if set, is used to encrypt the Data of all skipblocks
+	// stored on disk. It is initialised from StorageKeyEnv.
+	StorageKey []byte
+	// AdminKey is the public key that has to sign RemoveChain requests. If
+	// it is nil, no skipchain can be removed. It is initialised from
+	// AdminKeyEnv.
+	AdminKey abstract.Point
+	// OpLogger, if set, receives a structured record of every store,
+	// propagation, verification and BFT-round.
+	OpLogger OpLogger
+	// ForwardToLeader makes the service forward StoreSkipBlock-requests
+	// for which it is not the leader to the leader, instead of refusing
+	// them.
+	ForwardToLeader bool
+	// MaxRosterChange is the fraction of the previous roster that a new
+	// block may remove in a chain using VerifyRosterChange.
+	MaxRosterChange float64
+	// SyncSave makes StoreSkipBlock write all skipblocks to disk before
+	// it returns.
+	SyncSave bool
+	// chunks holds the Data of blocks that is stored in chunks.
+	chunks *chunkStore
 }
 
 // StoreSkipBlock stores a new skipblock in the system. This can be either a
@@ -68,13 +155,45 @@ type Service struct {
 // skipchain after verification that it fits and no other block already has been
 // added.
 func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, onet.ClientError) {
+	return s.storeAndLog(psbd, s.ForwardToLeader)
+}
+
+// storeAndLog calls storeSkipBlock and logs the operation.
+func (s *Service) storeAndLog(psbd *StoreSkipBlock, forward bool) (*StoreSkipBlockReply, onet.ClientError) {
+	start := time.Now()
+	reply, cerr := s.storeSkipBlock(psbd, forward)
+	if cerr != nil {
+		s.logOp(OpStore, psbd.NewBlock, start, cerr)
+		return nil, cerr
+	}
+	s.logOp(OpStore, reply.Latest, start, nil)
+	if s.SyncSave {
+		if err := s.Flush(); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorOnet,
+				"couldn't save skipblocks: "+err.Error())
+		}
+	}
+	return reply, nil
+}
+
+// storeSkipBlock does the actual work of StoreSkipBlock. If forward is true
+// and this conode is not the leader, the request is forwarded to the leader.
+func (s *Service) storeSkipBlock(psbd *StoreSkipBlock, forward bool) (*StoreSkipBlockReply, onet.ClientError) {
 	prop := psbd.NewBlock
+	if err := prop.VerifyAttachment(); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent, err.Error())
+	}
 	if !s.ServerIdentity().Equal(prop.Roster.Get(0)) {
+		if forward {
+			return s.forwardStore(prop.Roster.Get(0), psbd)
+		}
 		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
 			"only leader is allowed to add blocks")
 	}
 	var prev *SkipBlock
 	var changed []*SkipBlock
+	timestamp := prop.Timestamp
+	prop.Timestamp = time.Now().UnixNano()
 
 	if psbd.LatestID.IsNull() {
 		// A new chain is created
@@ -83,6 +202,14 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 		prop.ForwardLink = make([]*BlockLink, 0)
 		// genesis block has a random back-link:
 		bl := random.Bytes(32, random.Stream)
+		if psbd.GenesisBackLink != nil {
+			if len(psbd.GenesisBackLink) != 32 {
+				return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+					"genesis back-link must be 32 bytes")
+			}
+			bl = psbd.GenesisBackLink
+			prop.Timestamp = timestamp
+		}
 		prop.BackLinkIDs = []SkipBlockID{SkipBlockID(bl)}
 		prop.GenesisID = nil
 		prop.updateHash()
@@ -91,6 +218,28 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
 				err.Error())
 		}
+		for _, v := range prop.VerifierIDs {
+			if v.Equal(VerifySchema) {
+				if _, err := loadSchema(prop.Data); err != nil {
+					return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+						"invalid JSON schema in genesis-block: "+err.Error())
+				}
+			}
+			if v.Equal(VerifyChildBinding) {
+				if err := s.checkChildBinding(prop); err != nil {
+					return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+						"wrong parent-block: "+err.Error())
+				}
+			}
+		}
+		if len(s.AllowedVerifiers) > 0 {
+			for _, v := range prop.VerifierIDs {
+				if !s.AllowedVerifiers[v] {
+					return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+						"verifier "+v.String()+" is not allowed on this conode")
+				}
+			}
+		}
 		if !s.newBlockStart(prop) {
 			return nil, onet.NewClientErrorCode(ErrorBlockInProgress,
 				"this skipchain-id is currently processing a block")
@@ -103,9 +252,32 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 				return nil, onet.NewClientErrorCode(ErrorParameterWrong,
 					"Didn't find parent")
 			}
+			// The parent-block is changed, so no other block may be
+			// added to its skipchain in the meantime.
+			if !s.newBlockStart(parent) {
+				return nil, onet.NewClientErrorCode(ErrorBlockInProgress,
+					"the parent skipchain is currently processing a block")
+			}
+			defer s.newBlockEnd(parent)
+			if psbd.DryRun {
+				if i, _ := parent.Roster.Search(s.ServerIdentity().ID); i < 0 {
+					return nil, onet.NewClientErrorCode(ErrorVerification,
+						"leader of child is not in roster of parent")
+				}
+				return &StoreSkipBlockReply{Latest: prop}, nil
+			}
+			auth, err := s.authorizeChild(parent, prop)
+			if err != nil {
+				return nil, onet.NewClientErrorCode(ErrorVerification,
+					"parent didn't authorize child: "+err.Error())
+			}
 			parent.ChildSL = append(parent.ChildSL, prop.Hash)
+			parent.ChildAuth = append(parent.ChildAuth, auth)
 			changed = append(changed, parent)
 		}
+		if psbd.DryRun {
+			return &StoreSkipBlockReply{Latest: prop}, nil
+		}
 		changed = append(changed, prop)
 
 	} else {
@@ -119,71 +291,57 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 			return nil, onet.NewClientErrorCode(ErrorBlockContent,
 				"We're not responsible for latest block")
 		}
-		if len(prev.ForwardLink) > 0 {
-			return nil, onet.NewClientErrorCode(ErrorBlockContent,
-				"the latest block already has a follower")
-		}
+		// Check for a follower only once the skipchain is locked, so that
+		// no other block can be appended in the meantime.
 		if !s.newBlockStart(prev) {
 			return nil, onet.NewClientErrorCode(ErrorBlockInProgress,
 				"this skipchain-id is currently processing a block")
 		}
 		defer s.newBlockEnd(prev)
-		prop.MaximumHeight = prev.MaximumHeight
-		prop.BaseHeight = prev.BaseHeight
-		prop.ParentBlockID = nil
-		prop.VerifierIDs = prev.VerifierIDs
-		prop.Index = prev.Index + 1
-		prop.GenesisID = prev.SkipChainID()
-		index := prop.Index
-		for prop.Height = 1; index%prop.BaseHeight == 0; prop.Height++ {
-			index /= prop.BaseHeight
-			if prop.Height >= prop.MaximumHeight {
-				break
+		if len(prev.ForwardLink) > 0 {
+			if tip, err := s.Sbm.GetLatest(prev); err == nil && !tip.Equal(prev) {
+				return nil, onet.NewClientErrorCode(ErrorBlockNotLatest,
+					notLatestPrefix+hex.EncodeToString(tip.Hash))
 			}
+			return nil, onet.NewClientErrorCode(ErrorBlockContent,
+				"the latest block already has a follower")
 		}
-		log.Lvl4("Found height", prop.Height, "for index", prop.Index,
-			"and maxHeight", prop.MaximumHeight, "and base", prop.BaseHeight)
-		prop.BackLinkIDs = make([]SkipBlockID, prop.Height)
-		pointer := prev
-		for h := range prop.BackLinkIDs {
-			for pointer.Height < h+1 {
-				pointer = s.Sbm.GetByID(pointer.BackLinkIDs[0])
-				if pointer == nil {
-					return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
-						"Didn't find convenient SkipBlock for height "+
-							strconv.Itoa(h))
-				}
+		if cerr := s.chainBlock(prev, prop); cerr != nil {
+			return nil, cerr
+		}
+		if psbd.DryRun {
+			if err := s.verifyBlock(prop); err != nil {
+				return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+					err.Error())
 			}
-			prop.BackLinkIDs[h] = pointer.Hash
+			if !s.runVerifiers(prop.Hash, prev, prop) {
+				return nil, onet.NewClientErrorCode(ErrorVerification,
+					"block refused by verifiers")
+			}
+			return &StoreSkipBlockReply{Previous: prev, Latest: prop}, nil
 		}
-		prop.updateHash()
 		if err := s.addForwardLink(prev, prop); err != nil {
 			return nil, onet.NewClientErrorCode(ErrorBlockContent,
 				"Couldn't get forward signature on block: "+err.Error())
 		}
 		changed = append(changed, prev, prop)
-		for i, bl := range prop.BackLinkIDs[1:] {
-			back := s.Sbm.GetByID(bl)
-			if back == nil {
-				return nil, onet.NewClientErrorCode(ErrorBlockContent,
-					"Didn't get skipblock in back-link")
-			}
-			if err := s.forwardSignature(
-				&ForwardSignature{i + 1, prev.Hash, prop,
-					prev.GetForward(0)}); err != nil {
-				// This is not a critical failure - we have at least
-				// one forward-link
-				log.Error("Couldn't get old block to sign")
-			} else {
-				changed = append(changed, back)
-			}
+		backs, cerr := s.addHigherForwardLinks(prev, prop)
+		if cerr != nil {
+			return nil, cerr
 		}
+		changed = append(changed, backs...)
 	}
 	if err := s.startPropagation(changed); err != nil {
 		return nil, onet.NewClientErrorCode(ErrorVerification,
 			"Couldn't propagate new blocks: "+err.Error())
 	}
 	s.save()
+	s.metrics.add(&s.metrics.blocksStored)
+	go func() {
+		if err := s.collectAcks(prop); err != nil {
+			log.Error("Couldn't collect acknowledgements:", err)
+		}
+	}()
 	reply := &StoreSkipBlockReply{
 		Previous: prev,
 		Latest:   prop,
@@ -191,6 +349,287 @@ func (s *Service) StoreSkipBlock(psbd *StoreSkipBlock) (*StoreSkipBlockReply, on
 	return reply, nil
 }
 
+// addHigherForwardLinks asks the rosters of the blocks in the back-links of
+// prop above height 0 to sign a forward-link to prop. prev must already
+// have a forward-link to prop. It returns the blocks with a new forward-link.
+func (s *Service) addHigherForwardLinks(prev, prop *SkipBlock) ([]*SkipBlock, onet.ClientError) {
+	var changed []*SkipBlock
+	for i, bl := range prop.BackLinkIDs[1:] {
+		back := s.Sbm.GetByID(bl)
+		if back == nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockContent,
+				"Didn't get skipblock in back-link")
+		}
+		if err := s.forwardSignature(
+			&ForwardSignature{i + 1, prev.Hash, prop,
+				prev.GetForward(0)}); err != nil {
+			// This is not a critical failure - we have at least
+			// one forward-link
+			log.Error("Couldn't get old block to sign")
+		} else {
+			changed = append(changed, back)
+		}
+	}
+	return changed, nil
+}
+
+// chainBlock sets all fields of prop that depend on prev, so that prop can
+// follow prev in the skipchain: the parameters of the skipchain, the index,
+// the height and the back-links. Finally the hash of prop is updated.
+func (s *Service) chainBlock(prev, prop *SkipBlock) onet.ClientError {
+	prop.MaximumHeight = prev.MaximumHeight
+	prop.BaseHeight = prev.BaseHeight
+	prop.ParentBlockID = nil
+	prop.VerifierIDs = prev.VerifierIDs
+	prop.Index = prev.Index + 1
+	prop.GenesisID = prev.SkipChainID()
+	index := prop.Index
+	for prop.Height = 1; index%prop.BaseHeight == 0; prop.Height++ {
+		index /= prop.BaseHeight
+		if prop.Height >= prop.MaximumHeight {
+			break
+		}
+	}
+	log.Lvl4("Found height", prop.Height, "for index", prop.Index,
+		"and maxHeight", prop.MaximumHeight, "and base", prop.BaseHeight)
+	prop.BackLinkIDs = make([]SkipBlockID, prop.Height)
+	pointer := prev
+	for h := range prop.BackLinkIDs {
+		for pointer.Height < h+1 {
+			pointer = s.getBlockOrPending(pointer.BackLinkIDs[0])
+			if pointer == nil {
+				return onet.NewClientErrorCode(ErrorBlockNotFound,
+					"Didn't find convenient SkipBlock for height "+
+						strconv.Itoa(h))
+			}
+		}
+		prop.BackLinkIDs[h] = pointer.Hash
+	}
+	prop.updateHash()
+	return nil
+}
+
+// ProposeLeader makes this conode the leader of a skipchain whose leader is
+// unreachable. A new block with the same roster, but this conode at index
+// 0, is appended to the latest block. Instead of a BFT-round, which needs
+// all members, a quorum of the roster of the latest block signs the
+// forward-link. Only the forward-link at height 0 is created.
+func (s *Service) ProposeLeader(pl *ProposeLeader) (*ProposeLeaderReply, onet.ClientError) {
+	prev := s.Sbm.GetByID(pl.Latest)
+	if prev == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find latest block")
+	}
+	if len(prev.ForwardLink) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"the latest block already has a follower")
+	}
+	i, _ := prev.Roster.Search(s.ServerIdentity().ID)
+	if i < 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"We're not responsible for latest block")
+	}
+	if i == 0 {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"already leader of the skipchain")
+	}
+	leader := prev.Roster.Get(0)
+	if s.ping(leader) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"leader is still reachable")
+	}
+	if !s.newBlockStart(prev) {
+		return nil, onet.NewClientErrorCode(ErrorBlockInProgress,
+			"this skipchain-id is currently processing a block")
+	}
+	defer s.newBlockEnd(prev)
+
+	prop := prev.Copy()
+	prop.ForwardLink = []*BlockLink{}
+	prop.ChildSL = nil
+	prop.ChildAuth = nil
+	prop.Attachment = nil
+	list := []*network.ServerIdentity{s.ServerIdentity()}
+	for _, si := range prev.Roster.List {
+		if !si.Equal(s.ServerIdentity()) {
+			list = append(list, si)
+		}
+	}
+	prop.Roster = onet.NewRoster(list)
+	prop.Timestamp = time.Now().UnixNano()
+	if cerr := s.chainBlock(prev, prop); cerr != nil {
+		return nil, cerr
+	}
+	data, err := network.Marshal(prop)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorOnet, err.Error())
+	}
+	start := time.Now()
+	sig, err := s.startQuorum(bftHandover, prev.Roster, prop.Hash,
+		append(prev.Hash, data...))
+	s.logOp(OpBFT, prop, start, err)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"Couldn't get quorum for handover: "+err.Error())
+	}
+	prev.ForwardLink = []*BlockLink{{Hash: prop.Hash, Signature: sig,
+		SigScheme: SigSchemeQuorum}}
+	if err := prev.VerifyForwardSignatures(); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"Wrong quorum-signature: "+err.Error())
+	}
+	s.setOffline(leader)
+	if err := s.startPropagation([]*SkipBlock{prev, prop}); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"Couldn't propagate new blocks: "+err.Error())
+	}
+	s.save()
+	s.metrics.add(&s.metrics.blocksStored)
+	return &ProposeLeaderReply{Previous: prev, Latest: prop}, nil
+}
+
+// bftVerifyHandover verifies a new block created by ProposeLeader: it must
+// be a valid new block with the same members as the previous block, a new
+// leader, and the previous leader must be unreachable. Only one handover is
+// signed for every previous block, so that two members proposing at the
+// same time can't fork the skipchain.
+func (s *Service) bftVerifyHandover(msg []byte, data []byte) bool {
+	if !s.bftVerifyNewBlock(msg, data) {
+		return false
+	}
+	prev := s.Sbm.GetByID(data[0:32])
+	if prev == nil {
+		log.Lvl2("Don't have the previous block of the handover")
+		return false
+	}
+	_, newSBi, err := network.Unmarshal(data[32:])
+	if err != nil {
+		return false
+	}
+	newSB, ok := newSBi.(*SkipBlock)
+	if !ok {
+		log.Lvl2("Didn't receive a SkipBlock")
+		return false
+	}
+	if !newSB.Hash.Equal(newSB.CalculateHash()) || newSB.Index != prev.Index+1 {
+		log.Lvl2("Handover-block has wrong hash or index")
+		return false
+	}
+	if len(newSB.Roster.List) != len(prev.Roster.List) {
+		log.Lvl2("Handover changes the members of the roster")
+		return false
+	}
+	for _, si := range prev.Roster.List {
+		if i, _ := newSB.Roster.Search(si.ID); i < 0 {
+			log.Lvl2("Handover changes the members of the roster")
+			return false
+		}
+	}
+	leader := prev.Roster.Get(0)
+	if newSB.Roster.Get(0).Equal(leader) {
+		log.Lvl2("Handover doesn't change the leader")
+		return false
+	}
+	if s.ping(leader) {
+		log.Lvl2("Refusing handover - leader is still reachable")
+		return false
+	}
+	s.handoversMutex.Lock()
+	signed := s.handovers[string(prev.Hash)]
+	if signed != nil && !signed.Equal(newSB.Hash) {
+		s.handoversMutex.Unlock()
+		log.Lvl2("Already signed another handover for this block")
+		return false
+	}
+	s.handovers[string(prev.Hash)] = newSB.Hash
+	s.handoversMutex.Unlock()
+	s.setOffline(leader)
+	return true
+}
+
+// SetPropagateTimeout sets how long the service waits for the other nodes
+// when propagating blocks, fetching missing blocks and collecting
+// acknowledgements. Rosters with a high latency need a longer timeout.
+func (s *Service) SetPropagateTimeout(t time.Duration) {
+	s.timeoutMutex.Lock()
+	defer s.timeoutMutex.Unlock()
+	s.propagateTimeout = t
+}
+
+// getPropagateTimeout returns the timeout set with SetPropagateTimeout.
+func (s *Service) getPropagateTimeout() time.Duration {
+	s.timeoutMutex.Lock()
+	defer s.timeoutMutex.Unlock()
+	return s.propagateTimeout
+}
+
+// setOffline marks the conode as unreachable, so that BFT-rounds including
+// it are replaced by collecting a quorum.
+func (s *Service) setOffline(si *network.ServerIdentity) {
+	s.offlineMutex.Lock()
+	defer s.offlineMutex.Unlock()
+	s.offline[string(si.ID)] = si
+}
+
+// hasOffline returns true if a member of the roster is marked as offline and
+// still unreachable. Members that are reachable again are unmarked.
+func (s *Service) hasOffline(roster *onet.Roster) bool {
+	var offline []*network.ServerIdentity
+	s.offlineMutex.Lock()
+	for _, si := range roster.List {
+		if _, ok := s.offline[string(si.ID)]; ok {
+			offline = append(offline, si)
+		}
+	}
+	s.offlineMutex.Unlock()
+	for _, si := range offline {
+		if !s.ping(si) {
+			return true
+		}
+		s.offlineMutex.Lock()
+		delete(s.offline, string(si.ID))
+		s.offlineMutex.Unlock()
+	}
+	return false
+}
+
+// CompareAndAppend stores the new block only if the expected block is still
+// the latest block of its skipchain. The comparison is done while the
+// skipchain is locked for the new block, so that of two concurrent requests
+// with the same expected block, only one succeeds and the other one learns
+// about the new latest block. If another block of the skipchain is being
+// stored, CompareAndAppend waits for it up to the propagation timeout.
+//
+// If the expected block is not the latest block anymore, no error is returned,
+// but the reply holds the actual latest block in Tip.
+func (s *Service) CompareAndAppend(caa *CompareAndAppend) (*CompareAndAppendReply, onet.ClientError) {
+	expected := s.Sbm.GetByID(caa.ExpectedTip)
+	if expected == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find expected block")
+	}
+	deadline := time.Now().Add(s.getPropagateTimeout())
+	for {
+		reply, cerr := s.StoreSkipBlock(&StoreSkipBlock{LatestID: caa.ExpectedTip,
+			NewBlock: caa.NewBlock})
+		switch {
+		case cerr == nil:
+			return &CompareAndAppendReply{Stored: reply}, nil
+		case IsBlockInProgress(cerr) && time.Now().Before(deadline):
+			time.Sleep(casRetryInterval)
+		case IsBlockInProgress(cerr) || IsBlockNotLatest(cerr):
+			tip, err := s.Sbm.GetLatest(expected)
+			if err != nil {
+				return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+					"Couldn't find latest block: "+err.Error())
+			}
+			return &CompareAndAppendReply{Tip: tip}, nil
+		default:
+			return nil, cerr
+		}
+	}
+}
+
 // GetUpdateChain returns a slice of SkipBlocks which describe the part of the
 // skipchain from the latest block the caller knows of to the actual latest
 // SkipBlock.
@@ -200,10 +639,27 @@ func (s *Service) GetUpdateChain(latestKnown *GetUpdateChain) (network.Message,
 	if block == nil {
 		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, "Couldn't find latest skipblock")
 	}
+	if !latestKnown.IfChangedFrom.IsNull() {
+		if tip, err := s.Sbm.GetLatest(block); err == nil &&
+			tip.Hash.Equal(latestKnown.IfChangedFrom) {
+			log.Lvl3("Latest block didn't change")
+			return &GetUpdateChainReply{NotModified: true,
+				GenesisID: block.SkipChainID()}, nil
+		}
+	}
+	maxBlocks := latestKnown.MaxBlocks
+	if maxBlocks == 1 {
+		maxBlocks = 2
+	}
+	more := false
 	// at least the latest know and the next block:
 	blocks := []*SkipBlock{block}
 	log.Lvlf3("Starting to search chain at %x", s.Context.ServerIdentity().ID[0:8])
 	for block.GetForwardLen() > 0 {
+		if maxBlocks > 0 && len(blocks) >= maxBlocks {
+			more = true
+			break
+		}
 		link := block.ForwardLink[block.GetForwardLen()-1]
 		next := s.Sbm.GetByID(link.Hash)
 		if next == nil {
@@ -229,60 +685,545 @@ func (s *Service) GetUpdateChain(latestKnown *GetUpdateChain) (network.Message,
 		blocks = append(blocks, next)
 	}
 	log.Lvl3("Found", len(blocks), "blocks")
-	reply := &GetUpdateChainReply{blocks}
+	for _, b := range blocks {
+		b.Attachment = nil
+	}
+	reply := &GetUpdateChainReply{Update: blocks,
+		GenesisID: blocks[0].SkipChainID(), More: more}
 
 	return reply, nil
 }
 
-// GetSingleBlock searches for the given block and returns it. If no such block is
-// found, a nil is returned.
+// GetSingleBlock searches for the given block and returns it without its
+// attachment. If no such block is found, a nil is returned.
 func (s *Service) GetSingleBlock(id *GetSingleBlock) (*SkipBlock, onet.ClientError) {
 	sb := s.Sbm.GetByID(id.ID)
 	if sb == nil {
 		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 			"No such block")
 	}
+	sb.Attachment = nil
 	return sb, nil
 }
 
-// GetSingleBlockByIndex searches for the given block and returns it. If no such block is
-// found, a nil is returned.
+// GetForwardLink returns the forward-link at the requested height of a
+// block, if it is already signed.
+func (s *Service) GetForwardLink(gfl *GetForwardLink) (*GetForwardLinkReply, onet.ClientError) {
+	sb := s.Sbm.GetByID(gfl.ID)
+	if sb == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such block")
+	}
+	if gfl.Height < 0 || gfl.Height >= sb.Height {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"block has no forward-link at height "+strconv.Itoa(gfl.Height))
+	}
+	link := sb.GetForward(gfl.Height)
+	if link == nil {
+		return nil, onet.NewClientErrorCode(ErrorForwardLinkMissing,
+			"forward-link at height "+strconv.Itoa(gfl.Height)+
+				" is not signed yet")
+	}
+	return &GetForwardLinkReply{link}, nil
+}
+
+// GetTipProof returns the headers of the blocks linking the last known block
+// to the latest block of the skipchain.
+func (s *Service) GetTipProof(gtp *GetTipProof) (*GetTipProofReply, onet.ClientError) {
+	block := s.Sbm.GetByID(gtp.LastKnown)
+	if block == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such last known block")
+	}
+	if !block.SkipChainID().Equal(gtp.Genesis) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"Last known block is not part of this skipchain")
+	}
+	reply := &GetTipProofReply{Headers: []*SkipBlock{block.Header()}}
+	for block.GetForwardLen() > 0 {
+		id := block.GetForward(block.GetForwardLen() - 1).Hash
+		block = s.Sbm.GetByID(id)
+		if block == nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				"Didn't find block "+id.Short())
+		}
+		reply.Headers = append(reply.Headers, block.Header())
+	}
+	return reply, nil
+}
+
+// StoreDataChunk stores a chunk of Data that is too big to be sent in one
+// message. The chunks are written to disk once a block referencing them is
+// stored.
+func (s *Service) StoreDataChunk(sdc *StoreDataChunk) (*StoreDataChunkReply, onet.ClientError) {
+	if len(sdc.DataHash) != sha256.Size {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"wrong size of data-hash")
+	}
+	if sdc.Count <= 0 || sdc.Count > maxDataChunks ||
+		sdc.Index < 0 || sdc.Index >= sdc.Count {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"wrong index or count of chunks")
+	}
+	if len(sdc.Chunk) == 0 || len(sdc.Chunk) > DataChunkSize {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"wrong size of chunk")
+	}
+	complete, err := s.chunks.add(sdc.DataHash, sdc.Index, sdc.Count, sdc.Chunk)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent, err.Error())
+	}
+	return &StoreDataChunkReply{complete}, nil
+}
+
+// GetBlockData returns a chunk of the Data of a block. If the block doesn't
+// store its Data in chunks, the only chunk is its Data.
+func (s *Service) GetBlockData(gbd *GetBlockData) (*GetBlockDataReply, onet.ClientError) {
+	sb := s.Sbm.GetByID(gbd.ID)
+	if sb == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such block")
+	}
+	if sb.DataChunks == 0 {
+		if gbd.Index != 0 {
+			return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+				"block has no chunks")
+		}
+		return &GetBlockDataReply{sb.Data}, nil
+	}
+	if gbd.Index < 0 || gbd.Index >= sb.DataChunks {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"block has no chunk "+strconv.Itoa(gbd.Index))
+	}
+	chunks := s.chunks.get(sb.DataHash)
+	if len(chunks) != sb.DataChunks {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"missing chunks of block")
+	}
+	return &GetBlockDataReply{chunks[gbd.Index]}, nil
+}
+
+// GetBlocks returns all requested blocks that are stored by this service.
+func (s *Service) GetBlocks(gb *GetBlocks) (*GetBlocksReply, onet.ClientError) {
+	reply := &GetBlocksReply{}
+	for _, id := range gb.IDs {
+		if sb := s.Sbm.GetByID(id); sb != nil {
+			reply.Blocks = append(reply.Blocks, sb)
+		} else {
+			reply.Missing = append(reply.Missing, id)
+		}
+	}
+	return reply, nil
+}
+
+// GetAttachment returns the attachment of the given block.
+func (s *Service) GetAttachment(ga *GetAttachment) (*GetAttachmentReply, onet.ClientError) {
+	sb := s.Sbm.GetByID(ga.ID)
+	if sb == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such block")
+	}
+	return &GetAttachmentReply{sb.Attachment}, nil
+}
+
+// GetSingleBlockByIndex searches for the given block and returns it without
+// its attachment. If no such block is found, a nil is returned.
 func (s *Service) GetSingleBlockByIndex(id *GetSingleBlockByIndex) (*SkipBlock, onet.ClientError) {
 	sb := s.Sbm.GetByID(id.Genesis)
 	if sb == nil {
 		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
 			"No such genesis-block")
 	}
-	if sb.Index == id.Index {
-		return sb, nil
+	if found, _ := s.blockAtIndex(sb, id.Index); found != nil {
+		found.Attachment = nil
+		return found, nil
+	}
+	if latest, err := s.Sbm.GetLatest(sb); err == nil && id.Index > latest.Index {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			fmt.Sprintf("index %d beyond latest index %d", id.Index, latest.Index))
+	}
+	return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+		"No block with this index found")
+}
+
+// GetBlockRange returns all blocks with indices in [From, To]. It jumps to
+// From using the skip-links and then follows the height-1 forward-links.
+func (s *Service) GetBlockRange(gbr *GetBlockRange) (*GetBlockRangeReply, onet.ClientError) {
+	if gbr.From < 0 || gbr.From > gbr.To {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			fmt.Sprintf("invalid range [%d, %d]", gbr.From, gbr.To))
+	}
+	if gbr.To-gbr.From >= maxBlockRange {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			fmt.Sprintf("cannot return more than %d blocks", maxBlockRange))
+	}
+	genesis := s.Sbm.GetByID(gbr.Genesis)
+	if genesis == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such genesis-block")
+	}
+	sb, _ := s.blockAtIndex(genesis, gbr.From)
+	if sb == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			fmt.Sprintf("No block with index %d found", gbr.From))
+	}
+	reply := &GetBlockRangeReply{Blocks: []*SkipBlock{sb}}
+	for sb.Index < gbr.To {
+		if len(sb.ForwardLink) == 0 {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				fmt.Sprintf("index %d beyond latest index %d", gbr.To, sb.Index))
+		}
+		id := sb.ForwardLink[0].Hash
+		next := s.Sbm.GetByID(id)
+		if next == nil && sb.Roster != nil {
+			var err error
+			next, err = s.getUpdateBlock(sb, id)
+			if err != nil {
+				log.Lvl2("Couldn't fetch block:", err)
+			}
+		}
+		if next == nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				fmt.Sprintf("No block with index %d found", sb.Index+1))
+		}
+		sb = next
+		reply.Blocks = append(reply.Blocks, sb)
+	}
+	return reply, nil
+}
+
+// blockAtIndex searches the block with the given index, starting at sb. It
+// always follows the highest forward-link that doesn't overshoot the index,
+// so only O(log n) blocks are needed. Blocks that are not stored locally are
+// fetched from the roster. It returns nil if no block with this index is
+// found, together with the number of blocks looked up.
+func (s *Service) blockAtIndex(sb *SkipBlock, index int) (*SkipBlock, int) {
+	lookups := 0
+	for sb.Index < index {
+		var next *SkipBlock
+		for h := len(sb.ForwardLink) - 1; h >= 0; h-- {
+			// A block with height > h always has an index divisible by
+			// base^h, so the forward-link at height h jumps base^h blocks.
+			jump := 1
+			for i := 0; i < h; i++ {
+				jump *= sb.BaseHeight
+			}
+			if sb.Index+jump > index {
+				continue
+			}
+			id := sb.ForwardLink[h].Hash
+			lookups++
+			next = s.Sbm.GetByID(id)
+			if next == nil && sb.Roster != nil {
+				var err error
+				next, err = s.getUpdateBlock(sb, id)
+				if err != nil {
+					log.Lvl2("Couldn't fetch block:", err)
+				}
+			}
+			if next != nil && next.Index <= index {
+				break
+			}
+			next = nil
+		}
+		if next == nil {
+			return nil, lookups
+		}
+		sb = next
+	}
+	if sb.Index != index {
+		return nil, lookups
+	}
+	return sb, lookups
+}
+
+// GetProof returns the shortest list of blocks whose forward-links link the
+// genesis-block to the target-block.
+func (s *Service) GetProof(gp *GetProof) (*GetProofReply, onet.ClientError) {
+	genesis := s.Sbm.GetByID(gp.Genesis)
+	if genesis == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such genesis-block")
+	}
+	target := s.Sbm.GetByID(gp.Target)
+	if target == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No such target-block")
+	}
+	if !target.SkipChainID().Equal(genesis.Hash) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"Target-block is not part of this skipchain")
+	}
+	proof, err := s.Sbm.GetProof(genesis, target)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound, err.Error())
+	}
+	return &GetProofReply{proof}, nil
+}
+
+// FollowUpdate returns the blocks following the latest block given. If
+// there are no new blocks, it waits until a new block of the skipchain is
+// propagated, or returns an empty list after followUpdateTimeout.
+func (s *Service) FollowUpdate(fu *FollowUpdate) (*FollowUpdateReply, onet.ClientError) {
+	latest := s.Sbm.GetByID(fu.Latest)
+	if latest == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find latest block")
+	}
+	if !latest.SkipChainID().Equal(fu.Genesis) {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"Latest block is not part of this skipchain")
+	}
+	// Get the channel before looking again for new blocks, so that no
+	// propagation is missed. Only known skipchains get a channel.
+	update := s.updateChannel(fu.Genesis)
+	latest = s.Sbm.GetByID(fu.Latest)
+	if latest == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find latest block")
+	}
+	if latest.GetForwardLen() == 0 {
+		select {
+		case <-update:
+			latest = s.Sbm.GetByID(fu.Latest)
+		case <-time.After(time.Millisecond * followUpdateTimeout):
+			return &FollowUpdateReply{}, nil
+		}
+		if latest == nil {
+			return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+				"Didn't find latest block")
+		}
+	}
+	reply := &FollowUpdateReply{}
+	for latest.GetForwardLen() > 0 {
+		latest = s.Sbm.GetByID(latest.GetForward(0).Hash)
+		if latest == nil {
+			break
+		}
+		reply.Blocks = append(reply.Blocks, latest)
+	}
+	return reply, nil
+}
+
+// updateChannel returns a channel that is closed once a new block of the
+// skipchain is stored.
+func (s *Service) updateChannel(genesis SkipBlockID) chan bool {
+	s.updatesMutex.Lock()
+	defer s.updatesMutex.Unlock()
+	update, ok := s.updates[string(genesis)]
+	if !ok {
+		update = make(chan bool)
+		s.updates[string(genesis)] = update
+	}
+	return update
+}
+
+// notifyUpdate wakes up all FollowUpdate-requests waiting for the skipchain.
+// It never blocks, so slow subscribers don't slow down the propagation.
+func (s *Service) notifyUpdate(genesis SkipBlockID) {
+	s.updatesMutex.Lock()
+	defer s.updatesMutex.Unlock()
+	if update, ok := s.updates[string(genesis)]; ok {
+		close(update)
+		delete(s.updates, string(genesis))
+	}
+}
+
+// GetAllSkipchains returns a list of all known skipchains
+func (s *Service) GetAllSkipchains(gas *GetAllSkipchains) (*GetAllSkipchainsReply, onet.ClientError) {
+	chains := s.Sbm.chains()
+	ids := make([]string, 0, len(chains))
+	for _, id := range chains {
+		if string(id) >= string(gas.Start) {
+			ids = append(ids, string(id))
+		}
+	}
+	sort.Strings(ids)
+
+	reply := &GetAllSkipchainsReply{
+		SkipChains: make([]*SkipBlock, 0, len(ids)),
+	}
+	for i, id := range ids {
+		if gas.Max > 0 && i == gas.Max {
+			reply.Next = SkipBlockID(id)
+			break
+		}
+		// Return the genesis-block, or any block of the skipchain if
+		// the genesis-block is not stored.
+		sb := s.Sbm.GetByID(SkipBlockID(id))
+		if sb == nil {
+			blocks := s.Sbm.chainBlocks(SkipBlockID(id))
+			if len(blocks) == 0 {
+				continue
+			}
+			sb = blocks[0]
+		}
+		reply.SkipChains = append(reply.SkipChains, sb)
+	}
+	return reply, nil
+}
+
+// GetKnownConodes returns the union of the rosters of all known skipblocks,
+// with every conode present only once.
+func (s *Service) GetKnownConodes(gkc *GetKnownConodes) (*GetKnownConodesReply, onet.ClientError) {
+	sis := map[string]*network.ServerIdentity{}
+	for _, sb := range s.Sbm.all() {
+		if sb.Roster == nil {
+			continue
+		}
+		for _, si := range sb.Roster.List {
+			sis[uuid.UUID(si.ID).String()] = si
+		}
+	}
+
+	reply := &GetKnownConodesReply{
+		ServerIdentities: make([]*network.ServerIdentity, 0, len(sis)),
+	}
+	for _, si := range sis {
+		reply.ServerIdentities = append(reply.ServerIdentities, si)
+	}
+	return reply, nil
+}
+
+// PingRoster sends a ping to all members of the roster of the latest block
+// of the skipchain and returns which of them answered in time.
+func (s *Service) PingRoster(pr *PingRoster) (*PingRosterReply, onet.ClientError) {
+	sb := s.Sbm.GetByID(pr.ID)
+	if sb == nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find block")
+	}
+	latest, err := s.Sbm.GetLatest(sb)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			err.Error())
+	}
+	list := latest.Roster.List
+	reachable := make([]bool, len(list))
+	var wg sync.WaitGroup
+	for i, si := range list {
+		wg.Add(1)
+		go func(i int, si *network.ServerIdentity) {
+			defer wg.Done()
+			reachable[i] = s.ping(si)
+		}(i, si)
+	}
+	wg.Wait()
+
+	reply := &PingRosterReply{}
+	for i, si := range list {
+		if reachable[i] {
+			reply.Reachable = append(reply.Reachable, si)
+		} else {
+			reply.Unreachable = append(reply.Unreachable, si)
+		}
+	}
+	return reply, nil
+}
+
+// RepairForwardLinks asks the other members of the rosters for all blocks of
+// the given skipchain that are missing forward-links. Blocks returned with
+// more valid forward-links than the local copy are stored.
+func (s *Service) RepairForwardLinks(rfl *RepairForwardLinks) (*RepairForwardLinksReply, onet.ClientError) {
+	var blocks []*SkipBlock
+	for _, sb := range s.Sbm.chainBlocks(rfl.Genesis) {
+		blocks = append(blocks, sb.Copy())
+	}
+	if len(blocks) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"Didn't find skipchain")
+	}
+
+	reply := &RepairForwardLinksReply{}
+	for _, sb := range blocks {
+		if len(sb.ForwardLink) >= sb.Height {
+			continue
+		}
+		for _, si := range sb.Roster.List {
+			if s.ServerIdentity().Equal(si) {
+				continue
+			}
+			if _, err := s.getBlockFrom(si, sb.Hash); err != nil {
+				log.Lvl2("Couldn't get block from", si, err)
+			}
+		}
+		if repaired := s.Sbm.GetByID(sb.Hash); len(repaired.ForwardLink) > len(sb.ForwardLink) {
+			log.Lvl2("Repaired forward-links of block", sb.Index)
+			reply.Repaired++
+		}
+	}
+	return reply, nil
+}
+
+// GetMetrics returns the metrics of this service in the Prometheus
+// text-format.
+func (s *Service) GetMetrics(gm *GetMetrics) (*GetMetricsReply, onet.ClientError) {
+	return &GetMetricsReply{s.metrics.prometheus(len(s.Sbm.chains()))}, nil
+}
+
+// GetStatus returns the number of skipchains and skipblocks stored by this
+// service, and whether a propagation is running.
+func (s *Service) GetStatus(gs *GetStatus) (*GetStatusReply, onet.ClientError) {
+	return &GetStatusReply{
+		Chains:      len(s.Sbm.chains()),
+		Blocks:      s.Sbm.Length(),
+		Propagating: s.IsPropagating(),
+	}, nil
+}
+
+// RemoveChain deletes all blocks of a skipchain. The request must be signed
+// by the private key belonging to AdminKey. The acknowledgements and
+// handovers of the removed blocks are dropped, and the clients following the
+// skipchain are woken up.
+func (s *Service) RemoveChain(rc *RemoveChain) (*RemoveChainReply, onet.ClientError) {
+	if s.AdminKey == nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"no admin key set - see "+AdminKeyEnv)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.AdminKey,
+		rc.hash(), rc.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"wrong signature: "+err.Error())
 	}
-	for len(sb.ForwardLink) > 0 {
-		sb = s.Sbm.GetByID(sb.ForwardLink[0].Hash)
-		if sb.Index == id.Index {
-			return sb, nil
-		}
+	skew := time.Since(time.Unix(rc.Time, 0))
+	if skew > removeChainMaxSkew || skew < -removeChainMaxSkew {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"request is too old or in the future")
 	}
-	return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
-		"No block with this index found")
-}
-
-// GetAllSkipchains returns a list of all known skipchains
-func (s *Service) GetAllSkipchains(id *GetAllSkipchains) (*GetAllSkipchainsReply, onet.ClientError) {
-	// Write all known skipblocks to a map, thus removing double blocks.
-	chains := map[string]*SkipBlock{}
+	blocks := s.Sbm.chainBlocks(rc.Genesis)
+	removed := s.Sbm.RemoveChain(rc.Genesis)
+	if removed == 0 {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"unknown skipchain")
+	}
+	log.Lvlf2("%s: removed %d blocks of skipchain %x", s.ServerIdentity(),
+		removed, rc.Genesis)
+	s.acksMutex.Lock()
+	s.handoversMutex.Lock()
+	for _, sb := range blocks {
+		delete(s.acks, string(sb.Hash))
+		delete(s.handovers, string(sb.Hash))
+	}
+	s.handoversMutex.Unlock()
+	s.acksMutex.Unlock()
+	s.notifyUpdate(rc.Genesis)
 	s.Sbm.Lock()
-	for _, sb := range s.Sbm.SkipBlocks {
-		chains[string(sb.SkipChainID())] = sb
+	defer s.Sbm.Unlock()
+	s.lastSave = time.Now()
+	if err := s.saveStorage(); err != nil {
+		log.Error("Couldn't save file:", err)
 	}
-	s.Sbm.Unlock()
+	return &RemoveChainReply{removed}, nil
+}
 
-	reply := &GetAllSkipchainsReply{
-		SkipChains: make([]*SkipBlock, 0, len(chains)),
-	}
-	for _, sb := range chains {
-		reply.SkipChains = append(reply.SkipChains, sb)
-	}
-	return reply, nil
+// HealthCheck returns the identity of the conode, the version of the
+// service and the current time.
+func (s *Service) HealthCheck(hc *HealthCheck) (*HealthCheckReply, onet.ClientError) {
+	return &HealthCheckReply{
+		ServerIdentity: s.ServerIdentity(),
+		Version:        Version,
+		Time:           time.Now().UnixNano(),
+	}, nil
 }
 
 // IsPropagating returns true if there is at least one propagation running.
@@ -293,8 +1234,14 @@ func (s *Service) IsPropagating() bool {
 }
 
 func (s *Service) getUpdateBlock(known *SkipBlock, unknown SkipBlockID) (*SkipBlock, error) {
+	return s.getBlockFrom(known.Roster.RandomServerIdentity(), unknown)
+}
+
+// getBlockFrom asks the given conode for the block with the given id. The
+// returned block is stored, adding any new, valid forward-links.
+func (s *Service) getBlockFrom(node *network.ServerIdentity, unknown SkipBlockID) (*SkipBlock, error) {
 	s.blockRequestsMutex.Lock()
-	request := make(chan *SkipBlock)
+	request := make(chan *SkipBlock, 1)
 	s.blockRequests[string(unknown)] = request
 	s.blockRequestsMutex.Unlock()
 	defer func() {
@@ -302,21 +1249,78 @@ func (s *Service) getUpdateBlock(known *SkipBlock, unknown SkipBlockID) (*SkipBl
 		delete(s.blockRequests, string(unknown))
 		s.blockRequestsMutex.Unlock()
 	}()
-	node := known.Roster.RandomServerIdentity()
 	if err := s.SendRaw(node,
 		&GetBlock{unknown}); err != nil {
-		return nil, errors.New("Couldn't get updated block: " + known.Short())
+		return nil, errors.New("Couldn't get updated block: " + unknown.Short())
 	}
 	var block *SkipBlock
 	select {
 	case block = <-request:
 		log.Lvl3("Got block", block)
-	case <-time.After(time.Millisecond * time.Duration(propagateTimeout)):
+	case <-time.After(s.getPropagateTimeout()):
 		return nil, errors.New("Couldn't get updated block in time: " + unknown.Short())
 	}
 	return block, nil
 }
 
+// ping returns true if the given conode answers a ping in time.
+func (s *Service) ping(si *network.ServerIdentity) bool {
+	if s.ServerIdentity().Equal(si) {
+		return true
+	}
+	nonce := random.Bytes(16, random.Stream)
+	s.pingRequestsMutex.Lock()
+	request := make(chan bool, 1)
+	s.pingRequests[string(nonce)] = request
+	s.pingRequestsMutex.Unlock()
+	defer func() {
+		s.pingRequestsMutex.Lock()
+		delete(s.pingRequests, string(nonce))
+		s.pingRequestsMutex.Unlock()
+	}()
+	if err := s.SendRaw(si, &Ping{nonce}); err != nil {
+		log.Lvl2("Couldn't ping", si, err)
+		return false
+	}
+	select {
+	case <-request:
+		return true
+	case <-time.After(time.Millisecond * time.Duration(pingTimeout)):
+		log.Lvl2("Ping timed out for", si)
+		return false
+	}
+}
+
+// forwardStore sends the request to the leader and returns its reply.
+func (s *Service) forwardStore(leader *network.ServerIdentity, psbd *StoreSkipBlock) (*StoreSkipBlockReply, onet.ClientError) {
+	log.Lvl2("Forwarding request to leader", leader)
+	nonce := random.Bytes(16, random.Stream)
+	s.forwardsMutex.Lock()
+	request := make(chan *ForwardStoreReply, 1)
+	s.forwards[string(nonce)] = request
+	s.forwardsMutex.Unlock()
+	defer func() {
+		s.forwardsMutex.Lock()
+		delete(s.forwards, string(nonce))
+		s.forwardsMutex.Unlock()
+	}()
+	if err := s.SendRaw(leader, &ForwardStore{nonce, psbd}); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorOnet,
+			"couldn't forward request to leader: "+err.Error())
+	}
+	select {
+	case reply := <-request:
+		if reply.ErrorCode != 0 {
+			return nil, onet.NewClientErrorCode(reply.ErrorCode, reply.ErrorMsg)
+		}
+		return reply.Reply, nil
+	// The leader needs at least a BFT-round and a propagation.
+	case <-time.After(2 * s.getPropagateTimeout()):
+		return nil, onet.NewClientErrorCode(ErrorTimeout,
+			"leader didn't reply in time")
+	}
+}
+
 // forwardSignature receives a signature request of a newly accepted block.
 // It only needs the 2nd-newest block and the forward-link.
 func (s *Service) forwardSignature(fs *ForwardSignature) error {
@@ -332,18 +1336,85 @@ func (s *Service) forwardSignature(fs *ForwardSignature) error {
 		return err
 	}
 	// TODO: is this really signed by target.roster?
-	sig, err := s.startBFT(bftFollowBlock, target.Roster, fs.ForwardLink.Hash, data)
+	start := time.Now()
+	link, err := s.startBFT(bftFollowBlock, target.Roster, fs.ForwardLink.Hash, data)
+	s.logOp(OpBFT, target, start, err)
 	if err != nil {
 		return errors.New("Couldn't get signature")
 	}
 	s.Sbm.Lock()
 	log.Lvl1("Adding forward-link to", target.Index)
-	target.AddForward(&BlockLink{fs.ForwardLink.Hash, sig.Sig})
+	target.AddForward(link)
 	s.Sbm.Unlock()
 	s.startPropagation([]*SkipBlock{target})
 	return nil
 }
 
+func (s *Service) handlePing(env *network.Envelope) {
+	p, ok := env.Msg.(*Ping)
+	if !ok {
+		log.Error("Didn't receive Ping")
+		return
+	}
+	if err := s.SendRaw(env.ServerIdentity, &PingReply{p.Nonce}); err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *Service) handlePingReply(env *network.Envelope) {
+	pr, ok := env.Msg.(*PingReply)
+	if !ok {
+		log.Error("Didn't receive PingReply")
+		return
+	}
+	s.pingRequestsMutex.Lock()
+	if request, ok := s.pingRequests[string(pr.Nonce)]; ok {
+		select {
+		case request <- true:
+		default:
+		}
+	}
+	s.pingRequestsMutex.Unlock()
+}
+
+func (s *Service) handleForwardStore(env *network.Envelope) {
+	fs, ok := env.Msg.(*ForwardStore)
+	if !ok {
+		log.Error("Didn't receive ForwardStore")
+		return
+	}
+	// Storing the block needs a BFT-round, so don't block the processor.
+	go func() {
+		reply := &ForwardStoreReply{Nonce: fs.Nonce}
+		ssbr, cerr := s.storeAndLog(fs.Request, false)
+		if cerr != nil {
+			reply.ErrorCode = cerr.ErrorCode()
+			reply.ErrorMsg = cerr.ErrorMsg()
+		} else {
+			reply.Reply = ssbr
+		}
+		if err := s.SendRaw(env.ServerIdentity, reply); err != nil {
+			log.Error(err)
+		}
+	}()
+}
+
+func (s *Service) handleForwardStoreReply(env *network.Envelope) {
+	fsr, ok := env.Msg.(*ForwardStoreReply)
+	if !ok {
+		log.Error("Didn't receive ForwardStoreReply")
+		return
+	}
+	s.forwardsMutex.Lock()
+	if request, ok := s.forwards[string(fsr.Nonce)]; ok {
+		select {
+		case request <- fsr:
+		default:
+		}
+	}
+	s.forwardsMutex.Unlock()
+}
+
 func (s *Service) getBlock(env *network.Envelope) {
 	gb, ok := env.Msg.(*GetBlock)
 	if !ok {
@@ -375,17 +1446,104 @@ func (s *Service) getBlockReply(env *network.Envelope) {
 		log.Error("Didn't receive GetBlock")
 		return
 	}
-	if err := s.Sbm.VerifyLinks(gbr.SkipBlock); err != nil {
+	if gbr.SkipBlock == nil {
+		log.Lvl2("Other conode doesn't have the block")
+		return
+	}
+	if err := gbr.SkipBlock.VerifyHash(); err != nil {
+		log.Error("Received invalid skipblock: " + err.Error())
+		return
+	}
+	if err := gbr.SkipBlock.VerifyChildren(); err != nil {
 		log.Error("Received invalid skipblock: " + err.Error())
+		return
+	}
+	key := verifiedKey("links", gbr.SkipBlock)
+	if !s.verified.contains(key) {
+		if err := s.Sbm.VerifyLinks(gbr.SkipBlock); err != nil {
+			log.Error("Received invalid skipblock: " + err.Error())
+		} else {
+			s.verified.add(key)
+		}
 	}
 	id := s.Sbm.Store(gbr.SkipBlock)
 	s.save()
 	log.Lvl3("Sending block to channel")
+	// The requester might have timed out already, so don't block if
+	// nobody is waiting.
 	s.blockRequestsMutex.Lock()
-	s.blockRequests[string(id)] <- gbr.SkipBlock
+	if request, ok := s.blockRequests[string(id)]; ok {
+		select {
+		case request <- gbr.SkipBlock:
+		default:
+		}
+	}
 	s.blockRequestsMutex.Unlock()
 }
 
+// authorizeChild asks the roster of the parent-block for a collective
+// signature on the genesis-block of the new child-chain. Only if the roster
+// signs, the child may be added to the ChildSL of the parent, together with
+// the returned signature.
+func (s *Service) authorizeChild(parent, child *SkipBlock) (*BlockLink, error) {
+	if i, _ := parent.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		return nil, errors.New("leader of child is not in roster of parent")
+	}
+	data, err := network.Marshal(child)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't marshal block: %s", err.Error())
+	}
+	start := time.Now()
+	auth, err := s.startBFT(bftAddChild, parent.Roster, child.Hash, data)
+	s.logOp(OpBFT, parent, start, err)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.VerifySignature(parent.Roster.Publics()); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// bftVerifyAddChild makes sure that a signature-request for adding a
+// child-chain to a parent-block is valid: the genesis-block of the child
+// needs to point to a known parent-block and its leader must be part of the
+// roster of the parent.
+func (s *Service) bftVerifyAddChild(msg []byte, data []byte) bool {
+	err := func() error {
+		_, childInt, err := network.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+		child, ok := childInt.(*SkipBlock)
+		if !ok {
+			return errors.New("Didn't receive a SkipBlock")
+		}
+		if !child.Hash.Equal(msg) || !child.CalculateHash().Equal(msg) {
+			return errors.New("Wrong hash of child")
+		}
+		if child.Index != 0 {
+			return errors.New("Child is not a genesis-block")
+		}
+		parent := s.Sbm.GetByID(child.ParentBlockID)
+		if parent == nil {
+			return errors.New("Don't have parent-block")
+		}
+		if child.Roster == nil || len(child.Roster.List) == 0 {
+			return errors.New("Child has an empty roster")
+		}
+		if i, _ := parent.Roster.Search(child.Roster.List[0].ID); i < 0 {
+			return errors.New("Leader of child is not in roster of parent")
+		}
+		return nil
+	}()
+	if err != nil {
+		log.Lvl2(s.ServerIdentity(), "refusing child:", err)
+		return false
+	}
+	return true
+}
+
 // verifyFollowBlock makes sure that a signature-request for a forward-link
 // is valid.
 func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
@@ -438,6 +1596,7 @@ func (s *Service) bftVerifyFollowBlock(msg []byte, data []byte) bool {
 // is valid.
 func (s *Service) bftVerifyNewBlock(msg []byte, data []byte) bool {
 	log.Lvlf4("%s verifying block %x", s.ServerIdentity(), msg)
+	start := time.Now()
 	srcHash := data[0:32]
 	prevSB := s.Sbm.GetByID(srcHash)
 	if prevSB == nil {
@@ -463,23 +1622,43 @@ func (s *Service) bftVerifyNewBlock(msg []byte, data []byte) bool {
 		log.Lvl2("previous block already has forward-link")
 		return false
 	}
+	s.handoversMutex.Lock()
+	handover := s.handovers[string(srcHash)]
+	s.handoversMutex.Unlock()
+	if handover != nil && !handover.Equal(newSB.Hash) {
+		log.Lvl2("Already signed a handover for the previous block")
+		return false
+	}
+	if err := s.verifyChunks(newSB); err != nil {
+		log.Lvl2("Refusing block:", err)
+		return false
+	}
 
-	ok := func() bool {
-		for _, ver := range newSB.VerifierIDs {
-			f, ok := s.verifiers[ver]
-			if !ok {
-				log.Lvlf2("Found no user verification for %x", ver)
-				return false
-			}
-			if !f(msg, newSB) {
-				return false
-			}
-		}
-		return true
-	}()
+	ok := s.runVerifiers(msg, prevSB, newSB)
+	if !ok {
+		s.metrics.add(&s.metrics.verificationFailures)
+		s.logOp(OpVerify, newSB, start, errRefused)
+	} else {
+		s.logOp(OpVerify, newSB, start, nil)
+	}
 	return ok
 }
 
+// runVerifiers returns true if all verifiers of newSB accept it.
+func (s *Service) runVerifiers(msg []byte, prevSB, newSB *SkipBlock) bool {
+	for _, ver := range newSB.VerifierIDs {
+		f, ok := s.verifiers[ver]
+		if !ok {
+			log.Lvlf2("Found no user verification for %x", ver)
+			return false
+		}
+		if !f(msg, prevSB, newSB) {
+			return false
+		}
+	}
+	return true
+}
+
 // PropagateSkipBlock will save a new SkipBlock
 func (s *Service) propagateSkipBlock(msg network.Message) {
 	sbs, ok := msg.(*PropagateSkipBlocks)
@@ -487,19 +1666,66 @@ func (s *Service) propagateSkipBlock(msg network.Message) {
 		log.Error("Couldn't convert to slice of SkipBlocks")
 		return
 	}
+	// Only notify once all blocks are stored, so that waiting
+	// FollowUpdate-requests find the new blocks.
+	defer func() {
+		for _, sb := range sbs.SkipBlocks {
+			s.notifyUpdate(sb.SkipChainID())
+		}
+	}()
 	for _, sb := range sbs.SkipBlocks {
-		if err := sb.VerifyForwardSignatures(); err != nil {
+		if err := s.verifyForwardSignatures(sb); err != nil {
 			log.Error(err)
 			return
 		}
+		if err := sb.VerifyHash(); err != nil {
+			log.Error("Refusing block " + sb.Short() + ": " + err.Error())
+			return
+		}
+		if err := sb.VerifyChildren(); err != nil {
+			log.Error("Refusing block " + sb.Short() + ": " + err.Error())
+			return
+		}
 		s.Sbm.Store(sb)
 		s.save()
+		if err := s.saveChunks(sb); err != nil {
+			log.Error("Couldn't save chunks:", err)
+		}
+		if sb.GetForwardLen() > 0 {
+			// No other block can follow sb anymore.
+			s.handoversMutex.Lock()
+			delete(s.handovers, string(sb.Hash))
+			s.handoversMutex.Unlock()
+		}
+	}
+}
+
+// verifyForwardSignatures works like SkipBlock.VerifyForwardSignatures, but
+// doesn't verify the signatures again if the same block with the same
+// forward-links has already been verified.
+func (s *Service) verifyForwardSignatures(sb *SkipBlock) error {
+	key := verifiedKey("forward", sb)
+	if s.verified.contains(key) {
+		return nil
+	}
+	if err := sb.VerifyForwardSignatures(); err != nil {
+		return err
 	}
+	s.verified.add(key)
+	return nil
 }
 
 // RegisterVerification stores the verification in a map and will
 // call it whenever a verification needs to be done.
 func (s *Service) registerVerification(v VerifierID, f SkipBlockVerifier) error {
+	return s.registerVerificationV2(v, func(newID []byte, prev, newSB *SkipBlock) bool {
+		return f(newID, newSB)
+	})
+}
+
+// registerVerificationV2 stores the verification in a map and will call it
+// with the previous block whenever a verification needs to be done.
+func (s *Service) registerVerificationV2(v VerifierID, f SkipBlockVerifierV2) error {
 	s.verifiers[v] = f
 	return nil
 }
@@ -531,7 +1757,7 @@ func (s *Service) verifyBlock(sb *SkipBlock) error {
 	if sb.Roster == nil {
 		return errors.New("Need a roster")
 	}
-	return nil
+	return s.verifyChunks(sb)
 }
 
 // addForwardLink verifies if the new block is valid. If it is not valid, it
@@ -552,15 +1778,13 @@ func (s *Service) addForwardLink(src, dst *SkipBlock) error {
 		return fmt.Errorf("Couldn't marshal block: %s", err.Error())
 	}
 	msg := []byte(dst.Hash)
-	sig, err := s.startBFT(bftNewBlock, roster, msg, append(src.Hash, data...))
+	start := time.Now()
+	fwd, err := s.startBFT(bftNewBlock, roster, msg, append(src.Hash, data...))
+	s.logOp(OpBFT, dst, start, err)
 	if err != nil {
 		return err
 	}
 
-	fwd := &BlockLink{
-		Hash:      dst.Hash,
-		Signature: sig.Sig,
-	}
 	fwl := s.Sbm.GetByID(src.Hash).ForwardLink
 	log.Lvlf3("%s adds forward-link to %s: %d->%d - fwlinks:%v", s.ServerIdentity(),
 		roster.List, src.Index, dst.Index, fwl)
@@ -574,16 +1798,32 @@ func (s *Service) addForwardLink(src, dst *SkipBlock) error {
 	return nil
 }
 
-// startBFT starts a BFT-protocol with the given parameters.
-func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte) (*bftcosi.BFTSignature, error) {
+// startBFT starts a BFT-protocol with the given parameters and returns a
+// BlockLink to msg with the signature and its scheme.
+func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte) (*BlockLink, error) {
 	switch len(roster.List) {
 	case 0:
 		return nil, errors.New("Found empty Roster")
 	case 1:
-		return nil, errors.New("Need more than 1 entry for Roster")
+		sig, err := s.signSingle(proto, roster, msg, data)
+		if err != nil {
+			return nil, err
+		}
+		return &BlockLink{Hash: msg, Signature: sig.Sig,
+			SigScheme: SigSchemeBFTCoSi}, nil
+	}
+	if s.hasOffline(roster) {
+		log.Lvl2("Roster has offline members, collecting a quorum")
+		sig, err := s.startQuorum(proto, roster, msg, data)
+		if err != nil {
+			return nil, err
+		}
+		return &BlockLink{Hash: msg, Signature: sig,
+			SigScheme: SigSchemeQuorum}, nil
 	}
 
 	// Start the protocol
+	s.metrics.add(&s.metrics.bftRounds)
 	tree := roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
 	node, err := s.CreateProtocol(proto, tree)
 	if err != nil {
@@ -608,15 +1848,169 @@ func (s *Service) startBFT(proto string, roster *onet.Roster, msg, data []byte)
 		if sig.Sig == nil {
 			return nil, errors.New("Couldn't sign forward-link")
 		}
-		return sig, nil
+		return &BlockLink{Hash: msg, Signature: sig.Sig,
+			SigScheme: SigSchemeBFTCoSi}, nil
 	case <-time.After(time.Second * 60):
+		s.metrics.add(&s.metrics.bftTimeouts)
 		return nil, errors.New("Timed out while waiting for signature")
 	}
 }
 
+// collectAcks asks all nodes of the roster of the block for a signed
+// acknowledgement that they stored the block and keeps the valid ones. Only
+// the acknowledgements of the last maxAcks blocks are kept.
+func (s *Service) collectAcks(sb *SkipBlock) error {
+	tree := sb.Roster.GenerateNaryTreeWithRoot(len(sb.Roster.List),
+		s.ServerIdentity())
+	if tree == nil {
+		return errors.New("Didn't find ourselves in roster")
+	}
+	pi, err := s.CreateProtocol(ackProtocolName, tree)
+	if err != nil {
+		return err
+	}
+	p := pi.(*ackProtocol)
+	p.ID = sb.Hash
+	if err := p.Start(); err != nil {
+		return err
+	}
+	acks := <-p.Acks
+	s.acksMutex.Lock()
+	defer s.acksMutex.Unlock()
+	key := string(sb.Hash)
+	if _, ok := s.acks[key]; !ok {
+		s.acksOrder = append(s.acksOrder, key)
+	}
+	s.acks[key] = acks
+	if len(s.acksOrder) > maxAcks {
+		delete(s.acks, s.acksOrder[0])
+		s.acksOrder = s.acksOrder[1:]
+	}
+	return nil
+}
+
+// GetAcks returns the signed acknowledgements of the nodes that stored the
+// given block. Only the leader that created the block has them.
+func (s *Service) GetAcks(ga *GetAcks) (*GetAcksReply, onet.ClientError) {
+	s.acksMutex.Lock()
+	defer s.acksMutex.Unlock()
+	acks, ok := s.acks[string(ga.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorBlockNotFound,
+			"No acknowledgements for this block")
+	}
+	return &GetAcksReply{acks}, nil
+}
+
+// Snapshot returns all skipblocks of the service. The SkipBlockMap is locked
+// while the skipblocks are collected, so the snapshot is consistent even if
+// new blocks are stored at the same time.
+func (s *Service) Snapshot(snap *Snapshot) (*SnapshotReply, onet.ClientError) {
+	if err := crypto.VerifySchnorr(network.Suite, s.ServerIdentity().Public,
+		snap.hash(), snap.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"wrong signature: "+err.Error())
+	}
+	skew := time.Since(time.Unix(snap.Time, 0))
+	if skew > snapshotMaxSkew || skew < -snapshotMaxSkew {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"request is too old or in the future")
+	}
+	sbm := NewSkipBlockMap()
+	for _, sb := range s.Sbm.all() {
+		sbm.SkipBlocks[string(sb.Hash)] = sb
+	}
+	data, err := network.Marshal(sbm)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorOnet, err.Error())
+	}
+	return &SnapshotReply{data}, nil
+}
+
+// Restore loads a snapshot returned by Snapshot. To avoid overwriting
+// existing data, it is only accepted if the service doesn't hold any
+// skipblocks yet. All blocks of the snapshot are verified before it is
+// stored.
+func (s *Service) Restore(r *Restore) (*RestoreReply, onet.ClientError) {
+	if err := crypto.VerifySchnorr(network.Suite, s.ServerIdentity().Public,
+		r.hash(), r.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorVerification,
+			"wrong signature: "+err.Error())
+	}
+	_, msg, err := network.Unmarshal(r.Data)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"couldn't unmarshal snapshot: "+err.Error())
+	}
+	sbm, ok := msg.(*SkipBlockMap)
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong,
+			"snapshot of wrong type")
+	}
+	if err := sbm.verifySnapshot(); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorBlockContent,
+			"invalid snapshot: "+err.Error())
+	}
+	if err := s.Sbm.restore(sbm.SkipBlocks); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorParameterWrong, err.Error())
+	}
+	s.Sbm.Lock()
+	defer s.Sbm.Unlock()
+	s.lastSave = time.Now()
+	if err := s.saveStorage(); err != nil {
+		log.Error("Couldn't save file:", err)
+	}
+	return &RestoreReply{len(sbm.SkipBlocks)}, nil
+}
+
+// verifyFunction returns the verification used by the BFT-protocol proto,
+// or nil if proto is unknown.
+func (s *Service) verifyFunction(proto string) func(msg, data []byte) bool {
+	switch proto {
+	case bftNewBlock:
+		return s.bftVerifyNewBlock
+	case bftFollowBlock:
+		return s.bftVerifyFollowBlock
+	case bftAddChild:
+		return s.bftVerifyAddChild
+	case bftHandover:
+		return s.bftVerifyHandover
+	case bftNewBatch:
+		return s.bftVerifyBatch
+	}
+	return nil
+}
+
+// signSingle replaces the BFT-round for a roster with only this conode: the
+// block is verified locally and signed with a Schnorr-signature, which is
+// accepted by BlockLink.VerifySignature for rosters of size 1.
+func (s *Service) signSingle(proto string, roster *onet.Roster, msg, data []byte) (*bftcosi.BFTSignature, error) {
+	if !roster.List[0].Equal(s.ServerIdentity()) {
+		return nil, errors.New("Single node of roster is not this conode")
+	}
+	if !s.verifyFunction(proto)(msg, data) {
+		return nil, errors.New("Couldn't sign forward-link")
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, s.private(roster), msg)
+	if err != nil {
+		return nil, err
+	}
+	return &bftcosi.BFTSignature{Sig: sig, Msg: msg}, nil
+}
+
+// private returns the private key of the conode, which is only available
+// through a TreeNodeInstance. roster must be rooted at this conode.
+func (s *Service) private(roster *onet.Roster) abstract.Scalar {
+	tree := roster.GenerateNaryTreeWithRoot(1, s.ServerIdentity())
+	return s.NewTreeNodeInstance(tree, tree.Root, ackProtocolName).Private()
+}
+
 // notify other services about new/updated skipblock
 func (s *Service) startPropagation(blocks []*SkipBlock) error {
 	log.Lvl3("Starting to propagate for service", s.ServerIdentity())
+	start := time.Now()
+	s.metrics.propagationStart()
+	defer s.metrics.propagationEnd()
 	siMap := map[string]*network.ServerIdentity{}
 	// Add all rosters of all blocks - everybody needs to be contacted
 	for _, block := range blocks {
@@ -630,7 +2024,9 @@ func (s *Service) startPropagation(blocks []*SkipBlock) error {
 	}
 	roster := onet.NewRoster(siList)
 
-	replies, err := s.propagate(roster, &PropagateSkipBlocks{blocks}, propagateTimeout)
+	replies, err := s.propagate(roster, &PropagateSkipBlocks{blocks},
+		int(s.getPropagateTimeout()/time.Millisecond))
+	s.logOp(OpPropagate, blocks[len(blocks)-1], start, err)
 	if err != nil {
 		return err
 	}
@@ -640,44 +2036,110 @@ func (s *Service) startPropagation(blocks []*SkipBlock) error {
 	return nil
 }
 
+// newBlockStart marks the skipchain of sb as processing a new block. It
+// returns false if the skipchain is already processing a block. Different
+// skipchains can process new blocks concurrently.
 func (s *Service) newBlockStart(sb *SkipBlock) bool {
 	s.newBlocksMutex.Lock()
 	defer s.newBlocksMutex.Unlock()
-	if _, processing := s.newBlocks[string(sb.Hash)]; processing {
-		return false
-	}
-	if len(s.newBlocks) > 0 {
+	id := string(sb.SkipChainID())
+	if _, processing := s.newBlocks[id]; processing {
 		return false
 	}
-	s.newBlocks[string(sb.Hash)] = true
+	s.newBlocks[id] = true
 	return true
 }
 
+// newBlockEnd marks the skipchain of sb as ready for a new block.
 func (s *Service) newBlockEnd(sb *SkipBlock) bool {
 	s.newBlocksMutex.Lock()
 	defer s.newBlocksMutex.Unlock()
-	if _, processing := s.newBlocks[string(sb.Hash)]; !processing {
+	id := string(sb.SkipChainID())
+	if _, processing := s.newBlocks[id]; !processing {
 		return false
 	}
-	delete(s.newBlocks, string(sb.Hash))
+	delete(s.newBlocks, id)
 	return true
 }
 
-// saves all skipblocks.
+// saves all skipblocks. If a BlockDB is used, every skipblock is already
+// written when it is stored.
 func (s *Service) save() {
 	s.Sbm.Lock()
 	defer s.Sbm.Unlock()
+	if s.Sbm.db != nil {
+		return
+	}
 	if time.Now().Sub(s.lastSave) < time.Second*timeBetweenSave {
 		return
 	}
 	s.lastSave = time.Now()
 	log.Lvl3("Saving service")
-	err := s.Save(skipblocksID, s.Sbm)
+	err := s.saveStorage()
 	if err != nil {
 		log.Error("Couldn't save file:", err)
 	}
 }
 
+// Flush writes all skipblocks to disk, even if the last save was less than
+// timeBetweenSave ago. As onet doesn't tell the services when the conode
+// shuts down, it should be called before exiting to not lose the latest
+// blocks.
+func (s *Service) Flush() error {
+	s.Sbm.Lock()
+	defer s.Sbm.Unlock()
+	if s.Sbm.db != nil {
+		return nil
+	}
+	s.lastSave = time.Now()
+	log.Lvl3("Flushing service")
+	return s.saveStorage()
+}
+
+// runningServices holds all skipchain services of this process, so that
+// FlushAll can reach them.
+var runningServices struct {
+	sync.Mutex
+	list []*Service
+}
+
+// FlushAll calls Flush on all skipchain services of this process. The conode
+// calls it when it exits.
+func FlushAll() error {
+	runningServices.Lock()
+	defer runningServices.Unlock()
+	for _, s := range runningServices.list {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseBlockDB moves all skipblocks to db and uses it to store new skipblocks.
+// Afterwards only the accessed skipblocks are kept in memory. If a StorageKey
+// is set, the Data of the skipblocks in db is encrypted.
+func (s *Service) UseBlockDB(db BlockDB) error {
+	if s.StorageKey != nil {
+		var err error
+		db, err = newEncryptedBlockDB(db, s.StorageKey)
+		if err != nil {
+			return err
+		}
+	}
+	s.Sbm.Lock()
+	defer s.Sbm.Unlock()
+	for _, sb := range s.Sbm.SkipBlocks {
+		if err := db.Store(sb); err != nil {
+			return err
+		}
+	}
+	s.Sbm.db = db
+	s.Sbm.SkipBlocks = make(map[string]*SkipBlock)
+	// Remove the skipblocks from the file, they're in db now.
+	return s.saveStorage()
+}
+
 // Tries to load the configuration and updates the data in the service
 // if it finds a valid config-file.
 func (s *Service) tryLoad() error {
@@ -688,9 +2150,19 @@ func (s *Service) tryLoad() error {
 	if err != nil {
 		return err
 	}
-	var ok bool
-	s.Sbm, ok = msg.(*SkipBlockMap)
-	if !ok {
+	switch m := msg.(type) {
+	case *SkipBlockMap:
+		s.Sbm = m
+	case *encryptedBlocks:
+		if s.StorageKey == nil {
+			return errStorageKeyMissing
+		}
+		sbm, err := decryptBlocks(s.StorageKey, m)
+		if err != nil {
+			return err
+		}
+		s.Sbm = sbm
+	default:
 		return errors.New("Data of wrong type")
 	}
 	return nil
@@ -700,34 +2172,98 @@ func newSkipchainService(c *onet.Context) onet.Service {
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
 		Sbm:              NewSkipBlockMap(),
-		verifiers:        map[VerifierID]SkipBlockVerifier{},
+		verifiers:        map[VerifierID]SkipBlockVerifierV2{},
 		blockRequests:    make(map[string]chan *SkipBlock),
+		pingRequests:     make(map[string]chan bool),
+		updates:          make(map[string]chan bool),
+		forwards:         make(map[string]chan *ForwardStoreReply),
+		acks:             make(map[string][]*BlockAck),
 		newBlocks:        make(map[string]bool),
+		offline:          make(map[string]*network.ServerIdentity),
+		handovers:        make(map[string]SkipBlockID),
+		verified:         newVerifiedCache(defaultVerifiedCacheSize),
+		propagateTimeout: defaultPropagateTimeout,
+		pending:          newPendingBlocks(),
+		MaxRosterChange:  defaultMaxRosterChange,
+		chunks:           newChunkStore(),
+	}
+	key, err := storageKeyFromEnv()
+	log.ErrFatal(err)
+	s.StorageKey = key
+	if env := os.Getenv(AdminKeyEnv); env != "" {
+		s.AdminKey, err = crypto.String64ToPoint(network.Suite, env)
+		log.ErrFatal(err, "invalid "+AdminKeyEnv)
 	}
 	if err := s.tryLoad(); err != nil {
+		// Don't overwrite the stored skipblocks with an empty map.
+		if err == errStorageKeyMissing {
+			log.Fatal(err)
+		}
+		log.Error(err)
+	}
+	if err := s.loadChunks(); err != nil {
+		if err == errStorageKeyMissing {
+			log.Fatal(err)
+		}
 		log.Error(err)
 	}
+	if path := os.Getenv(BlockDBEnv); path != "" {
+		db, err := NewBoltBlockDB(path)
+		log.ErrFatal(err)
+		log.ErrFatal(s.UseBlockDB(db))
+	}
 	s.lastSave = time.Now()
-	log.ErrFatal(s.RegisterHandlers(s.StoreSkipBlock, s.GetUpdateChain,
-		s.GetSingleBlock, s.GetSingleBlockByIndex, s.GetAllSkipchains))
+	log.ErrFatal(s.RegisterHandlers(s.StoreSkipBlock, s.CompareAndAppend, s.GetUpdateChain,
+		s.GetSingleBlock, s.GetSingleBlockByIndex, s.GetAllSkipchains,
+		s.GetKnownConodes, s.PingRoster, s.GetAttachment,
+		s.RepairForwardLinks, s.GetMetrics, s.GetAcks, s.Snapshot, s.Restore,
+		s.GetProof, s.FollowUpdate, s.GetStatus, s.GetBlocks,
+		s.GetBlockRange, s.HealthCheck, s.RemoveChain, s.ProposeLeader,
+		s.StoreSkipBlocks, s.GetForwardLink, s.GetTipProof, s.StoreDataChunk,
+		s.GetBlockData))
 	s.RegisterProcessorFunc(network.MessageType(GetBlock{}),
 		s.getBlock)
 	s.RegisterProcessorFunc(network.MessageType(GetBlockReply{}),
 		s.getBlockReply)
+	s.RegisterProcessorFunc(network.MessageType(Ping{}),
+		s.handlePing)
+	s.RegisterProcessorFunc(network.MessageType(PingReply{}),
+		s.handlePingReply)
+	s.RegisterProcessorFunc(network.MessageType(ForwardStore{}),
+		s.handleForwardStore)
+	s.RegisterProcessorFunc(network.MessageType(ForwardStoreReply{}),
+		s.handleForwardStoreReply)
 
 	log.ErrFatal(s.registerVerification(VerifyBase, s.verifyFuncBase))
 	log.ErrFatal(s.registerVerification(VerifyRoot, s.verifyFuncRoot))
 	log.ErrFatal(s.registerVerification(VerifyControl, s.verifyFuncControl))
 	log.ErrFatal(s.registerVerification(VerifyData, s.verifyFuncData))
+	log.ErrFatal(s.registerVerification(VerifyRateLimit, s.verifyFuncRateLimit))
+	log.ErrFatal(s.registerVerification(VerifyRosterAllowlist, s.verifyFuncRosterAllowlist))
+	log.ErrFatal(s.registerVerification(VerifySchema, s.verifyFuncSchema))
+	log.ErrFatal(s.registerVerification(VerifyExternalData, s.verifyFuncExternalData))
+	log.ErrFatal(s.registerVerification(VerifyChildBinding, s.verifyFuncChildBinding))
+	log.ErrFatal(s.registerVerification(VerifyRosterChange, s.verifyFuncRosterChange))
+	log.ErrFatal(s.registerVerificationV2(VerifyTimestamp, s.verifyFuncTimestamp))
 
-	var err error
 	s.propagate, err = messaging.NewPropagationFunc(c, "SkipchainPropagate", s.propagateSkipBlock)
 	log.ErrFatal(err)
 	s.ProtocolRegister(bftNewBlock, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyNewBlock)
 	})
+	s.ProtocolRegister(ackProtocolName, s.newAckProtocol)
+	s.ProtocolRegister(quorumProtocolName, s.newQuorumProtocol)
 	s.ProtocolRegister(bftFollowBlock, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyFollowBlock)
 	})
+	s.ProtocolRegister(bftAddChild, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyAddChild)
+	})
+	s.ProtocolRegister(bftNewBatch, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyBatch)
+	})
+	runningServices.Lock()
+	runningServices.list = append(runningServices.list, s)
+	runningServices.Unlock()
 	return s
 }
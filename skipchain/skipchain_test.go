@@ -7,8 +7,17 @@ import (
 
 	"strconv"
 
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 
 	"time"
 
@@ -17,8 +26,13 @@ import (
 	"github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestMain(m *testing.M) {
@@ -47,7 +61,7 @@ func TestService_StoreSkipBlock(t *testing.T) {
 	genesis.Roster = sbRoot.Roster
 	genesis.VerifierIDs = VerificationStandard
 	blockCount := 0
-	psbr, err := service.StoreSkipBlock(&StoreSkipBlock{nil, genesis})
+	psbr, err := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
 	assert.Nil(t, err)
 	latest := psbr.Latest
 	// verify creation of GenesisBlock:
@@ -64,7 +78,7 @@ func TestService_StoreSkipBlock(t *testing.T) {
 	next.ParentBlockID = sbRoot.Hash
 	next.Roster = sbRoot.Roster
 	id := psbr.Latest.Hash
-	psbr2, err := service.StoreSkipBlock(&StoreSkipBlock{id, next})
+	psbr2, err := service.StoreSkipBlock(&StoreSkipBlock{LatestID: id, NewBlock: next})
 	assert.Nil(t, err)
 	log.Lvl2(psbr2)
 	if psbr2 == nil {
@@ -104,14 +118,15 @@ func TestService_GetUpdateChain(t *testing.T) {
 		newSB.Roster = onet.NewRoster(el.List[i : i+2])
 		service := local.Services[servers[i].ServerIdentity.ID][skipchainSID].(*Service)
 		log.Lvl2("Doing skipblock", i, servers[i].ServerIdentity, newSB.Roster.List)
-		reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbs[i-1].Hash, newSB})
+		reply, err := service.StoreSkipBlock(&StoreSkipBlock{LatestID: sbs[i-1].Hash,
+			NewBlock: newSB})
 		assert.Nil(t, err)
 		require.NotNil(t, reply.Latest)
 		sbs[i] = reply.Latest
 	}
 
 	for i := 0; i < sbCount; i++ {
-		m, err := s.GetUpdateChain(&GetUpdateChain{sbs[i].Hash})
+		m, err := s.GetUpdateChain(&GetUpdateChain{LatestID: sbs[i].Hash})
 		log.ErrFatal(err)
 		sbc := m.(*GetUpdateChainReply)
 		if !sbc.Update[0].Equal(sbs[i]) {
@@ -166,7 +181,7 @@ func TestService_SetChildrenSkipBlock(t *testing.T) {
 	for i, h := range hosts {
 		log.Lvlf2("%x", skipchainSID)
 		s := local.Services[h.ServerIdentity.ID][skipchainSID].(*Service)
-		m, err := s.GetUpdateChain(&GetUpdateChain{sbRoot.Hash})
+		m, err := s.GetUpdateChain(&GetUpdateChain{LatestID: sbRoot.Hash})
 		log.ErrFatal(err, "Failed in iteration="+strconv.Itoa(i)+":")
 		sb := m.(*GetUpdateChainReply)
 		log.Lvl2(s.Context)
@@ -190,7 +205,7 @@ func TestService_SetChildrenSkipBlock(t *testing.T) {
 	for _, h := range hosts {
 		s := local.Services[h.ServerIdentity.ID][skipchainSID].(*Service)
 
-		m, cerr := s.GetUpdateChain(&GetUpdateChain{sbInter.Hash})
+		m, cerr := s.GetUpdateChain(&GetUpdateChain{LatestID: sbInter.Hash})
 		sb := m.(*GetUpdateChainReply)
 
 		log.ErrFatal(cerr)
@@ -232,7 +247,8 @@ func TestService_MultiLevel(t *testing.T) {
 				log.Lvl3("Adding block", sbi)
 				sb := NewSkipBlock()
 				sb.Roster = el
-				psbr, err := service.StoreSkipBlock(&StoreSkipBlock{latest.Hash, sb})
+				psbr, err := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+					NewBlock: sb})
 				log.ErrFatal(err)
 				latest = psbr.Latest
 				for n, i := range sb.BackLinkIDs {
@@ -289,6 +305,1447 @@ func TestService_Verification(t *testing.T) {
 	log.ErrFatal(err)
 }
 
+func TestService_VerifyRateLimit(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	rl, err := network.Marshal(&RateLimit{Interval: 2000})
+	log.ErrFatal(err)
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = VerificationRateLimit
+	genesis.Data = rl
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	sbGenesis := ssbr.Latest
+
+	log.Lvl1("Appending a block before the end of the interval")
+	sb := NewSkipBlock()
+	sb.Roster = el
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: sbGenesis.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Appending a block after the interval")
+	time.Sleep(2 * time.Second)
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: sbGenesis.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	require.True(t, ssbr.Latest.Timestamp-sbGenesis.Timestamp >=
+		int64(2*time.Second))
+}
+
+func TestService_AllowedVerifiers(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+	service.AllowedVerifiers = map[VerifierID]bool{VerifyBase: true}
+
+	log.Lvl1("Creating skipchain with allowed verifier")
+	_, err := makeGenesisRosterArgs(service, el, nil, VerificationStandard, 1, 1)
+	log.ErrFatal(err)
+
+	log.Lvl1("Creating skipchain with disallowed verifier")
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: &SkipBlock{
+		SkipBlockFix: &SkipBlockFix{
+			MaximumHeight: 1,
+			BaseHeight:    1,
+			Roster:        el,
+			VerifierIDs:   VerificationRoot,
+			Data:          []byte{},
+		},
+	}})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+}
+
+func TestService_FollowKnown(t *testing.T) {
+	// A conode only signs a new block if it already knows the previous
+	// block of that skipchain.
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	hosts, el, s1 := makeHELS(local, 3)
+	s2 := local.Services[hosts[1].ServerIdentity.ID][skipchainSID].(*Service)
+
+	genesis, err := makeGenesisRoster(s1, el)
+	log.ErrFatal(err)
+
+	log.Lvl1("Verifying block of known skipchain")
+	sb := genesis.Copy()
+	sb.Index = 1
+	sb.Height = 1
+	sb.ForwardLink = nil
+	sb.BackLinkIDs = []SkipBlockID{genesis.Hash}
+	sb.GenesisID = genesis.Hash
+	sb.updateHash()
+	data, err := network.Marshal(sb)
+	log.ErrFatal(err)
+	require.True(t, s2.bftVerifyNewBlock(sb.Hash, append(genesis.Hash, data...)))
+
+	log.Lvl1("Refusing block of unknown skipchain")
+	unknown := SkipBlockID(random.Bytes(32, random.Stream))
+	sb.BackLinkIDs = []SkipBlockID{unknown}
+	sb.GenesisID = unknown
+	sb.updateHash()
+	data, err = network.Marshal(sb)
+	log.ErrFatal(err)
+	require.False(t, s2.bftVerifyNewBlock(sb.Hash, append(unknown, data...)))
+}
+
+func TestService_GetMetrics(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	sb := NewSkipBlock()
+	sb.Roster = el
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+
+	service.metrics.Lock()
+	require.Equal(t, uint64(2), service.metrics.blocksStored)
+	require.Equal(t, uint64(1), service.metrics.bftRounds)
+	require.Equal(t, uint64(2), service.metrics.propagations)
+	require.Equal(t, int64(0), service.metrics.propagationsInFlight)
+	service.metrics.Unlock()
+
+	reply, cerr := service.GetMetrics(&GetMetrics{})
+	log.ErrFatal(cerr)
+	require.Contains(t, reply.Metrics, "skipchain_blocks_stored_total 2\n")
+	require.Contains(t, reply.Metrics, "skipchain_chains_known 1\n")
+}
+
+func TestService_PropagateDataHash(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	servers, el, genService := local.MakeHELS(2, skipchainSID)
+	service := genService.(*Service)
+	other := local.Services[servers[1].ServerIdentity.ID][skipchainSID].(*Service)
+
+	genesis, err := makeGenesisRoster(service, onet.NewRoster(el.List[0:1]))
+	log.ErrFatal(err)
+	require.NotNil(t, genesis.DataHash)
+	require.Nil(t, other.Sbm.GetByID(genesis.Hash))
+
+	log.Lvl1("Refusing block with changed data")
+	mutated := genesis.Copy()
+	mutated.Data = []byte("changed after signing")
+	require.True(t, mutated.Hash.Equal(mutated.CalculateHash()))
+	other.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{mutated}})
+	require.Nil(t, other.Sbm.GetByID(genesis.Hash))
+
+	log.Lvl1("Refusing block with changed data and no DataHash")
+	mutated.DataHash = nil
+	other.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{mutated}})
+	require.Nil(t, other.Sbm.GetByID(genesis.Hash))
+	other.getBlockReply(&network.Envelope{Msg: &GetBlockReply{mutated}})
+	require.Nil(t, other.Sbm.GetByID(genesis.Hash))
+
+	log.Lvl1("Accepting original block")
+	other.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{genesis.Copy()}})
+	require.NotNil(t, other.Sbm.GetByID(genesis.Hash))
+}
+
+func TestService_ForwardToLeader(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	servers, el, genService := local.MakeHELS(3, skipchainSID)
+	leader := genService.(*Service)
+	follower := local.Services[servers[1].ServerIdentity.ID][skipchainSID].(*Service)
+
+	genesis, err := makeGenesisRoster(leader, el)
+	log.ErrFatal(err)
+
+	_, cerr := follower.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Forwarding to the leader")
+	follower.ForwardToLeader = true
+	ssbr, cerr := follower.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	require.Equal(t, 1, ssbr.Latest.Index)
+	require.NotNil(t, leader.Sbm.GetByID(ssbr.Latest.Hash))
+
+	log.Lvl1("Not forwarding a forwarded request")
+	_, cerr = follower.storeAndLog(&StoreSkipBlock{LatestID: ssbr.Latest.Hash,
+		NewBlock: newBlockRoster(el)}, false)
+	require.NotNil(t, cerr)
+}
+
+func TestService_GetAllSkipchainsPage(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	for i := 0; i < 50; i++ {
+		_, err := makeGenesisRoster(service, el)
+		log.ErrFatal(err)
+	}
+
+	all, cerr := service.GetAllSkipchains(&GetAllSkipchains{})
+	log.ErrFatal(cerr)
+	require.Equal(t, 50, len(all.SkipChains))
+	require.Nil(t, all.Next)
+
+	var pages [][]*SkipBlock
+	var start SkipBlockID
+	for {
+		reply, cerr := service.GetAllSkipchains(&GetAllSkipchains{start, 10})
+		log.ErrFatal(cerr)
+		require.Equal(t, 10, len(reply.SkipChains))
+		pages = append(pages, reply.SkipChains)
+		if reply.Next == nil {
+			break
+		}
+		start = reply.Next
+	}
+	require.Equal(t, 5, len(pages))
+	var ids []string
+	for _, page := range pages {
+		for _, sb := range page {
+			ids = append(ids, string(sb.SkipChainID()))
+		}
+	}
+	require.True(t, sort.StringsAreSorted(ids))
+	for i, sb := range all.SkipChains {
+		require.Equal(t, string(sb.SkipChainID()), ids[i])
+	}
+}
+
+func TestService_GetBlockReplyLate(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+
+	done := make(chan bool)
+	go func() {
+		// Nobody is waiting for this reply anymore.
+		service.getBlockReply(&network.Envelope{Msg: &GetBlockReply{genesis}})
+		service.blockRequestsMutex.Lock()
+		service.blockRequestsMutex.Unlock()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("late reply blocked the service")
+	}
+}
+
+func TestService_GetStatus(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	reply, cerr := service.GetStatus(&GetStatus{})
+	log.ErrFatal(cerr)
+	require.Equal(t, &GetStatusReply{}, reply)
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	_, err = makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+
+	reply, cerr = service.GetStatus(&GetStatus{})
+	log.ErrFatal(cerr)
+	require.Equal(t, 2, reply.Chains)
+	require.Equal(t, 3, reply.Blocks)
+	require.False(t, reply.Propagating)
+}
+
+func TestService_SnapshotRestore(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	hosts, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+	priv := local.GetPrivate(hosts[0])
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	sb := NewSkipBlock()
+	sb.Roster = el
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Refusing an unsigned snapshot request")
+	_, cerr = service.Snapshot(&Snapshot{Time: time.Now().Unix()})
+	require.Equal(t, ErrorVerification, cerr.ErrorCode())
+	snapReq := &Snapshot{Time: time.Now().Add(-time.Hour).Unix()}
+	snapReq.Signature, err = crypto.SignSchnorr(network.Suite, priv, snapReq.hash())
+	log.ErrFatal(err)
+	_, cerr = service.Snapshot(snapReq)
+	require.NotNil(t, cerr, "old request")
+	snapReq = &Snapshot{Time: time.Now().Unix()}
+	snapReq.Signature, err = crypto.SignSchnorr(network.Suite, priv, snapReq.hash())
+	log.ErrFatal(err)
+	snap, cerr := service.Snapshot(snapReq)
+	log.ErrFatal(cerr)
+
+	log.Lvl1("Refusing to restore into a populated service")
+	_, cerr = service.Restore(signedRestore(snap.Data, priv))
+	require.NotNil(t, cerr)
+
+	local2 := onet.NewLocalTest()
+	defer local2.CloseAll()
+	hosts2, _, genService2 := local2.MakeHELS(1, skipchainSID)
+	service2 := genService2.(*Service)
+	priv2 := local2.GetPrivate(hosts2[0])
+
+	log.Lvl1("Refusing a restore signed by another conode")
+	_, cerr = service2.Restore(signedRestore(snap.Data, priv))
+	require.Equal(t, ErrorVerification, cerr.ErrorCode())
+
+	log.Lvl1("Refusing a tampered snapshot")
+	sbm := NewSkipBlockMap()
+	for _, b := range service.Sbm.SkipBlocks {
+		sbm.Store(b.Copy())
+	}
+	sbm.SkipBlocks[string(latest.Hash)].Data = []byte("tampered")
+	data, err := network.Marshal(sbm)
+	log.ErrFatal(err)
+	_, cerr = service2.Restore(signedRestore(data, priv2))
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Restoring into an empty service")
+	rr, cerr := service2.Restore(signedRestore(snap.Data, priv2))
+	log.ErrFatal(cerr)
+	require.Equal(t, service.Sbm.Length(), rr.Blocks)
+	for id, b := range service.Sbm.SkipBlocks {
+		restored := service2.Sbm.GetByID(SkipBlockID(id))
+		require.NotNil(t, restored)
+		require.True(t, b.Equal(restored))
+		require.Equal(t, b.GetForwardLen(), restored.GetForwardLen())
+	}
+	restoredLatest, err := service2.Sbm.GetLatest(service2.Sbm.GetByID(genesis.Hash))
+	log.ErrFatal(err)
+	require.True(t, restoredLatest.Hash.Equal(latest.Hash))
+}
+
+// signedRestore returns a Restore request for data signed by priv.
+func signedRestore(data []byte, priv abstract.Scalar) *Restore {
+	r := &Restore{Data: data}
+	sig, err := crypto.SignSchnorr(network.Suite, priv, r.hash())
+	log.ErrFatal(err)
+	r.Signature = sig
+	return r
+}
+
+func TestService_OpLogger(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	var buf bytes.Buffer
+	jsonLogger := JSONOpLogger(&buf)
+	var recordsMutex sync.Mutex
+	var records []*OpRecord
+	service.OpLogger = func(rec *OpRecord) {
+		recordsMutex.Lock()
+		records = append(records, rec)
+		recordsMutex.Unlock()
+		jsonLogger(rec)
+	}
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	sb := NewSkipBlock()
+	sb.Roster = el
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	recordsMutex.Lock()
+	defer recordsMutex.Unlock()
+	var store *OpRecord
+	ops := map[string]bool{}
+	for _, rec := range records {
+		ops[rec.Operation] = true
+		if rec.Operation == OpStore && rec.Index == 1 {
+			store = rec
+		}
+	}
+	require.True(t, ops[OpPropagate])
+	require.True(t, ops[OpBFT])
+	require.NotNil(t, store)
+	require.Equal(t, hex.EncodeToString(genesis.Hash), store.Chain)
+	require.Equal(t, hex.EncodeToString(latest.Hash), store.Block)
+	require.Equal(t, "", store.Error)
+	require.True(t, store.LatencyMs > 0)
+
+	line, err := buf.ReadBytes('\n')
+	log.ErrFatal(err)
+	fields := map[string]interface{}{}
+	log.ErrFatal(json.Unmarshal(line, &fields))
+	for _, f := range []string{"time", "server", "op", "chain", "block",
+		"index", "latency_ms"} {
+		require.Contains(t, fields, f)
+	}
+}
+
+func TestService_StorageKey(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(2, skipchainSID)
+	service := genService.(*Service)
+	service.StorageKey = []byte("storage key")
+
+	secret := []byte("very secret configuration")
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.Data = secret
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	genesis = ssbr.Latest
+	sb := newBlockRoster(el)
+	sb.Data = secret
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	service.save()
+
+	msg, err := service.Load(skipblocksID)
+	log.ErrFatal(err)
+	_, ok := msg.(*encryptedBlocks)
+	require.True(t, ok)
+	buf, err := network.Marshal(msg)
+	log.ErrFatal(err)
+	require.False(t, bytes.Contains(buf, secret))
+
+	log.Lvl1("Reloading with the key")
+	blocks := service.Sbm.Length()
+	service.Sbm = NewSkipBlockMap()
+	log.ErrFatal(service.tryLoad())
+	require.Equal(t, blocks, service.Sbm.Length())
+	for _, sb := range service.Sbm.SkipBlocks {
+		require.Equal(t, secret, sb.Data)
+		require.True(t, sb.Hash.Equal(sb.CalculateHash()))
+		log.ErrFatal(sb.VerifyForwardSignatures())
+	}
+
+	log.Lvl1("Refusing to load without or with a wrong key")
+	service.StorageKey = nil
+	require.Equal(t, errStorageKeyMissing, service.tryLoad())
+	service.StorageKey = []byte("wrong key")
+	require.NotNil(t, service.tryLoad())
+	service.StorageKey = []byte("storage key")
+}
+
+func TestService_Flush(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	// stored returns whether the block is in the skipblocks on disk.
+	stored := func(id SkipBlockID) bool {
+		loaded := &Service{ServiceProcessor: service.ServiceProcessor,
+			Sbm: NewSkipBlockMap()}
+		log.ErrFatal(loaded.tryLoad())
+		return loaded.Sbm.GetByID(id) != nil
+	}
+	// Pretend the service just saved, so that save() doesn't write.
+	recentSave := func() {
+		service.Sbm.Lock()
+		service.lastSave = time.Now().Add(time.Hour)
+		service.Sbm.Unlock()
+	}
+
+	recentSave()
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	genesis = ssbr.Latest
+	require.False(t, stored(genesis.Hash))
+
+	log.Lvl1("Flushing on shutdown")
+	log.ErrFatal(service.Flush())
+	require.True(t, stored(genesis.Hash))
+
+	log.Lvl1("Saving before StoreSkipBlock returns")
+	recentSave()
+	service.SyncSave = true
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	require.True(t, stored(ssbr.Latest.Hash))
+}
+
+func TestService_DataChunks(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	service.chunks.quota = 3 * 16
+	chunk := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 16)
+	}
+	data := append(chunk(0), chunk(1)...)
+	hash := sha256.Sum256(data)
+
+	log.Lvl1("Refusing chunks above the quota")
+	other := random.Bytes(32, random.Stream)
+	_, cerr := service.StoreDataChunk(&StoreDataChunk{other, 0, 4, chunk(0)})
+	log.ErrFatal(cerr)
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{hash[:], 0, 2, chunk(0)})
+	log.ErrFatal(cerr)
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{other, 1, 4, chunk(1)})
+	log.ErrFatal(cerr)
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{hash[:], 1, 2, chunk(1)})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Removing expired chunks")
+	service.chunks.ttl = 0
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{hash[:], 1, 2, chunk(1)})
+	log.ErrFatal(cerr)
+	service.chunks.ttl = time.Hour
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{hash[:], 0, 2, chunk(0)})
+	log.ErrFatal(cerr)
+	require.Nil(t, service.chunks.get(other))
+	require.Equal(t, 2, len(service.chunks.get(hash[:])))
+
+	log.Lvl1("Keeping and writing chunks of a stored block")
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.DataHash = hash[:]
+	genesis.DataChunks = 2
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	service.chunks.ttl = 0
+	_, cerr = service.StoreDataChunk(&StoreDataChunk{other, 0, 4, chunk(0)})
+	log.ErrFatal(cerr)
+	require.Equal(t, 2, len(service.chunks.get(hash[:])))
+	loaded := &Service{ServiceProcessor: service.ServiceProcessor,
+		chunks: newChunkStore()}
+	log.ErrFatal(loaded.loadChunks())
+	require.Equal(t, data, bytes.Join(loaded.chunks.get(hash[:]), nil))
+}
+
+func TestService_BlockDB(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, _, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	dir, err := ioutil.TempDir("", "blockdb")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocks.db")
+
+	db, err := NewBoltBlockDB(path)
+	log.ErrFatal(err)
+	log.ErrFatal(service.UseBlockDB(db))
+	var ids []SkipBlockID
+	for i := 0; i < 1000; i++ {
+		sb := NewSkipBlock()
+		sb.Index = i
+		if i > 0 {
+			sb.GenesisID = ids[0]
+		}
+		sb.Data = []byte(strconv.Itoa(i))
+		sb.Hash = sb.CalculateHash()
+		ids = append(ids, service.Sbm.Store(sb))
+	}
+	require.Equal(t, 1000, service.Sbm.Length())
+	require.Equal(t, maxCachedBlocks, len(service.Sbm.SkipBlocks))
+	log.ErrFatal(db.Close())
+
+	log.Lvl1("Restarting with the stored blocks")
+	service.Sbm = NewSkipBlockMap()
+	log.ErrFatal(service.tryLoad())
+	require.Equal(t, 0, len(service.Sbm.SkipBlocks))
+	db, err = NewBoltBlockDB(path)
+	log.ErrFatal(err)
+	defer db.Close()
+	log.ErrFatal(service.UseBlockDB(db))
+	require.Equal(t, 0, len(service.Sbm.SkipBlocks))
+	for i, id := range ids {
+		reply, cerr := service.GetSingleBlock(&GetSingleBlock{id})
+		log.ErrFatal(cerr)
+		require.Equal(t, i, reply.Index)
+		require.Equal(t, []byte(strconv.Itoa(i)), reply.Data)
+	}
+	require.Equal(t, maxCachedBlocks, len(service.Sbm.SkipBlocks))
+
+	log.Lvl1("Using the index of the chains")
+	require.Equal(t, 1000, service.Sbm.Length())
+	chains := service.Sbm.chains()
+	require.Equal(t, 1, len(chains))
+	require.True(t, chains[0].Equal(ids[0]))
+	require.Equal(t, 1000, len(service.Sbm.chainBlocks(ids[0])))
+	require.Equal(t, 1000, service.Sbm.RemoveChain(ids[0]))
+	require.Equal(t, 0, service.Sbm.Length())
+	require.Equal(t, 0, len(service.Sbm.chains()))
+	require.Equal(t, 0, len(service.Sbm.SkipBlocks))
+}
+
+func TestService_GetSingleBlockByIndex(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, _, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	blocks := NewSyntheticChain(4, 4, 64)
+	for _, sb := range blocks {
+		service.Sbm.Store(sb)
+	}
+
+	for i, sb := range blocks {
+		found, lookups := service.blockAtIndex(blocks[0], i)
+		require.NotNil(t, found)
+		require.True(t, found.Equal(sb))
+		// At most base-1 lookups for each of the log_4(64) digits of i.
+		require.True(t, lookups <= 9, "%d lookups for index %d", lookups, i)
+		reply, cerr := service.GetSingleBlockByIndex(
+			&GetSingleBlockByIndex{blocks[0].Hash, i})
+		log.ErrFatal(cerr)
+		require.True(t, reply.Equal(sb))
+	}
+	found, lookups := service.blockAtIndex(blocks[0], 63)
+	require.Equal(t, blocks[63].Hash, found.Hash)
+	require.Equal(t, 9, lookups)
+}
+
+func TestService_GetSingleBlockByIndexBeyond(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, _, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	blocks := NewSyntheticChain(2, 2, 3)
+	for _, sb := range blocks {
+		service.Sbm.Store(sb)
+	}
+
+	_, cerr := service.GetSingleBlockByIndex(
+		&GetSingleBlockByIndex{blocks[0].Hash, 100})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorBlockNotFound, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), "index 100 beyond latest index 2")
+}
+
+func TestService_GetBlockRange(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, _, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	blocks := NewSyntheticChain(2, 3, 30)
+	for _, sb := range blocks {
+		service.Sbm.Store(sb)
+	}
+
+	reply, cerr := service.GetBlockRange(&GetBlockRange{blocks[0].Hash, 11, 19})
+	log.ErrFatal(cerr)
+	require.Equal(t, 9, len(reply.Blocks))
+	for i, sb := range reply.Blocks {
+		require.True(t, sb.Equal(blocks[11+i]))
+	}
+
+	_, cerr = service.GetBlockRange(&GetBlockRange{blocks[0].Hash, 19, 11})
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+	_, cerr = service.GetBlockRange(&GetBlockRange{blocks[0].Hash, 0, maxBlockRange})
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+	_, cerr = service.GetBlockRange(&GetBlockRange{blocks[0].Hash, 25, 35})
+	require.Equal(t, ErrorBlockNotFound, cerr.ErrorCode())
+}
+
+func TestService_GetUpdateChainMaxBlocks(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	blocks := NewSyntheticChain(2, 1, 100)
+	for _, sb := range blocks {
+		sb.Roster = el
+		service.Sbm.Store(sb)
+	}
+
+	reply, cerr := service.GetUpdateChain(&GetUpdateChain{LatestID: blocks[0].Hash})
+	log.ErrFatal(cerr)
+	require.Equal(t, 100, len(reply.Update))
+	require.False(t, reply.More)
+
+	var chain []*SkipBlock
+	latest := blocks[0].Hash
+	for {
+		reply, cerr = service.GetUpdateChain(&GetUpdateChain{LatestID: latest,
+			MaxBlocks: 20})
+		log.ErrFatal(cerr)
+		require.True(t, len(reply.Update) <= 20)
+		require.True(t, reply.Update[0].Hash.Equal(latest))
+		for i, sb := range reply.Update[1:] {
+			require.True(t, reply.Update[i].ForwardLink[0].Hash.Equal(sb.Hash))
+		}
+		if len(chain) > 0 {
+			chain = chain[:len(chain)-1]
+		}
+		chain = append(chain, reply.Update...)
+		if !reply.More {
+			break
+		}
+		latest = reply.Update[len(reply.Update)-1].Hash
+	}
+	require.Equal(t, 100, len(chain))
+	for i, sb := range chain {
+		require.True(t, sb.Hash.Equal(blocks[i].Hash))
+	}
+}
+
+func TestService_CompareAndAppendInProgress(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	service.SetPropagateTimeout(200 * time.Millisecond)
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+
+	log.Lvl2("Another block being stored makes CompareAndAppend fail")
+	require.True(t, service.newBlockStart(genesis))
+	reply, cerr := service.CompareAndAppend(&CompareAndAppend{
+		ExpectedTip: genesis.Hash, NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	require.Nil(t, reply.Stored)
+	require.True(t, reply.Tip.Hash.Equal(genesis.Hash))
+
+	log.Lvl2("CompareAndAppend waits for the other block")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		service.newBlockEnd(genesis)
+	}()
+	reply, cerr = service.CompareAndAppend(&CompareAndAppend{
+		ExpectedTip: genesis.Hash, NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	require.NotNil(t, reply.Stored)
+	latest := reply.Stored.Latest
+
+	log.Lvl2("Appending to an old block returns the tip")
+	reply, cerr = service.CompareAndAppend(&CompareAndAppend{
+		ExpectedTip: genesis.Hash, NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	require.Nil(t, reply.Stored)
+	require.True(t, reply.Tip.Hash.Equal(latest.Hash))
+}
+
+func TestService_RemoveChain(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	hosts, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	admin := config.NewKeyPair(network.Suite)
+
+	var chains [][]*SkipBlock
+	for i := 0; i < 3; i++ {
+		genesis, err := makeGenesisRoster(service, el)
+		log.ErrFatal(err)
+		chain := []*SkipBlock{genesis}
+		for j := 0; j <= i; j++ {
+			ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{
+				LatestID: chain[len(chain)-1].Hash, NewBlock: newBlockRoster(el)})
+			log.ErrFatal(cerr)
+			chain = append(chain, ssbr.Latest)
+		}
+		chains = append(chains, chain)
+	}
+	require.Equal(t, 9, service.Sbm.Length())
+
+	genesis := chains[1][0].Hash
+	now := time.Now().Unix()
+	_, cerr := service.RemoveChain(signedRemoveChain(genesis, now, admin.Secret))
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+
+	service.AdminKey = admin.Public
+	log.Lvl2("The key of the conode is not accepted")
+	_, cerr = service.RemoveChain(signedRemoveChain(genesis, now,
+		local.GetPrivate(hosts[0])))
+	require.Equal(t, ErrorVerification, cerr.ErrorCode())
+
+	log.Lvl2("An ack of the genesis-block can't be replayed")
+	sig, err := crypto.SignSchnorr(network.Suite, admin.Secret, genesis)
+	log.ErrFatal(err)
+	_, cerr = service.RemoveChain(&RemoveChain{Genesis: genesis, Time: now,
+		Signature: sig})
+	require.Equal(t, ErrorVerification, cerr.ErrorCode())
+
+	log.Lvl2("A stale request is refused")
+	_, cerr = service.RemoveChain(signedRemoveChain(genesis,
+		now-int64(2*removeChainMaxSkew/time.Second), admin.Secret))
+	require.Equal(t, ErrorParameterWrong, cerr.ErrorCode())
+
+	update := service.updateChannel(genesis)
+	reply, cerr := service.RemoveChain(signedRemoveChain(genesis, now, admin.Secret))
+	log.ErrFatal(cerr)
+	select {
+	case <-update:
+	default:
+		t.Fatal("followers of the removed chain were not woken up")
+	}
+	require.Equal(t, 3, reply.Removed)
+	require.Equal(t, 6, service.Sbm.Length())
+	for i, chain := range chains {
+		for _, sb := range chain {
+			require.Equal(t, i != 1, service.Sbm.GetByID(sb.Hash) != nil)
+		}
+	}
+	_, cerr = service.RemoveChain(signedRemoveChain(genesis, now, admin.Secret))
+	require.Equal(t, ErrorBlockNotFound, cerr.ErrorCode())
+}
+
+func signedRemoveChain(genesis SkipBlockID, time int64, priv abstract.Scalar) *RemoveChain {
+	rc := &RemoveChain{Genesis: genesis, Time: time}
+	sig, err := crypto.SignSchnorr(network.Suite, priv, rc.hash())
+	log.ErrFatal(err)
+	rc.Signature = sig
+	return rc
+}
+
+func TestVerifiedCache(t *testing.T) {
+	vc := newVerifiedCache(2)
+	vc.add("one")
+	vc.add("two")
+	require.True(t, vc.contains("one"))
+	vc.add("three")
+	require.True(t, vc.contains("one"))
+	require.False(t, vc.contains("two"))
+	require.True(t, vc.contains("three"))
+
+	var nilCache *verifiedCache
+	nilCache.add("one")
+	require.False(t, nilCache.contains("one"))
+}
+
+func TestService_VerifiedCacheTampered(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+	genesis := signedGenesis(t, service, el)
+
+	tampered := genesis.Copy()
+	tampered.ForwardLink[0].Signature[0] ^= 0xff
+	require.NotNil(t, service.verifyForwardSignatures(tampered))
+	require.False(t, service.verified.contains(verifiedKey("forward", tampered)))
+
+	require.Nil(t, service.verifyForwardSignatures(genesis))
+	require.True(t, service.verified.contains(verifiedKey("forward", genesis)))
+	require.NotNil(t, service.verifyForwardSignatures(tampered))
+
+	log.Lvl2("A changed roster with the same hash is not taken from the cache")
+	tampered = genesis.Copy()
+	tampered.Roster = onet.NewRoster(el.List[0:1])
+	require.NotNil(t, service.verifyForwardSignatures(tampered))
+
+	log.Lvl2("A changed signature scheme is not taken from the cache")
+	tampered = genesis.Copy()
+	tampered.ForwardLink[0].SigScheme = SigSchemeQuorum
+	require.NotEqual(t, verifiedKey("forward", genesis),
+		verifiedKey("forward", tampered))
+	require.NotNil(t, service.verifyForwardSignatures(tampered))
+}
+
+func BenchmarkService_PropagateCached(b *testing.B) {
+	benchmarkPropagate(b, true)
+}
+
+func BenchmarkService_PropagateUncached(b *testing.B) {
+	benchmarkPropagate(b, false)
+}
+
+func benchmarkPropagate(b *testing.B, cached bool) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+	genesis := signedGenesis(b, service, el)
+	if !cached {
+		service.verified = nil
+	}
+	psb := &PropagateSkipBlocks{[]*SkipBlock{genesis}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.propagateSkipBlock(psb)
+	}
+}
+
+// signedGenesis returns a genesis-block with a signed forward-link to a
+// second block.
+func signedGenesis(t require.TestingT, service *Service, el *onet.Roster) *SkipBlock {
+	genesis, err := makeGenesisRoster(service, el)
+	require.Nil(t, err)
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	require.Nil(t, cerr)
+	genesis = service.Sbm.GetByID(genesis.Hash).Copy()
+	require.Equal(t, 1, genesis.GetForwardLen())
+	return genesis
+}
+
+func BenchmarkService_GetSingleBlockByIndex(b *testing.B) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, _, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	blocks := NewSyntheticChain(4, 4, 1024)
+	for _, sb := range blocks {
+		service.Sbm.Store(sb)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, cerr := service.GetSingleBlockByIndex(
+			&GetSingleBlockByIndex{blocks[0].Hash, len(blocks) - 1})
+		if cerr != nil {
+			b.Fatal(cerr)
+		}
+	}
+}
+
+func TestService_VerifyRosterAllowlist(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(4, skipchainSID)
+	service := genService.(*Service)
+
+	al := &RosterAllowlist{}
+	for _, si := range el.List[0:3] {
+		al.IDs = append(al.IDs, si.ID)
+	}
+	data, err := network.Marshal(al)
+	log.ErrFatal(err)
+	genesis := NewSkipBlock()
+	genesis.Roster = onet.NewRoster(el.List[0:2])
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = VerificationRosterAllowlist
+	genesis.Data = data
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Changing to a roster in the allowlist")
+	sb := NewSkipBlock()
+	sb.Roster = onet.NewRoster(el.List[0:3])
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Changing to a roster outside the allowlist")
+	sb = NewSkipBlock()
+	sb.Roster = el
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+}
+
+func TestService_VerifyRosterChange(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(6, skipchainSID)
+	service := genService.(*Service)
+
+	genesis := NewSkipBlock()
+	genesis.Roster = onet.NewRoster(el.List[0:3])
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = VerificationRosterChange
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Swapping one conode")
+	sb := NewSkipBlock()
+	sb.Roster = onet.NewRoster([]*network.ServerIdentity{el.List[0],
+		el.List[1], el.List[3]})
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Swapping too many conodes")
+	sb = NewSkipBlock()
+	sb.Roster = onet.NewRoster([]*network.ServerIdentity{el.List[0],
+		el.List[4], el.List[5]})
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Replacing the whole roster")
+	sb = NewSkipBlock()
+	sb.Roster = onet.NewRoster([]*network.ServerIdentity{el.List[2],
+		el.List[4], el.List[5]})
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+}
+
+func TestService_StoreSkipBlockDryRun(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(6, skipchainSID)
+	service := genService.(*Service)
+
+	genesis := NewSkipBlock()
+	genesis.Roster = onet.NewRoster(el.List[0:3])
+	genesis.MaximumHeight = 2
+	genesis.BaseHeight = 2
+	genesis.VerifierIDs = VerificationRosterChange
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis, DryRun: true})
+	log.ErrFatal(cerr)
+	require.Nil(t, service.Sbm.GetByID(ssbr.Latest.Hash))
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	for _, test := range []struct {
+		roster []*network.ServerIdentity
+		valid  bool
+	}{
+		{[]*network.ServerIdentity{el.List[0], el.List[1], el.List[3]}, true},
+		{[]*network.ServerIdentity{el.List[0], el.List[4], el.List[5]}, false},
+	} {
+		sb := NewSkipBlock()
+		sb.Roster = onet.NewRoster(test.roster)
+		dry, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+			NewBlock: sb, DryRun: true})
+		require.Equal(t, test.valid, cerr == nil)
+		require.Equal(t, 0, latest.GetForwardLen())
+		if test.valid {
+			require.Equal(t, 1, dry.Latest.Index)
+			require.Equal(t, 1, dry.Latest.Height)
+			require.Equal(t, []SkipBlockID{latest.Hash}, dry.Latest.BackLinkIDs)
+			require.Nil(t, service.Sbm.GetByID(dry.Latest.Hash))
+		}
+
+		sb = NewSkipBlock()
+		sb.Roster = onet.NewRoster(test.roster)
+		real, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+		require.Equal(t, test.valid, cerr == nil)
+		if test.valid {
+			require.Equal(t, dry.Latest.BackLinkIDs, real.Latest.BackLinkIDs)
+			require.Equal(t, dry.Latest.Height, real.Latest.Height)
+			latest = real.Latest
+		}
+	}
+}
+
+func TestService_VerifyChildBinding(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	parent := NewSkipBlock()
+	parent.Roster = el
+	parent.MaximumHeight = 1
+	parent.BaseHeight = 1
+	parent.VerifierIDs = VerificationStandard
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: parent})
+	log.ErrFatal(cerr)
+	parentGenesis := ssbr.Latest
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: parentGenesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	parentTip := ssbr.Latest
+
+	newChild := func(parentID SkipBlockID) *SkipBlock {
+		child := NewSkipBlock()
+		child.Roster = el
+		child.MaximumHeight = 1
+		child.BaseHeight = 1
+		child.VerifierIDs = VerificationChildBinding
+		child.ParentBlockID = parentID
+		return child
+	}
+
+	log.Lvl1("Refusing child claiming an old parent-block")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{NewBlock: newChild(parentGenesis.Hash)})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Accepting child of the latest parent-block")
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{NewBlock: newChild(parentTip.Hash)})
+	log.ErrFatal(cerr)
+	child := ssbr.Latest
+	require.True(t, child.ParentBlockID.Equal(parentTip.Hash))
+	parentTip = service.Sbm.GetByID(parentTip.Hash)
+	require.Equal(t, 1, len(parentTip.ChildSL))
+	require.True(t, parentTip.ChildSL[0].Equal(child.Hash))
+
+	log.Lvl1("Appending to the bound child")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: child.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+}
+
+func TestService_SigScheme(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+	link := service.Sbm.GetByID(genesis.Hash).ForwardLink[0]
+	require.Equal(t, SigSchemeBFTCoSi, link.SigScheme)
+	require.Nil(t, link.VerifySignature(el.Publics()))
+
+	// Links stored without a scheme still verify.
+	link.SigScheme = SigSchemeUnset
+	require.Nil(t, link.VerifySignature(el.Publics()))
+	link.SigScheme = SigSchemeQuorum
+	require.NotNil(t, link.VerifySignature(el.Publics()))
+	link.SigScheme = SigSchemeQuorum + 1
+	require.NotNil(t, link.VerifySignature(el.Publics()))
+}
+
+func TestService_PropagateTimeout(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	servers, el, genService := local.MakeHELS(5, skipchainSID)
+	service := genService.(*Service)
+	require.Equal(t, defaultPropagateTimeout, service.getPropagateTimeout())
+
+	// Simulate a roster with a high latency, which uses up part of the
+	// timeout before the propagation starts.
+	latency := 500 * time.Millisecond
+	propagate := service.propagate
+	var timeouts []int
+	service.propagate = func(ro *onet.Roster, msg network.Message, msec int) (int, error) {
+		timeouts = append(timeouts, msec)
+		time.Sleep(latency)
+		remaining := msec - int(latency/time.Millisecond)
+		if remaining <= 0 {
+			return 0, errors.New("timeout")
+		}
+		return propagate(ro, msg, remaining)
+	}
+
+	service.SetPropagateTimeout(latency / 2)
+	_, err := makeGenesisRoster(service, el)
+	require.NotNil(t, err)
+
+	service.SetPropagateTimeout(20 * time.Second)
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	for _, msec := range timeouts {
+		require.True(t, msec == 250 || msec == 20000)
+	}
+	for _, s := range local.GetServices(servers, skipchainSID) {
+		require.NotNil(t, s.(*Service).Sbm.GetByID(genesis.Hash))
+	}
+}
+
+func TestService_AuthorizeChild(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	servers, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+	services := local.GetServices(servers, skipchainSID)
+	outsider := services[2].(*Service)
+
+	parentRoster := onet.NewRoster(el.List[0:2])
+	parent, err := makeGenesisRoster(service, parentRoster)
+	log.ErrFatal(err)
+
+	log.Lvl1("Refusing a child from a conode outside the parent-roster")
+	outsider.Sbm.Store(parent.Copy())
+	_, err = makeGenesisRosterArgs(outsider,
+		onet.NewRoster([]*network.ServerIdentity{el.List[2], el.List[0]}),
+		parent.Hash, VerificationNone, 1, 1)
+	require.NotNil(t, err)
+	require.Equal(t, 0, len(outsider.Sbm.GetByID(parent.Hash).ChildSL))
+
+	log.Lvl1("Parent-roster refuses to sign a foreign child")
+	foreign := NewSkipBlock()
+	foreign.Roster = onet.NewRoster([]*network.ServerIdentity{el.List[2]})
+	foreign.MaximumHeight = 1
+	foreign.BaseHeight = 1
+	foreign.ParentBlockID = parent.Hash
+	foreign.BackLinkIDs = []SkipBlockID{SkipBlockID(random.Bytes(32, random.Stream))}
+	foreign.updateHash()
+	data, err := network.Marshal(foreign)
+	log.ErrFatal(err)
+	require.False(t, services[1].(*Service).bftVerifyAddChild(foreign.Hash, data))
+
+	log.Lvl1("Accepting a child from the parent-roster")
+	child, err := makeGenesisRosterArgs(service, el, parent.Hash,
+		VerificationNone, 1, 1)
+	log.ErrFatal(err)
+	parent = service.Sbm.GetByID(parent.Hash)
+	require.Equal(t, 1, len(parent.ChildSL))
+	require.True(t, parent.ChildSL[0].Equal(child.Hash))
+	require.Equal(t, 1, len(parent.ChildAuth))
+	log.ErrFatal(parent.VerifyChildren())
+
+	log.Lvl1("Refusing a child without authorization")
+	member := services[1].(*Service)
+	unauthorized := parent.Copy()
+	unauthorized.ChildSL = append(unauthorized.ChildSL, foreign.Hash)
+	member.propagateSkipBlock(&PropagateSkipBlocks{[]*SkipBlock{unauthorized}})
+	require.Equal(t, 1, len(member.Sbm.GetByID(parent.Hash).ChildSL))
+	unauthorized.ChildAuth = append(unauthorized.ChildAuth, parent.ChildAuth[0])
+	require.Nil(t, member.Sbm.Store(unauthorized))
+	require.Equal(t, 1, len(member.Sbm.GetByID(parent.Hash).ChildSL))
+}
+
+func TestService_VerifySchema(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	newGenesis := func(schema string) *SkipBlock {
+		genesis := NewSkipBlock()
+		genesis.Roster = el
+		genesis.MaximumHeight = 1
+		genesis.BaseHeight = 1
+		genesis.VerifierIDs = VerificationSchema
+		genesis.Data = []byte(schema)
+		return genesis
+	}
+
+	log.Lvl1("Refusing invalid schema")
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: newGenesis(`{"type": 12}`)})
+	require.NotNil(t, cerr)
+
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{
+		NewBlock: newGenesis(`{"type": "object", "required": ["name"]}`)})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Appending conforming block")
+	sb := NewSkipBlock()
+	sb.Roster = el
+	sb.Data = []byte(`{"name": "conode"}`)
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Refusing non-conforming block")
+	sb = NewSkipBlock()
+	sb.Roster = el
+	sb.Data = []byte(`{"address": "conode"}`)
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+}
+
+func TestService_VerifyExternalData(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	content := []byte("data stored outside of the skipchain")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+	hash := network.Suite.Hash()
+	hash.Write(content)
+	goodHash := hash.Sum(nil)
+	badHash := make([]byte, len(goodHash))
+
+	newBlock := func(h []byte) *SkipBlock {
+		data, err := network.Marshal(&ExternalData{srv.URL, h})
+		log.ErrFatal(err)
+		sb := NewSkipBlock()
+		sb.Roster = el
+		sb.Data = data
+		return sb
+	}
+	genesis := newBlock(goodHash)
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = VerificationExternalData
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Appending block with matching hash")
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(goodHash)})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Refusing block with mismatching hash")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(badHash)})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Accepting mismatching hash without fetching")
+	for _, srvc := range local.Services {
+		srvc[skipchainSID].(*Service).ExternalDataHashOnly = true
+	}
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(badHash)})
+	log.ErrFatal(cerr)
+}
+
+func TestService_VerifyTimestamp(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	newBlock := func(ts time.Time) *SkipBlock {
+		data, err := network.Marshal(&TimestampedData{ts.UnixNano(), []byte("entry")})
+		log.ErrFatal(err)
+		sb := NewSkipBlock()
+		sb.Roster = el
+		sb.Data = data
+		return sb
+	}
+	start := time.Now()
+	genesis := newBlock(start.Add(-time.Second))
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = VerificationTimestamp
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Appending block with later timestamp")
+	ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(start)})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Refusing back-dated block")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(start.Add(-time.Millisecond))})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Refusing block too far in the future")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+		NewBlock: newBlock(time.Now().Add(2 * maxTimestampSkew))})
+	require.NotNil(t, cerr)
+
+	log.Lvl1("Refusing block without timestamp")
+	sb := NewSkipBlock()
+	sb.Roster = el
+	sb.Data = []byte("entry")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+}
+
+func TestService_SingleNode(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 2
+	genesis.BaseHeight = 2
+	genesis.VerifierIDs = VerificationStandard
+	ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+	for i := 0; i < 4; i++ {
+		ssbr, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+			NewBlock: newBlockRoster(el)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+
+	sb := service.Sbm.GetByID(genesis.SkipChainID())
+	require.NotNil(t, sb)
+	require.Equal(t, 2, sb.GetForwardLen())
+	for {
+		log.ErrFatal(sb.VerifyForwardSignatures())
+		log.ErrFatal(service.Sbm.VerifyLinks(sb))
+		if sb.GetForwardLen() == 0 {
+			break
+		}
+		sb = service.Sbm.GetByID(sb.GetForward(0).Hash)
+		require.NotNil(t, sb)
+	}
+	require.True(t, sb.Hash.Equal(latest.Hash))
+
+	log.Lvl1("Refusing a wrong Schnorr-signature")
+	link := service.Sbm.GetByID(latest.BackLinkIDs[0]).GetForward(0)
+	link.Signature[0] ^= 0xff
+	require.NotNil(t, link.VerifySignature(el.Publics()))
+}
+
+func TestService_AppendNotLatest(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	genesis, err := makeGenesisRoster(service, el)
+	log.ErrFatal(err)
+	latest := genesis
+	for i := 0; i < 2; i++ {
+		ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+			NewBlock: newBlockRoster(el)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+
+	_, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: genesis.Hash,
+		NewBlock: newBlockRoster(el)})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorBlockNotLatest, cerr.ErrorCode())
+	require.True(t, NotLatestTip(cerr).Equal(latest.Hash))
+
+	log.Lvl1("Rebasing on the returned tip")
+	_, cerr = service.StoreSkipBlock(&StoreSkipBlock{LatestID: NotLatestTip(cerr),
+		NewBlock: newBlockRoster(el)})
+	log.ErrFatal(cerr)
+}
+
 func TestService_SignBlock(t *testing.T) {
 	// Testing whether we sign correctly the SkipBlocks
 	local := onet.NewLocalTest()
@@ -302,7 +1759,7 @@ func TestService_SignBlock(t *testing.T) {
 	el2 := onet.NewRoster(el.List[0:2])
 	sb := NewSkipBlock()
 	sb.Roster = el2
-	reply, err := service.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb})
+	reply, err := service.StoreSkipBlock(&StoreSkipBlock{LatestID: sbRoot.Hash, NewBlock: sb})
 	log.ErrFatal(err)
 	sbRoot = reply.Previous
 	sbSecond := reply.Latest
@@ -332,7 +1789,7 @@ func TestService_ProtocolVerification(t *testing.T) {
 	log.ErrFatal(err)
 	sbNext := sbRoot.Copy()
 	sbNext.BackLinkIDs = []SkipBlockID{sbRoot.Hash}
-	_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sbNext})
+	_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{LatestID: sbRoot.Hash, NewBlock: sbNext})
 	log.ErrFatal(cerr)
 	for i := 0; i < 3; i++ {
 		select {
@@ -371,6 +1828,105 @@ func TestService_RegisterVerification(t *testing.T) {
 	require.Equal(t, 0, len(ServiceVerifierChan))
 }
 
+func TestService_StoreSkipBlocks(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	hosts, el, service := makeHELS(local, 3)
+	VerifyBatch := VerifierID(uuid.NewV5(uuid.NamespaceURL, "Batch"))
+	verifier := func(msg []byte, s *SkipBlock) bool {
+		return !bytes.Equal(s.Data, []byte("invalid"))
+	}
+	for _, h := range hosts {
+		s := h.Service(ServiceName).(*Service)
+		log.ErrFatal(s.registerVerification(VerifyBatch, verifier))
+	}
+	genesis, err := makeGenesisRosterArgs(service, el, nil,
+		[]VerifierID{VerifyBase, VerifyBatch}, 2, 2)
+	log.ErrFatal(err)
+
+	var batch []*SkipBlock
+	for i := 0; i < 5; i++ {
+		sb := NewSkipBlock()
+		sb.Data = []byte{byte(i)}
+		batch = append(batch, sb)
+	}
+	reply, cerr := service.StoreSkipBlocks(&StoreSkipBlocks{genesis.Hash, batch})
+	log.ErrFatal(cerr)
+	require.Equal(t, 5, reply.Latest.Index)
+	require.True(t, reply.Previous.Hash.Equal(genesis.Hash))
+
+	for _, h := range hosts {
+		s := h.Service(ServiceName).(*Service)
+		sb := s.Sbm.GetByID(genesis.Hash)
+		for i := 0; i < 5; i++ {
+			require.Nil(t, sb.VerifyForwardSignatures())
+			require.NotEqual(t, 0, len(sb.ForwardLink))
+			next := s.Sbm.GetByID(sb.ForwardLink[0].Hash)
+			require.NotNil(t, next)
+			require.Equal(t, i+1, next.Index)
+			require.Equal(t, []byte{byte(i)}, next.Data)
+			require.True(t, next.BackLinkIDs[0].Equal(sb.Hash))
+			sb = next
+		}
+		require.True(t, sb.Hash.Equal(reply.Latest.Hash))
+		require.Equal(t, 0, len(sb.ForwardLink))
+	}
+	require.Equal(t, 2, len(service.Sbm.GetByID(genesis.Hash).ForwardLink))
+
+	log.Lvl1("Refusing a batch with an invalid block")
+	length := service.Sbm.Length()
+	batch[2].Data = []byte("invalid")
+	_, cerr = service.StoreSkipBlocks(&StoreSkipBlocks{reply.Latest.Hash, batch})
+	require.NotNil(t, cerr)
+	for _, h := range hosts {
+		s := h.Service(ServiceName).(*Service)
+		require.Equal(t, length, s.Sbm.Length())
+		require.Equal(t, 0, len(s.Sbm.GetByID(reply.Latest.Hash).ForwardLink))
+	}
+	require.Equal(t, 0, len(service.pending.blocks))
+}
+
+func TestService_RegisterVerificationV2(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	hosts, el, s1 := makeHELS(local, 3)
+	VerifyCounter := VerifierID(uuid.NewV5(uuid.NamespaceURL, "Counter"))
+	verifier := func(msg []byte, prev, sb *SkipBlock) bool {
+		if prev == nil {
+			return true
+		}
+		return len(prev.Data) == 1 && len(sb.Data) == 1 &&
+			sb.Data[0] > prev.Data[0]
+	}
+	for _, h := range hosts {
+		log.ErrFatal(RegisterVerificationV2(h, VerifyCounter, verifier))
+	}
+	genesis := NewSkipBlock()
+	genesis.Roster = el
+	genesis.MaximumHeight = 1
+	genesis.BaseHeight = 1
+	genesis.VerifierIDs = []VerifierID{VerifyCounter}
+	genesis.Data = []byte{1}
+	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{NewBlock: genesis})
+	log.ErrFatal(cerr)
+	latest := ssbr.Latest
+
+	log.Lvl1("Increasing the counter")
+	sb := newBlockRoster(el)
+	sb.Data = []byte{2}
+	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	log.ErrFatal(cerr)
+	latest = ssbr.Latest
+
+	log.Lvl1("Not increasing the counter")
+	sb = newBlockRoster(el)
+	sb.Data = []byte{2}
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash, NewBlock: sb})
+	require.NotNil(t, cerr)
+}
+
 func TestService_StoreSkipBlock2(t *testing.T) {
 	nbrHosts := 3
 	local := onet.NewLocalTest()
@@ -389,15 +1945,15 @@ func TestService_StoreSkipBlock2(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbr, cerr := s1.StoreSkipBlock(&StoreSkipBlock{NewBlock: sbRoot})
 	log.ErrFatal(cerr)
 	roster2 := onet.NewRoster(roster.List[:nbrHosts-1])
 	log.Lvl1("Proposing roster", roster2)
 	sb1 := ssbr.Latest.Copy()
 	sb1.Roster = roster2
-	ssbr, cerr = s2.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1})
+	ssbr, cerr = s2.StoreSkipBlock(&StoreSkipBlock{LatestID: sbRoot.Hash, NewBlock: sb1})
 	require.NotNil(t, cerr)
-	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sb1})
+	ssbr, cerr = s1.StoreSkipBlock(&StoreSkipBlock{LatestID: sbRoot.Hash, NewBlock: sb1})
 	log.ErrFatal(cerr)
 	require.NotNil(t, ssbr.Latest)
 
@@ -411,14 +1967,14 @@ func TestService_StoreSkipBlock2(t *testing.T) {
 		},
 	}
 	sbErr.ParentBlockID = SkipBlockID([]byte{1, 2, 3})
-	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{nil, sbErr})
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{NewBlock: sbErr})
 	require.NotNil(t, cerr)
-	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{sbErr.ParentBlockID, sbErr})
+	_, cerr = s1.StoreSkipBlock(&StoreSkipBlock{LatestID: sbErr.ParentBlockID, NewBlock: sbErr})
 	// Last successful log...
 	require.NotNil(t, cerr)
 
 	sbErr = ssbr.Latest.Copy()
-	_, cerr = s3.StoreSkipBlock(&StoreSkipBlock{ssbr.Latest.Hash, sbErr})
+	_, cerr = s3.StoreSkipBlock(&StoreSkipBlock{LatestID: ssbr.Latest.Hash, NewBlock: sbErr})
 	require.NotNil(t, cerr)
 }
 
@@ -439,7 +1995,7 @@ func TestService_StoreSkipBlockSpeed(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{NewBlock: sbRoot})
 	log.ErrFatal(cerr)
 
 	last := time.Now()
@@ -447,8 +2003,8 @@ func TestService_StoreSkipBlockSpeed(t *testing.T) {
 		now := time.Now()
 		log.Lvl3(i, now.Sub(last))
 		last = now
-		ssbrep, cerr = s1.StoreSkipBlock(&StoreSkipBlock{ssbrep.Latest.Hash,
-			sbRoot})
+		ssbrep, cerr = s1.StoreSkipBlock(&StoreSkipBlock{LatestID: ssbrep.Latest.Hash,
+			NewBlock: sbRoot})
 		log.ErrFatal(cerr)
 	}
 }
@@ -467,7 +2023,7 @@ func TestService_ParallelStore(t *testing.T) {
 			Data:          []byte{},
 		},
 	}
-	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{nil, sbRoot})
+	ssbrep, cerr := s1.StoreSkipBlock(&StoreSkipBlock{NewBlock: sbRoot})
 	log.ErrFatal(cerr)
 
 	wg := &sync.WaitGroup{}
@@ -477,13 +2033,15 @@ func TestService_ParallelStore(t *testing.T) {
 			cl := NewClient()
 			block := sbRoot.Copy()
 			for {
-				_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{latest.Hash, block})
+				_, cerr := s1.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+					NewBlock: block})
 				if cerr == nil {
 					log.Lvl1("Done with", i)
 					wg.Done()
 					break
-				} else if cerr.ErrorCode() != ErrorBlockInProgress &&
-					cerr.ErrorCode() != ErrorBlockContent {
+				} else if !IsBlockInProgress(cerr) &&
+					!IsBlockContent(cerr) &&
+					!IsBlockNotLatest(cerr) {
 					log.Fatal(cerr)
 				}
 				for {
@@ -501,6 +2059,51 @@ func TestService_ParallelStore(t *testing.T) {
 	wg.Wait()
 }
 
+func TestService_ParallelChains(t *testing.T) {
+	nbrChains := 2
+	nbrBlocks := 3
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(3, skipchainSID)
+	service := genService.(*Service)
+
+	var genesis []*SkipBlock
+	for i := 0; i < nbrChains; i++ {
+		sb, err := makeGenesisRoster(service, el)
+		log.ErrFatal(err)
+		genesis = append(genesis, sb)
+	}
+
+	errs := make(chan onet.ClientError, nbrChains*nbrBlocks)
+	wg := &sync.WaitGroup{}
+	wg.Add(nbrChains)
+	for _, g := range genesis {
+		go func(latest *SkipBlock) {
+			defer wg.Done()
+			for i := 0; i < nbrBlocks; i++ {
+				ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+					NewBlock: newBlockRoster(el)})
+				if cerr != nil {
+					errs <- cerr
+					return
+				}
+				latest = ssbr.Latest
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for cerr := range errs {
+		t.Fatal("Got error while storing in parallel:", cerr)
+	}
+	for _, g := range genesis {
+		latest, err := service.Sbm.GetLatest(service.Sbm.GetByID(g.Hash))
+		log.ErrFatal(err)
+		require.Equal(t, nbrBlocks, latest.Index)
+	}
+}
+
 func TestService_Propagation(t *testing.T) {
 	nbr_nodes := 100
 	local := onet.NewLocalTest()
@@ -517,7 +2120,7 @@ func TestService_Propagation(t *testing.T) {
 		3, 3)
 	log.ErrFatal(err)
 	require.NotNil(t, sbRoot)
-	_, err = service.StoreSkipBlock(&StoreSkipBlock{sbRoot.Hash, sbRoot})
+	_, err = service.StoreSkipBlock(&StoreSkipBlock{LatestID: sbRoot.Hash, NewBlock: sbRoot})
 	log.ErrFatal(err)
 }
 
@@ -535,7 +2138,7 @@ func checkMLForwardBackward(service *Service, root *SkipBlock, base, height int)
 
 func checkMLUpdate(service *Service, root, latest *SkipBlock, base, height int) error {
 	log.Lvl3(service, root, latest, base, height)
-	chain, err := service.GetUpdateChain(&GetUpdateChain{root.Hash})
+	chain, err := service.GetUpdateChain(&GetUpdateChain{LatestID: root.Hash})
 	if err != nil {
 		return err
 	}
@@ -593,7 +2196,7 @@ func makeGenesisRosterArgs(s *Service, el *onet.Roster, parent SkipBlockID,
 	sb.BaseHeight = base
 	sb.ParentBlockID = parent
 	sb.VerifierIDs = vid
-	psbr, err := s.StoreSkipBlock(&StoreSkipBlock{nil, sb})
+	psbr, err := s.StoreSkipBlock(&StoreSkipBlock{NewBlock: sb})
 	if err != nil {
 		return nil, err
 	}
@@ -604,6 +2207,13 @@ func makeGenesisRoster(s *Service, el *onet.Roster) (*SkipBlock, error) {
 	return makeGenesisRosterArgs(s, el, nil, VerificationNone, 1, 1)
 }
 
+// newBlockRoster returns a new block to be appended with the given roster.
+func newBlockRoster(el *onet.Roster) *SkipBlock {
+	sb := NewSkipBlock()
+	sb.Roster = el
+	return sb
+}
+
 // Makes a Host, an Roster, and a service
 func makeHELS(local *onet.LocalTest, nbr int) ([]*onet.Server, *onet.Roster, *Service) {
 	hosts := local.GenServers(nbr)
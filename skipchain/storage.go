@@ -0,0 +1,130 @@
+package skipchain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1/network"
+)
+
+/*
+This file holds the encryption of the Data of the skipblocks when they are
+stored on disk. Only the stored copy is encrypted, the SkipBlockMap of the
+service always holds the plaintext, so the hashes stay valid.
+*/
+
+// StorageKeyEnv is the environment-variable holding the hex-encoded key used
+// to encrypt the Data of all skipblocks stored on disk.
+const StorageKeyEnv = "SKIPCHAIN_STORAGE_KEY"
+
+// errStorageKeyMissing is returned when loading encrypted skipblocks
+// without a StorageKey.
+var errStorageKeyMissing = errors.New("stored skipblocks are encrypted - please set " +
+	StorageKeyEnv)
+
+func init() {
+	network.RegisterMessage(&encryptedBlocks{})
+}
+
+// encryptedBlocks is stored instead of the SkipBlockMap if the service has a
+// StorageKey. The Data of all skipblocks is encrypted.
+type encryptedBlocks struct {
+	SkipBlocks map[string]*SkipBlock
+}
+
+// storageKeyFromEnv returns the key given in StorageKeyEnv, or nil if it is
+// not set.
+func storageKeyFromEnv() ([]byte, error) {
+	env := os.Getenv(StorageKeyEnv)
+	if env == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(env)
+	if err != nil {
+		return nil, errors.New("invalid " + StorageKeyEnv + ": " + err.Error())
+	}
+	return key, nil
+}
+
+// storageCipher returns an AES-GCM cipher using the hash of key.
+func storageCipher(key []byte) (cipher.AEAD, error) {
+	hash := sha256.Sum256(key)
+	block, err := aes.NewCipher(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptBlocks returns a copy of all skipblocks of sbm with encrypted Data.
+// The caller has to hold the lock of sbm.
+func encryptBlocks(key []byte, sbm *SkipBlockMap) (*encryptedBlocks, error) {
+	aead, err := storageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	eb := &encryptedBlocks{SkipBlocks: make(map[string]*SkipBlock, len(sbm.SkipBlocks))}
+	for id, sb := range sbm.SkipBlocks {
+		enc := sb.Copy()
+		enc.Data = sealData(aead, sb)
+		eb.SkipBlocks[id] = enc
+	}
+	return eb, nil
+}
+
+// decryptBlocks returns a SkipBlockMap with the decrypted skipblocks of eb.
+func decryptBlocks(key []byte, eb *encryptedBlocks) (*SkipBlockMap, error) {
+	aead, err := storageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sbm := NewSkipBlockMap()
+	for id, sb := range eb.SkipBlocks {
+		data, err := openData(aead, sb)
+		if err != nil {
+			return nil, err
+		}
+		sb.Data = data
+		sbm.SkipBlocks[id] = sb
+	}
+	return sbm, nil
+}
+
+// sealData returns the encrypted Data of sb, prefixed with the nonce. The
+// hash of sb is authenticated, too.
+func sealData(aead cipher.AEAD, sb *SkipBlock) []byte {
+	nonce := random.Bytes(aead.NonceSize(), random.Stream)
+	return aead.Seal(nonce, nonce, sb.Data, sb.Hash)
+}
+
+// openData returns the plaintext of the Data of sb encrypted by sealData.
+func openData(aead cipher.AEAD, sb *SkipBlock) ([]byte, error) {
+	size := aead.NonceSize()
+	if len(sb.Data) < size {
+		return nil, errors.New("encrypted data too short")
+	}
+	data, err := aead.Open(nil, sb.Data[:size], sb.Data[size:], sb.Hash)
+	if err != nil {
+		return nil, errors.New("couldn't decrypt block " + sb.Short() +
+			": " + err.Error())
+	}
+	return data, nil
+}
+
+// saveStorage writes all skipblocks to disk, encrypting their Data if a
+// StorageKey is set. The caller has to hold the lock of the SkipBlockMap.
+func (s *Service) saveStorage() error {
+	if s.StorageKey == nil {
+		return s.Save(skipblocksID, s.Sbm)
+	}
+	eb, err := encryptBlocks(s.StorageKey, s.Sbm)
+	if err != nil {
+		return err
+	}
+	return s.Save(skipblocksID, eb)
+}
@@ -11,19 +11,37 @@ import (
 
 	"encoding/binary"
 
+	"crypto/sha256"
 	"encoding/hex"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/satori/go.uuid"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/cosi"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
 
-// How many msec to wait before a timeout is generated in the propagation.
-const propagateTimeout = 10000
+// maxCachedBlocks is the number of skipblocks a SkipBlockMap with a BlockDB
+// keeps in memory.
+const maxCachedBlocks = 500
+
+// How long to wait before a timeout is generated in the propagation, if
+// the service doesn't set another value with SetPropagateTimeout.
+const defaultPropagateTimeout = 10 * time.Second
+
+// How many msec to wait for a ping to be answered.
+const pingTimeout = 2000
+
+// How many msec a FollowUpdate-request waits for a new block.
+const followUpdateTimeout = 10000
+
+// How many blocks GetBlockRange returns at most.
+const maxBlockRange = 1000
 
 // How often we save the skipchains - in seconds.
 const timeBetweenSave = 0
@@ -76,6 +94,15 @@ func (vId VerifierID) IsNil() bool {
 //   newSB is the new block
 type SkipBlockVerifier func(newID []byte, newSB *SkipBlock) bool
 
+// SkipBlockVerifierV2 is like SkipBlockVerifier, but also gets the previous
+// block, so it can verify the transition from prev to newSB. For a
+// genesis-block, prev is nil.
+//
+//   newID is the hash of the new block that will be signed
+//   prev is the previous block, or nil
+//   newSB is the new block
+type SkipBlockVerifierV2 func(newID []byte, prev, newSB *SkipBlock) bool
+
 // GetService makes it possible to give either an `onet.Context` or
 // `onet.Server` to `RegisterVerification`.
 type GetService interface {
@@ -92,6 +119,16 @@ func RegisterVerification(s GetService, v VerifierID, f SkipBlockVerifier) error
 	return scs.(*Service).registerVerification(v, f)
 }
 
+// RegisterVerificationV2 stores the verification in a map and will call it
+// with the previous block whenever a verification needs to be done.
+func RegisterVerificationV2(s GetService, v VerifierID, f SkipBlockVerifierV2) error {
+	scs := s.Service(ServiceName)
+	if scs == nil {
+		return errors.New("Didn't find our service: " + ServiceName)
+	}
+	return scs.(*Service).registerVerificationV2(v, f)
+}
+
 var (
 	// VerifyBase checks that the base-parameters are correct, i.e.,
 	// the links are correctly set up, the height-parameters and the
@@ -112,6 +149,33 @@ var (
 	//   - its Roster doesn't change between blocks
 	//   - if there is a newer parent, no new block will be appended to that chain.
 	VerifyData = VerifierID(uuid.NewV5(uuid.NamespaceURL, "Data"))
+	// VerifyRateLimit makes sure that a new block is not created before
+	// the interval stored in the genesis-block has passed since the
+	// previous block.
+	VerifyRateLimit = VerifierID(uuid.NewV5(uuid.NamespaceURL, "RateLimit"))
+	// VerifyRosterAllowlist makes sure that the roster of a new block only
+	// holds conodes present in the allowlist stored in the genesis-block.
+	VerifyRosterAllowlist = VerifierID(uuid.NewV5(uuid.NamespaceURL, "RosterAllowlist"))
+	// VerifySchema makes sure that the data of a new block conforms to the
+	// JSON schema stored in the genesis-block.
+	VerifySchema = VerifierID(uuid.NewV5(uuid.NamespaceURL, "Schema"))
+	// VerifyExternalData makes sure that the data of a new block points to
+	// external data with a hash, and, if enabled, that the data can be
+	// fetched and matches the hash.
+	VerifyExternalData = VerifierID(uuid.NewV5(uuid.NamespaceURL, "ExternalData"))
+	// VerifyChildBinding makes sure that the genesis-block of a child-chain
+	// references the latest block of its parent-chain, which is the block
+	// registering the child, and that the parent keeps the child in its
+	// ChildSL.
+	VerifyChildBinding = VerifierID(uuid.NewV5(uuid.NamespaceURL, "ChildBinding"))
+	// VerifyRosterChange makes sure that a new block doesn't remove too
+	// many conodes of the previous roster and that its leader was part of
+	// the previous roster.
+	VerifyRosterChange = VerifierID(uuid.NewV5(uuid.NamespaceURL, "RosterChange"))
+	// VerifyTimestamp makes sure that the timestamp in the data of a new
+	// block is later than the one of the previous block and close to the
+	// time of the conode.
+	VerifyTimestamp = VerifierID(uuid.NewV5(uuid.NamespaceURL, "Timestamp"))
 )
 
 // VerificationStandard makes sure that all links are correct and that the
@@ -129,6 +193,36 @@ var VerificationControl = []VerifierID{VerifyBase, VerifyControl}
 // VerificationData is used in chains that depend on a 'Control'-chain.
 var VerificationData = []VerifierID{VerifyBase, VerifyData}
 
+// VerificationRateLimit is used in chains that accept at most one block per
+// interval. The genesis-block needs to hold a RateLimit in its Data.
+var VerificationRateLimit = []VerifierID{VerifyBase, VerifyRateLimit}
+
+// VerificationRosterAllowlist is used in chains whose rosters may only hold
+// the conodes given at creation. The genesis-block needs to hold a
+// RosterAllowlist in its Data.
+var VerificationRosterAllowlist = []VerifierID{VerifyBase, VerifyRosterAllowlist}
+
+// VerificationSchema is used in chains whose data must conform to a JSON
+// schema. The genesis-block needs to hold the schema in its Data.
+var VerificationSchema = []VerifierID{VerifyBase, VerifySchema}
+
+// VerificationExternalData is used in chains whose blocks only hold a
+// reference to external data. The Data of every block needs to hold an
+// ExternalData.
+var VerificationExternalData = []VerifierID{VerifyBase, VerifyExternalData}
+
+// VerificationChildBinding is used in child-chains that must stay bound to
+// the block of the parent-chain that registered them.
+var VerificationChildBinding = []VerifierID{VerifyBase, VerifyChildBinding}
+
+// VerificationRosterChange is used in chains whose roster may only change
+// gradually.
+var VerificationRosterChange = []VerifierID{VerifyBase, VerifyRosterChange}
+
+// VerificationTimestamp is used in chains whose entries may not be
+// back-dated. The Data of every block needs to hold a TimestampedData.
+var VerificationTimestamp = []VerifierID{VerifyBase, VerifyTimestamp}
+
 // VerificationNone is mostly used for test - it allows for nearly every new
 // block to be appended.
 var VerificationNone = []VerifierID{}
@@ -157,8 +251,50 @@ type SkipBlockFix struct {
 	GenesisID SkipBlockID
 	// Data is any data to be stored in that SkipBlock
 	Data []byte
+	// DataHash is the SHA-256 hash of Data. It is hashed instead of Data,
+	// so Data can be stored separately from the block.
+	DataHash []byte
+	// DataChunks is the number of chunks the Data is stored in, if it is
+	// too big for one message. Data is empty then, and DataHash is the
+	// hash of the complete Data, which is returned by GetBlockData.
+	DataChunks int
 	// Roster holds the roster-definition of that SkipBlock
 	Roster *onet.Roster
+	// Timestamp is set by the leader when the block is created, in
+	// nanoseconds since the Unix epoch.
+	Timestamp int64
+}
+
+// RateLimit is stored in the Data of the genesis-block of a chain using
+// VerifyRateLimit.
+type RateLimit struct {
+	// Interval is the minimal time between two blocks, in milliseconds.
+	Interval int64
+}
+
+// RosterAllowlist is stored in the Data of the genesis-block of a chain using
+// VerifyRosterAllowlist.
+type RosterAllowlist struct {
+	// IDs of all conodes that may be part of a roster of that chain.
+	IDs []network.ServerIdentityID
+}
+
+// ExternalData is stored in the Data of the blocks of a chain using
+// VerifyExternalData. It points to data stored outside of the skipchain.
+type ExternalData struct {
+	// URL where the data can be fetched.
+	URL string
+	// Hash of the data, using network.Suite.Hash().
+	Hash []byte
+}
+
+// TimestampedData is stored in the Data of the blocks of a chain using
+// VerifyTimestamp.
+type TimestampedData struct {
+	// Timestamp of the entry in nanoseconds since the Unix epoch.
+	Timestamp int64
+	// Data is the payload of the block.
+	Data []byte
 }
 
 // SkipBlockData represents all entries - as maps are not ordered and thus
@@ -211,7 +347,20 @@ func (sbf *SkipBlockFix) CalculateHash() SkipBlockID {
 	}
 	hash.Write(sbf.ParentBlockID)
 	hash.Write(sbf.GenesisID)
-	hash.Write(sbf.Data)
+	// Blocks created before DataHash and DataChunks have been added are
+	// hashed over their Data, so that their hash doesn't change.
+	if len(sbf.DataHash) == 0 && sbf.DataChunks == 0 {
+		hash.Write(sbf.Data)
+	} else {
+		hash.Write(sbf.DataHash)
+	}
+	if sbf.DataChunks > 0 {
+		binary.Write(hash, binary.LittleEndian, int64(sbf.DataChunks))
+	}
+	// Blocks created before Timestamp has been added don't hash it.
+	if sbf.Timestamp != 0 {
+		binary.Write(hash, binary.LittleEndian, sbf.Timestamp)
+	}
 	if sbf.Roster != nil {
 		for _, pub := range sbf.Roster.Publics() {
 			pub.MarshalTo(hash)
@@ -221,6 +370,58 @@ func (sbf *SkipBlockFix) CalculateHash() SkipBlockID {
 	return buf
 }
 
+// VerifyDataHash returns an error if DataHash is set but doesn't match the
+// hash of Data. If the Data is stored in chunks, the block must not hold
+// any Data.
+func (sbf *SkipBlockFix) VerifyDataHash() error {
+	if sbf.DataChunks > 0 {
+		if len(sbf.Data) > 0 {
+			return errors.New("block with chunks must not hold data")
+		}
+		return nil
+	}
+	if len(sbf.DataHash) == 0 {
+		return nil
+	}
+	hash := sha256.Sum256(sbf.Data)
+	if !bytes.Equal(hash[:], sbf.DataHash) {
+		return errors.New("data doesn't match DataHash")
+	}
+	return nil
+}
+
+// VerifyHash returns an error if the Hash of the block doesn't match its
+// content.
+func (sb *SkipBlock) VerifyHash() error {
+	if !sb.Hash.Equal(sb.CalculateHash()) {
+		return errors.New("hash doesn't match block")
+	}
+	return sb.VerifyDataHash()
+}
+
+// VerifyChildren returns an error if an entry of ChildSL has not been
+// authorized by the roster of the block.
+func (sb *SkipBlock) VerifyChildren() error {
+	return sb.verifyChildren(sb.Roster.Publics(), 0)
+}
+
+// verifyChildren returns an error if an entry of ChildSL, starting at from,
+// has not been signed by publics.
+func (sb *SkipBlock) verifyChildren(publics []abstract.Point, from int) error {
+	for i := from; i < len(sb.ChildSL); i++ {
+		if i >= len(sb.ChildAuth) || sb.ChildAuth[i] == nil {
+			return errors.New("child has not been authorized")
+		}
+		if !sb.ChildAuth[i].Hash.Equal(sb.ChildSL[i]) {
+			return errors.New("authorization is for another child")
+		}
+		if err := sb.ChildAuth[i].VerifySignature(publics); err != nil {
+			return errors.New("wrong authorization of child: " + err.Error())
+		}
+	}
+	return nil
+}
+
 // SkipBlock represents a SkipBlock of any type - the fields that won't
 // be hashed (yet).
 type SkipBlock struct {
@@ -234,6 +435,34 @@ type SkipBlock struct {
 	// SkipLists that depend on us, given as the first SkipBlock - can
 	// be a Data or a Roster SkipBlock
 	ChildSL []SkipBlockID
+	// ChildAuth holds for every entry of ChildSL the collective signature
+	// of our roster that authorized the child.
+	ChildAuth []*BlockLink
+	// Attachment is a payload that is stored and propagated with the
+	// block, but not hashed. To protect its integrity, the Data of the
+	// block must hold HashAttachment(Attachment). It is not returned with
+	// the block, but only with GetAttachment.
+	Attachment []byte
+}
+
+// HashAttachment returns the hash of the attachment, to be stored in the
+// Data of the block.
+func HashAttachment(attachment []byte) []byte {
+	hash := network.Suite.Hash()
+	hash.Write(attachment)
+	return hash.Sum(nil)
+}
+
+// VerifyAttachment returns an error if the block has an attachment whose
+// hash is not found in the Data of the block.
+func (sb *SkipBlock) VerifyAttachment() error {
+	if sb.Attachment == nil {
+		return nil
+	}
+	if !bytes.Contains(sb.Data, HashAttachment(sb.Attachment)) {
+		return errors.New("hash of attachment not found in data")
+	}
+	return nil
 }
 
 // NewSkipBlock pre-initialises the block so it can be sent over
@@ -262,28 +491,153 @@ func (sb *SkipBlock) Equal(other *SkipBlock) bool {
 	return bytes.Equal(sb.Hash, other.Hash)
 }
 
+// Diff returns a human-readable description of every field that differs
+// between the two blocks. If both blocks are the same, an empty slice is
+// returned.
+func (sb *SkipBlock) Diff(other *SkipBlock) []string {
+	var diff []string
+	addInt := func(name string, a, b int64) {
+		if a != b {
+			diff = append(diff, fmt.Sprintf("%s: %d != %d", name, a, b))
+		}
+	}
+	addBytes := func(name string, a, b []byte) {
+		if !bytes.Equal(a, b) {
+			diff = append(diff, fmt.Sprintf("%s: %x != %x", name, a, b))
+		}
+	}
+	addIDs := func(name string, a, b []SkipBlockID) {
+		if len(a) != len(b) {
+			diff = append(diff, fmt.Sprintf("%s: length %d != %d", name,
+				len(a), len(b)))
+			return
+		}
+		for i := range a {
+			addBytes(fmt.Sprintf("%s[%d]", name, i), a[i], b[i])
+		}
+	}
+	addInt("Index", int64(sb.Index), int64(other.Index))
+	addInt("Height", int64(sb.Height), int64(other.Height))
+	addInt("MaximumHeight", int64(sb.MaximumHeight), int64(other.MaximumHeight))
+	addInt("BaseHeight", int64(sb.BaseHeight), int64(other.BaseHeight))
+	addIDs("BackLinkIDs", sb.BackLinkIDs, other.BackLinkIDs)
+	if len(sb.VerifierIDs) != len(other.VerifierIDs) {
+		diff = append(diff, fmt.Sprintf("VerifierIDs: length %d != %d",
+			len(sb.VerifierIDs), len(other.VerifierIDs)))
+	} else {
+		for i := range sb.VerifierIDs {
+			if !sb.VerifierIDs[i].Equal(other.VerifierIDs[i]) {
+				diff = append(diff, fmt.Sprintf("VerifierIDs[%d]: %s != %s",
+					i, sb.VerifierIDs[i], other.VerifierIDs[i]))
+			}
+		}
+	}
+	addBytes("ParentBlockID", sb.ParentBlockID, other.ParentBlockID)
+	addBytes("GenesisID", sb.GenesisID, other.GenesisID)
+	addBytes("Data", sb.Data, other.Data)
+	addBytes("DataHash", sb.DataHash, other.DataHash)
+	addInt("DataChunks", int64(sb.DataChunks), int64(other.DataChunks))
+	if r1, r2 := rosterString(sb.Roster), rosterString(other.Roster); r1 != r2 {
+		diff = append(diff, fmt.Sprintf("Roster: %s != %s", r1, r2))
+	}
+	addInt("Timestamp", sb.Timestamp, other.Timestamp)
+	addBytes("Hash", sb.Hash, other.Hash)
+	fl1 := make([]SkipBlockID, len(sb.ForwardLink))
+	for i, fl := range sb.ForwardLink {
+		fl1[i] = fl.Hash
+	}
+	fl2 := make([]SkipBlockID, len(other.ForwardLink))
+	for i, fl := range other.ForwardLink {
+		fl2[i] = fl.Hash
+	}
+	addIDs("ForwardLink", fl1, fl2)
+	addIDs("ChildSL", sb.ChildSL, other.ChildSL)
+	addBytes("Attachment", sb.Attachment, other.Attachment)
+	return diff
+}
+
+// rosterString returns the addresses of the conodes in the roster.
+func rosterString(r *onet.Roster) string {
+	if r == nil {
+		return "nil"
+	}
+	addresses := make([]string, len(r.List))
+	for i, si := range r.List {
+		addresses[i] = si.Address.String()
+	}
+	return "[" + strings.Join(addresses, " ") + "]"
+}
+
 // Copy makes a deep copy of the SkipBlock
 func (sb *SkipBlock) Copy() *SkipBlock {
 	if sb == nil {
 		return nil
 	}
 	sbf := *sb.SkipBlockFix
+	sbf.BackLinkIDs = copyIDs(sb.BackLinkIDs)
+	sbf.VerifierIDs = make([]VerifierID, len(sb.VerifierIDs))
+	copy(sbf.VerifierIDs, sb.VerifierIDs)
+	sbf.ParentBlockID = SkipBlockID(copyBytes(sb.ParentBlockID))
+	sbf.GenesisID = SkipBlockID(copyBytes(sb.GenesisID))
+	sbf.Data = copyBytes(sb.Data)
+	sbf.DataHash = copyBytes(sb.DataHash)
+	if sb.Roster != nil {
+		// The ServerIdentities themselves are never changed, so only
+		// the list is copied.
+		roster := *sb.Roster
+		roster.List = make([]*network.ServerIdentity, len(sb.Roster.List))
+		copy(roster.List, sb.Roster.List)
+		sbf.Roster = &roster
+	}
 	b := &SkipBlock{
 		SkipBlockFix: &sbf,
 		Hash:         make([]byte, len(sb.Hash)),
 		ForwardLink:  make([]*BlockLink, len(sb.ForwardLink)),
-		ChildSL:      make([]SkipBlockID, len(sb.ChildSL)),
+		ChildSL:      copyIDs(sb.ChildSL),
+		ChildAuth:    make([]*BlockLink, len(sb.ChildAuth)),
+		Attachment:   copyBytes(sb.Attachment),
 	}
 	for i, fl := range sb.ForwardLink {
 		b.ForwardLink[i] = fl.Copy()
 	}
-	copy(b.ChildSL, sb.ChildSL)
+	for i, ca := range sb.ChildAuth {
+		b.ChildAuth[i] = ca.Copy()
+	}
 	copy(b.Hash, sb.Hash)
-	b.VerifierIDs = make([]VerifierID, len(sb.VerifierIDs))
-	copy(b.VerifierIDs, sb.VerifierIDs)
 	return b
 }
 
+// copyBytes returns a copy of buf, or nil if buf is nil.
+func copyBytes(buf []byte) []byte {
+	if buf == nil {
+		return nil
+	}
+	c := make([]byte, len(buf))
+	copy(c, buf)
+	return c
+}
+
+// copyIDs returns a deep copy of ids.
+func copyIDs(ids []SkipBlockID) []SkipBlockID {
+	c := make([]SkipBlockID, len(ids))
+	for i, id := range ids {
+		c[i] = SkipBlockID(copyBytes(id))
+	}
+	return c
+}
+
+// Header returns a copy of the block without Attachment. The Data is only
+// removed if DataHash is set, as the hash of older blocks covers the Data
+// itself.
+func (sb *SkipBlock) Header() *SkipBlock {
+	h := sb.Copy()
+	if len(h.DataHash) > 0 {
+		h.Data = nil
+	}
+	h.Attachment = nil
+	return h
+}
+
 // Short returns only the 8 first bytes of the hash as hex-encoded string.
 func (sb *SkipBlock) Short() string {
 	return sb.Hash.Short()
@@ -332,14 +686,35 @@ func (sb *SkipBlock) GetForwardLen() int {
 }
 
 func (sb *SkipBlock) updateHash() SkipBlockID {
+	if sb.DataChunks == 0 {
+		hash := sha256.Sum256(sb.Data)
+		sb.DataHash = hash[:]
+	}
 	sb.Hash = sb.CalculateHash()
 	return sb.Hash
 }
 
+// SignatureScheme indicates how the signature of a BlockLink has been
+// created and how it is verified.
+type SignatureScheme int
+
+const (
+	// SigSchemeUnset is the scheme of links stored before the scheme was
+	// added. They are verified with all schemes below.
+	SigSchemeUnset SignatureScheme = iota
+	// SigSchemeBFTCoSi is a bftcosi-signature of the roster. For a roster
+	// with a single conode, it is a Schnorr-signature.
+	SigSchemeBFTCoSi
+	// SigSchemeQuorum is a marshalled QuorumSignature.
+	SigSchemeQuorum
+)
+
 // BlockLink has the hash and a signature of a block
 type BlockLink struct {
 	Hash      SkipBlockID
 	Signature []byte
+	// SigScheme is the scheme used to create Signature.
+	SigScheme SignatureScheme
 }
 
 // Copy makes a deep copy of a blocklink
@@ -347,25 +722,166 @@ func (bl *BlockLink) Copy() *BlockLink {
 	sigCopy := make([]byte, len(bl.Signature))
 	copy(sigCopy, bl.Signature)
 	return &BlockLink{
-		Hash:      bl.Hash,
+		Hash:      SkipBlockID(copyBytes(bl.Hash)),
 		Signature: sigCopy,
+		SigScheme: bl.SigScheme,
 	}
 }
 
 // VerifySignature returns whether the BlockLink has been signed
-// correctly using the given list of public keys.
+// correctly using the given list of public keys. The verification depends
+// on the SigScheme of the link.
 func (bl *BlockLink) VerifySignature(publics []abstract.Point) error {
 	if len(bl.Signature) == 0 {
 		return errors.New("No signature present" + log.Stack())
 	}
+	switch bl.SigScheme {
+	case SigSchemeUnset:
+		err := bl.verifyBFTCoSi(publics)
+		if err != nil && verifyQuorum(publics, bl.Hash, bl.Signature) == nil {
+			return nil
+		}
+		return err
+	case SigSchemeBFTCoSi:
+		return bl.verifyBFTCoSi(publics)
+	case SigSchemeQuorum:
+		return verifyQuorum(publics, bl.Hash, bl.Signature)
+	}
+	return fmt.Errorf("unknown signature scheme %d", bl.SigScheme)
+}
+
+// verifyBFTCoSi verifies a signature of the SigSchemeBFTCoSi.
+func (bl *BlockLink) verifyBFTCoSi(publics []abstract.Point) error {
+	if len(publics) == 1 && crypto.VerifySchnorr(network.Suite, publics[0],
+		bl.Hash, crypto.SchnorrSig(bl.Signature)) == nil {
+		return nil
+	}
 	return cosi.VerifySignature(network.Suite, publics, bl.Hash, bl.Signature)
 }
 
+// VerifyForwardLink returns an error if the link has not been signed by the
+// roster of prev, or if it doesn't point to a block following prev. It can
+// be used by clients to audit every hop of an update-chain.
+func VerifyForwardLink(prev *SkipBlock, link *BlockLink) error {
+	if prev == nil || link == nil {
+		return errors.New("missing block or link")
+	}
+	if prev.Roster == nil {
+		return errors.New("block " + prev.Short() + " has no roster")
+	}
+	if link.Hash.IsNull() || link.Hash.Equal(prev.Hash) {
+		return errors.New("forward-link of " + prev.Short() + " has an invalid target")
+	}
+	if err := link.VerifySignature(prev.Roster.Publics()); err != nil {
+		return errors.New("forward-link of " + prev.Short() +
+			" is not signed by its roster: " + err.Error())
+	}
+	return nil
+}
+
+// VerifyHop returns an error if next is not correctly linked from prev: the
+// hash of next must be correct, prev must have a forward-link to next that
+// is verified by VerifyForwardLink, and next must have a back-link to prev.
+func VerifyHop(prev, next *SkipBlock) error {
+	if prev == nil || next == nil {
+		return errors.New("missing block")
+	}
+	if !next.Hash.Equal(next.CalculateHash()) {
+		return errors.New("block " + next.Short() + " has a wrong hash")
+	}
+	var link *BlockLink
+	for _, fl := range prev.ForwardLink {
+		if fl.Hash.Equal(next.Hash) {
+			link = fl
+			break
+		}
+	}
+	if link == nil {
+		return errors.New("block " + prev.Short() + " has no forward-link to " +
+			next.Short())
+	}
+	if err := VerifyForwardLink(prev, link); err != nil {
+		return err
+	}
+	for _, bl := range next.BackLinkIDs {
+		if bl.Equal(prev.Hash) {
+			return nil
+		}
+	}
+	return errors.New("block " + next.Short() + " has no back-link to " +
+		prev.Short())
+}
+
 // SkipBlockMap holds the map to the skipblocks. This is used for verification,
 // so that all links can be followed.
 type SkipBlockMap struct {
 	SkipBlocks map[string]*SkipBlock
 	sync.Mutex
+	// db, if set, holds all skipblocks and SkipBlocks is only a cache of
+	// the skipblocks accessed so far.
+	db BlockDB
+}
+
+// getLocked returns the stored skipblock, loading it from the BlockDB if
+// it is not cached. The caller has to hold the lock.
+func (sbm *SkipBlockMap) getLocked(sbID SkipBlockID) *SkipBlock {
+	sb, ok := sbm.SkipBlocks[string(sbID)]
+	if ok || sbm.db == nil {
+		return sb
+	}
+	sb, err := sbm.db.Get(sbID)
+	if err != nil {
+		log.Error("Couldn't read block from db:", err)
+		return nil
+	}
+	if sb != nil {
+		sbm.cacheLocked(sb)
+	}
+	return sb
+}
+
+// cacheLocked adds sb to SkipBlocks. If a BlockDB is used and the cache is
+// full, another skipblock is removed from the cache. The caller has to hold
+// the lock.
+func (sbm *SkipBlockMap) cacheLocked(sb *SkipBlock) {
+	if sbm.db != nil && len(sbm.SkipBlocks) >= maxCachedBlocks {
+		for id := range sbm.SkipBlocks {
+			delete(sbm.SkipBlocks, id)
+			break
+		}
+	}
+	sbm.SkipBlocks[string(sb.Hash)] = sb
+}
+
+// all returns all skipblocks, reading them from the BlockDB if one is set.
+// The skipblocks read from the BlockDB are not cached.
+func (sbm *SkipBlockMap) all() []*SkipBlock {
+	sbm.Lock()
+	defer sbm.Unlock()
+	return sbm.allLocked()
+}
+
+// allLocked is the same as all, but the caller must hold the lock.
+func (sbm *SkipBlockMap) allLocked() []*SkipBlock {
+	if sbm.db == nil {
+		blocks := make([]*SkipBlock, 0, len(sbm.SkipBlocks))
+		for _, sb := range sbm.SkipBlocks {
+			blocks = append(blocks, sb)
+		}
+		return blocks
+	}
+	var blocks []*SkipBlock
+	err := sbm.db.ForEach(func(sb *SkipBlock) error {
+		if cached, ok := sbm.SkipBlocks[string(sb.Hash)]; ok {
+			sb = cached
+		}
+		blocks = append(blocks, sb)
+		return nil
+	})
+	if err != nil {
+		log.Error("Couldn't read blocks from db:", err)
+	}
+	return blocks
 }
 
 // NewSkipBlockMap returns a pre-initialised SkipBlockMap.
@@ -377,14 +893,21 @@ func NewSkipBlockMap() *SkipBlockMap {
 func (sbm *SkipBlockMap) GetByID(sbID SkipBlockID) *SkipBlock {
 	sbm.Lock()
 	defer sbm.Unlock()
-	return sbm.SkipBlocks[string(sbID)].Copy()
+	return sbm.getLocked(sbID).Copy()
 }
 
 // Store stores the given SkipBlock in the service-list
 func (sbm *SkipBlockMap) Store(sb *SkipBlock) SkipBlockID {
 	sbm.Lock()
 	defer sbm.Unlock()
-	if sbOld, exists := sbm.SkipBlocks[string(sb.Hash)]; exists {
+	return sbm.storeLocked(sb)
+}
+
+// storeLocked is the same as Store, but the caller must hold the lock.
+func (sbm *SkipBlockMap) storeLocked(sb *SkipBlock) SkipBlockID {
+	sbOld := sbm.getLocked(sb.Hash)
+	stored := sbOld
+	if sbOld != nil {
 		// If this skipblock already exists, only copy forward-links and
 		// new children.
 		if len(sb.ForwardLink) > len(sbOld.ForwardLink) {
@@ -397,19 +920,189 @@ func (sbm *SkipBlockMap) Store(sb *SkipBlock) SkipBlockID {
 			}
 		}
 		if len(sb.ChildSL) > len(sbOld.ChildSL) {
-			sbOld.ChildSL = append(sbOld.ChildSL, sb.ChildSL[len(sbOld.ChildSL):]...)
+			from := len(sbOld.ChildSL)
+			if err := sb.verifyChildren(sbOld.Roster.Publics(), from); err != nil {
+				log.Error("Got a known block with a wrong child:", err)
+				return nil
+			}
+			// Children stored before their authorization was kept
+			// have none.
+			for len(sbOld.ChildAuth) < from {
+				sbOld.ChildAuth = append(sbOld.ChildAuth, nil)
+			}
+			sbOld.ChildSL = append(sbOld.ChildSL, sb.ChildSL[from:]...)
+			sbOld.ChildAuth = append(sbOld.ChildAuth, sb.ChildAuth[from:len(sb.ChildSL)]...)
+		}
+		if sbOld.Attachment == nil && sb.VerifyAttachment() == nil {
+			sbOld.Attachment = sb.Attachment
 		}
 	} else {
-		sbm.SkipBlocks[string(sb.Hash)] = sb
+		if err := sb.VerifyAttachment(); err != nil {
+			log.Error("Dropping attachment:", err)
+			sb.Attachment = nil
+		}
+		sbm.cacheLocked(sb)
+		stored = sb
+	}
+	if sbm.db != nil {
+		if err := sbm.db.Store(stored); err != nil {
+			log.Error("Couldn't write block to db:", err)
+			return nil
+		}
 	}
 	return sb.Hash
 }
 
+// restore stores all blocks if the map is empty. The check and the storing
+// are done under the same lock, so no other block can be stored in between.
+func (sbm *SkipBlockMap) restore(blocks map[string]*SkipBlock) error {
+	sbm.Lock()
+	defer sbm.Unlock()
+	if sbm.lengthLocked() > 0 {
+		return errors.New("can only restore into an empty service")
+	}
+	for _, sb := range blocks {
+		if sbm.storeLocked(sb) == nil {
+			return errors.New("couldn't store block")
+		}
+	}
+	return nil
+}
+
+// CheckConsistency verifies for all stored skipblocks of the skipchain with
+// the given genesis-id that back-links and forward-links agree: if block B
+// has A in BackLinkIDs[h], then ForwardLink[h] of A, if present, points to
+// B, and vice versa. Links to blocks that are not stored are ignored.
+func (sbm *SkipBlockMap) CheckConsistency(genesis SkipBlockID) error {
+	blocks := map[string]*SkipBlock{}
+	for _, sb := range sbm.chainBlocks(genesis) {
+		blocks[string(sb.Hash)] = sb
+	}
+	if len(blocks) == 0 {
+		return errors.New("unknown skipchain")
+	}
+	for _, sb := range blocks {
+		if sb.Index > 0 {
+			for h, bl := range sb.BackLinkIDs {
+				prev, ok := blocks[string(bl)]
+				if !ok || h >= len(prev.ForwardLink) {
+					continue
+				}
+				if !prev.ForwardLink[h].Hash.Equal(sb.Hash) {
+					return fmt.Errorf("block %d has back-link %d to block %d, "+
+						"but its forward-link %d points to %x", sb.Index, h,
+						prev.Index, h, []byte(prev.ForwardLink[h].Hash))
+				}
+			}
+		}
+		for h, fl := range sb.ForwardLink {
+			next, ok := blocks[string(fl.Hash)]
+			if !ok {
+				continue
+			}
+			if h >= len(next.BackLinkIDs) || !next.BackLinkIDs[h].Equal(sb.Hash) {
+				return fmt.Errorf("block %d has forward-link %d to block %d, "+
+					"but its back-link %d doesn't point back", sb.Index, h,
+					next.Index, h)
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveChain deletes all skipblocks of the skipchain with the given
+// genesis-id, including the genesis-block, and returns the number of removed
+// skipblocks.
+func (sbm *SkipBlockMap) RemoveChain(genesis SkipBlockID) int {
+	var ids []SkipBlockID
+	for _, sb := range sbm.chainBlocks(genesis) {
+		ids = append(ids, sb.Hash)
+	}
+	sbm.Lock()
+	defer sbm.Unlock()
+	for _, id := range ids {
+		delete(sbm.SkipBlocks, string(id))
+		if sbm.db != nil {
+			if err := sbm.db.Delete(id); err != nil {
+				log.Error("Couldn't delete block from db:", err)
+			}
+		}
+	}
+	return len(ids)
+}
+
 // Length returns the actual length using mutexes
 func (sbm *SkipBlockMap) Length() int {
 	sbm.Lock()
 	defer sbm.Unlock()
-	return len(sbm.SkipBlocks)
+	return sbm.lengthLocked()
+}
+
+// lengthLocked is the same as Length, but the caller must hold the lock.
+func (sbm *SkipBlockMap) lengthLocked() int {
+	if sbm.db == nil {
+		return len(sbm.SkipBlocks)
+	}
+	count, err := sbm.db.Count()
+	if err != nil {
+		log.Error("Couldn't count blocks in db:", err)
+	}
+	return count
+}
+
+// chains returns the IDs of all skipchains with stored skipblocks.
+func (sbm *SkipBlockMap) chains() []SkipBlockID {
+	sbm.Lock()
+	defer sbm.Unlock()
+	if sbm.db != nil {
+		ids, err := sbm.db.Chains()
+		if err != nil {
+			log.Error("Couldn't read chains from db:", err)
+		}
+		return ids
+	}
+	seen := map[string]bool{}
+	var ids []SkipBlockID
+	for _, sb := range sbm.SkipBlocks {
+		id := sb.SkipChainID()
+		if !seen[string(id)] {
+			seen[string(id)] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// chainBlocks returns all stored skipblocks of the skipchain with the given
+// ID. As with all, the skipblocks read from the BlockDB are not cached.
+func (sbm *SkipBlockMap) chainBlocks(genesis SkipBlockID) []*SkipBlock {
+	sbm.Lock()
+	defer sbm.Unlock()
+	var blocks []*SkipBlock
+	if sbm.db == nil {
+		for _, sb := range sbm.SkipBlocks {
+			if sb.SkipChainID().Equal(genesis) {
+				blocks = append(blocks, sb)
+			}
+		}
+		return blocks
+	}
+	ids, err := sbm.db.ChainBlocks(genesis)
+	if err != nil {
+		log.Error("Couldn't read chain from db:", err)
+	}
+	for _, id := range ids {
+		sb, ok := sbm.SkipBlocks[string(id)]
+		if !ok {
+			sb, err = sbm.db.Get(id)
+			if err != nil || sb == nil {
+				log.Error("Couldn't read block from db:", err)
+				continue
+			}
+		}
+		blocks = append(blocks, sb)
+	}
+	return blocks
 }
 
 // GetResponsible searches for the block that is responsible for sb
@@ -497,6 +1190,30 @@ func (sbm *SkipBlockMap) VerifyLinks(sb *SkipBlock) error {
 	return nil
 }
 
+// verifySnapshot returns an error if one of the stored blocks has a wrong
+// hash, an invalid forward-signature, or if its previous block is missing.
+func (sbm *SkipBlockMap) verifySnapshot() error {
+	for key, sb := range sbm.SkipBlocks {
+		if sb == nil || sb.SkipBlockFix == nil {
+			return errors.New("empty skipblock")
+		}
+		if !sb.Hash.Equal(SkipBlockID(key)) ||
+			!sb.Hash.Equal(sb.CalculateHash()) {
+			return errors.New("wrong hash for block " + sb.Short())
+		}
+		if err := sb.VerifyForwardSignatures(); err != nil {
+			return errors.New("wrong forward-signature in block " +
+				sb.Short() + ": " + err.Error())
+		}
+		if sb.Index > 0 && len(sb.BackLinkIDs) > 0 {
+			if _, ok := sbm.SkipBlocks[string(sb.BackLinkIDs[0])]; !ok {
+				return errors.New("missing previous block of " + sb.Short())
+			}
+		}
+	}
+	return nil
+}
+
 // GetLatest searches for the latest available block for that skipblock.
 func (sbm *SkipBlockMap) GetLatest(sb *SkipBlock) (*SkipBlock, error) {
 	latest := sb
@@ -509,6 +1226,84 @@ func (sbm *SkipBlockMap) GetLatest(sb *SkipBlock) (*SkipBlock, error) {
 	return latest, nil
 }
 
+// NewSyntheticChain returns nbrBlocks blocks with the heights and
+// forward-links of a skipchain with the given base and maximum height. The
+// blocks have no roster and the forward-links are not signed, so it is only
+// useful for tests and simulations of the skip-links.
+func NewSyntheticChain(base, maxHeight, nbrBlocks int) []*SkipBlock {
+	blocks := make([]*SkipBlock, nbrBlocks)
+	for i := range blocks {
+		sb := NewSkipBlock()
+		sb.Index = i
+		sb.BaseHeight = base
+		sb.MaximumHeight = maxHeight
+		sb.Height = maxHeight
+		if i > 0 {
+			sb.GenesisID = blocks[0].Hash
+			index := i
+			for sb.Height = 1; index%base == 0 && sb.Height < maxHeight; sb.Height++ {
+				index /= base
+			}
+		}
+		sb.Data = []byte(strconv.Itoa(i))
+		sb.Hash = sb.CalculateHash()
+		blocks[i] = sb
+	}
+	for i, sb := range blocks {
+		for h := 0; h < sb.Height; h++ {
+			for j := i + 1; j < nbrBlocks; j++ {
+				if blocks[j].Height > h {
+					sb.ForwardLink = append(sb.ForwardLink,
+						&BlockLink{Hash: blocks[j].Hash})
+					break
+				}
+			}
+		}
+	}
+	return blocks
+}
+
+// ProofLength returns the number of forward-links that need to be followed
+// to get from the block from to the block to, always taking the highest
+// forward-link that doesn't jump over to. It returns an error if to cannot be
+// reached using the stored blocks.
+func (sbm *SkipBlockMap) ProofLength(from, to *SkipBlock) (int, error) {
+	proof, err := sbm.GetProof(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return len(proof) - 1, nil
+}
+
+// GetProof returns the blocks from the block from to the block to, always
+// following the highest forward-link that doesn't jump over to. The
+// forward-links of the returned blocks prove that to is part of the skipchain
+// of from. It returns an error if to cannot be reached using the stored
+// blocks.
+func (sbm *SkipBlockMap) GetProof(from, to *SkipBlock) ([]*SkipBlock, error) {
+	if from.Index > to.Index {
+		return nil, errors.New("from-block is after to-block")
+	}
+	sb := from
+	proof := []*SkipBlock{sb}
+	for !sb.Hash.Equal(to.Hash) {
+		var next *SkipBlock
+		for h := sb.GetForwardLen() - 1; h >= 0; h-- {
+			next = sbm.GetByID(sb.GetForward(h).Hash)
+			if next != nil && next.Index <= to.Index {
+				break
+			}
+			next = nil
+		}
+		if next == nil {
+			return nil, errors.New("to-block is not reachable")
+		}
+		sb = next
+		proof = append(proof, sb)
+	}
+	return proof, nil
+}
+
 // GetFuzzy searches for a block that resembles the given ID, if ID is not full.
 // If there are multiple matching skipblocks, the first one is chosen. If none
 // match, nil will be returned.
@@ -518,17 +1313,18 @@ func (sbm *SkipBlockMap) GetLatest(sb *SkipBlock) (*SkipBlock, error) {
 //  2. as suffix - if none is found
 //  3. anywhere
 func (sbm *SkipBlockMap) GetFuzzy(id string) *SkipBlock {
-	for _, sb := range sbm.SkipBlocks {
+	blocks := sbm.all()
+	for _, sb := range blocks {
 		if strings.HasPrefix(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
 	}
-	for _, sb := range sbm.SkipBlocks {
+	for _, sb := range blocks {
 		if strings.HasSuffix(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
 	}
-	for _, sb := range sbm.SkipBlocks {
+	for _, sb := range blocks {
 		if strings.Contains(hex.EncodeToString(sb.Hash), id) {
 			return sb
 		}
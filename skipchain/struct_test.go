@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"crypto/sha512"
+	"encoding/hex"
 
 	"bytes"
 
@@ -77,6 +78,74 @@ func TestSkipBlock_VerifySignatures(t *testing.T) {
 	require.NotNil(t, sbm.VerifyLinks(block1))
 }
 
+func TestVerifyForwardLink(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(5, skipchainSID)
+	service := genService.(*Service)
+	genesis := signedGenesis(t, service, onet.NewRoster(el.List[0:3]))
+	next := service.Sbm.GetByID(genesis.ForwardLink[0].Hash)
+	require.NotNil(t, next)
+
+	log.Lvl2("Valid link")
+	require.Nil(t, VerifyForwardLink(genesis, genesis.ForwardLink[0]))
+	require.Nil(t, VerifyHop(genesis, next))
+
+	log.Lvl2("Link signed by the wrong roster")
+	wrong := genesis.Copy()
+	wrong.Roster = onet.NewRoster(el.List[2:5])
+	require.NotNil(t, VerifyForwardLink(wrong, wrong.ForwardLink[0]))
+
+	log.Lvl2("Link with a mismatched target")
+	other := next.Copy()
+	other.Data = []byte("other")
+	other.Hash = other.CalculateHash()
+	require.NotNil(t, VerifyHop(genesis, other))
+	link := genesis.ForwardLink[0].Copy()
+	link.Hash = other.Hash
+	require.NotNil(t, VerifyForwardLink(genesis, link))
+	link.Hash = genesis.Hash
+	require.NotNil(t, VerifyForwardLink(genesis, link))
+}
+
+func TestSkipBlockMap_CheckConsistency(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer waitPropagationFinished(t, local)
+	defer local.CloseAll()
+	_, el, genService := local.MakeHELS(1, skipchainSID)
+	service := genService.(*Service)
+	genesis, err := makeGenesisRosterArgs(service, el, nil, VerificationNone, 2, 3)
+	log.ErrFatal(err)
+	latest := genesis
+	for i := 0; i < 8; i++ {
+		ssbr, cerr := service.StoreSkipBlock(&StoreSkipBlock{LatestID: latest.Hash,
+			NewBlock: newBlockRoster(el)})
+		log.ErrFatal(cerr)
+		latest = ssbr.Latest
+	}
+	log.ErrFatal(service.Sbm.CheckConsistency(genesis.Hash))
+	require.NotNil(t, service.Sbm.CheckConsistency(SkipBlockID{1, 2, 3}))
+
+	var blocks []*SkipBlock
+	for sb := service.Sbm.GetByID(genesis.Hash); sb != nil; {
+		blocks = append(blocks, sb)
+		if sb.GetForwardLen() == 0 {
+			break
+		}
+		sb = service.Sbm.GetByID(sb.GetForward(0).Hash)
+	}
+	require.Equal(t, 9, len(blocks))
+	blocks[2].ForwardLink[0].Hash = blocks[4].Hash
+	sbm := NewSkipBlockMap()
+	for _, sb := range blocks {
+		sbm.Store(sb)
+	}
+	err = sbm.CheckConsistency(genesis.Hash)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "forward-link 0")
+}
+
 func TestSkipBlock_Hash1(t *testing.T) {
 	sbd1 := NewSkipBlock()
 	sbd1.Data = []byte("1")
@@ -108,6 +177,32 @@ func TestSkipBlock_Hash2(t *testing.T) {
 	assert.NotEqual(t, h1, h2)
 }
 
+func TestSkipBlock_HashLegacy(t *testing.T) {
+	// A block holding only the fields of the first SkipBlockFix must keep
+	// its hash.
+	sb := NewSkipBlock()
+	sb.Index = 1
+	sb.Height = 2
+	sb.MaximumHeight = 2
+	sb.BaseHeight = 2
+	sb.BackLinkIDs = []SkipBlockID{SkipBlockID("back")}
+	sb.VerifierIDs = []VerifierID{VerifyBase}
+	sb.ParentBlockID = SkipBlockID("parent")
+	sb.GenesisID = SkipBlockID("genesis")
+	sb.Data = []byte("data")
+	require.Equal(t, "41b4e428f5fa6faa84e87a44a5eb92712ffce7c5df668a54857488624c8d67d8",
+		hex.EncodeToString(sb.CalculateHash()))
+	sb.Hash = sb.CalculateHash()
+	require.Nil(t, sb.VerifyHash())
+	require.Equal(t, sb.Data, sb.Header().Data)
+
+	// New blocks are hashed over DataHash.
+	h := sb.updateHash()
+	require.NotEqual(t, "41b4e428f5fa6faa84e87a44a5eb92712ffce7c5df668a54857488624c8d67d8",
+		hex.EncodeToString(h))
+	require.True(t, sb.Header().Hash.Equal(sb.Header().CalculateHash()))
+}
+
 func TestBlockLink_Copy(t *testing.T) {
 	// Test if copy is deep or only shallow
 	b1 := &BlockLink{}
@@ -133,6 +228,63 @@ func TestBlockLink_Copy(t *testing.T) {
 	}
 }
 
+func TestSkipBlock_CopyDeep(t *testing.T) {
+	local := onet.NewLocalTest()
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(2, false)
+	leader := roster.List[0]
+
+	sb := NewSkipBlock()
+	sb.Index = 1
+	sb.BackLinkIDs = []SkipBlockID{{1, 2}}
+	sb.VerifierIDs = VerificationStandard
+	sb.ParentBlockID = SkipBlockID{3}
+	sb.GenesisID = SkipBlockID{4}
+	sb.Data = []byte{5}
+	sb.DataHash = []byte{6}
+	sb.Roster = roster
+	sb.Hash = SkipBlockID{7}
+	sb.ForwardLink = []*BlockLink{{Hash: SkipBlockID{8}, Signature: []byte{9}}}
+	sb.ChildSL = []SkipBlockID{{10}}
+	sb.ChildAuth = []*BlockLink{{Hash: SkipBlockID{10}, Signature: []byte{12}}}
+	sb.Attachment = []byte{11}
+	orig := sb.Copy()
+
+	c := sb.Copy()
+	c.Index = 2
+	c.BackLinkIDs[0][0] = 0
+	c.BackLinkIDs = append(c.BackLinkIDs, SkipBlockID{0})
+	c.VerifierIDs[0] = VerifyRoot
+	c.ParentBlockID[0] = 0
+	c.GenesisID[0] = 0
+	c.Data[0] = 0
+	c.DataHash[0] = 0
+	c.Roster.List[0] = c.Roster.List[1]
+	c.Hash[0] = 0
+	c.ForwardLink[0].Hash[0] = 0
+	c.ForwardLink[0].Signature[0] = 0
+	c.ChildSL[0][0] = 0
+	c.ChildAuth[0].Signature[0] = 0
+	c.Attachment[0] = 0
+
+	require.Equal(t, 1, sb.Index)
+	require.Equal(t, []SkipBlockID{{1, 2}}, sb.BackLinkIDs)
+	require.Equal(t, VerifyBase, sb.VerifierIDs[0])
+	require.Equal(t, VerifyBase, VerificationStandard[0])
+	require.Equal(t, SkipBlockID{3}, sb.ParentBlockID)
+	require.Equal(t, SkipBlockID{4}, sb.GenesisID)
+	require.Equal(t, []byte{5}, sb.Data)
+	require.Equal(t, []byte{6}, sb.DataHash)
+	require.True(t, sb.Roster.List[0].Equal(leader))
+	require.Equal(t, SkipBlockID{7}, sb.Hash)
+	require.Equal(t, SkipBlockID{8}, sb.ForwardLink[0].Hash)
+	require.Equal(t, []byte{9}, sb.ForwardLink[0].Signature)
+	require.Equal(t, []SkipBlockID{{10}}, sb.ChildSL)
+	require.Equal(t, []byte{12}, sb.ChildAuth[0].Signature)
+	require.Equal(t, []byte{11}, sb.Attachment)
+	require.Equal(t, orig, sb)
+}
+
 func TestSign(t *testing.T) {
 	l := onet.NewTCPTest()
 	servers, roster, _ := l.GenTree(10, true)
@@ -175,3 +327,30 @@ func sign(msg SkipBlockID, servers []*onet.Server, l *onet.LocalTest) (*bftcosi.
 	copy(sig[32:64], sigR)
 	return &bftcosi.BFTSignature{Sig: sig, Msg: msg, Exceptions: nil}, nil
 }
+
+func TestSkipBlock_Diff(t *testing.T) {
+	l := onet.NewTCPTest()
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+	sb1 := NewSkipBlock()
+	sb1.Index = 1
+	sb1.Roster = roster
+	sb1.Data = []byte{1}
+	sb1.BackLinkIDs = []SkipBlockID{{1, 2}}
+	sb1.Hash = sb1.CalculateHash()
+	require.Equal(t, 0, len(sb1.Diff(sb1.Copy())))
+
+	sb2 := sb1.Copy()
+	sb2.Index = 2
+	sb2.Roster = onet.NewRoster(roster.List[0:2])
+	sb2.Data = []byte{2}
+	sb2.BackLinkIDs = []SkipBlockID{{1, 3}}
+	sb2.ForwardLink = []*BlockLink{{Hash: SkipBlockID{4}}}
+	diff := sb1.Diff(sb2)
+	require.Equal(t, 5, len(diff))
+	require.Equal(t, "Index: 1 != 2", diff[0])
+	require.Equal(t, "BackLinkIDs[0]: 0102 != 0103", diff[1])
+	require.Equal(t, "Data: 01 != 02", diff[2])
+	require.Contains(t, diff[3], "Roster: ")
+	require.Equal(t, "ForwardLink: length 0 != 1", diff[4])
+}
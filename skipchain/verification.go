@@ -1,6 +1,29 @@
 package skipchain
 
-import "gopkg.in/dedis/onet.v1/log"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
+	"gopkg.in/xeipuuv/gojsonschema.v1"
+)
+
+// How far in the future the timestamp of a new block may be.
+const maxTimestampSkew = time.Minute
+
+// How long to wait for external data to be fetched.
+const externalDataTimeout = 10 * time.Second
+
+// Default fraction of the previous roster a new block may remove.
+const defaultMaxRosterChange = 0.5
+
+// Maximum size of external data that will be fetched.
+const externalDataMaxSize = 10 * 1024 * 1024
 
 /*
 This file holds all verification-functions for the skipchain.
@@ -56,3 +79,279 @@ func (s *Service) verifyFuncData(newID []byte, newSB *SkipBlock) bool {
 	}
 	return true
 }
+
+// VerifyRateLimit makes sure that the timestamp of the new block is at least
+// the interval given in the genesis-block later than the timestamp of the
+// previous block, and that it is not too far in the future.
+func (s *Service) verifyFuncRateLimit(newID []byte, newSB *SkipBlock) bool {
+	if len(newSB.BackLinkIDs) == 0 {
+		log.Lvl3("No previous block")
+		return false
+	}
+	genesis := s.Sbm.GetByID(newSB.GenesisID)
+	prev := s.getBlockOrPending(newSB.BackLinkIDs[0])
+	if genesis == nil || prev == nil {
+		log.Lvl3("Didn't find genesis or previous block")
+		return false
+	}
+	_, rlInt, err := network.Unmarshal(genesis.Data)
+	if err != nil {
+		log.Lvl3("Couldn't unmarshal rate-limit:", err)
+		return false
+	}
+	rl, ok := rlInt.(*RateLimit)
+	if !ok {
+		log.Lvl3("Genesis-block doesn't hold a rate-limit")
+		return false
+	}
+	interval := time.Duration(rl.Interval) * time.Millisecond
+	if newSB.Timestamp < prev.Timestamp+int64(interval) {
+		log.Lvl2("Block created before end of interval")
+		return false
+	}
+	if newSB.Timestamp > time.Now().Add(maxTimestampSkew).UnixNano() {
+		log.Lvl2("Block timestamp too far in the future")
+		return false
+	}
+	return true
+}
+
+// VerifyRosterAllowlist makes sure that all conodes in the roster of the new
+// block are in the allowlist stored in the genesis-block.
+func (s *Service) verifyFuncRosterAllowlist(newID []byte, newSB *SkipBlock) bool {
+	genesis := s.Sbm.GetByID(newSB.GenesisID)
+	if genesis == nil {
+		log.Lvl3("Didn't find genesis-block")
+		return false
+	}
+	_, alInt, err := network.Unmarshal(genesis.Data)
+	if err != nil {
+		log.Lvl3("Couldn't unmarshal allowlist:", err)
+		return false
+	}
+	al, ok := alInt.(*RosterAllowlist)
+	if !ok {
+		log.Lvl3("Genesis-block doesn't hold an allowlist")
+		return false
+	}
+	allowed := map[network.ServerIdentityID]bool{}
+	for _, id := range al.IDs {
+		allowed[id] = true
+	}
+	for _, si := range newSB.Roster.List {
+		if !allowed[si.ID] {
+			log.Lvl2("ServerIdentity", si, "is not in allowlist")
+			return false
+		}
+	}
+	return true
+}
+
+// VerifySchema makes sure that the data of the new block is valid JSON and
+// conforms to the JSON schema stored in the genesis-block.
+func (s *Service) verifyFuncSchema(newID []byte, newSB *SkipBlock) bool {
+	genesis := s.Sbm.GetByID(newSB.GenesisID)
+	if genesis == nil {
+		log.Lvl3("Didn't find genesis-block")
+		return false
+	}
+	schema, err := loadSchema(genesis.Data)
+	if err != nil {
+		log.Lvl3("Invalid schema in genesis-block:", err)
+		return false
+	}
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(newSB.Data))
+	if err != nil {
+		log.Lvl2("Couldn't validate data:", err)
+		return false
+	}
+	if !result.Valid() {
+		log.Lvl2("Data doesn't conform to schema:", result.Errors())
+		return false
+	}
+	return true
+}
+
+// loadSchema parses the JSON schema stored in the data of a genesis-block.
+func loadSchema(data []byte) (*gojsonschema.Schema, error) {
+	return gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+}
+
+// VerifyExternalData makes sure that the data of the new block holds an
+// ExternalData. Unless ExternalDataHashOnly is set, the data is fetched and
+// compared to the hash.
+func (s *Service) verifyFuncExternalData(newID []byte, newSB *SkipBlock) bool {
+	_, edInt, err := network.Unmarshal(newSB.Data)
+	if err != nil {
+		log.Lvl3("Couldn't unmarshal external data:", err)
+		return false
+	}
+	ed, ok := edInt.(*ExternalData)
+	if !ok {
+		log.Lvl3("Block doesn't hold external data")
+		return false
+	}
+	if ed.URL == "" || len(ed.Hash) != network.Suite.Hash().Size() {
+		log.Lvl2("Invalid url or hash in external data")
+		return false
+	}
+	if s.ExternalDataHashOnly {
+		return true
+	}
+	client := &http.Client{Timeout: externalDataTimeout}
+	resp, err := client.Get(ed.URL)
+	if err != nil {
+		log.Lvl2("Couldn't fetch external data:", err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Lvl2("Couldn't fetch external data:", resp.Status)
+		return false
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, externalDataMaxSize+1))
+	if err != nil {
+		log.Lvl2("Couldn't read external data:", err)
+		return false
+	}
+	if len(content) > externalDataMaxSize {
+		log.Lvl2("External data is too big")
+		return false
+	}
+	hash := network.Suite.Hash()
+	hash.Write(content)
+	if !bytes.Equal(hash.Sum(nil), ed.Hash) {
+		log.Lvl2("External data doesn't match hash")
+		return false
+	}
+	return true
+}
+
+// VerifyChildBinding makes sure that the genesis-block of the chain is still
+// registered in the ChildSL of its parent-block. The binding of a new
+// genesis-block is checked by checkChildBinding when the child registers.
+func (s *Service) verifyFuncChildBinding(newID []byte, newSB *SkipBlock) bool {
+	if newSB.Index == 0 {
+		return s.checkChildBinding(newSB) == nil
+	}
+	genesis := s.Sbm.GetByID(newSB.GenesisID)
+	if genesis == nil {
+		log.Lvl3("Didn't find genesis-block")
+		return false
+	}
+	parent := s.Sbm.GetByID(genesis.ParentBlockID)
+	if parent == nil {
+		log.Lvl3("Didn't find parent-block")
+		return false
+	}
+	for _, child := range parent.ChildSL {
+		if child.Equal(genesis.Hash) {
+			return true
+		}
+	}
+	log.Lvl3("Parent-block doesn't know about this chain")
+	return false
+}
+
+// checkChildBinding returns an error if the genesis-block of a child-chain
+// doesn't reference the latest block of its parent-chain, which is the one
+// registering the child.
+func (s *Service) checkChildBinding(genesis *SkipBlock) error {
+	if genesis.ParentBlockID.IsNull() {
+		return errors.New("no parent-block given")
+	}
+	parent := s.Sbm.GetByID(genesis.ParentBlockID)
+	if parent == nil {
+		return errors.New("didn't find parent-block")
+	}
+	if parent.GetForwardLen() > 0 {
+		return errors.New("parent-block is not the latest block of its chain")
+	}
+	return nil
+}
+
+// VerifyRosterChange makes sure that the new block removes at most
+// MaxRosterChange of the conodes of the previous roster, and that its leader
+// was already part of the previous roster.
+func (s *Service) verifyFuncRosterChange(newID []byte, newSB *SkipBlock) bool {
+	if len(newSB.BackLinkIDs) == 0 {
+		log.Lvl3("No previous block")
+		return false
+	}
+	prev := s.getBlockOrPending(newSB.BackLinkIDs[0])
+	if prev == nil || prev.Roster == nil {
+		log.Lvl3("Didn't find previous block")
+		return false
+	}
+	if newSB.Roster == nil || len(newSB.Roster.List) == 0 {
+		log.Lvl3("New block has no roster")
+		return false
+	}
+	if i, _ := prev.Roster.Search(newSB.Roster.List[0].ID); i < 0 {
+		log.Lvl2("Leader of new block is not in previous roster")
+		return false
+	}
+	removed := 0
+	for _, si := range prev.Roster.List {
+		if i, _ := newSB.Roster.Search(si.ID); i < 0 {
+			removed++
+		}
+	}
+	if float64(removed) > s.MaxRosterChange*float64(len(prev.Roster.List)) {
+		log.Lvl2("New block removes", removed, "of",
+			len(prev.Roster.List), "conodes")
+		return false
+	}
+	return true
+}
+
+// VerifyTimestamp makes sure that the data of the new block holds a
+// TimestampedData with a timestamp later than the one of the previous block,
+// and that it differs at most maxTimestampSkew from the time of the conode.
+// If the previous block is a genesis-block without a TimestampedData, its
+// Timestamp is used.
+func (s *Service) verifyFuncTimestamp(newID []byte, prev, newSB *SkipBlock) bool {
+	ts, err := dataTimestamp(newSB)
+	if err != nil {
+		log.Lvl3("New block:", err)
+		return false
+	}
+	if prev == nil {
+		log.Lvl3("No previous block")
+		return false
+	}
+	prevTs, err := dataTimestamp(prev)
+	if err != nil {
+		if prev.Index > 0 {
+			log.Lvl3("Previous block:", err)
+			return false
+		}
+		prevTs = prev.Timestamp
+	}
+	if ts <= prevTs {
+		log.Lvl2("Timestamp is not later than previous timestamp")
+		return false
+	}
+	now := time.Now()
+	if ts > now.Add(maxTimestampSkew).UnixNano() ||
+		ts < now.Add(-maxTimestampSkew).UnixNano() {
+		log.Lvl2("Timestamp too far from the time of the conode")
+		return false
+	}
+	return true
+}
+
+// dataTimestamp returns the timestamp of the TimestampedData in the data of
+// sb.
+func dataTimestamp(sb *SkipBlock) (int64, error) {
+	_, tdInt, err := network.Unmarshal(sb.Data)
+	if err != nil {
+		return 0, errors.New("couldn't unmarshal timestamped data: " +
+			err.Error())
+	}
+	td, ok := tdInt.(*TimestampedData)
+	if !ok {
+		return 0, errors.New("block doesn't hold timestamped data")
+	}
+	return td.Timestamp, nil
+}